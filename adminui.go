@@ -0,0 +1,342 @@
+package app
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"maps"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultAdminUIErrorLimit = 20
+	redactedConfigValue      = "[redacted]"
+)
+
+//go:embed adminui.html
+var adminUIHTML string
+
+var adminUITemplate = template.Must(template.New("adminui").Funcs(template.FuncMap{
+	"since": func(t time.Time) string { return time.Since(t).Round(time.Second).String() },
+}).Parse(adminUIHTML))
+
+// sensitiveConfigNames are the parts of a config field name whose value is never shown, matched
+// case-insensitively. The password of URL and key=value DSN values is redacted whatever the field name.
+var sensitiveConfigNames = []string{"password", "secret", "token", "key", "credential", "dsn"}
+
+// dsnPassword matches the password of a key=value DSN, quoted or not, e.g. "host=db password='p w'".
+var dsnPassword = regexp.MustCompile(`(?i)\b(password|pwd)\s*=\s*('(?:[^'\\]|\\.)*'|[^\s;]*)`)
+
+type AdminUIConfig struct {
+	// User and Password protect the dashboard with HTTP Basic authentication, which browsers prompt
+	// for, unlike the bearer tokens of the JSON endpoints.
+	User     string
+	Password string
+	// ErrorLimit is the number of journal entries shown, zero meaning 20.
+	ErrorLimit int
+}
+
+// adminUI serves a dashboard over the state exposed by the JSON endpoints, with forms for the admin
+// actions of the configured components.
+type adminUI struct {
+	svc *Service
+	cfg AdminUIConfig
+}
+
+type adminUIGate struct {
+	Name   string
+	Passed bool
+	Error  string
+}
+
+type adminUISetting struct {
+	Name  string
+	Value string
+}
+
+type adminUIBreaker struct {
+	Name  string
+	State string
+}
+
+type adminUIPage struct {
+	Name      string
+	Build     BuildInfo
+	StartedAt time.Time
+	Notice    string
+	Failed    bool
+
+	Readiness ReadinessReport
+	Gates     []adminUIGate
+	Config    []adminUISetting
+	Breakers  []adminUIBreaker
+
+	Journal      bool
+	Errors       []ErrorJournalEntry
+	JournalError string
+
+	Lifeboat *LifeboatStatus
+	Backups  []string
+	Commands []string
+}
+
+// page collects the state shown by the dashboard, notice being the outcome of the last action.
+func (u *adminUI) page(ctx context.Context, notice string, failed bool) adminUIPage {
+	s := u.svc
+	page := adminUIPage{
+		Name:      s.Name,
+		Build:     s.BuildInfo(),
+		StartedAt: s.startTime,
+		Notice:    notice,
+		Failed:    failed,
+		Readiness: s.ReadinessReport(ctx),
+		Gates:     u.gates(ctx),
+		Config:    redactConfig(s.Config),
+	}
+
+	// as reported by the readiness probe
+	if !allReady([]*atomic.Value{s.isStarted, s.isServing}) {
+		page.Readiness.Status = "not_ready"
+	}
+
+	for _, b := range s.Breakers.list() {
+		page.Breakers = append(page.Breakers, adminUIBreaker{Name: b.Name(), State: b.State().String()})
+	}
+
+	if s.journal != nil {
+		page.Journal = true
+		entries, err := s.journal.Recent(u.cfg.ErrorLimit)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to read error journal")
+			page.JournalError = err.Error()
+		}
+		page.Errors = entries
+	}
+
+	if s.Lifeboat != nil {
+		status := s.Lifeboat.Status()
+		page.Lifeboat = &status
+	}
+	if s.Backups != nil {
+		page.Backups = s.Backups.names()
+	}
+	if s.Plugins != nil {
+		page.Commands = s.Plugins.commandNames()
+	}
+
+	return page
+}
+
+// gates lists what the startup probe waits for: the startup checks and the self-tests, reported by the
+// started flag, then the serving flag cleared while draining.
+func (u *adminUI) gates(ctx context.Context) []adminUIGate {
+	s := u.svc
+
+	var gates []adminUIGate
+	checks := s.healthCheckSet()
+	for _, name := range slices.Sorted(maps.Keys(checks)) {
+		check := checks[name]
+		if check.opts.Criticality != HealthStartup {
+			continue
+		}
+		gate := adminUIGate{Name: "check " + name, Passed: true}
+		if result := check.result(ctx); result.err != nil {
+			gate.Passed, gate.Error = false, result.err.Error()
+		}
+		gates = append(gates, gate)
+	}
+
+	return append(gates,
+		adminUIGate{Name: "started", Passed: s.Started()},
+		adminUIGate{Name: "serving", Passed: s.isServing.Load().(bool)},
+	)
+}
+
+// redactConfig flattens the current config, hiding the sensitive fields and the passwords of URLs.
+func redactConfig(r *ConfigReloader) []adminUISetting {
+	if r == nil {
+		return nil
+	}
+	v := reflect.ValueOf(r.Current())
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+
+	values := make(map[string]any)
+	flattenConfig(v.Elem(), "", values)
+
+	settings := make([]adminUISetting, 0, len(values))
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		settings = append(settings, adminUISetting{Name: name, Value: redactConfigValue(name, values[name])})
+	}
+	return settings
+}
+
+func redactConfigValue(name string, value any) string {
+	field := strings.ToLower(name[strings.LastIndex(name, ".")+1:])
+	for _, sensitive := range sensitiveConfigNames {
+		if strings.Contains(field, sensitive) {
+			if reflect.ValueOf(value).IsZero() {
+				return ""
+			}
+			return redactedConfigValue
+		}
+	}
+
+	s := fmt.Sprint(value)
+	if u, err := url.Parse(s); err == nil && u.User != nil {
+		s = u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(s, "$1="+redactedConfigValue)
+}
+
+// sameOrigin rejects the cross-site form posts a browser would send with the cached Basic credentials.
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// render shows the dashboard with the outcome of the last action passed by redirect.
+func (u *adminUI) render(w http.ResponseWriter, r *http.Request) {
+	notice, failed := r.URL.Query().Get("notice"), r.URL.Query().Has("failed")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'")
+	if err := adminUITemplate.Execute(w, u.page(r.Context(), notice, failed)); err != nil {
+		log.Error().Err(err).Msg("failed to render admin ui")
+	}
+}
+
+// redirect answers an action with the dashboard showing its outcome, so reloading the page doesn't
+// repeat the action.
+func (u *adminUI) redirect(w http.ResponseWriter, r *http.Request, notice string, failed bool) {
+	query := url.Values{"notice": {notice}}
+	if failed {
+		query.Set("failed", "1")
+	}
+	http.Redirect(w, r, "/admin/ui?"+query.Encode(), http.StatusSeeOther)
+}
+
+func (u *adminUI) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(BasicAuth(u.cfg.User, u.cfg.Password))
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && !sameOrigin(r) {
+				AnswerWithJSONError(w, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	r.Get("/", u.render)
+
+	s := u.svc
+	if s.Lifeboat != nil {
+		r.Post("/lifeboat", func(w http.ResponseWriter, r *http.Request) {
+			switch r.PostFormValue("action") {
+			case "enable":
+				s.Lifeboat.Enable(r.PostFormValue("reason"))
+				u.redirect(w, r, "Lifeboat mode enabled", false)
+			case "disable":
+				s.Lifeboat.Disable()
+				u.redirect(w, r, "Lifeboat mode disabled", false)
+			default:
+				AnswerWithJSONError(w, http.StatusBadRequest)
+			}
+		})
+	}
+
+	if s.Backups != nil {
+		r.Post("/backups/{name}", func(w http.ResponseWriter, r *http.Request) {
+			name := chi.URLParam(r, "name")
+			if _, err := s.Backups.get(name); err != nil {
+				AnswerWithJSONError(w, http.StatusNotFound)
+				return
+			}
+
+			if err := s.Backups.runAsync(r.Context(), name); err != nil {
+				u.redirect(w, r, fmt.Sprintf("Backup of %s not started: %v", name, err), true)
+				return
+			}
+			u.redirect(w, r, fmt.Sprintf("Backup of %s started", name), false)
+		})
+	}
+
+	if s.Plugins != nil {
+		r.Post("/commands/{name}", func(w http.ResponseWriter, r *http.Request) {
+			name := chi.URLParam(r, "name")
+			args := strings.TrimSpace(r.PostFormValue("args"))
+			if args == "" {
+				args = "null"
+			}
+			if !json.Valid([]byte(args)) {
+				u.redirect(w, r, fmt.Sprintf("Arguments of %s are not valid JSON", name), true)
+				return
+			}
+
+			result, err := s.Plugins.Command(r.Context(), name, json.RawMessage(args))
+			if err != nil {
+				log.Error().Err(err).Str("command", name).Msg("admin command failed")
+				u.redirect(w, r, fmt.Sprintf("%s failed: %v", name, err), true)
+				return
+			}
+			log.Info().Str("command", name).Str("remote_addr", r.RemoteAddr).Msg("admin command run")
+
+			out, err := json.Marshal(result)
+			if err != nil {
+				out = []byte(err.Error())
+			}
+			u.redirect(w, r, fmt.Sprintf("%s: %s", name, out), false)
+		})
+	}
+
+	return r
+}
+
+type AdminUIOption struct {
+	cfg AdminUIConfig
+}
+
+func (w AdminUIOption) Apply(s *Service) error {
+	if w.cfg.User == "" || w.cfg.Password == "" {
+		return errors.New("admin ui requires a user and a password")
+	}
+	if w.cfg.ErrorLimit == 0 {
+		w.cfg.ErrorLimit = defaultAdminUIErrorLimit
+	}
+
+	s.adminUI = &adminUI{svc: s, cfg: w.cfg}
+	return nil
+}
+
+// WithAdminUI serves a dashboard at /admin/ui on the tech server: the health components, the startup
+// gates, the redacted config, the recent errors of the journal, the circuit breakers, and forms for the
+// lifeboat, the backups and the plugin commands.
+func WithAdminUI(cfg AdminUIConfig) Option {
+	return AdminUIOption{cfg: cfg}
+}
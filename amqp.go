@@ -0,0 +1,406 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultAMQPChannelPoolSize = 8
+	amqpReconnectDelay         = 2 * time.Second
+)
+
+type AMQPExchange struct {
+	Name       string
+	Kind       string
+	Durable    bool
+	AutoDelete bool
+	Args       amqp.Table
+}
+
+type AMQPBinding struct {
+	Exchange   string
+	RoutingKey string
+}
+
+type AMQPQueue struct {
+	Name       string
+	Durable    bool
+	AutoDelete bool
+	Exclusive  bool
+	Args       amqp.Table
+	Bindings   []AMQPBinding
+}
+
+// AMQPConfig describes the broker and the topology declared on every (re)connect.
+type AMQPConfig struct {
+	URL string
+	// ChannelPoolSize bounds the channels kept open for publishing, zero means defaultAMQPChannelPoolSize.
+	ChannelPoolSize int
+	Exchanges       []AMQPExchange
+	Queues          []AMQPQueue
+}
+
+// AMQPClient manages a connection, re-dialed on demand after a failure, and a pool of publishing channels.
+type AMQPClient struct {
+	cfg      AMQPConfig
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	channels chan *amqp.Channel
+	claims   *ClaimCheck
+}
+
+func NewAMQPClient(cfg AMQPConfig) (*AMQPClient, error) {
+	if cfg.ChannelPoolSize == 0 {
+		cfg.ChannelPoolSize = defaultAMQPChannelPoolSize
+	}
+
+	c := &AMQPClient{
+		cfg:      cfg,
+		channels: make(chan *amqp.Channel, cfg.ChannelPoolSize),
+	}
+
+	if _, err := c.connection(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *AMQPClient) Name() string {
+	return "amqp"
+}
+
+// ShutdownPriority closes the connection after the consumers sharing it.
+func (c *AMQPClient) ShutdownPriority() int {
+	return ShutdownPriorityProducer
+}
+
+func (c *AMQPClient) Ready() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn != nil && !c.conn.IsClosed()
+}
+
+// connection returns the current connection, dialing and declaring the topology again if it was closed.
+func (c *AMQPClient) connection() (*amqp.Connection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil && !c.conn.IsClosed() {
+		return c.conn, nil
+	}
+
+	conn, err := amqp.Dial(c.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to amqp: %w", err)
+	}
+
+	if err := c.declare(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	log.Info().Msg("amqp connected")
+	c.conn = conn
+	return conn, nil
+}
+
+func (c *AMQPClient) declare(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open amqp channel: %w", err)
+	}
+	defer ch.Close()
+
+	for _, e := range c.cfg.Exchanges {
+		if err := ch.ExchangeDeclare(e.Name, e.Kind, e.Durable, e.AutoDelete, false, false, e.Args); err != nil {
+			return fmt.Errorf("failed to declare exchange %s: %w", e.Name, err)
+		}
+	}
+
+	for _, q := range c.cfg.Queues {
+		if _, err := ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.Args); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", q.Name, err)
+		}
+
+		for _, b := range q.Bindings {
+			if err := ch.QueueBind(q.Name, b.RoutingKey, b.Exchange, false, nil); err != nil {
+				return fmt.Errorf("failed to bind queue %s to %s: %w", q.Name, b.Exchange, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Channel takes a channel from the pool or opens a new one. Return it with Release.
+func (c *AMQPClient) Channel() (*amqp.Channel, error) {
+	for {
+		select {
+		case ch := <-c.channels:
+			if !ch.IsClosed() {
+				return ch, nil
+			}
+		default:
+			conn, err := c.connection()
+			if err != nil {
+				return nil, err
+			}
+			return conn.Channel()
+		}
+	}
+}
+
+// Release puts the channel back to the pool, closing it when the pool is full.
+func (c *AMQPClient) Release(ch *amqp.Channel) {
+	if ch.IsClosed() {
+		return
+	}
+
+	select {
+	case c.channels <- ch:
+	default:
+		ch.Close()
+	}
+}
+
+func (c *AMQPClient) Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	if c.claims != nil {
+		if err := c.claims.offloadPublishing(ctx, &msg); err != nil {
+			return fmt.Errorf("failed to offload message to %s: %w", exchange, err)
+		}
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		return err
+	}
+	defer c.Release(ch)
+
+	if err := ch.PublishWithContext(ctx, exchange, routingKey, false, false, msg); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", exchange, err)
+	}
+	return nil
+}
+
+func (c *AMQPClient) Close() error {
+	for {
+		select {
+		case ch := <-c.channels:
+			ch.Close()
+		default:
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			if c.conn == nil || c.conn.IsClosed() {
+				return nil
+			}
+			return c.conn.Close()
+		}
+	}
+}
+
+// AMQPHandler processes a delivery, returning nil acks it, an error requeues it.
+type AMQPHandler func(ctx context.Context, d amqp.Delivery) error
+
+// DedupAMQPHandler skips deliveries whose MessageId was already processed by consumer.
+// Deliveries without a MessageId are always processed.
+func DedupAMQPHandler(store CheckpointStore, consumer string, handler AMQPHandler) AMQPHandler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		if d.MessageId == "" {
+			return handler(ctx, d)
+		}
+
+		return ProcessOnce(ctx, store, consumer, d.MessageId, func(ctx context.Context) error {
+			return handler(ctx, d)
+		})
+	}
+}
+
+// AMQPConsumer consumes a queue on a dedicated channel with the given prefetch,
+// re-subscribing after connection failures.
+type AMQPConsumer struct {
+	client   *AMQPClient
+	queue    string
+	prefetch int
+	handler  AMQPHandler
+	ready    atomic.Bool
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewAMQPConsumer(client *AMQPClient, queue string, prefetch int, handler AMQPHandler) *AMQPConsumer {
+	return &AMQPConsumer{
+		client:   client,
+		queue:    queue,
+		prefetch: prefetch,
+		handler:  handler,
+		done:     make(chan struct{}),
+	}
+}
+
+func (c *AMQPConsumer) Name() string {
+	return "amqp-consumer-" + c.queue
+}
+
+func (c *AMQPConsumer) ShutdownPriority() int {
+	return ShutdownPriorityConsumer
+}
+
+func (c *AMQPConsumer) Ready() bool {
+	return c.ready.Load()
+}
+
+func (c *AMQPConsumer) Run(ctx context.Context) error {
+	c.mu.Lock()
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.running.Store(true)
+	c.mu.Unlock()
+	defer close(c.done)
+
+	for {
+		err := c.consume(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Warn().Err(err).Str("queue", c.queue).Msg("amqp consumer interrupted, resubscribing")
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(amqpReconnectDelay):
+		}
+	}
+}
+
+func (c *AMQPConsumer) consume(ctx context.Context) error {
+	conn, err := c.client.connection()
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open amqp channel: %w", err)
+	}
+	defer ch.Close()
+
+	if err := ch.Qos(c.prefetch, 0, false); err != nil {
+		return fmt.Errorf("failed to set prefetch: %w", err)
+	}
+
+	tag := c.Name()
+	deliveries, err := ch.Consume(c.queue, tag, false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to consume %s: %w", c.queue, err)
+	}
+
+	c.ready.Store(true)
+	defer c.ready.Store(false)
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := ch.Cancel(tag, false); err != nil {
+				log.Error().Err(err).Str("queue", c.queue).Msg("failed to cancel amqp consumer")
+			}
+			// prefetched but unprocessed deliveries go back to the queue
+			requeued := 0
+			for d := range deliveries {
+				if err := d.Nack(false, true); err == nil {
+					requeued++
+				}
+			}
+			log.Info().Str("queue", c.queue).Int("requeued", requeued).Msg("amqp consumer stopped")
+			return nil
+		case d, ok := <-deliveries:
+			if !ok {
+				return errors.New("amqp delivery channel closed")
+			}
+			c.handle(ctx, d)
+		}
+	}
+}
+
+func (c *AMQPConsumer) handle(ctx context.Context, d amqp.Delivery) {
+	// in-flight handlers are not interrupted by shutdown
+	if err := c.handler(context.WithoutCancel(ctx), d); err != nil {
+		log.Error().Err(err).Str("queue", c.queue).Msg("amqp handler failed, requeueing")
+		if err := d.Nack(false, true); err != nil {
+			log.Error().Err(err).Str("queue", c.queue).Msg("failed to nack amqp delivery")
+		}
+		return
+	}
+
+	if err := d.Ack(false); err != nil {
+		log.Error().Err(err).Str("queue", c.queue).Msg("failed to ack amqp delivery")
+	}
+}
+
+// Close stops consuming after the in-flight delivery and requeues the prefetched ones.
+func (c *AMQPConsumer) Close() error {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
+
+	if c.running.Load() {
+		<-c.done
+	}
+	return nil
+}
+
+type AMQPOption struct {
+	cfg AMQPConfig
+}
+
+func (w AMQPOption) Apply(s *Service) error {
+	c, err := NewAMQPClient(w.cfg)
+	if err != nil {
+		return err
+	}
+
+	s.AMQP = c
+	return s.RegisterSubService(c)
+}
+
+// WithAMQP connects to RabbitMQ, declares the configured topology and exposes the client as Service.AMQP.
+func WithAMQP(cfg AMQPConfig) Option {
+	return AMQPOption{cfg: cfg}
+}
+
+type AMQPConsumerOption struct {
+	queue    string
+	prefetch int
+	handler  AMQPHandler
+}
+
+func (w AMQPConsumerOption) Apply(s *Service) error {
+	if s.AMQP == nil {
+		return requireOption(errors.New("amqp consumer requires WithAMQP to be applied first"), AMQPOption{})
+	}
+	if w.handler == nil {
+		return fmt.Errorf("amqp consumer %s: handler is nil", w.queue)
+	}
+
+	c := NewAMQPConsumer(s.AMQP, w.queue, w.prefetch, w.handler)
+	return s.RegisterSubService(c)
+}
+
+// WithAMQPConsumer runs a queue consumer as a subservice, prefetch bounds the unacked deliveries.
+func WithAMQPConsumer(queue string, prefetch int, handler AMQPHandler) Option {
+	return AMQPConsumerOption{queue: queue, prefetch: prefetch, handler: handler}
+}
@@ -0,0 +1,154 @@
+// Package apptest runs an app.Service in-process for integration tests: servers listen on
+// ephemeral ports, the service is started and awaited, and torn down with the test.
+package apptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jetbrainer/app"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// LocalAddr listens on an ephemeral port of the loopback interface.
+const LocalAddr = "127.0.0.1:0"
+
+// ReadyTimeout bounds how long Start waits for the service to be ready.
+var ReadyTimeout = 10 * time.Second
+
+const readyPollInterval = 20 * time.Millisecond
+
+// TechServer adds the tech server on an ephemeral port.
+func TechServer() app.Option {
+	return app.WithTechHTTPServerOption(LocalAddr)
+}
+
+// GRPCServer adds a gRPC server on an ephemeral port.
+func GRPCServer() app.Option {
+	return app.WithGRPCServer(LocalAddr)
+}
+
+type Instance struct {
+	Service *app.Service
+	// Addresses are the bound addresses of the servers, in the order they were added.
+	Addresses app.Addresses
+}
+
+// Start builds the service, starts it and waits until it is started and ready. The service is
+// stopped when the test ends, and the test fails if goroutines of the service leaked, see
+// app.WithLeakDetection. Servers must be configured on port 0 (LocalAddr) to run tests in parallel.
+func Start(t testing.TB, name string, opts ...app.Option) *Instance {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	opts = append([]app.Option{
+		app.WithLeakDetection(app.LeakDetectionConfig{}),
+		// a forced shutdown fails the test rather than exiting the test binary
+		app.WithForcedShutdownExit(func(code int) {
+			t.Errorf("apptest: shutdown forced, exit code %d", code)
+		}),
+	}, opts...)
+
+	s, err := app.New(ctx, name, opts...)
+	if err != nil {
+		cancel()
+		t.Fatalf("apptest: failed to create service: %v", err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Start()
+	}()
+
+	// errors are reported by the service while the test runs
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for err := range s.ErrChan {
+			t.Logf("apptest: service error: %v", err)
+		}
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errs; err != nil && !errors.Is(err, context.Canceled) {
+			t.Errorf("apptest: service failed: %v", err)
+		}
+		s.Stop()
+		<-drained
+
+		for _, leak := range s.GoroutineLeaks() {
+			t.Errorf("apptest: %d goroutines leaked by %s:\n%s", leak.Count, leak.Component, leak.Stack)
+		}
+	})
+
+	if err := waitReady(ctx, s, errs); err != nil {
+		t.Fatalf("apptest: %v", err)
+	}
+
+	return &Instance{Service: s, Addresses: s.Addresses()}
+}
+
+func waitReady(ctx context.Context, s *app.Service, errs chan error) error {
+	deadline := time.After(ReadyTimeout)
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.Started() && s.ReadinessReport(ctx).Ready() {
+			return nil
+		}
+
+		select {
+		case err := <-errs:
+			// Start returned before being ready, put the error back for the cleanup
+			errs <- err
+			return fmt.Errorf("service stopped before being ready: %v", err)
+		case <-deadline:
+			return fmt.Errorf("service not ready after %v: %s", ReadyTimeout, notReady(ctx, s))
+		case <-ticker.C:
+		}
+	}
+}
+
+func notReady(ctx context.Context, s *app.Service) string {
+	if !s.Started() {
+		return "startup gates did not pass"
+	}
+
+	for _, component := range s.ReadinessReport(ctx).Components {
+		if component.Critical && component.Error != "" {
+			return component.Name + ": " + component.Error
+		}
+	}
+	return "not ready"
+}
+
+// URL returns the base URL of the i-th HTTP server.
+func (i *Instance) URL(server int) string {
+	return "http://" + i.Addresses.HTTP[server]
+}
+
+// HTTPClient returns a client with a timeout suited to tests.
+func (i *Instance) HTTPClient() *http.Client {
+	return &http.Client{Timeout: 5 * time.Second}
+}
+
+// GRPCConn dials the i-th gRPC server without TLS, the connection is closed when the test ends.
+func (i *Instance) GRPCConn(t testing.TB, server int, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.NewClient(i.Addresses.GRPC[server], opts...)
+	if err != nil {
+		t.Fatalf("apptest: failed to dial grpc server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
@@ -0,0 +1,37 @@
+package apptest_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jetbrainer/app/apptest"
+)
+
+func TestStart(t *testing.T) {
+	inst := apptest.Start(t, "apptest", apptest.TechServer(), apptest.GRPCServer())
+
+	if len(inst.Addresses.HTTP) != 1 || len(inst.Addresses.GRPC) != 1 {
+		t.Fatalf("addresses = %+v, want one http and one grpc server", inst.Addresses)
+	}
+
+	resp, err := inst.HTTPClient().Get(inst.URL(0) + "/health/ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("ready = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+// Instances started one after the other are stopped with their test, freeing the signals they trapped.
+func TestStartSequential(t *testing.T) {
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			inst := apptest.Start(t, name, apptest.TechServer())
+			if !inst.Service.Started() {
+				t.Error("service not started")
+			}
+		})
+	}
+}
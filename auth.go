@@ -0,0 +1,47 @@
+package app
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// BearerTokenAuth allows requests carrying one of the given tokens in the Authorization header. Empty tokens
+// never match, so an empty entry in a config doesn't open the endpoints.
+func BearerTokenAuth(tokens ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if ok && token != "" {
+				for _, t := range tokens {
+					if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			AnswerWithJSONError(w, http.StatusUnauthorized)
+		})
+	}
+}
+
+// BasicAuth allows requests carrying the user and password with HTTP Basic authentication.
+func BasicAuth(user, password string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, p, ok := r.BasicAuth()
+			// both are compared so the time doesn't tell which one is wrong
+			userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+			passwordOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+			if ok && userOK && passwordOK {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
+			AnswerWithJSONError(w, http.StatusUnauthorized)
+		})
+	}
+}
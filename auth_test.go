@@ -0,0 +1,47 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerTokenAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name          string
+		tokens        []string
+		authorization string
+		want          int
+	}{
+		{"valid token", []string{"secret"}, "Bearer secret", http.StatusOK},
+		{"second token", []string{"old", "secret"}, "Bearer secret", http.StatusOK},
+		{"wrong token", []string{"secret"}, "Bearer guess", http.StatusUnauthorized},
+		{"no header", []string{"secret"}, "", http.StatusUnauthorized},
+		{"other scheme", []string{"secret"}, "Basic secret", http.StatusUnauthorized},
+		{"empty bearer", []string{"secret"}, "Bearer ", http.StatusUnauthorized},
+		{"empty configured token", []string{"secret", ""}, "Bearer ", http.StatusUnauthorized},
+		{"no tokens", nil, "Bearer ", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			BearerTokenAuth(tt.tokens...)(ok).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Error("missing WWW-Authenticate challenge")
+			}
+		})
+	}
+}
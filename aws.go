@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const awsCredentialsTimeout = 10 * time.Second
+
+// awsInstrumentation times the calls of the AWS clients, retries included, and traces them.
+type awsInstrumentation struct {
+	duration *prometheus.HistogramVec
+}
+
+func newAWSRequestDuration() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aws_request_duration_seconds",
+		Help:    "Duration of the AWS API calls, retries included, by service, operation and result: success or the error code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "operation", "result"})
+}
+
+func (a *awsInstrumentation) ID() string {
+	return "AppInstrumentation"
+}
+
+func (a *awsInstrumentation) HandleInitialize(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	service, operation := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+
+	ctx, span := otel.Tracer("github.com/jetbrainer/app").Start(ctx, service+"."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "aws-api"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", operation),
+			attribute.String("cloud.region", awsmiddleware.GetRegion(ctx)),
+		))
+	defer span.End()
+
+	start := time.Now()
+	out, metadata, err := next.HandleInitialize(ctx, in)
+
+	result := "success"
+	if err != nil {
+		result = "error"
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			result = apiErr.ErrorCode()
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, result)
+	}
+	a.duration.WithLabelValues(service, operation, result).Observe(time.Since(start).Seconds())
+
+	return out, metadata, err
+}
+
+func (a *awsInstrumentation) apply(stack *middleware.Stack) error {
+	return stack.Initialize.Add(a, middleware.Before)
+}
+
+type AWSOption struct {
+	opts []func(*config.LoadOptions) error
+}
+
+func (w AWSOption) Apply(s *Service) error {
+	opts := append([]func(*config.LoadOptions) error{
+		config.WithRetryer(func() aws.Retryer { return retry.NewAdaptiveMode() }),
+	}, w.opts...)
+
+	cfg, err := config.LoadDefaultConfig(s.ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to load aws config: %w", err)
+	}
+	if cfg.Region == "" {
+		return errors.New("aws region not set, see AWS_REGION")
+	}
+
+	// fail at startup rather than on the first call
+	ctx, cancel := context.WithTimeout(s.ctx, awsCredentialsTimeout)
+	defer cancel()
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return fmt.Errorf("failed to resolve aws credentials: %w", err)
+	}
+
+	a := &awsInstrumentation{duration: registerCollector(s.registry, newAWSRequestDuration())}
+	cfg.APIOptions = append(cfg.APIOptions, a.apply)
+
+	s.AWS = &cfg
+	log.Debug().Str("region", cfg.Region).Msg("aws config loaded")
+	return nil
+}
+
+// WithAWS loads the shared config as Service.AWS, from the environment, the shared config files and the
+// instance or task role, then opts, e.g. config.WithRegion. Calls use the adaptive retry mode, and the
+// clients created from it, e.g. s3.NewFromConfig(*service.AWS), are timed and traced.
+func WithAWS(opts ...func(*config.LoadOptions) error) Option {
+	return AWSOption{opts: opts}
+}
@@ -0,0 +1,426 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultBackupKeep = 7
+	// backupKeyLayout has a fixed width so the keys sort by time, and nanoseconds so backups started
+	// within the same second don't overwrite each other.
+	backupKeyLayout = "20060102T150405.000000000Z"
+)
+
+var (
+	ErrBackupNotFound   = errors.New("backup not found")
+	ErrBackupInProgress = errors.New("backup already in progress")
+	ErrBackupsClosed    = errors.New("backups closed")
+)
+
+// BackupStore persists backup archives under keys of the form <component>/<timestamp>.bak.
+type BackupStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get returns an error matching ErrBackupNotFound when the key is missing.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List returns the keys starting with prefix in ascending order.
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// FileBackupStore keeps backups in a local directory, e.g. a mounted volume.
+type FileBackupStore struct {
+	dir string
+}
+
+func NewFileBackupStore(dir string) *FileBackupStore {
+	return &FileBackupStore{dir: dir}
+}
+
+func (f *FileBackupStore) path(key string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(key))
+}
+
+func (f *FileBackupStore) Put(_ context.Context, key string, r io.Reader) error {
+	path := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	// written to a temporary file first so a failed backup never looks complete
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *FileBackupStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(f.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBackupNotFound, key)
+	}
+	return file, err
+}
+
+func (f *FileBackupStore) List(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(f.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".backup-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(f.dir, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+
+	slices.Sort(keys)
+	return keys, err
+}
+
+func (f *FileBackupStore) Delete(_ context.Context, key string) error {
+	return os.Remove(f.path(key))
+}
+
+// Backup is registered by a stateful component (embedded KV, SQLite, local cache, ...).
+type Backup struct {
+	Name    string
+	Backup  func(ctx context.Context, w io.Writer) error
+	Restore func(ctx context.Context, r io.Reader) error
+}
+
+type BackupConfig struct {
+	Store BackupStore
+	// Spec schedules backups of every component on the scheduler, empty means on demand only.
+	Spec string
+	// Keep is the number of backups retained per component, zero means defaultBackupKeep.
+	Keep int
+	// AdminTokens enable the /admin/backups endpoints on the tech server for these bearer tokens.
+	AdminTokens []string
+}
+
+// Backups runs the registered backups, one at a time per component, and prunes old ones.
+type Backups struct {
+	cfg BackupConfig
+
+	mu      sync.Mutex
+	backups map[string]*registeredBackup
+	closed  bool
+	wg      sync.WaitGroup
+	report  func(err error)
+
+	duration *prometheus.HistogramVec
+	success  *prometheus.GaugeVec
+}
+
+type registeredBackup struct {
+	Backup
+	running sync.Mutex
+}
+
+func NewBackups(cfg BackupConfig) *Backups {
+	if cfg.Keep == 0 {
+		cfg.Keep = defaultBackupKeep
+	}
+
+	return &Backups{
+		cfg:     cfg,
+		backups: make(map[string]*registeredBackup),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "backup_duration_seconds",
+			Help:    "Duration of component backups.",
+			Buckets: []float64{.1, 1, 5, 10, 30, 60, 300, 900, 3600},
+		}, []string{"component", "result"}),
+		success: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "backup_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful backup.",
+		}, []string{"component"}),
+	}
+}
+
+func (b *Backups) Register(backup Backup) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.backups[backup.Name]; ok {
+		return fmt.Errorf("backup %s already registered", backup.Name)
+	}
+
+	b.backups[backup.Name] = &registeredBackup{Backup: backup}
+	return nil
+}
+
+func (b *Backups) get(name string) (*registeredBackup, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	backup, ok := b.backups[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBackupNotFound, name)
+	}
+	return backup, nil
+}
+
+func (b *Backups) names() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	names := make([]string, 0, len(b.backups))
+	for name := range b.backups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Run backs up the component and returns the key of the backup.
+func (b *Backups) Run(ctx context.Context, name string) (string, error) {
+	backup, err := b.get(name)
+	if err != nil {
+		return "", err
+	}
+
+	if !backup.running.TryLock() {
+		return "", fmt.Errorf("%w: %s", ErrBackupInProgress, name)
+	}
+	defer backup.running.Unlock()
+
+	start := time.Now()
+	key := fmt.Sprintf("%s/%s.bak", name, start.UTC().Format(backupKeyLayout))
+
+	pr, pw := io.Pipe()
+	go func() {
+		// a panicking component fails its backup, not the process
+		err := catchPanic("backup "+name, func() error { return backup.Backup.Backup(ctx, pw) })
+		reportPanic(b.report, err)
+		pw.CloseWithError(err)
+	}()
+	err = b.cfg.Store.Put(ctx, key, pr)
+	pr.CloseWithError(err)
+
+	if err != nil {
+		b.duration.WithLabelValues(name, "error").Observe(time.Since(start).Seconds())
+		return "", fmt.Errorf("failed to back up %s: %w", name, err)
+	}
+
+	b.duration.WithLabelValues(name, "success").Observe(time.Since(start).Seconds())
+	b.success.WithLabelValues(name).SetToCurrentTime()
+	log.Info().Str("component", name).Str("key", key).Dur("duration", time.Since(start)).Msg("backup completed")
+
+	if err := b.prune(ctx, name); err != nil {
+		log.Error().Err(err).Str("component", name).Msg("failed to prune old backups")
+	}
+
+	return key, nil
+}
+
+// runAsync starts the backup in the background, waited for by Close. It isn't cancelled with ctx.
+func (b *Backups) runAsync(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Add must not race with the Wait of Close
+	if b.closed {
+		return ErrBackupsClosed
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	b.wg.Add(1)
+	goRecover("backup "+name, b.report, func() {
+		defer b.wg.Done()
+		if _, err := b.Run(ctx, name); err != nil {
+			log.Error().Err(err).Str("component", name).Msg("backup failed")
+		}
+	})
+	return nil
+}
+
+// RunAll backs up every component, returning the first error once all ran.
+func (b *Backups) RunAll(ctx context.Context) error {
+	var errs []error
+	for _, name := range b.names() {
+		if _, err := b.Run(ctx, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Restore feeds the backup stored under key to the component, the latest one when key is empty.
+func (b *Backups) Restore(ctx context.Context, name, key string) error {
+	backup, err := b.get(name)
+	if err != nil {
+		return err
+	}
+
+	if key == "" {
+		keys, err := b.List(ctx, name)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("%w: no backup of %s", ErrBackupNotFound, name)
+		}
+		key = keys[len(keys)-1]
+	}
+
+	if !backup.running.TryLock() {
+		return fmt.Errorf("%w: %s", ErrBackupInProgress, name)
+	}
+	defer backup.running.Unlock()
+
+	r, err := b.cfg.Store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := backup.Restore(ctx, r); err != nil {
+		return fmt.Errorf("failed to restore %s from %s: %w", name, key, err)
+	}
+
+	log.Info().Str("component", name).Str("key", key).Msg("backup restored")
+	return nil
+}
+
+func (b *Backups) List(ctx context.Context, name string) ([]string, error) {
+	return b.cfg.Store.List(ctx, name+"/")
+}
+
+func (b *Backups) prune(ctx context.Context, name string) error {
+	keys, err := b.List(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	for len(keys) > b.cfg.Keep {
+		if err := b.cfg.Store.Delete(ctx, keys[0]); err != nil {
+			return err
+		}
+		keys = keys[1:]
+	}
+	return nil
+}
+
+func (b *Backups) Name() string {
+	return "backups"
+}
+
+func (b *Backups) Ready() bool {
+	return true
+}
+
+// Close waits for the backups triggered over HTTP.
+func (b *Backups) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return nil
+}
+
+func (b *Backups) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(BearerTokenAuth(b.cfg.AdminTokens...))
+
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		listing := make(map[string][]string)
+		for _, name := range b.names() {
+			keys, err := b.List(r.Context(), name)
+			if err != nil {
+				log.Error().Err(err).Str("component", name).Msg("failed to list backups")
+				AnswerWithJSONError(w, http.StatusInternalServerError)
+				return
+			}
+			listing[name] = keys
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(listing)
+	})
+
+	// backups outlive the request, the tech server has a short write timeout
+	r.Post("/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		if _, err := b.get(name); err != nil {
+			AnswerWithJSONError(w, http.StatusNotFound)
+			return
+		}
+
+		if err := b.runAsync(r.Context(), name); err != nil {
+			AnswerWithJSONError(w, http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	return r
+}
+
+type BackupsOption struct {
+	cfg BackupConfig
+}
+
+func (w BackupsOption) Apply(s *Service) error {
+	if w.cfg.Store == nil {
+		return errors.New("backups require a store")
+	}
+
+	b := NewBackups(w.cfg)
+	b.duration = registerCollector(s.registry, b.duration)
+	b.success = registerCollector(s.registry, b.success)
+	b.report = s.notifyReporter
+
+	s.Backups = b
+	if err := s.RegisterSubService(b); err != nil {
+		return err
+	}
+
+	if w.cfg.Spec == "" {
+		return nil
+	}
+	return SchedulerOption{jobs: []Job{{Name: "backups", Spec: w.cfg.Spec, Run: b.RunAll}}}.Apply(s)
+}
+
+// WithBackups lets stateful components register backups with Service.Backups.Register,
+// backups run on cfg.Spec and on demand through the admin endpoints.
+func WithBackups(cfg BackupConfig) Option {
+	return BackupsOption{cfg: cfg}
+}
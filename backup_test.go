@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// newTestBackups backs up a component whose state is the number of backups taken, restoring it to restored.
+func newTestBackups(t *testing.T, keep int) (*Backups, *string) {
+	t.Helper()
+
+	b := NewBackups(BackupConfig{Store: NewFileBackupStore(t.TempDir()), Keep: keep})
+	taken := 0
+	restored := new(string)
+	err := b.Register(Backup{
+		Name: "kv",
+		Backup: func(_ context.Context, w io.Writer) error {
+			taken++
+			_, err := fmt.Fprintf(w, "state %d", taken)
+			return err
+		},
+		Restore: func(_ context.Context, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			*restored = string(data)
+			return err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, restored
+}
+
+func TestBackupsRunAndRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		runs     int
+		keep     int
+		wantKeys int
+		want     string
+	}{
+		{"single", 1, 3, 1, "state 1"},
+		{"same second", 3, 3, 3, "state 3"},
+		{"pruned", 5, 2, 2, "state 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			b, restored := newTestBackups(t, tt.keep)
+
+			for range tt.runs {
+				if _, err := b.Run(ctx, "kv"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			keys, err := b.List(ctx, "kv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != tt.wantKeys {
+				t.Fatalf("keys = %v, want %d", keys, tt.wantKeys)
+			}
+
+			if err := b.Restore(ctx, "kv", ""); err != nil {
+				t.Fatal(err)
+			}
+			if *restored != tt.want {
+				t.Errorf("restored %q, want the latest backup %q", *restored, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, b *Backups) error
+		want error
+	}{
+		{"unknown component", func(ctx context.Context, b *Backups) error {
+			_, err := b.Run(ctx, "missing")
+			return err
+		}, ErrBackupNotFound},
+		{"restore without backup", func(ctx context.Context, b *Backups) error {
+			return b.Restore(ctx, "kv", "")
+		}, ErrBackupNotFound},
+		{"restore unknown key", func(ctx context.Context, b *Backups) error {
+			return b.Restore(ctx, "kv", "kv/missing.bak")
+		}, ErrBackupNotFound},
+		{"run once closed", func(ctx context.Context, b *Backups) error {
+			if err := b.Close(); err != nil {
+				return err
+			}
+			return b.runAsync(ctx, "kv")
+		}, ErrBackupsClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := newTestBackups(t, 0)
+
+			if err := tt.run(context.Background(), b); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupKeysSortByTime(t *testing.T) {
+	b, _ := newTestBackups(t, 10)
+	ctx := context.Background()
+
+	var keys []string
+	for range 3 {
+		key, err := b.Run(ctx, "kv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	listed, err := b.List(ctx, "kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(listed, ",") != strings.Join(keys, ",") {
+		t.Errorf("listed %v, want the keys in the order they were taken %v", listed, keys)
+	}
+}
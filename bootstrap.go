@@ -0,0 +1,213 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+const kafkaAdminTimeout = 30 * time.Second
+
+type KafkaTopic struct {
+	Name string
+	// Partitions is the minimum number of partitions, zero means the broker default. Partitions are
+	// added to existing topics, never removed.
+	Partitions int32
+	// ReplicationFactor only applies to created topics, zero means the broker default.
+	ReplicationFactor int16
+	// Retention sets retention.ms, zero keeps the current or default retention.
+	Retention time.Duration
+	// Configs are topic configs set on created and existing topics, e.g. "cleanup.policy".
+	Configs map[string]string
+}
+
+func (t KafkaTopic) configs() map[string]string {
+	configs := make(map[string]string, len(t.Configs)+1)
+	for k, v := range t.Configs {
+		configs[k] = v
+	}
+	if t.Retention > 0 {
+		configs["retention.ms"] = strconv.FormatInt(t.Retention.Milliseconds(), 10)
+	}
+	return configs
+}
+
+// EnsureKafkaTopics creates the missing topics, and adds partitions and sets the configs of the
+// existing ones. It is idempotent, e.g. as a PreStartTask.
+func EnsureKafkaTopics(ctx context.Context, client *kgo.Client, topics ...KafkaTopic) error {
+	req := kmsg.NewPtrCreateTopicsRequest()
+	req.TimeoutMillis = int32(kafkaAdminTimeout.Milliseconds())
+	byName := make(map[string]KafkaTopic, len(topics))
+	for _, topic := range topics {
+		byName[topic.Name] = topic
+
+		rt := kmsg.NewCreateTopicsRequestTopic()
+		rt.Topic = topic.Name
+		rt.NumPartitions = -1
+		if topic.Partitions > 0 {
+			rt.NumPartitions = topic.Partitions
+		}
+		rt.ReplicationFactor = -1
+		if topic.ReplicationFactor > 0 {
+			rt.ReplicationFactor = topic.ReplicationFactor
+		}
+		for name, value := range topic.configs() {
+			rc := kmsg.NewCreateTopicsRequestTopicConfig()
+			rc.Name = name
+			rc.Value = kmsg.StringPtr(value)
+			rt.Configs = append(rt.Configs, rc)
+		}
+		req.Topics = append(req.Topics, rt)
+	}
+
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to create kafka topics: %w", err)
+	}
+
+	var existing []KafkaTopic
+	var errs []error
+	for _, rt := range resp.Topics {
+		switch err := kerr.ErrorForCode(rt.ErrorCode); {
+		case err == nil:
+			log.Info().Str("topic", rt.Topic).Msg("kafka topic created")
+		case errors.Is(err, kerr.TopicAlreadyExists):
+			existing = append(existing, byName[rt.Topic])
+		default:
+			errs = append(errs, fmt.Errorf("failed to create kafka topic %s: %w", rt.Topic, err))
+		}
+	}
+
+	if len(existing) > 0 {
+		if err := ensureKafkaPartitions(ctx, client, existing); err != nil {
+			errs = append(errs, err)
+		}
+		if err := ensureKafkaTopicConfigs(ctx, client, existing); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func ensureKafkaPartitions(ctx context.Context, client *kgo.Client, topics []KafkaTopic) error {
+	meta := kmsg.NewPtrMetadataRequest()
+	for _, topic := range topics {
+		mt := kmsg.NewMetadataRequestTopic()
+		mt.Topic = kmsg.StringPtr(topic.Name)
+		meta.Topics = append(meta.Topics, mt)
+	}
+	metaResp, err := meta.RequestWith(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to describe kafka topics: %w", err)
+	}
+
+	current := make(map[string]int32, len(metaResp.Topics))
+	for _, mt := range metaResp.Topics {
+		if mt.Topic != nil {
+			current[*mt.Topic] = int32(len(mt.Partitions))
+		}
+	}
+
+	req := kmsg.NewPtrCreatePartitionsRequest()
+	req.TimeoutMillis = int32(kafkaAdminTimeout.Milliseconds())
+	for _, topic := range topics {
+		if topic.Partitions > current[topic.Name] {
+			rt := kmsg.NewCreatePartitionsRequestTopic()
+			rt.Topic = topic.Name
+			rt.Count = topic.Partitions
+			req.Topics = append(req.Topics, rt)
+		}
+	}
+	if len(req.Topics) == 0 {
+		return nil
+	}
+
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to add kafka partitions: %w", err)
+	}
+
+	var errs []error
+	for _, rt := range resp.Topics {
+		if err := kerr.ErrorForCode(rt.ErrorCode); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add partitions to kafka topic %s: %w", rt.Topic, err))
+			continue
+		}
+		log.Info().Str("topic", rt.Topic).Msg("kafka partitions added")
+	}
+	return errors.Join(errs...)
+}
+
+func ensureKafkaTopicConfigs(ctx context.Context, client *kgo.Client, topics []KafkaTopic) error {
+	req := kmsg.NewPtrIncrementalAlterConfigsRequest()
+	for _, topic := range topics {
+		configs := topic.configs()
+		if len(configs) == 0 {
+			continue
+		}
+
+		rr := kmsg.NewIncrementalAlterConfigsRequestResource()
+		rr.ResourceType = kmsg.ConfigResourceTypeTopic
+		rr.ResourceName = topic.Name
+		for name, value := range configs {
+			rc := kmsg.NewIncrementalAlterConfigsRequestResourceConfig()
+			rc.Name = name
+			rc.Op = kmsg.IncrementalAlterConfigOpSet
+			rc.Value = kmsg.StringPtr(value)
+			rr.Configs = append(rr.Configs, rc)
+		}
+		req.Resources = append(req.Resources, rr)
+	}
+	if len(req.Resources) == 0 {
+		return nil
+	}
+
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to set kafka topic configs: %w", err)
+	}
+
+	var errs []error
+	for _, rr := range resp.Resources {
+		if err := kerr.ErrorForCode(rr.ErrorCode); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set configs of kafka topic %s: %w", rr.ResourceName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type RedisACLUser struct {
+	Name      string
+	Passwords []string
+	// Rules are ACL rules, e.g. "~orders:*", "+@read", "+set".
+	Rules []string
+}
+
+// EnsureRedisACL resets the user to the passwords and rules, enabling it. Running it again with the
+// same user leaves Redis unchanged.
+func EnsureRedisACL(ctx context.Context, client redis.UniversalClient, users ...RedisACLUser) error {
+	for _, user := range users {
+		args := []any{"ACL", "SETUSER", user.Name, "reset", "on"}
+		for _, password := range user.Passwords {
+			args = append(args, ">"+password)
+		}
+		for _, rule := range user.Rules {
+			args = append(args, rule)
+		}
+
+		if err := client.Do(ctx, args...).Err(); err != nil {
+			return fmt.Errorf("failed to set redis acl user %s: %w", user.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/rs/zerolog/log"
+)
+
+// S3BucketClient is the subset of *s3.Client used by EnsureS3Bucket.
+type S3BucketClient interface {
+	CreateBucket(ctx context.Context, in *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error)
+	PutBucketLifecycleConfiguration(ctx context.Context, in *s3.PutBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error)
+}
+
+type S3Bucket struct {
+	Name string
+	// Region is the location constraint, empty creates the bucket in us-east-1.
+	Region string
+	// Lifecycle replaces the lifecycle rules of the bucket when set.
+	Lifecycle []s3types.LifecycleRule
+}
+
+// EnsureS3Bucket creates the bucket unless it is already owned by the account, then sets its lifecycle rules.
+func EnsureS3Bucket(ctx context.Context, client S3BucketClient, bucket S3Bucket) error {
+	in := &s3.CreateBucketInput{Bucket: aws.String(bucket.Name)}
+	if bucket.Region != "" && bucket.Region != "us-east-1" {
+		in.CreateBucketConfiguration = &s3types.CreateBucketConfiguration{
+			LocationConstraint: s3types.BucketLocationConstraint(bucket.Region),
+		}
+	}
+
+	_, err := client.CreateBucket(ctx, in)
+	var owned *s3types.BucketAlreadyOwnedByYou
+	switch {
+	case err == nil:
+		log.Info().Str("bucket", bucket.Name).Msg("s3 bucket created")
+	case errors.As(err, &owned):
+	default:
+		return fmt.Errorf("failed to create s3 bucket %s: %w", bucket.Name, err)
+	}
+
+	if len(bucket.Lifecycle) == 0 {
+		return nil
+	}
+
+	_, err = client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket.Name),
+		LifecycleConfiguration: &s3types.BucketLifecycleConfiguration{Rules: bucket.Lifecycle},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set lifecycle of s3 bucket %s: %w", bucket.Name, err)
+	}
+	return nil
+}
+
+// SQSQueueClient is the subset of *sqs.Client used by EnsureSQSQueue.
+type SQSQueueClient interface {
+	CreateQueue(ctx context.Context, in *sqs.CreateQueueInput, optFns ...func(*sqs.Options)) (*sqs.CreateQueueOutput, error)
+	GetQueueUrl(ctx context.Context, in *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error)
+	SetQueueAttributes(ctx context.Context, in *sqs.SetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.SetQueueAttributesOutput, error)
+}
+
+type SQSQueue struct {
+	Name string
+	// Attributes are the queue attributes, e.g. "VisibilityTimeout" or "RedrivePolicy".
+	Attributes map[string]string
+}
+
+// EnsureSQSQueue creates the queue, or updates the attributes of an existing queue which differ,
+// and returns its URL.
+func EnsureSQSQueue(ctx context.Context, client SQSQueueClient, queue SQSQueue) (string, error) {
+	out, err := client.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName:  aws.String(queue.Name),
+		Attributes: queue.Attributes,
+	})
+	if err == nil {
+		return aws.ToString(out.QueueUrl), nil
+	}
+
+	var exists *sqstypes.QueueNameExists
+	if !errors.As(err, &exists) {
+		return "", fmt.Errorf("failed to create sqs queue %s: %w", queue.Name, err)
+	}
+
+	urlOut, err := client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(queue.Name)})
+	if err != nil {
+		return "", fmt.Errorf("failed to get url of sqs queue %s: %w", queue.Name, err)
+	}
+
+	_, err = client.SetQueueAttributes(ctx, &sqs.SetQueueAttributesInput{
+		QueueUrl:   urlOut.QueueUrl,
+		Attributes: queue.Attributes,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to set attributes of sqs queue %s: %w", queue.Name, err)
+	}
+	log.Info().Str("queue", queue.Name).Msg("sqs queue attributes updated")
+
+	return aws.ToString(urlOut.QueueUrl), nil
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BuildInfo describes the binary, e.g. set with -ldflags "-X main.version=...". Empty fields are read from
+// the build info embedded by the go command: the module version, vcs.revision and vcs.time.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// withDefaults fills the empty fields from the embedded build info.
+func (b BuildInfo) withDefaults() BuildInfo {
+	if b.GoVersion == "" {
+		b.GoVersion = runtime.Version()
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	if b.Version == "" && info.Main.Version != "(devel)" {
+		b.Version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && b.Commit == "":
+			b.Commit = setting.Value
+		case setting.Key == "vcs.time" && b.BuildDate == "":
+			b.BuildDate = setting.Value
+		}
+	}
+	return b
+}
+
+// BuildInfo returns the build info of the service, see WithBuildInfo.
+func (s *Service) BuildInfo() BuildInfo {
+	return s.buildInfo.withDefaults()
+}
+
+// Version returns the version of the service, see WithVersion.
+func (s *Service) Version() string {
+	return s.BuildInfo().Version
+}
+
+// registerBuildInfo publishes build_info once all options are applied.
+func (s *Service) registerBuildInfo() {
+	info := s.BuildInfo()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "build_info",
+		Help: "Always 1, labeled with the version, commit, build date and Go version of the service.",
+		ConstLabels: prometheus.Labels{
+			"version":    info.Version,
+			"commit":     info.Commit,
+			"build_date": info.BuildDate,
+			"go_version": info.GoVersion,
+		},
+	})
+	gauge.Set(1)
+	registerCollector(s.registry, gauge)
+}
+
+type infoResponse struct {
+	Name string `json:"name"`
+	BuildInfo
+	StartedAt time.Time `json:"started_at,omitzero"`
+}
+
+func (s *Service) registerInfo(r chi.Router) {
+	r.Get("/info", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(infoResponse{Name: s.Name, BuildInfo: s.BuildInfo(), StartedAt: s.startTime})
+	})
+}
+
+type BuildInfoOption struct {
+	info BuildInfo
+}
+
+func (w BuildInfoOption) Apply(s *Service) error {
+	s.buildInfo = w.info
+	return nil
+}
+
+// WithVersion sets the version reported by /info, build_info and the integrations describing the service,
+// e.g. the OpenTelemetry resource, whatever the order of the options.
+func WithVersion(version string) Option {
+	return BuildInfoOption{info: BuildInfo{Version: version}}
+}
+
+// WithBuildInfo is WithVersion with the commit, build date and Go version as well, the empty fields
+// default to the build info embedded by the go command.
+func WithBuildInfo(info BuildInfo) Option {
+	return BuildInfoOption{info: info}
+}
@@ -0,0 +1,326 @@
+package app
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultCacheMaxEntries = 10000
+	defaultCacheLocalTTL   = 10 * time.Second
+)
+
+// CacheBackend stores values with a ttl, zero meaning no expiry.
+type CacheBackend interface {
+	// Get returns false when the key is missing or expired.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// MemoryCache is a least recently used cache bounded to a number of entries, expired entries are
+// dropped when read or evicted.
+type MemoryCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+
+	evictions prometheus.Counter
+}
+
+type memoryCacheEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewMemoryCache keeps up to maxEntries values, zero means defaultCacheMaxEntries.
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (m *MemoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		m.remove(elem)
+		return nil, false, nil
+	}
+
+	m.lru.MoveToFront(elem)
+	return entry.value, true, nil
+}
+
+func (m *MemoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	if elem, ok := m.entries[key]; ok {
+		entry := elem.Value.(*memoryCacheEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		m.lru.MoveToFront(elem)
+		return nil
+	}
+
+	m.entries[key] = m.lru.PushFront(&memoryCacheEntry{key: key, value: value, expiresAt: expiresAt})
+	for m.lru.Len() > m.maxEntries {
+		m.remove(m.lru.Back())
+		if m.evictions != nil {
+			m.evictions.Inc()
+		}
+	}
+	return nil
+}
+
+func (m *MemoryCache) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+	return nil
+}
+
+// Len returns the number of entries, expired ones included until they are dropped.
+func (m *MemoryCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lru.Len()
+}
+
+// remove must be called with the lock held.
+func (m *MemoryCache) remove(elem *list.Element) {
+	m.lru.Remove(elem)
+	delete(m.entries, elem.Value.(*memoryCacheEntry).key)
+}
+
+// RedisCache stores the values in Redis under a key prefix, shared by the instances.
+type RedisCache struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisCache stores the values under prefix, e.g. "orders:cache:".
+func NewRedisCache(client redis.UniversalClient, prefix string) *RedisCache {
+	return &RedisCache{client: client, prefix: prefix}
+}
+
+func (r *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get cache key %s: %w", key, err)
+	}
+	return value, true, nil
+}
+
+func (r *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := r.client.Set(ctx, r.prefix+key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set cache key %s: %w", key, err)
+	}
+	return nil
+}
+
+func (r *RedisCache) Delete(ctx context.Context, key string) error {
+	if err := r.client.Del(ctx, r.prefix+key).Err(); err != nil {
+		return fmt.Errorf("failed to delete cache key %s: %w", key, err)
+	}
+	return nil
+}
+
+type CacheConfig struct {
+	// Name labels the metrics of the cache, defaults to "default".
+	Name string
+	// MaxEntries bounds the in-memory tier, zero means defaultCacheMaxEntries.
+	MaxEntries int
+	// Redis is the shared tier, nil means the cache is in memory only.
+	Redis redis.UniversalClient
+	// Prefix of the Redis keys, defaults to "<service>:cache:".
+	Prefix string
+	// TwoTier keeps the values read from Redis in memory as well, for LocalTTL. Deletes and updates made
+	// by other instances are seen once the local copy expired.
+	TwoTier bool
+	// LocalTTL bounds the lifetime of the in-memory copies of the two-tier mode, zero means
+	// defaultCacheLocalTTL.
+	LocalTTL time.Duration
+}
+
+type cacheMetrics struct {
+	requests  *prometheus.CounterVec
+	evictions *prometheus.CounterVec
+}
+
+func newCacheMetrics() cacheMetrics {
+	return cacheMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_requests_total",
+			Help: "Cache reads by cache, tier (memory or redis) and result: hit, miss or error.",
+		}, []string{"cache", "tier", "result"}),
+		evictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_evictions_total",
+			Help: "Entries evicted from the in-memory tier because it was full.",
+		}, []string{"cache"}),
+	}
+}
+
+// Cache reads through the in-memory tier, if any, then the Redis one. Errors of the Redis tier are
+// returned so callers can fall back to the source.
+type Cache struct {
+	name     string
+	local    *MemoryCache
+	remote   CacheBackend
+	localTTL time.Duration
+	requests *prometheus.CounterVec
+}
+
+func NewCache(cfg CacheConfig) *Cache {
+	if cfg.Name == "" {
+		cfg.Name = "default"
+	}
+	if cfg.LocalTTL == 0 {
+		cfg.LocalTTL = defaultCacheLocalTTL
+	}
+
+	c := &Cache{name: cfg.Name, localTTL: cfg.LocalTTL}
+	if cfg.Redis != nil {
+		c.remote = NewRedisCache(cfg.Redis, cfg.Prefix)
+	}
+	if cfg.Redis == nil || cfg.TwoTier {
+		c.local = NewMemoryCache(cfg.MaxEntries)
+	}
+	c.setMetrics(newCacheMetrics())
+
+	return c
+}
+
+func (c *Cache) setMetrics(m cacheMetrics) {
+	c.requests = m.requests
+	if c.local != nil {
+		c.local.evictions = m.evictions.WithLabelValues(c.name)
+	}
+}
+
+// Get returns false when the key is missing or expired.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if c.local != nil {
+		value, ok, _ := c.local.Get(ctx, key)
+		if ok || c.remote == nil {
+			c.observe("memory", ok, nil)
+			return value, ok, nil
+		}
+		c.observe("memory", false, nil)
+	}
+
+	value, ok, err := c.remote.Get(ctx, key)
+	c.observe("redis", ok, err)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	if c.local != nil {
+		_ = c.local.Set(ctx, key, value, c.localTTL)
+	}
+	return value, true, nil
+}
+
+func (c *Cache) observe(tier string, hit bool, err error) {
+	result := "miss"
+	switch {
+	case err != nil:
+		result = "error"
+	case hit:
+		result = "hit"
+	}
+	c.requests.WithLabelValues(c.name, tier, result).Inc()
+}
+
+// Set stores the value in every tier, the in-memory copy of the two-tier mode expiring after LocalTTL
+// at most.
+func (c *Cache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if c.remote != nil {
+		if err := c.remote.Set(ctx, key, value, ttl); err != nil {
+			if c.local != nil {
+				_ = c.local.Delete(ctx, key)
+			}
+			return err
+		}
+	}
+	if c.local != nil {
+		localTTL := ttl
+		if c.remote != nil && (ttl == 0 || ttl > c.localTTL) {
+			localTTL = c.localTTL
+		}
+		_ = c.local.Set(ctx, key, value, localTTL)
+	}
+	return nil
+}
+
+// Delete removes the key from every tier, the in-memory copies of other instances expire after LocalTTL.
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	if c.local != nil {
+		_ = c.local.Delete(ctx, key)
+	}
+	if c.remote != nil {
+		return c.remote.Delete(ctx, key)
+	}
+	return nil
+}
+
+type CacheOption struct {
+	cfg CacheConfig
+}
+
+func (w CacheOption) Apply(s *Service) error {
+	cfg := w.cfg
+	if cfg.Redis != nil && cfg.Prefix == "" {
+		cfg.Prefix = s.Name + ":cache:"
+	}
+
+	c := NewCache(cfg)
+	m := newCacheMetrics()
+	m.requests = registerCollector(s.registry, m.requests)
+	m.evictions = registerCollector(s.registry, m.evictions)
+	c.setMetrics(m)
+
+	s.Cache = c
+	log.Debug().Str("cache", c.name).Bool("redis", c.remote != nil).Bool("memory", c.local != nil).Msg("cache configured")
+	return nil
+}
+
+// WithCache sets up Service.Cache: in memory, in Redis, or both with TwoTier.
+func WithCache(cfg CacheConfig) Option {
+	return CacheOption{cfg: cfg}
+}
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// CheckpointStore persists consumer progress (offsets, cursors, sequence numbers) per partition
+// and remembers processed message IDs for deduplication.
+type CheckpointStore interface {
+	// Load returns the saved position, ok is false when nothing was saved yet.
+	Load(ctx context.Context, consumer, partition string) (position string, ok bool, err error)
+	Save(ctx context.Context, consumer, partition, position string) error
+	// MarkProcessed claims messageID, returning false when it was already claimed.
+	MarkProcessed(ctx context.Context, consumer, messageID string) (bool, error)
+	// Forget releases a claim, so the message can be processed again.
+	Forget(ctx context.Context, consumer, messageID string) error
+}
+
+// ProcessOnce runs fn unless messageID was already processed by consumer. The claim is
+// released when fn fails so a redelivery is processed again. Side effects of fn outside the
+// store are not atomic with the claim, for that use PostgresCheckpointStore.WithTx.
+func ProcessOnce(ctx context.Context, store CheckpointStore, consumer, messageID string, fn func(ctx context.Context) error) error {
+	first, err := store.MarkProcessed(ctx, consumer, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to mark message %s processed: %w", messageID, err)
+	}
+	if !first {
+		return nil
+	}
+
+	if err := fn(ctx); err != nil {
+		if forgetErr := store.Forget(ctx, consumer, messageID); forgetErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to release message %s: %w", messageID, forgetErr))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// CheckpointSchema creates the tables used by PostgresCheckpointStore.
+const CheckpointSchema = `
+CREATE TABLE IF NOT EXISTS app_checkpoints (
+	consumer   TEXT        NOT NULL,
+	partition  TEXT        NOT NULL,
+	position   TEXT        NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (consumer, partition)
+);
+
+CREATE TABLE IF NOT EXISTS app_processed_messages (
+	consumer     TEXT        NOT NULL,
+	message_id   TEXT        NOT NULL,
+	processed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (consumer, message_id)
+);`
+
+// PostgresCheckpointStore keeps checkpoints in Postgres. Bound to a transaction with WithTx,
+// checkpoints and dedup claims commit atomically with the business writes.
+type PostgresCheckpointStore struct {
+	db DBTX
+}
+
+func NewPostgresCheckpointStore(db DBTX) *PostgresCheckpointStore {
+	return &PostgresCheckpointStore{db: db}
+}
+
+func (s *PostgresCheckpointStore) EnsureSchema(ctx context.Context) error {
+	if _, err := s.db.Exec(ctx, CheckpointSchema); err != nil {
+		return fmt.Errorf("failed to create checkpoint tables: %w", err)
+	}
+	return nil
+}
+
+// WithTx returns a store running its statements in tx.
+func (s *PostgresCheckpointStore) WithTx(tx pgx.Tx) *PostgresCheckpointStore {
+	return &PostgresCheckpointStore{db: tx}
+}
+
+func (s *PostgresCheckpointStore) Load(ctx context.Context, consumer, partition string) (string, bool, error) {
+	var position string
+	err := s.db.QueryRow(ctx,
+		`SELECT position FROM app_checkpoints WHERE consumer = $1 AND partition = $2`,
+		consumer, partition).Scan(&position)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+
+	return position, true, nil
+}
+
+func (s *PostgresCheckpointStore) Save(ctx context.Context, consumer, partition, position string) error {
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO app_checkpoints (consumer, partition, position) VALUES ($1, $2, $3)
+		ON CONFLICT (consumer, partition) DO UPDATE SET position = EXCLUDED.position, updated_at = now()`,
+		consumer, partition, position)
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresCheckpointStore) MarkProcessed(ctx context.Context, consumer, messageID string) (bool, error) {
+	tag, err := s.db.Exec(ctx,
+		`INSERT INTO app_processed_messages (consumer, message_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		consumer, messageID)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark message processed: %w", err)
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *PostgresCheckpointStore) Forget(ctx context.Context, consumer, messageID string) error {
+	_, err := s.db.Exec(ctx,
+		`DELETE FROM app_processed_messages WHERE consumer = $1 AND message_id = $2`,
+		consumer, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to forget message: %w", err)
+	}
+	return nil
+}
+
+// PurgeProcessed deletes dedup entries older than the given age, returning how many were removed.
+func (s *PostgresCheckpointStore) PurgeProcessed(ctx context.Context, olderThan time.Duration) (int64, error) {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM app_processed_messages WHERE processed_at < now() - $1::interval`,
+		olderThan)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge processed messages: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// RedisCheckpointStore keeps checkpoints in a hash per consumer and dedup claims as keys expiring after DedupTTL.
+type RedisCheckpointStore struct {
+	client   redis.UniversalClient
+	prefix   string
+	dedupTTL time.Duration
+}
+
+const defaultCheckpointDedupTTL = 7 * 24 * time.Hour
+
+// NewRedisCheckpointStore creates a store with keys under prefix, dedupTTL zero means seven days.
+func NewRedisCheckpointStore(client redis.UniversalClient, prefix string, dedupTTL time.Duration) *RedisCheckpointStore {
+	if dedupTTL == 0 {
+		dedupTTL = defaultCheckpointDedupTTL
+	}
+
+	return &RedisCheckpointStore{client: client, prefix: prefix, dedupTTL: dedupTTL}
+}
+
+func (s *RedisCheckpointStore) Load(ctx context.Context, consumer, partition string) (string, bool, error) {
+	position, err := s.client.HGet(ctx, s.checkpointKey(consumer), partition).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+
+	return position, true, nil
+}
+
+func (s *RedisCheckpointStore) Save(ctx context.Context, consumer, partition, position string) error {
+	if err := s.client.HSet(ctx, s.checkpointKey(consumer), partition, position).Err(); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisCheckpointStore) MarkProcessed(ctx context.Context, consumer, messageID string) (bool, error) {
+	first, err := s.client.SetNX(ctx, s.processedKey(consumer, messageID), 1, s.dedupTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark message processed: %w", err)
+	}
+
+	return first, nil
+}
+
+func (s *RedisCheckpointStore) Forget(ctx context.Context, consumer, messageID string) error {
+	if err := s.client.Del(ctx, s.processedKey(consumer, messageID)).Err(); err != nil {
+		return fmt.Errorf("failed to forget message: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisCheckpointStore) checkpointKey(consumer string) string {
+	return s.prefix + "checkpoints:" + consumer
+}
+
+func (s *RedisCheckpointStore) processedKey(consumer, messageID string) string {
+	return s.prefix + "processed:" + consumer + ":" + messageID
+}
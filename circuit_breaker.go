@@ -0,0 +1,304 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenTimeout      = 30 * time.Second
+	defaultBreakerHalfOpenProbes   = 1
+)
+
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// BreakerState is exported as the value of the circuit_breaker_state gauge.
+type BreakerState int
+
+const (
+	// BreakerClosed lets the calls through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects the calls with ErrBreakerOpen until OpenTimeout elapsed.
+	BreakerOpen
+	// BreakerHalfOpen lets probe calls through, closing the breaker once they succeeded or opening it again.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures opening the breaker, zero means
+	// defaultBreakerFailureThreshold.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before probing, zero means defaultBreakerOpenTimeout.
+	OpenTimeout time.Duration
+	// HalfOpenProbes is the number of probe calls, let through concurrently, which must succeed to close the
+	// breaker, zero means defaultBreakerHalfOpenProbes.
+	HalfOpenProbes int
+}
+
+// CircuitBreaker stops calling a failing target for a while, so callers fail fast instead of piling up
+// on timeouts, then probes it to find out whether it recovered.
+type CircuitBreaker struct {
+	name string
+	cfg  BreakerConfig
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+
+	gauge       prometheus.Gauge
+	transitions *prometheus.CounterVec
+}
+
+func (b *CircuitBreaker) Name() string {
+	return b.name
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cfg.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// breakerResult is the outcome of a call let through by the breaker.
+type breakerResult int
+
+const (
+	breakerSuccess breakerResult = iota
+	breakerFailure
+	// breakerIgnored are the calls canceled by their caller, which tell nothing about the target.
+	breakerIgnored
+)
+
+func newBreakerResult(success bool) breakerResult {
+	if success {
+		return breakerSuccess
+	}
+	return breakerFailure
+}
+
+// callerCanceled reports whether a call failed because its caller gave up, ctx being its context.
+func callerCanceled(ctx context.Context, err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled)
+}
+
+// Allow returns ErrBreakerOpen when the call must not be made, otherwise done must be called with its
+// outcome.
+func (b *CircuitBreaker) Allow() (done func(success bool), err error) {
+	probe, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	var once sync.Once
+	return func(success bool) {
+		once.Do(func() { b.record(probe, newBreakerResult(success)) })
+	}, nil
+}
+
+// allow is Allow for the callers recording the result themselves, probe telling a half-open probe.
+func (b *CircuitBreaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			return false, fmt.Errorf("%w: %s", ErrBreakerOpen, b.name)
+		}
+		b.transition(BreakerHalfOpen)
+	}
+
+	probe = b.state == BreakerHalfOpen
+	if probe {
+		if b.probes+b.successes >= b.cfg.HalfOpenProbes {
+			return false, fmt.Errorf("%w: %s", ErrBreakerOpen, b.name)
+		}
+		b.probes++
+	}
+	return probe, nil
+}
+
+// record counts the result of a call. While half-open only the probes count, the other calls having
+// been let through before the breaker opened.
+func (b *CircuitBreaker) record(probe bool, result breakerResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probes--
+	}
+	if result == breakerIgnored || (!probe && b.state == BreakerHalfOpen) {
+		return
+	}
+
+	success := result == breakerSuccess
+	switch {
+	case success && b.state == BreakerHalfOpen:
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenProbes {
+			b.transition(BreakerClosed)
+		}
+	case success:
+		b.failures = 0
+	case b.state == BreakerHalfOpen:
+		b.transition(BreakerOpen)
+	case b.state == BreakerClosed:
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.transition(BreakerOpen)
+		}
+	}
+}
+
+// transition must be called with the lock held.
+func (b *CircuitBreaker) transition(state BreakerState) {
+	if state == b.state {
+		return
+	}
+
+	log.Warn().Str("breaker", b.name).Str("from", b.state.String()).Str("to", state.String()).Msg("circuit breaker state changed")
+	b.state = state
+	b.failures, b.successes = 0, 0
+	if state == BreakerOpen {
+		b.openedAt = time.Now()
+	}
+	b.gauge.Set(float64(state))
+	b.transitions.WithLabelValues(b.name, state.String()).Inc()
+}
+
+// Do calls fn when the breaker allows it, an error counting as a failure unless it is context.Canceled.
+func (b *CircuitBreaker) Do(fn func() error) error {
+	probe, err := b.allow()
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+	result := newBreakerResult(err == nil)
+	if errors.Is(err, context.Canceled) {
+		result = breakerIgnored
+	}
+	b.record(probe, result)
+	return err
+}
+
+// UnaryClientInterceptor guards the calls of a gRPC client connection, server faults counting as failures.
+// The calls canceled by the caller don't count.
+func (b *CircuitBreaker) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		probe, err := b.allow()
+		if err != nil {
+			return err
+		}
+
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		result := newBreakerResult(err == nil || !isServerFault(status.Code(err)))
+		if err != nil && callerCanceled(ctx, err) {
+			result = breakerIgnored
+		}
+		b.record(probe, result)
+		return err
+	}
+}
+
+// CircuitBreakers is the registry of the breakers of the service, reported by the health status.
+type CircuitBreakers struct {
+	mu       sync.Mutex
+	breakers map[string]*CircuitBreaker
+
+	state       *prometheus.GaugeVec
+	transitions *prometheus.CounterVec
+}
+
+func newCircuitBreakers(registry prometheus.Registerer) *CircuitBreakers {
+	return &CircuitBreakers{
+		breakers: make(map[string]*CircuitBreaker),
+		state: registerCollector(registry, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "State of the circuit breakers: 0 closed, 1 open, 2 half-open.",
+		}, []string{"breaker"})),
+		transitions: registerCollector(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "circuit_breaker_transitions_total",
+			Help: "Circuit breaker state changes by the state entered.",
+		}, []string{"breaker", "state"})),
+	}
+}
+
+// Get returns the breaker called name, creating it with cfg when it doesn't exist yet.
+func (c *CircuitBreakers) Get(name string, cfg BreakerConfig) *CircuitBreaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if b, ok := c.breakers[name]; ok {
+		return b
+	}
+
+	if cfg.FailureThreshold == 0 {
+		cfg.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	if cfg.OpenTimeout == 0 {
+		cfg.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if cfg.HalfOpenProbes == 0 {
+		cfg.HalfOpenProbes = defaultBreakerHalfOpenProbes
+	}
+
+	b := &CircuitBreaker{
+		name:        name,
+		cfg:         cfg,
+		gauge:       c.state.WithLabelValues(name),
+		transitions: c.transitions,
+	}
+	b.gauge.Set(float64(BreakerClosed))
+	c.breakers[name] = b
+
+	return b
+}
+
+func (c *CircuitBreakers) list() []*CircuitBreaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	breakers := make([]*CircuitBreaker, 0, len(c.breakers))
+	for _, b := range c.breakers {
+		breakers = append(breakers, b)
+	}
+	sort.Slice(breakers, func(i, j int) bool { return breakers[i].name < breakers[j].name })
+	return breakers
+}
+
+// CircuitBreaker returns the breaker called name from Service.Breakers, e.g. for a gRPC client:
+//
+//	grpc.WithChainUnaryInterceptor(service.CircuitBreaker("orders", app.BreakerConfig{}).UnaryClientInterceptor())
+func (s *Service) CircuitBreaker(name string, cfg BreakerConfig) *CircuitBreaker {
+	return s.Breakers.Get(name, cfg)
+}
@@ -0,0 +1,272 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	// ClaimCheckHeader marks the messages whose payload was replaced by a claim check reference.
+	ClaimCheckHeader = "claim-check"
+
+	defaultClaimCheckThreshold = 256 << 10
+	defaultClaimCheckPrefix    = "claim-check/"
+)
+
+// BlobStore keeps the offloaded payloads, ObjectStorage and FileBackupStore are blob stores.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+type ClaimCheckConfig struct {
+	// Store defaults to Service.ObjectStorage.
+	Store BlobStore
+	// Threshold is the payload size above which payloads are offloaded, zero means 256KiB.
+	Threshold int
+	// Prefix of the keys of the offloaded payloads, defaults to claim-check/. The payloads are never
+	// deleted as every consumer group may read them, expire them with a lifecycle rule of the bucket.
+	Prefix string
+}
+
+// claimCheckReference replaces an offloaded payload, consumers unaware of claim checks see where it is.
+type claimCheckReference struct {
+	Key  string `json:"claim_check"`
+	Size int    `json:"size"`
+}
+
+type claimCheckMetrics struct {
+	payloads *prometheus.CounterVec
+	bytes    *prometheus.CounterVec
+}
+
+func newClaimCheckMetrics() claimCheckMetrics {
+	return claimCheckMetrics{
+		payloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "claim_check_payloads_total",
+			Help: "Payloads offloaded to and resolved from the blob store by operation and result.",
+		}, []string{"operation", "result"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "claim_check_bytes_total",
+			Help: "Bytes of the payloads offloaded to and resolved from the blob store by operation.",
+		}, []string{"operation"}),
+	}
+}
+
+// ClaimCheck stores the payloads larger than the threshold in a blob store and sends a reference in their
+// place. The managed Kafka producer and AMQP client offload the payloads, the managed consumers resolve them.
+type ClaimCheck struct {
+	store     BlobStore
+	threshold int
+	prefix    string
+
+	payloads *prometheus.CounterVec
+	bytes    *prometheus.CounterVec
+}
+
+func NewClaimCheck(cfg ClaimCheckConfig) (*ClaimCheck, error) {
+	if cfg.Store == nil {
+		return nil, errors.New("claim check requires a blob store")
+	}
+	if cfg.Threshold == 0 {
+		cfg.Threshold = defaultClaimCheckThreshold
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = defaultClaimCheckPrefix
+	}
+
+	c := &ClaimCheck{store: cfg.Store, threshold: cfg.Threshold, prefix: cfg.Prefix}
+	c.setMetrics(newClaimCheckMetrics())
+	return c, nil
+}
+
+func (c *ClaimCheck) setMetrics(m claimCheckMetrics) {
+	c.payloads = m.payloads
+	c.bytes = m.bytes
+}
+
+// Offload stores payload and returns its reference if it's larger than the threshold, otherwise payload
+// unchanged. Messages carrying a reference must be sent with the ClaimCheckHeader header.
+func (c *ClaimCheck) Offload(ctx context.Context, payload []byte) (_ []byte, offloaded bool, err error) {
+	if len(payload) <= c.threshold {
+		return payload, false, nil
+	}
+
+	key := c.prefix + uuid.NewString()
+	if err := c.store.Put(ctx, key, bytes.NewReader(payload)); err != nil {
+		c.payloads.WithLabelValues("offload", "error").Inc()
+		return nil, false, fmt.Errorf("failed to offload payload: %w", err)
+	}
+	c.payloads.WithLabelValues("offload", "success").Inc()
+	c.bytes.WithLabelValues("offload").Add(float64(len(payload)))
+
+	ref, err := json.Marshal(claimCheckReference{Key: key, Size: len(payload)})
+	if err != nil {
+		return nil, false, err
+	}
+	return ref, true, nil
+}
+
+// Resolve returns the payload of a reference returned by Offload.
+func (c *ClaimCheck) Resolve(ctx context.Context, ref []byte) (_ []byte, err error) {
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		c.payloads.WithLabelValues("resolve", result).Inc()
+	}()
+
+	var reference claimCheckReference
+	if err := json.Unmarshal(ref, &reference); err != nil || reference.Key == "" {
+		return nil, fmt.Errorf("invalid claim check reference %q", ref)
+	}
+
+	r, err := c.store.Get(ctx, reference.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve claim check %s: %w", reference.Key, err)
+	}
+	defer r.Close()
+
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read claim check %s: %w", reference.Key, err)
+	}
+	if len(payload) != reference.Size {
+		return nil, fmt.Errorf("claim check %s has %d bytes, %d expected", reference.Key, len(payload), reference.Size)
+	}
+
+	c.bytes.WithLabelValues("resolve").Add(float64(len(payload)))
+	return payload, nil
+}
+
+// offloadRecord replaces the value of the record by a reference if it's larger than the threshold.
+func (c *ClaimCheck) offloadRecord(ctx context.Context, record *kgo.Record) error {
+	value, offloaded, err := c.Offload(ctx, record.Value)
+	if err != nil || !offloaded {
+		return err
+	}
+
+	record.Value = value
+	record.Headers = append(record.Headers, kgo.RecordHeader{Key: ClaimCheckHeader, Value: []byte("1")})
+	return nil
+}
+
+// offloadPublishing replaces the body of the message by a reference if it's larger than the threshold.
+func (c *ClaimCheck) offloadPublishing(ctx context.Context, msg *amqp.Publishing) error {
+	body, offloaded, err := c.Offload(ctx, msg.Body)
+	if err != nil || !offloaded {
+		return err
+	}
+
+	msg.Body = body
+	// the headers of the caller are left untouched
+	msg.Headers = maps.Clone(msg.Headers)
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	msg.Headers[ClaimCheckHeader] = "1"
+	return nil
+}
+
+// ClaimCheckKafkaHandler resolves the records offloaded by claims before passing them to handler, without
+// the ClaimCheckHeader header. Resolution failures are retried like handler failures.
+func ClaimCheckKafkaHandler(claims *ClaimCheck, handler KafkaHandler) KafkaHandler {
+	return func(ctx context.Context, record *kgo.Record) error {
+		i := slices.IndexFunc(record.Headers, func(h kgo.RecordHeader) bool { return h.Key == ClaimCheckHeader })
+		if i < 0 {
+			return handler(ctx, record)
+		}
+
+		value, err := claims.Resolve(ctx, record.Value)
+		if err != nil {
+			return err
+		}
+		record.Value = value
+		record.Headers = slices.Delete(record.Headers, i, i+1)
+		return handler(ctx, record)
+	}
+}
+
+// ClaimCheckAMQPHandler resolves the deliveries offloaded by claims before passing them to handler, without
+// the ClaimCheckHeader header. Deliveries failing to resolve are requeued.
+func ClaimCheckAMQPHandler(claims *ClaimCheck, handler AMQPHandler) AMQPHandler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		if _, ok := d.Headers[ClaimCheckHeader]; !ok {
+			return handler(ctx, d)
+		}
+
+		body, err := claims.Resolve(ctx, d.Body)
+		if err != nil {
+			return err
+		}
+		d.Body = body
+		d.Headers = maps.Clone(d.Headers)
+		delete(d.Headers, ClaimCheckHeader)
+		return handler(ctx, d)
+	}
+}
+
+// wireClaimCheck offloads the payloads of the Kafka producer and the AMQP client and resolves them in the
+// Kafka and AMQP consumers, once all options are applied.
+func (s *Service) wireClaimCheck() {
+	if s.ClaimCheck == nil {
+		return
+	}
+	if s.KafkaProducer != nil {
+		s.KafkaProducer.claims = s.ClaimCheck
+	}
+	if s.AMQP != nil {
+		s.AMQP.claims = s.ClaimCheck
+	}
+
+	for _, sub := range s.subServices() {
+		switch c := sub.(type) {
+		case *KafkaConsumer:
+			c.handler = ClaimCheckKafkaHandler(s.ClaimCheck, c.handler)
+		case *AMQPConsumer:
+			c.handler = ClaimCheckAMQPHandler(s.ClaimCheck, c.handler)
+		}
+	}
+}
+
+type ClaimCheckOption struct {
+	cfg ClaimCheckConfig
+}
+
+func (w ClaimCheckOption) Apply(s *Service) error {
+	cfg := w.cfg
+	if cfg.Store == nil && s.ObjectStorage != nil {
+		cfg.Store = s.ObjectStorage
+	}
+
+	c, err := NewClaimCheck(cfg)
+	if err != nil {
+		return err
+	}
+
+	m := newClaimCheckMetrics()
+	m.payloads = registerCollector(s.registry, m.payloads)
+	m.bytes = registerCollector(s.registry, m.bytes)
+	c.setMetrics(m)
+
+	s.ClaimCheck = c
+	return nil
+}
+
+// WithClaimCheck offloads the large payloads of the Kafka producer and the AMQP client to a blob store and
+// resolves them in the Kafka and AMQP consumers. Apply it after WithObjectStorage.
+func WithClaimCheck(cfg ClaimCheckConfig) Option {
+	return ClaimCheckOption{cfg: cfg}
+}
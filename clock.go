@@ -0,0 +1,234 @@
+package app
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultClockSkewThreshold = 2 * time.Second
+	defaultClockSkewInterval  = time.Minute
+	clockSkewRequestTimeout   = 5 * time.Second
+
+	// seconds between the NTP epoch (1900) and the unix epoch (1970)
+	ntpEpochOffset = 2208988800
+)
+
+// ClockSkewConfig configures the clock skew checker. NTPServer is used when set,
+// otherwise the Date header of HTTPURL, which only has a one second resolution.
+type ClockSkewConfig struct {
+	NTPServer string
+	HTTPURL   string
+	// Threshold over which the service is reported unhealthy, zero means defaultClockSkewThreshold.
+	Threshold time.Duration
+	// Interval between measurements, zero means defaultClockSkewInterval.
+	Interval time.Duration
+}
+
+// ClockSkewChecker periodically measures the local clock offset against a trusted source.
+// Token validation and TTL logic silently break under skew, so exceeding the threshold
+// makes the checker not ready and logs an error.
+type ClockSkewChecker struct {
+	cfg    ClockSkewConfig
+	skew   atomic.Int64
+	gauge  prometheus.Gauge
+	client *http.Client
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewClockSkewChecker(cfg ClockSkewConfig) (*ClockSkewChecker, error) {
+	if cfg.NTPServer == "" && cfg.HTTPURL == "" {
+		return nil, errors.New("clock skew checker needs an NTP server or an HTTP URL")
+	}
+	if cfg.Threshold == 0 {
+		cfg.Threshold = defaultClockSkewThreshold
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultClockSkewInterval
+	}
+
+	return &ClockSkewChecker{
+		cfg: cfg,
+		gauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "clock_skew_seconds",
+			Help: "Offset of the local clock relative to the reference time source.",
+		}),
+		client: &http.Client{Timeout: clockSkewRequestTimeout},
+		done:   make(chan struct{}),
+	}, nil
+}
+
+func (c *ClockSkewChecker) Name() string {
+	return "clock-skew"
+}
+
+func (c *ClockSkewChecker) Ready() bool {
+	return c.Skew().Abs() <= c.cfg.Threshold
+}
+
+// Skew returns the last measured offset, positive when the local clock is behind.
+func (c *ClockSkewChecker) Skew() time.Duration {
+	return time.Duration(c.skew.Load())
+}
+
+func (c *ClockSkewChecker) Run(ctx context.Context) error {
+	c.mu.Lock()
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.running.Store(true)
+	c.mu.Unlock()
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *ClockSkewChecker) Close() error {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
+
+	if c.running.Load() {
+		<-c.done
+	}
+	return nil
+}
+
+func (c *ClockSkewChecker) check(ctx context.Context) {
+	var (
+		skew time.Duration
+		err  error
+	)
+	if c.cfg.NTPServer != "" {
+		skew, err = ntpOffset(ctx, c.cfg.NTPServer)
+	} else {
+		skew, err = c.httpOffset(ctx)
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to measure clock skew")
+		return
+	}
+
+	c.skew.Store(int64(skew))
+	c.gauge.Set(skew.Seconds())
+
+	if skew.Abs() > c.cfg.Threshold {
+		log.Error().Dur("skew", skew).Dur("threshold", c.cfg.Threshold).Msg("clock skew exceeds threshold")
+	}
+}
+
+func (c *ClockSkewChecker) httpOffset(ctx context.Context) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.cfg.HTTPURL, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	resp.Body.Close()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid Date header: %w", err)
+	}
+
+	// Date is truncated to the second, compare with the middle of the round trip shifted by half a second
+	local := sent.Add(received.Sub(sent) / 2)
+	return date.Add(500 * time.Millisecond).Sub(local), nil
+}
+
+// ntpOffset performs a single SNTP request (RFC 4330) and returns the clock offset.
+func ntpOffset(ctx context.Context, server string) (time.Duration, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(clockSkewRequestTimeout)); err != nil {
+		return 0, err
+	}
+
+	req := make([]byte, 48)
+	req[0] = 0x23 // LI 0, version 4, mode 3 (client)
+
+	t1 := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+
+	resp := make([]byte, 48)
+	if _, err := conn.Read(resp); err != nil {
+		return 0, err
+	}
+	t4 := time.Now()
+
+	if mode := resp[0] & 0x7; mode != 4 {
+		return 0, fmt.Errorf("unexpected ntp mode %d", mode)
+	}
+	if stratum := resp[1]; stratum == 0 {
+		return 0, errors.New("ntp kiss-o'-death response")
+	}
+
+	t2 := ntpTime(resp[32:40])
+	t3 := ntpTime(resp[40:48])
+
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+}
+
+func ntpTime(b []byte) time.Time {
+	seconds := binary.BigEndian.Uint32(b[:4])
+	fraction := binary.BigEndian.Uint32(b[4:])
+	nanos := (int64(fraction) * 1e9) >> 32
+
+	return time.Unix(int64(seconds)-ntpEpochOffset, nanos)
+}
+
+type ClockSkewOption struct {
+	cfg ClockSkewConfig
+}
+
+func (w ClockSkewOption) Apply(s *Service) error {
+	c, err := NewClockSkewChecker(w.cfg)
+	if err != nil {
+		return err
+	}
+
+	c.gauge = registerCollector(s.registry, c.gauge)
+	return s.RegisterSubService(c)
+}
+
+// WithClockSkewCheck runs a ClockSkewChecker as a subservice, its skew is exported as the clock_skew_seconds gauge.
+func WithClockSkewCheck(cfg ClockSkewConfig) Option {
+	return ClockSkewOption{cfg: cfg}
+}
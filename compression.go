@@ -0,0 +1,260 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultCompressionMinSize = 1024
+	maxDecompressedSize       = 64 << 20
+)
+
+// compressionMagic starts the compressed payloads, the payloads written before compression was enabled
+// are returned as is by Decompress.
+var compressionMagic = []byte{0, 'a', 'p', 'z'}
+
+var ErrDecompressedTooLarge = errors.New("decompressed payload exceeds 64MiB")
+
+type CompressionAlgorithm byte
+
+const (
+	// compressionNone marks the payloads stored as is which start with compressionMagic.
+	compressionNone CompressionAlgorithm = iota
+	CompressionZstd
+	CompressionGzip
+)
+
+func (a CompressionAlgorithm) String() string {
+	switch a {
+	case compressionNone:
+		return "none"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionGzip:
+		return "gzip"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(a))
+	}
+}
+
+type CompressionConfig struct {
+	// Algorithm defaults to CompressionZstd.
+	Algorithm CompressionAlgorithm
+	// MinSize is the size under which payloads are stored as is, zero means 1KiB.
+	MinSize int
+}
+
+// PayloadCompressor compresses payloads persisted by the framework (outbox rows, persisted tasks, ...)
+// before they are encrypted, since ciphertexts don't compress. Payloads smaller than MinSize or which
+// don't shrink are stored as is.
+type PayloadCompressor struct {
+	cfg     CompressionConfig
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+
+	input  *prometheus.CounterVec
+	output *prometheus.CounterVec
+	ratio  *prometheus.HistogramVec
+}
+
+type compressionMetrics struct {
+	input  *prometheus.CounterVec
+	output *prometheus.CounterVec
+	ratio  *prometheus.HistogramVec
+}
+
+func NewPayloadCompressor(cfg CompressionConfig) (*PayloadCompressor, error) {
+	if cfg.Algorithm == compressionNone {
+		cfg.Algorithm = CompressionZstd
+	}
+	if cfg.Algorithm != CompressionZstd && cfg.Algorithm != CompressionGzip {
+		return nil, fmt.Errorf("unsupported compression algorithm %s", cfg.Algorithm)
+	}
+	if cfg.MinSize == 0 {
+		cfg.MinSize = defaultCompressionMinSize
+	}
+
+	// the zstd decoder is needed whatever the algorithm, to read payloads written before it changed
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &PayloadCompressor{cfg: cfg, encoder: encoder, decoder: decoder}
+	c.setMetrics(newCompressionMetrics())
+	return c, nil
+}
+
+func newCompressionMetrics() compressionMetrics {
+	return compressionMetrics{
+		input: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "payload_compression_input_bytes_total",
+			Help: "Bytes of the payloads passed to the compressor.",
+		}, []string{"algorithm"}),
+		output: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "payload_compression_output_bytes_total",
+			Help: "Bytes of the payloads stored by the compressor, compressed or not.",
+		}, []string{"algorithm"}),
+		ratio: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "payload_compression_ratio",
+			Help:    "Compressed to original size ratio of the compressed payloads.",
+			Buckets: []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+		}, []string{"algorithm"}),
+	}
+}
+
+func (c *PayloadCompressor) setMetrics(m compressionMetrics) {
+	c.input = m.input
+	c.output = m.output
+	c.ratio = m.ratio
+}
+
+// Compress returns payload compressed, or as is when compressing doesn't pay off.
+// The compressed layout is: magic | algorithm | compressed payload.
+func (c *PayloadCompressor) Compress(payload []byte) ([]byte, error) {
+	algorithm := c.cfg.Algorithm.String()
+	c.input.WithLabelValues(algorithm).Add(float64(len(payload)))
+
+	// larger payloads couldn't be decompressed, see maxDecompressedSize
+	stored := c.store(payload)
+	if len(payload) >= c.cfg.MinSize && len(payload) <= maxDecompressedSize {
+		compressed, err := c.compress(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress payload: %w", err)
+		}
+		if len(compressed) < len(payload) {
+			c.ratio.WithLabelValues(algorithm).Observe(float64(len(compressed)) / float64(len(payload)))
+			stored = compressed
+		}
+	}
+
+	c.output.WithLabelValues(algorithm).Add(float64(len(stored)))
+	return stored, nil
+}
+
+// store returns payload uncompressed, with a header if it could be mistaken for a compressed one.
+func (c *PayloadCompressor) store(payload []byte) []byte {
+	if !bytes.HasPrefix(payload, compressionMagic) {
+		return payload
+	}
+
+	stored := make([]byte, 0, len(compressionMagic)+1+len(payload))
+	stored = append(stored, compressionMagic...)
+	stored = append(stored, byte(compressionNone))
+	return append(stored, payload...)
+}
+
+func (c *PayloadCompressor) compress(payload []byte) ([]byte, error) {
+	compressed := append(bytes.Clone(compressionMagic), byte(c.cfg.Algorithm))
+	if c.cfg.Algorithm == CompressionZstd {
+		return c.encoder.EncodeAll(payload, compressed), nil
+	}
+
+	buf := bytes.NewBuffer(compressed)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress reverses Compress whatever the algorithm it used, payloads without header are returned as is.
+func (c *PayloadCompressor) Decompress(stored []byte) ([]byte, error) {
+	if !bytes.HasPrefix(stored, compressionMagic) || len(stored) == len(compressionMagic) {
+		return stored, nil
+	}
+
+	algorithm := CompressionAlgorithm(stored[len(compressionMagic)])
+	compressed := stored[len(compressionMagic)+1:]
+	switch algorithm {
+	case compressionNone:
+		return compressed, nil
+	case CompressionZstd:
+		payload, err := c.decoder.DecodeAll(compressed, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, ErrDecompressedTooLarge
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress zstd payload: %w", err)
+		}
+		return payload, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress gzip payload: %w", err)
+		}
+		payload, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress gzip payload: %w", err)
+		}
+		if len(payload) > maxDecompressedSize {
+			return nil, ErrDecompressedTooLarge
+		}
+		return payload, nil
+	default:
+		return nil, fmt.Errorf("unknown payload compression algorithm %d", byte(algorithm))
+	}
+}
+
+// payloadDecompressor reads the compressed payloads when compression isn't enabled, e.g. the rows written
+// before it was turned off.
+var payloadDecompressor = sync.OnceValues(func() (*PayloadCompressor, error) {
+	return NewPayloadCompressor(CompressionConfig{})
+})
+
+// decompressPayload decompresses stored with c, or with payloadDecompressor if c is nil.
+func decompressPayload(c *PayloadCompressor, stored []byte) ([]byte, error) {
+	if c != nil {
+		return c.Decompress(stored)
+	}
+	if !bytes.HasPrefix(stored, compressionMagic) {
+		return stored, nil
+	}
+
+	c, err := payloadDecompressor()
+	if err != nil {
+		return nil, err
+	}
+	return c.Decompress(stored)
+}
+
+type PayloadCompressionOption struct {
+	cfg CompressionConfig
+}
+
+func (w PayloadCompressionOption) Apply(s *Service) error {
+	c, err := NewPayloadCompressor(w.cfg)
+	if err != nil {
+		return err
+	}
+
+	m := newCompressionMetrics()
+	m.input = registerCollector(s.registry, m.input)
+	m.output = registerCollector(s.registry, m.output)
+	m.ratio = registerCollector(s.registry, m.ratio)
+	c.setMetrics(m)
+
+	s.Compressor = c
+	return nil
+}
+
+// WithPayloadCompression makes components persisting payloads (outbox, persisted worker pool tasks, ...)
+// compress them through Service.Compressor, before encrypting them when WithPayloadEncryption is applied.
+func WithPayloadCompression(cfg CompressionConfig) Option {
+	return PayloadCompressionOption{cfg: cfg}
+}
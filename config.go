@@ -0,0 +1,363 @@
+package app
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrConfigMissing is wrapped by the ConfigError of a required field without value.
+	ErrConfigMissing = errors.New("required config value missing")
+	// ErrConfigInvalid is wrapped by the ConfigError of a value which can't be parsed.
+	ErrConfigInvalid = errors.New("invalid config value")
+)
+
+// ConfigError reports a field which couldn't be loaded, LoadConfig joins the errors of every field.
+type ConfigError struct {
+	// Field is the path of the struct field, e.g. "DB.DSN".
+	Field string
+	// Source is where the value comes from: the env variable, the file key, or "default".
+	Source string
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("config %s: %v", e.Field, e.Err)
+	}
+	return fmt.Sprintf("config %s (%s): %v", e.Field, e.Source, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+type ConfigOpt func(*configLoader)
+
+// ConfigFile reads values from a JSON or YAML file, found with the file tag as a dotted path,
+// e.g. `file:"db.dsn"`. Env values take precedence over the file.
+func ConfigFile(path string) ConfigOpt {
+	return func(l *configLoader) { l.path = path }
+}
+
+// ConfigEnvPrefix is prepended to every env tag, e.g. "ORDERS_".
+func ConfigEnvPrefix(prefix string) ConfigOpt {
+	return func(l *configLoader) { l.envPrefix = prefix }
+}
+
+type configLoader struct {
+	path      string
+	envPrefix string
+	file      map[string]any
+	errs      []error
+
+	reloadSignal os.Signal
+	watch        time.Duration
+}
+
+// LoadConfig fills the struct cfg points to from, in increasing precedence, the `default` tags,
+// the `file` keys of ConfigFile and the `env` variables. Fields tagged `required:"true"` must get a
+// value. Supported fields are strings, bools, numbers, time.Duration, comma-separated slices,
+// encoding.TextUnmarshaler and nested structs.
+//
+//	type Config struct {
+//		app.ServiceConfig
+//		Brokers []string      `env:"KAFKA_BROKERS" file:"kafka.brokers" required:"true"`
+//		Timeout time.Duration `env:"TIMEOUT" default:"5s"`
+//	}
+func LoadConfig(cfg any, opts ...ConfigOpt) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a pointer to a struct, got %T", cfg)
+	}
+
+	l := &configLoader{}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if l.path != "" {
+		if err := l.readFile(); err != nil {
+			return err
+		}
+	}
+
+	l.load(v.Elem(), "")
+	return errors.Join(l.errs...)
+}
+
+func (l *configLoader) readFile() error {
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch ext := filepath.Ext(l.path); ext {
+	case ".json":
+		err = json.Unmarshal(data, &l.file)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &l.file)
+	default:
+		return fmt.Errorf("unsupported config file format %q", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", l.path, err)
+	}
+
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+func (l *configLoader) load(v reflect.Value, prefix string) {
+	t := v.Type()
+	for i := range t.NumField() {
+		field, fv := t.Field(i), v.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := prefix + field.Name
+
+		if isNestedConfig(field) {
+			// embedded structs share the namespace of their parent
+			nested := name + "."
+			if field.Anonymous {
+				nested = prefix
+			}
+			l.load(fv, nested)
+			continue
+		}
+
+		raw, source, ok := l.lookup(field)
+		if !ok {
+			if field.Tag.Get("required") == "true" {
+				l.errs = append(l.errs, &ConfigError{Field: name, Source: l.sources(field), Err: ErrConfigMissing})
+			}
+			continue
+		}
+
+		if err := setConfigValue(fv, raw); err != nil {
+			l.errs = append(l.errs, &ConfigError{Field: name, Source: source, Err: fmt.Errorf("%w: %w", ErrConfigInvalid, err)})
+		}
+	}
+}
+
+// isNestedConfig tells whether the fields of a struct field are loaded rather than the field itself.
+func isNestedConfig(field reflect.StructField) bool {
+	_, hasEnv := field.Tag.Lookup("env")
+	_, hasFile := field.Tag.Lookup("file")
+
+	return field.Type.Kind() == reflect.Struct && !hasEnv && !hasFile &&
+		!reflect.PointerTo(field.Type).Implements(textUnmarshalerType)
+}
+
+// lookup returns the value of the field with the highest precedence.
+func (l *configLoader) lookup(field reflect.StructField) (string, string, bool) {
+	if key, ok := field.Tag.Lookup("env"); ok {
+		if value, ok := os.LookupEnv(l.envPrefix + key); ok {
+			return value, l.envPrefix + key, true
+		}
+	}
+
+	if key, ok := field.Tag.Lookup("file"); ok && l.file != nil {
+		if value, ok := lookupFileKey(l.file, key); ok {
+			return value, key, true
+		}
+	}
+
+	if value, ok := field.Tag.Lookup("default"); ok {
+		return value, "default", true
+	}
+
+	return "", "", false
+}
+
+func (l *configLoader) sources(field reflect.StructField) string {
+	var sources []string
+	if key, ok := field.Tag.Lookup("env"); ok {
+		sources = append(sources, l.envPrefix+key)
+	}
+	if key, ok := field.Tag.Lookup("file"); ok {
+		sources = append(sources, key)
+	}
+	return strings.Join(sources, ", ")
+}
+
+func lookupFileKey(file map[string]any, key string) (string, bool) {
+	var value any = file
+	for _, part := range strings.Split(key, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if value, ok = m[part]; !ok {
+			return "", false
+		}
+	}
+
+	switch value := value.(type) {
+	case nil:
+		return "", false
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ","), true
+	case float64:
+		// JSON numbers, formatted without exponent so they parse as integers
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	default:
+		return fmt.Sprint(value), true
+	}
+}
+
+func setConfigValue(v reflect.Value, raw string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(raw))
+	}
+
+	if v.Type() == reflect.TypeFor[time.Duration]() {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		if raw != "" {
+			items = strings.Split(raw, ",")
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setConfigValue(slice.Index(i), strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// ServiceConfig holds the settings of the built-in options, embed it in the service config struct
+// passed to WithConfig to configure them from the environment or the config file.
+type ServiceConfig struct {
+	// TechAddr is the address of the tech server, empty means no tech server.
+	TechAddr string `env:"TECH_ADDR" file:"tech.addr"`
+	// GRPCAddr is the address of a gRPC server, empty means no gRPC server.
+	GRPCAddr    string `env:"GRPC_ADDR" file:"grpc.addr"`
+	DatabaseURL string `env:"DATABASE_URL" file:"database.url"`
+	LogLevel    string `env:"LOG_LEVEL" file:"log.level" default:"info"`
+}
+
+func (c *ServiceConfig) serviceConfig() *ServiceConfig {
+	return c
+}
+
+type serviceConfigurer interface {
+	serviceConfig() *ServiceConfig
+}
+
+func (c *ServiceConfig) apply(s *Service) error {
+	level, err := zerolog.ParseLevel(c.LogLevel)
+	if err != nil {
+		return &ConfigError{Field: "LogLevel", Err: fmt.Errorf("%w: %w", ErrConfigInvalid, err)}
+	}
+	zerolog.SetGlobalLevel(level)
+
+	if c.TechAddr != "" {
+		if err := (TechHTTPServerOption{address: c.TechAddr}).Apply(s); err != nil {
+			return err
+		}
+	}
+
+	if c.GRPCAddr != "" {
+		if err := (GRPCServerOption{address: c.GRPCAddr}).Apply(s); err != nil {
+			return err
+		}
+	}
+
+	if c.DatabaseURL != "" {
+		poolConfig, err := pgxpool.ParseConfig(c.DatabaseURL)
+		if err != nil {
+			return &ConfigError{Field: "DatabaseURL", Err: fmt.Errorf("%w: %w", ErrConfigInvalid, err)}
+		}
+		if err := (DBOption{cfg: *poolConfig}).Apply(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type ConfigOption struct {
+	cfg  any
+	opts []ConfigOpt
+}
+
+func (w ConfigOption) Apply(s *Service) error {
+	if err := LoadConfig(w.cfg, w.opts...); err != nil {
+		return err
+	}
+
+	if c, ok := w.cfg.(serviceConfigurer); ok {
+		if err := c.serviceConfig().apply(s); err != nil {
+			return err
+		}
+	}
+
+	return w.applyReload(s)
+}
+
+// WithConfig loads cfg with LoadConfig, New fails on invalid config. When cfg embeds ServiceConfig,
+// the tech server, gRPC server, database and log level are configured from it, so the matching
+// With options must not be passed as well. The config is reloaded on ConfigReloadSignal and
+// ConfigWatch, see Service.Config.
+func WithConfig(cfg any, opts ...ConfigOpt) Option {
+	return ConfigOption{cfg: cfg, opts: opts}
+}
@@ -0,0 +1,285 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const configWatcherName = "config-watcher"
+
+// ConfigReloadFunc applies a reloaded config, cfg is a pointer of the type passed to WithConfig and
+// changed lists the paths of the fields which changed, e.g. "LogLevel" or "DB.DSN".
+type ConfigReloadFunc func(ctx context.Context, cfg any, changed []string) error
+
+// ConfigReloadSignal reloads the config when the process receives sig, e.g. syscall.SIGHUP.
+func ConfigReloadSignal(sig os.Signal) ConfigOpt {
+	return func(l *configLoader) { l.reloadSignal = sig }
+}
+
+// ConfigWatch reloads the config when the modification time of the ConfigFile changes, checked every interval.
+func ConfigWatch(interval time.Duration) ConfigOpt {
+	return func(l *configLoader) { l.watch = interval }
+}
+
+// ConfigReloader reloads the config passed to WithConfig and notifies the callbacks of the changed
+// fields. The struct given to WithConfig keeps the values loaded by New, Current returns the latest ones.
+type ConfigReloader struct {
+	typ     reflect.Type
+	opts    []ConfigOpt
+	path    string
+	watch   time.Duration
+	signal  os.Signal
+	current atomic.Value
+
+	callbacks []ConfigReloadFunc
+	reloadMu  sync.Mutex
+
+	reloads *prometheus.CounterVec
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func newConfigReloader(cfg any, opts []ConfigOpt) *ConfigReloader {
+	l := &configLoader{}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	r := &ConfigReloader{
+		typ:    reflect.TypeOf(cfg).Elem(),
+		opts:   opts,
+		path:   l.path,
+		watch:  l.watch,
+		signal: l.reloadSignal,
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "config_reloads_total",
+			Help: "Number of config reloads by result: applied, unchanged, or failed.",
+		}, []string{"result"}),
+		done: make(chan struct{}),
+	}
+
+	// the snapshot is a copy, the callbacks must not race with readers of the initial struct
+	snapshot := reflect.New(r.typ)
+	snapshot.Elem().Set(reflect.ValueOf(cfg).Elem())
+	r.current.Store(snapshot.Interface())
+
+	return r
+}
+
+// Current returns the latest loaded config, a pointer of the type passed to WithConfig.
+func (r *ConfigReloader) Current() any {
+	return r.current.Load()
+}
+
+// OnReload registers a callback run after each reload changing at least one field, in registration order.
+// Current returns the previous config until every callback succeeded.
+func (r *ConfigReloader) OnReload(fn ConfigReloadFunc) {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	r.callbacks = append(r.callbacks, fn)
+}
+
+// Reload loads the config again and runs the callbacks when it changed. An invalid config, or one
+// a callback fails to apply, is rejected and the current one is kept.
+func (r *ConfigReloader) Reload(ctx context.Context) error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	next := reflect.New(r.typ).Interface()
+	if err := LoadConfig(next, r.opts...); err != nil {
+		r.reloads.WithLabelValues("failed").Inc()
+		log.Error().Err(err).Msg("failed to reload config, keeping the current one")
+		return err
+	}
+
+	changed := diffConfig(r.Current(), next)
+	if len(changed) == 0 {
+		r.reloads.WithLabelValues("unchanged").Inc()
+		log.Debug().Msg("config reloaded, nothing changed")
+		return nil
+	}
+
+	var errs []error
+	for _, fn := range r.callbacks {
+		if err := fn(ctx, next, changed); err != nil {
+			log.Error().Err(err).Strs("changed", changed).Msg("config reload callback failed")
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		r.reloads.WithLabelValues("failed").Inc()
+		log.Error().Strs("changed", changed).Msg("failed to apply the reloaded config, keeping the current one")
+		return err
+	}
+
+	r.current.Store(next)
+	r.reloads.WithLabelValues("applied").Inc()
+	log.Info().Strs("changed", changed).Msg("config reloaded")
+	return nil
+}
+
+// diffConfig returns the sorted paths of the fields which differ between a and b.
+func diffConfig(a, b any) []string {
+	before, after := make(map[string]any), make(map[string]any)
+	flattenConfig(reflect.ValueOf(a).Elem(), "", before)
+	flattenConfig(reflect.ValueOf(b).Elem(), "", after)
+
+	var changed []string
+	for name, value := range after {
+		if !reflect.DeepEqual(before[name], value) {
+			changed = append(changed, name)
+		}
+	}
+
+	slices.Sort(changed)
+	return changed
+}
+
+// flattenConfig collects the leaf fields loaded by LoadConfig, named as in ConfigError.Field.
+func flattenConfig(v reflect.Value, prefix string, values map[string]any) {
+	t := v.Type()
+	for i := range t.NumField() {
+		field, fv := t.Field(i), v.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := prefix + field.Name
+
+		if isNestedConfig(field) {
+			nested := name + "."
+			if field.Anonymous {
+				nested = prefix
+			}
+			flattenConfig(fv, nested, values)
+			continue
+		}
+
+		values[name] = fv.Interface()
+	}
+}
+
+func (r *ConfigReloader) Name() string {
+	return configWatcherName
+}
+
+func (r *ConfigReloader) Ready() bool {
+	return true
+}
+
+// Run reloads the config whenever the modification time of the file changes.
+func (r *ConfigReloader) Run(ctx context.Context) error {
+	r.mu.Lock()
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.running.Store(true)
+	r.mu.Unlock()
+	defer close(r.done)
+
+	var modTime time.Time
+	if info, err := os.Stat(r.path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(r.watch)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(r.path)
+		if err != nil {
+			log.Warn().Err(err).Str("path", r.path).Msg("failed to stat config file")
+			continue
+		}
+		if info.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+
+		// errors are logged by Reload, the watcher keeps running
+		_ = r.Reload(ctx)
+	}
+}
+
+func (r *ConfigReloader) Close() error {
+	r.mu.Lock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.mu.Unlock()
+
+	if r.running.Load() {
+		<-r.done
+	}
+
+	return nil
+}
+
+// reloadServiceConfig applies the log level of a reloaded ServiceConfig, the servers and the database
+// are only configured at startup.
+func reloadServiceConfig(_ context.Context, cfg any, changed []string) error {
+	next := cfg.(serviceConfigurer).serviceConfig()
+	for _, name := range changed {
+		switch name {
+		case "LogLevel":
+			level, err := zerolog.ParseLevel(next.LogLevel)
+			if err != nil {
+				return &ConfigError{Field: name, Err: fmt.Errorf("%w: %w", ErrConfigInvalid, err)}
+			}
+			zerolog.SetGlobalLevel(level)
+			log.Info().Str("level", level.String()).Msg("log level changed")
+		case "TechAddr", "GRPCAddr", "DatabaseURL":
+			log.Warn().Str("field", name).Msg("config change requires a restart")
+		}
+	}
+
+	return nil
+}
+
+func (w ConfigOption) applyReload(s *Service) error {
+	r := newConfigReloader(w.cfg, w.opts)
+	r.reloads = registerCollector(s.registry, r.reloads)
+
+	if _, ok := w.cfg.(serviceConfigurer); ok {
+		r.OnReload(reloadServiceConfig)
+	}
+
+	if r.watch > 0 {
+		if r.path == "" {
+			return errors.New("config watch requires a config file")
+		}
+		if err := s.RegisterSubService(r); err != nil {
+			return err
+		}
+	}
+
+	if r.signal != nil {
+		err := SignalHandlerOption{sig: r.signal, handler: func(ctx context.Context, _ os.Signal) error {
+			return r.Reload(ctx)
+		}}.Apply(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.Config = r
+	return nil
+}
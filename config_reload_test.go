@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestConfigReload(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		wantLevel string
+		wantErr   bool
+	}{
+		{"changed level", `{"log":{"level":"debug"}}`, "debug", false},
+		{"unchanged", `{"log":{"level":"info"}}`, "info", false},
+		{"bad level", `{"log":{"level":"loud"}}`, "info", true},
+		{"bad file", `{"log":`, "info", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level := zerolog.GlobalLevel()
+			t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(`{"log":{"level":"info"}}`), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg := &ServiceConfig{}
+			if err := LoadConfig(cfg, ConfigFile(path)); err != nil {
+				t.Fatal(err)
+			}
+			r := newConfigReloader(cfg, []ConfigOpt{ConfigFile(path)})
+			r.OnReload(reloadServiceConfig)
+
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Reload(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got := r.Current().(*ServiceConfig).LogLevel; got != tt.wantLevel {
+				t.Errorf("current level = %q, want %q", got, tt.wantLevel)
+			}
+		})
+	}
+}
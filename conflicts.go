@@ -0,0 +1,137 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// listenAddr is an address a server listens on over TCP.
+type listenAddr struct {
+	server string
+	host   string
+	port   string
+}
+
+func isWildcardHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
+func (a listenAddr) overlaps(b listenAddr) bool {
+	return a.port == b.port && (a.host == b.host || isWildcardHost(a.host) || isWildcardHost(b.host))
+}
+
+// listenAddrs returns the addresses of the HTTP, gRPC and TCP servers, servers on port 0 never overlap.
+func (s *Service) listenAddrs() ([]listenAddr, error) {
+	servers := make(map[string]string)
+	var order []string
+	add := func(server, addr string) {
+		servers[server] = addr
+		order = append(order, server)
+	}
+
+	for i, httpServer := range s.HTTPServers {
+		if httpServer == nil {
+			continue
+		}
+		addr := httpServer.Addr
+		if addr == "" {
+			addr = ":http"
+		}
+		name := fmt.Sprintf("http server %d (%s)", i, addr)
+		switch {
+		case httpServer == s.techServer:
+			name = fmt.Sprintf("tech server (%s)", addr)
+		case httpServer == s.debugServer:
+			name = fmt.Sprintf("debug server (%s)", addr)
+		}
+		add(name, addr)
+	}
+	for _, grpcServer := range s.GRPCServers {
+		add(fmt.Sprintf("grpc server (%s)", grpcServer.address), grpcServer.address)
+	}
+	subServices := s.subServices()
+	for _, name := range slices.Sorted(maps.Keys(subServices)) {
+		if tcpServer, ok := subServices[name].(*TCPServer); ok {
+			add(fmt.Sprintf("tcp server (%s)", tcpServer.addr), tcpServer.addr)
+		}
+	}
+
+	var addrs []listenAddr
+	for _, server := range order {
+		host, port, err := net.SplitHostPort(servers[server])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid address: %w", server, err)
+		}
+		portNum, err := net.LookupPort("tcp", port)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid port: %w", server, err)
+		}
+		if portNum == 0 {
+			continue
+		}
+		addrs = append(addrs, listenAddr{server: server, host: host, port: fmt.Sprint(portNum)})
+	}
+	return addrs, nil
+}
+
+// routeConflicts returns the routes of a chi router registered more than once, e.g. a route shadowing
+// one of a router mounted on a parent path. chi serves one of them without complaining.
+func routeConflicts(routes chi.Routes) []string {
+	seen := make(map[string]int)
+	_ = chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		seen[method+" "+route]++
+		return nil
+	})
+
+	var conflicts []string
+	for route, n := range seen {
+		if n > 1 {
+			conflicts = append(conflicts, route)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// checkConflicts reports the servers listening on overlapping addresses and the ambiguous routes of chi
+// routers, which would otherwise surface at runtime as a failed bind or a silently shadowed route.
+func (s *Service) checkConflicts() error {
+	addrs, err := s.listenAddrs()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, a := range addrs {
+		for _, b := range addrs[i+1:] {
+			if a.overlaps(b) {
+				errs = append(errs, fmt.Errorf("%s and %s listen on overlapping addresses", a.server, b.server))
+			}
+		}
+	}
+
+	for i, httpServer := range s.HTTPServers {
+		if httpServer == nil {
+			continue
+		}
+		routes, ok := httpServer.Handler.(chi.Routes)
+		if !ok {
+			continue
+		}
+		for _, route := range routeConflicts(routes) {
+			errs = append(errs, fmt.Errorf("http server %d (%s): route %s is registered more than once", i, httpServer.Addr, route))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("conflicting servers: %w", err)
+	}
+	return nil
+}
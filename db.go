@@ -0,0 +1,47 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultDBName is the registry name of the pool exposed as Service.DB.
+const DefaultDBName = "default"
+
+var ErrDBNotFound = errors.New("db pool not found")
+
+// DBTX is satisfied by *pgxpool.Pool, *pgx.Conn, pgx.Tx and *DBRouter, so helpers work inside and outside transactions.
+type DBTX interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// NamedDB returns the pool registered under name with WithNamedDB, or the default pool for DefaultDBName.
+func (s *Service) NamedDB(name string) (*pgxpool.Pool, error) {
+	db, ok := s.DBs[name]
+	if !ok {
+		return nil, requireOption(fmt.Errorf("%w: %s", ErrDBNotFound, name), DBOption{}, NamedDBOption{})
+	}
+
+	return db, nil
+}
+
+func (s *Service) addDB(name string, db *pgxpool.Pool) error {
+	if _, ok := s.DBs[name]; ok {
+		return fmt.Errorf("db pool %q already registered", name)
+	}
+
+	s.DBs[name] = db
+	s.traceBudget(name, db)
+	if name == DefaultDBName {
+		s.DB = db
+	}
+
+	return nil
+}
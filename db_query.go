@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dbQueryDuration is shared by the services of the process since the query helpers are not bound to one,
+// each service registers it.
+var dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "db_query_duration_seconds",
+	Help:    "Duration of the queries made with the query helpers, by result type and result: success, no_rows or error.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"type", "result"})
+
+// rowMapper scans structs by column name, matching the field names or their db tags, and other types,
+// e.g. int64, time.Time, pgtype values or pointers for nullable columns, from the single column of the row.
+func rowMapper[T any]() pgx.RowToFunc[T] {
+	t := reflect.TypeFor[T]()
+	scanner := reflect.TypeFor[sql.Scanner]()
+	if t.Kind() == reflect.Struct && t != reflect.TypeFor[time.Time]() && !reflect.PointerTo(t).Implements(scanner) {
+		return pgx.RowToStructByName[T]
+	}
+	return pgx.RowTo[T]
+}
+
+func observeQuery[T any](start time.Time, err error) {
+	result := "success"
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		result = "no_rows"
+	case err != nil:
+		result = "error"
+	}
+	dbQueryDuration.WithLabelValues(reflect.TypeFor[T]().String(), result).Observe(time.Since(start).Seconds())
+}
+
+// Query runs the query and maps its rows to T, an empty slice when there are none. NULL values need
+// nullable fields: pointers or pgtype values.
+//
+//	orders, err := app.Query[Order](ctx, service.DB, "SELECT id, total, paid_at FROM orders WHERE customer = $1", id)
+func Query[T any](ctx context.Context, db DBTX, sql string, args ...any) (items []T, err error) {
+	defer func(start time.Time) { observeQuery[T](start, err) }(time.Now())
+
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %T: %w", *new(T), err)
+	}
+	if items, err = pgx.CollectRows(rows, rowMapper[T]()); err != nil {
+		return nil, fmt.Errorf("failed to scan %T: %w", *new(T), err)
+	}
+	if items == nil {
+		items = []T{}
+	}
+
+	return items, nil
+}
+
+// QueryOne maps the single row of the query to T. It returns an error wrapping pgx.ErrNoRows when there
+// is none, and pgx.ErrTooManyRows when there are more.
+func QueryOne[T any](ctx context.Context, db DBTX, sql string, args ...any) (item T, err error) {
+	defer func(start time.Time) { observeQuery[T](start, err) }(time.Now())
+
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return item, fmt.Errorf("failed to query %T: %w", item, err)
+	}
+	if item, err = pgx.CollectExactlyOneRow(rows, rowMapper[T]()); err != nil {
+		return item, fmt.Errorf("failed to scan %T: %w", item, err)
+	}
+
+	return item, nil
+}
+
+// QueryOptional is QueryOne returning nil when there is no row.
+func QueryOptional[T any](ctx context.Context, db DBTX, sql string, args ...any) (*T, error) {
+	item, err := QueryOne[T](ctx, db, sql, args...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// QueryMap is Query indexing the items by key, the last item wins for duplicate keys.
+func QueryMap[K comparable, T any](ctx context.Context, db DBTX, key func(T) K, sql string, args ...any) (map[K]T, error) {
+	items, err := Query[T](ctx, db, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[K]T, len(items))
+	for _, item := range items {
+		m[key(item)] = item
+	}
+	return m, nil
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dependencyMonitorName     = "dependencies"
+	defaultDependencyInterval = 5 * time.Second
+)
+
+// Pauser is implemented by subservices which can stop their work while a dependency is unhealthy,
+// e.g. KafkaConsumer stops fetching. A paused subservice is reported "paused" by the readiness check
+// and doesn't make the instance not ready.
+type Pauser interface {
+	Pause()
+	Resume()
+	Paused() bool
+}
+
+// DependencyMonitor pauses the subservices declared with WithDependency while one of their
+// dependencies is unhealthy, and resumes them once all of them recovered.
+type DependencyMonitor struct {
+	svc      *Service
+	deps     map[string][]string
+	interval time.Duration
+
+	pausedGauge *prometheus.GaugeVec
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func newDependencyMonitor(s *Service) *DependencyMonitor {
+	return &DependencyMonitor{
+		svc:      s,
+		deps:     make(map[string][]string),
+		interval: defaultDependencyInterval,
+		pausedGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "component_paused",
+			Help: "1 while the component is paused because a dependency is unhealthy.",
+		}, []string{"component"}),
+		done: make(chan struct{}),
+	}
+}
+
+func (m *DependencyMonitor) Name() string {
+	return dependencyMonitorName
+}
+
+func (m *DependencyMonitor) Ready() bool {
+	return true
+}
+
+// validate resolves the declared names once every option is applied.
+func (m *DependencyMonitor) validate(ctx context.Context) (map[string]Pauser, error) {
+	known := make(map[string]struct{})
+	for _, component := range m.svc.checkComponents(ctx) {
+		known[component.Name] = struct{}{}
+	}
+
+	pausers := make(map[string]Pauser, len(m.deps))
+	var errs []error
+	for name, deps := range m.deps {
+		p, err := GetSubService[Pauser](m.svc, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("component %s can't be paused: %w", name, err))
+			continue
+		}
+		pausers[name] = p
+
+		for _, dep := range deps {
+			if _, ok := known[dep]; !ok {
+				errs = append(errs, fmt.Errorf("unknown dependency %s of %s", dep, name))
+			}
+		}
+	}
+
+	return pausers, errors.Join(errs...)
+}
+
+func (m *DependencyMonitor) Run(ctx context.Context) error {
+	m.mu.Lock()
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.running.Store(true)
+	m.mu.Unlock()
+	defer close(m.done)
+
+	pausers, err := m.validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.check(ctx, pausers)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *DependencyMonitor) check(ctx context.Context, pausers map[string]Pauser) {
+	// a paused dependency is unhealthy for its own dependents
+	unhealthy := make(map[string]string)
+	for _, component := range m.svc.checkComponents(ctx) {
+		switch {
+		case component.failed:
+			unhealthy[component.Name] = component.Error
+		case component.Status == componentPaused:
+			unhealthy[component.Name] = componentPaused
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	for name, p := range pausers {
+		i := slices.IndexFunc(m.deps[name], func(dep string) bool {
+			_, ok := unhealthy[dep]
+			return ok
+		})
+
+		switch {
+		case i >= 0 && !p.Paused():
+			dep := m.deps[name][i]
+			p.Pause()
+			m.pausedGauge.WithLabelValues(name).Set(1)
+			log.Warn().Str("component", name).Str("dependency", dep).Str("error", unhealthy[dep]).
+				Msg("dependency unhealthy, component paused")
+		case i < 0 && p.Paused():
+			p.Resume()
+			m.pausedGauge.WithLabelValues(name).Set(0)
+			log.Info().Str("component", name).Msg("dependencies recovered, component resumed")
+		}
+	}
+}
+
+func (m *DependencyMonitor) Close() error {
+	m.mu.Lock()
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.mu.Unlock()
+
+	if m.running.Load() {
+		<-m.done
+	}
+
+	return nil
+}
+
+type DependencyOption struct {
+	component string
+	deps      []string
+}
+
+func (w DependencyOption) Apply(s *Service) error {
+	if len(w.deps) == 0 {
+		return fmt.Errorf("no dependency declared for %s", w.component)
+	}
+
+	m, err := GetSubService[*DependencyMonitor](s, dependencyMonitorName)
+	if err != nil {
+		m = newDependencyMonitor(s)
+		m.pausedGauge = registerCollector(s.registry, m.pausedGauge)
+		if err := s.RegisterSubService(m); err != nil {
+			return err
+		}
+	}
+	m.deps[w.component] = append(m.deps[w.component], w.deps...)
+
+	return nil
+}
+
+// WithDependency pauses the subservice named component, e.g. "kafka-consumer-orders", while one of deps
+// is unhealthy and resumes it on recovery. Dependencies are named as in the /health/ready report:
+// "database", "database_<name>", subservice and health check names. The subservice must implement
+// Pauser, Start fails on unknown names.
+func WithDependency(component string, deps ...string) Option {
+	return DependencyOption{component: component, deps: deps}
+}
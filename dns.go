@@ -0,0 +1,337 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	defaultDNSRefreshInterval = 30 * time.Second
+	dnsLookupTimeout          = 5 * time.Second
+
+	// DNSRefreshScheme is the gRPC target scheme handled by DNSRefresher.GRPCResolver, e.g. "dnsrefresh:///orders:9090".
+	DNSRefreshScheme = "dnsrefresh"
+)
+
+// DNSRefresher periodically re-resolves outbound hosts and notifies watchers when their addresses change,
+// so long-lived clients move to new IPs behind a service DNS name without a restart.
+type DNSRefresher struct {
+	interval time.Duration
+	resolver *net.Resolver
+
+	watchMu  sync.Mutex
+	watchers map[string]map[int]func([]string)
+	addrs    map[string][]string
+	nextID   int
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewDNSRefresher(interval time.Duration) *DNSRefresher {
+	if interval == 0 {
+		interval = defaultDNSRefreshInterval
+	}
+
+	return &DNSRefresher{
+		interval: interval,
+		resolver: net.DefaultResolver,
+		watchers: make(map[string]map[int]func([]string)),
+		addrs:    make(map[string][]string),
+		done:     make(chan struct{}),
+	}
+}
+
+func (r *DNSRefresher) Name() string {
+	return "dns-refresher"
+}
+
+func (r *DNSRefresher) Ready() bool {
+	return true
+}
+
+func (r *DNSRefresher) Run(ctx context.Context) error {
+	r.mu.Lock()
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.running.Store(true)
+	r.mu.Unlock()
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.refresh(ctx)
+		}
+	}
+}
+
+func (r *DNSRefresher) Close() error {
+	r.mu.Lock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.mu.Unlock()
+
+	if r.running.Load() {
+		<-r.done
+	}
+	return nil
+}
+
+// Watch calls onChange with the new addresses every time the resolution of host changes.
+// The returned function stops watching.
+func (r *DNSRefresher) Watch(host string, onChange func(addrs []string)) (unwatch func()) {
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+
+	if r.watchers[host] == nil {
+		r.watchers[host] = make(map[int]func([]string))
+	}
+	id := r.nextID
+	r.nextID++
+	r.watchers[host][id] = onChange
+
+	return func() {
+		r.watchMu.Lock()
+		defer r.watchMu.Unlock()
+
+		delete(r.watchers[host], id)
+		if len(r.watchers[host]) == 0 {
+			delete(r.watchers, host)
+			delete(r.addrs, host)
+		}
+	}
+}
+
+// Lookup resolves host and, when it is watched, records the result as its known addresses, notifying
+// the watchers if they changed.
+func (r *DNSRefresher) Lookup(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+
+	addrs, err := r.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(addrs)
+
+	r.watchMu.Lock()
+	previous := r.addrs[host]
+	if len(r.watchers[host]) == 0 || slices.Equal(previous, addrs) {
+		r.watchMu.Unlock()
+		return addrs, nil
+	}
+	r.addrs[host] = addrs
+	callbacks := make([]func([]string), 0, len(r.watchers[host]))
+	for _, onChange := range r.watchers[host] {
+		callbacks = append(callbacks, onChange)
+	}
+	r.watchMu.Unlock()
+
+	// the first resolution of the hosts never looked up is passed to the watchers as their initial state
+	if previous != nil {
+		log.Info().Str("host", host).Strs("previous", previous).Strs("current", addrs).Msg("dns resolution changed")
+	}
+	for _, onChange := range callbacks {
+		onChange(addrs)
+	}
+	return addrs, nil
+}
+
+func (r *DNSRefresher) refresh(ctx context.Context) {
+	r.watchMu.Lock()
+	hosts := make([]string, 0, len(r.watchers))
+	for host := range r.watchers {
+		hosts = append(hosts, host)
+	}
+	r.watchMu.Unlock()
+
+	for _, host := range hosts {
+		if _, err := r.Lookup(ctx, host); err != nil {
+			log.Warn().Err(err).Str("host", host).Msg("dns re-resolution failed, keeping previous addresses")
+		}
+	}
+}
+
+// Transport wraps base so that connections are recycled when the address of any host it talked to
+// changes: the idle ones at once, the active ones once their response body is closed. New requests
+// then dial the fresh addresses.
+func (r *DNSRefresher) Transport(base *http.Transport) http.RoundTripper {
+	return &dnsRefreshTransport{base: base, refresher: r, hosts: make(map[string][]string)}
+}
+
+type dnsRefreshTransport struct {
+	base      *http.Transport
+	refresher *DNSRefresher
+	mu        sync.Mutex
+	// hosts are the addresses of the hosts watched, nil until their first resolution
+	hosts   map[string][]string
+	changes atomic.Uint64
+}
+
+func (t *dnsRefreshTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+
+	t.mu.Lock()
+	if _, ok := t.hosts[host]; !ok && net.ParseIP(host) == nil {
+		t.hosts[host] = nil
+		t.refresher.Watch(host, func(addrs []string) {
+			t.changed(host, addrs)
+		})
+	}
+	t.mu.Unlock()
+
+	changes := t.changes.Load()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		return resp, err
+	}
+
+	resp.Body = &dnsRefreshBody{ReadCloser: resp.Body, transport: t, changes: changes}
+	return resp, nil
+}
+
+// changed recycles the idle connections once the addresses of host changed, the first resolution
+// being the addresses the connections were dialed to.
+func (t *dnsRefreshTransport) changed(host string, addrs []string) {
+	t.mu.Lock()
+	previous := t.hosts[host]
+	t.hosts[host] = addrs
+	t.mu.Unlock()
+
+	if previous == nil {
+		return
+	}
+	t.changes.Add(1)
+	t.base.CloseIdleConnections()
+}
+
+// dnsRefreshBody recycles the connection of a response in flight when the addresses changed, once
+// the body is closed and the connection is idle.
+type dnsRefreshBody struct {
+	io.ReadCloser
+	transport *dnsRefreshTransport
+	changes   uint64
+}
+
+func (b *dnsRefreshBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.transport.changes.Load() != b.changes {
+		b.transport.base.CloseIdleConnections()
+	}
+	return err
+}
+
+func (t *dnsRefreshTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
+
+// GRPCResolver returns a resolver for DNSRefreshScheme targets, pass it with grpc.WithResolvers.
+// Address changes are pushed to the client connection, which then connects to the new
+// addresses and drops the removed ones.
+func (r *DNSRefresher) GRPCResolver() resolver.Builder {
+	return dnsRefreshBuilder{refresher: r}
+}
+
+type dnsRefreshBuilder struct {
+	refresher *DNSRefresher
+}
+
+func (b dnsRefreshBuilder) Scheme() string {
+	return DNSRefreshScheme
+}
+
+func (b dnsRefreshBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	host, port, err := net.SplitHostPort(target.Endpoint())
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s target %q: %w", DNSRefreshScheme, target.Endpoint(), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	res := &dnsRefreshResolver{refresher: b.refresher, cc: cc, host: host, port: port, ctx: ctx, cancel: cancel}
+	res.unwatch = b.refresher.Watch(host, res.update)
+	res.ResolveNow(resolver.ResolveNowOptions{})
+
+	return res, nil
+}
+
+type dnsRefreshResolver struct {
+	refresher *DNSRefresher
+	cc        resolver.ClientConn
+	host      string
+	port      string
+	unwatch   func()
+
+	// ctx is canceled by Close, the lookups in flight are waited for
+	ctx     context.Context
+	cancel  context.CancelFunc
+	lookups sync.WaitGroup
+}
+
+// ResolveNow looks the host up in the background, gRPC calling it with its own locks held.
+func (r *dnsRefreshResolver) ResolveNow(resolver.ResolveNowOptions) {
+	r.lookups.Add(1)
+	goRecover("dns resolver "+r.host, nil, func() {
+		defer r.lookups.Done()
+		r.resolve(r.ctx)
+	})
+}
+
+func (r *dnsRefreshResolver) resolve(ctx context.Context) {
+	addrs, err := r.refresher.Lookup(ctx, r.host)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.cc.ReportError(err)
+		}
+		return
+	}
+	r.update(addrs)
+}
+
+func (r *dnsRefreshResolver) update(addrs []string) {
+	state := resolver.State{Addresses: make([]resolver.Address, 0, len(addrs))}
+	for _, addr := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: net.JoinHostPort(addr, r.port)})
+	}
+
+	if err := r.cc.UpdateState(state); err != nil {
+		log.Debug().Err(err).Str("host", r.host).Msg("grpc resolver state update rejected")
+	}
+}
+
+func (r *dnsRefreshResolver) Close() {
+	r.unwatch()
+	r.cancel()
+	r.lookups.Wait()
+}
+
+type DNSRefreshOption struct {
+	interval time.Duration
+}
+
+func (w DNSRefreshOption) Apply(s *Service) error {
+	s.DNSRefresher = NewDNSRefresher(w.interval)
+	return s.RegisterSubService(s.DNSRefresher)
+}
+
+// WithDNSRefresh re-resolves outbound hosts every interval, see DNSRefresher.Transport and DNSRefresher.GRPCResolver.
+func WithDNSRefresh(interval time.Duration) Option {
+	return DNSRefreshOption{interval: interval}
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc"
+)
+
+// PendingReporter is implemented by subservices with queued or running work which completes during
+// shutdown, reported by /drain/status.
+type PendingReporter interface {
+	Pending() int
+}
+
+// DrainStatus is the progress of the shutdown served by /drain/status on the tech server.
+type DrainStatus struct {
+	Draining  bool      `json:"draining"`
+	Phase     string    `json:"phase,omitempty"`
+	StartedAt time.Time `json:"started_at,omitzero"`
+	// Deadline is when the shutdown is forced.
+	Deadline         time.Time      `json:"deadline,omitzero"`
+	InFlightRequests int64          `json:"in_flight_requests"`
+	InFlightRPCs     int64          `json:"in_flight_rpcs"`
+	OpenStreams      int64          `json:"open_streams"`
+	PendingJobs      map[string]int `json:"pending_jobs,omitempty"`
+	// EstimatedCompletion extrapolates the rate the work completed at since the drain started,
+	// bounded by the drain delay and the deadline.
+	EstimatedCompletion time.Time `json:"estimated_completion,omitzero"`
+}
+
+// inFlightTracker counts the work in progress on the servers.
+type inFlightTracker struct {
+	requests atomic.Int64
+	rpcs     atomic.Int64
+	streams  atomic.Int64
+
+	mu        sync.Mutex
+	phase     string
+	startedAt time.Time
+	initial   int
+}
+
+func (t *inFlightTracker) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.requests.Add(1)
+		defer t.requests.Add(-1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Service) drainInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	s.inFlight.rpcs.Add(1)
+	defer s.inFlight.rpcs.Add(-1)
+
+	return handler(ctx, req)
+}
+
+func (s *Service) drainStreamInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	s.inFlight.streams.Add(1)
+	defer s.inFlight.streams.Add(-1)
+
+	return handler(srv, ss)
+}
+
+func (s *Service) pendingJobs() map[string]int {
+	pending := make(map[string]int)
+	for name, subService := range s.subServices() {
+		if r, ok := subService.(PendingReporter); ok {
+			pending[name] = r.Pending()
+		}
+	}
+	return pending
+}
+
+func (s *Service) remainingWork() (DrainStatus, int) {
+	status := DrainStatus{
+		InFlightRequests: s.inFlight.requests.Load(),
+		InFlightRPCs:     s.inFlight.rpcs.Load(),
+		OpenStreams:      s.inFlight.streams.Load(),
+		PendingJobs:      s.pendingJobs(),
+	}
+
+	remaining := int(status.InFlightRequests + status.InFlightRPCs + status.OpenStreams)
+	for _, n := range status.PendingJobs {
+		remaining += n
+	}
+	return status, remaining
+}
+
+// startDrain records the work in progress when the shutdown starts, the baseline of the estimate.
+func (s *Service) startDrain() {
+	_, remaining := s.remainingWork()
+
+	s.inFlight.mu.Lock()
+	defer s.inFlight.mu.Unlock()
+
+	s.inFlight.startedAt = time.Now()
+	s.inFlight.initial = remaining
+}
+
+func (s *Service) DrainStatus() DrainStatus {
+	status, remaining := s.remainingWork()
+
+	s.inFlight.mu.Lock()
+	status.Phase = s.inFlight.phase
+	status.StartedAt = s.inFlight.startedAt
+	initial := s.inFlight.initial
+	s.inFlight.mu.Unlock()
+
+	if status.StartedAt.IsZero() {
+		return status
+	}
+	status.Draining = true
+	status.Deadline = status.StartedAt.Add(s.drainDelay + s.stopTimeout)
+
+	now := time.Now()
+	elapsed := now.Sub(status.StartedAt)
+	switch {
+	case remaining == 0:
+		status.EstimatedCompletion = now
+	case initial > remaining && elapsed > 0:
+		rate := float64(initial-remaining) / elapsed.Seconds()
+		status.EstimatedCompletion = now.Add(time.Duration(float64(remaining) / rate * float64(time.Second)))
+	default:
+		status.EstimatedCompletion = status.Deadline
+	}
+
+	// servers keep serving for the drain delay, and the shutdown is forced at the deadline
+	if drained := status.StartedAt.Add(s.drainDelay); status.EstimatedCompletion.Before(drained) {
+		status.EstimatedCompletion = drained
+	}
+	if status.EstimatedCompletion.After(status.Deadline) {
+		status.EstimatedCompletion = status.Deadline
+	}
+
+	return status
+}
+
+func (s *Service) registerDrainStatus(r chi.Router) {
+	r.Get("/drain/status", func(w http.ResponseWriter, _ *http.Request) {
+		status := s.DrainStatus()
+		if len(status.PendingJobs) == 0 {
+			status.PendingJobs = nil
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status)
+	})
+}
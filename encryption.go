@@ -0,0 +1,241 @@
+package app
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	envelopeVersion = 1
+
+	dataKeySize             = 32
+	defaultDataKeyTTL       = 5 * time.Minute
+	maxCachedDecryptionKeys = 1024
+)
+
+var ErrInvalidEnvelope = errors.New("invalid encrypted payload")
+
+// DataKeyProvider issues data keys wrapped by a master key held by a key management service.
+type DataKeyProvider interface {
+	// GenerateDataKey returns a new data key in plaintext and wrapped by the master key.
+	GenerateDataKey(ctx context.Context) (plaintext, wrapped []byte, err error)
+	// DecryptDataKey unwraps a data key returned by GenerateDataKey.
+	DecryptDataKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// LocalKeyProvider wraps data keys with a local AES-256 master key, meant for development
+// and for deployments where the master key is injected as a secret.
+type LocalKeyProvider struct {
+	master cipher.AEAD
+}
+
+func NewLocalKeyProvider(masterKey []byte) (*LocalKeyProvider, error) {
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalKeyProvider{master: aead}, nil
+}
+
+func (p *LocalKeyProvider) GenerateDataKey(_ context.Context) ([]byte, []byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := seal(p.master, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, wrapped, nil
+}
+
+func (p *LocalKeyProvider) DecryptDataKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	return open(p.master, wrapped, nil)
+}
+
+// PayloadEncrypter applies envelope encryption to payloads persisted by the framework (outbox rows,
+// persisted tasks, ...). A data key is reused for DataKeyTTL to limit calls to the provider,
+// unwrapped keys are cached for decryption.
+type PayloadEncrypter struct {
+	provider DataKeyProvider
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	current   *dataKey
+	decrypted map[string]cipher.AEAD
+}
+
+type dataKey struct {
+	aead      cipher.AEAD
+	wrapped   []byte
+	expiresAt time.Time
+}
+
+// NewPayloadEncrypter creates an encrypter, dataKeyTTL zero means defaultDataKeyTTL.
+func NewPayloadEncrypter(provider DataKeyProvider, dataKeyTTL time.Duration) *PayloadEncrypter {
+	if dataKeyTTL == 0 {
+		dataKeyTTL = defaultDataKeyTTL
+	}
+
+	return &PayloadEncrypter{
+		provider:  provider,
+		ttl:       dataKeyTTL,
+		decrypted: make(map[string]cipher.AEAD),
+	}
+}
+
+// Encrypt seals plaintext, aad (e.g. a row ID) is authenticated but not stored.
+// The envelope layout is: version | wrapped key length (uint16) | wrapped key | nonce | ciphertext.
+func (e *PayloadEncrypter) Encrypt(ctx context.Context, plaintext, aad []byte) ([]byte, error) {
+	key, err := e.dataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := seal(key.aead, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := make([]byte, 0, 3+len(key.wrapped)+len(sealed))
+	envelope = append(envelope, envelopeVersion)
+	envelope = binary.BigEndian.AppendUint16(envelope, uint16(len(key.wrapped)))
+	envelope = append(envelope, key.wrapped...)
+
+	return append(envelope, sealed...), nil
+}
+
+func (e *PayloadEncrypter) Decrypt(ctx context.Context, envelope, aad []byte) ([]byte, error) {
+	if len(envelope) < 3 || envelope[0] != envelopeVersion {
+		return nil, ErrInvalidEnvelope
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(envelope[1:3]))
+	if len(envelope) < 3+keyLen {
+		return nil, ErrInvalidEnvelope
+	}
+	wrapped, sealed := envelope[3:3+keyLen], envelope[3+keyLen:]
+
+	aead, err := e.decryptionKey(ctx, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, sealed, aad)
+}
+
+func (e *PayloadEncrypter) dataKey(ctx context.Context) (*dataKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.current != nil && time.Now().Before(e.current.expiresAt) {
+		return e.current, nil
+	}
+
+	plaintext, wrapped, err := e.provider.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	aead, err := newAEAD(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	e.current = &dataKey{aead: aead, wrapped: wrapped, expiresAt: time.Now().Add(e.ttl)}
+	e.cacheDecryptionKey(wrapped, aead)
+
+	return e.current, nil
+}
+
+func (e *PayloadEncrypter) decryptionKey(ctx context.Context, wrapped []byte) (cipher.AEAD, error) {
+	e.mu.Lock()
+	aead, ok := e.decrypted[string(wrapped)]
+	e.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	plaintext, err := e.provider.DecryptDataKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+
+	if aead, err = newAEAD(plaintext); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.cacheDecryptionKey(wrapped, aead)
+	e.mu.Unlock()
+
+	return aead, nil
+}
+
+func (e *PayloadEncrypter) cacheDecryptionKey(wrapped []byte, aead cipher.AEAD) {
+	if len(e.decrypted) >= maxCachedDecryptionKeys {
+		clear(e.decrypted)
+	}
+	e.decrypted[string(wrapped)] = aead
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts with a random nonce prepended to the ciphertext.
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidEnvelope
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+
+	return plaintext, nil
+}
+
+type PayloadEncryptionOption struct {
+	provider DataKeyProvider
+	ttl      time.Duration
+}
+
+func (w PayloadEncryptionOption) Apply(s *Service) error {
+	if w.provider == nil {
+		return errors.New("payload encryption: data key provider is nil")
+	}
+	s.Encrypter = NewPayloadEncrypter(w.provider, w.ttl)
+	return nil
+}
+
+// WithPayloadEncryption makes components persisting payloads (outbox, persisted worker pool tasks, ...)
+// encrypt them through Service.Encrypter.
+func WithPayloadEncryption(provider DataKeyProvider, dataKeyTTL time.Duration) Option {
+	return PayloadEncryptionOption{provider: provider, ttl: dataKeyTTL}
+}
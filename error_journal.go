@@ -0,0 +1,288 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+var errJournalClosed = errors.New("error journal closed")
+
+const (
+	defaultJournalMaxSize  = 1 << 20
+	defaultJournalMaxFiles = 3
+	defaultJournalLimit    = 100
+	maxJournalStackSize    = 16 << 10
+	maxJournalLineSize     = 1 << 20
+)
+
+type ErrorJournalConfig struct {
+	// Path of the journal, e.g. on a volume outliving the container. Rotated files get a .1, .2, ... suffix.
+	Path string
+	// MaxSize of a file before it is rotated, zero means 1MiB.
+	MaxSize int64
+	// MaxFiles is the number of rotated files kept, zero means 3.
+	MaxFiles int
+	// AdminTokens enable the /admin/errors endpoint on the tech server for these bearer tokens.
+	AdminTokens []string
+}
+
+// ErrorJournalEntry is a line of the journal.
+type ErrorJournalEntry struct {
+	Time time.Time `json:"time"`
+	// Kind is error, panic, health_check or goroutine_leak.
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	// Component is the goroutine which panicked or the failing health check.
+	Component string `json:"component,omitempty"`
+	Stack     string `json:"stack,omitempty"`
+	PID       int    `json:"pid"`
+}
+
+// ErrorJournal appends the errors reported by the service to a local file, synced on every entry, so the
+// errors of the previous runs can be read even when the centralized logging is down.
+type ErrorJournal struct {
+	cfg ErrorJournalConfig
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewErrorJournal(cfg ErrorJournalConfig) (*ErrorJournal, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("error journal requires a path")
+	}
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = defaultJournalMaxSize
+	}
+	if cfg.MaxFiles == 0 {
+		cfg.MaxFiles = defaultJournalMaxFiles
+	}
+
+	j := &ErrorJournal{cfg: cfg}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *ErrorJournal) open() error {
+	file, err := os.OpenFile(j.cfg.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open error journal: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open error journal: %w", err)
+	}
+
+	j.file = file
+	j.size = info.Size()
+	return nil
+}
+
+// Append writes an entry for err.
+func (j *ErrorJournal) Append(err error) error {
+	entry := ErrorJournalEntry{Time: time.Now().UTC(), Kind: "error", Message: err.Error(), PID: os.Getpid()}
+
+	var (
+		panicErr  *PanicError
+		healthErr *HealthCheckError
+		leakErr   *GoroutineLeakError
+	)
+	switch {
+	case errors.As(err, &panicErr):
+		entry.Kind = "panic"
+		entry.Component = panicErr.Goroutine
+		entry.Stack = string(panicErr.Stack[:min(len(panicErr.Stack), maxJournalStackSize)])
+	case errors.As(err, &healthErr):
+		entry.Kind = "health_check"
+		entry.Component = healthErr.Check
+	case errors.As(err, &leakErr):
+		entry.Kind = "goroutine_leak"
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return errJournalClosed
+	}
+	if j.size > 0 && j.size+int64(len(line)) > j.cfg.MaxSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// rotate shifts the rotated files, dropping the oldest one, and starts a new file.
+func (j *ErrorJournal) rotate() error {
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	j.file = nil
+
+	for i := j.cfg.MaxFiles - 1; i > 0; i-- {
+		_ = os.Rename(j.rotated(i), j.rotated(i+1))
+	}
+	if err := os.Rename(j.cfg.Path, j.rotated(1)); err != nil {
+		return fmt.Errorf("failed to rotate error journal: %w", err)
+	}
+	return j.open()
+}
+
+func (j *ErrorJournal) rotated(i int) string {
+	return j.cfg.Path + "." + strconv.Itoa(i)
+}
+
+// Recent returns the last entries, the newest first, up to limit.
+func (j *ErrorJournal) Recent(limit int) ([]ErrorJournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var entries []ErrorJournalEntry
+	for i := 0; i <= j.cfg.MaxFiles && len(entries) < limit; i++ {
+		path := j.cfg.Path
+		if i > 0 {
+			path = j.rotated(i)
+		}
+
+		fileEntries, err := readJournalFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		slices.Reverse(fileEntries)
+		entries = append(entries, fileEntries...)
+	}
+
+	return entries[:min(len(entries), limit)], nil
+}
+
+// readJournalFile skips the lines which can't be parsed, e.g. one cut by a crash.
+func readJournalFile(path string) ([]ErrorJournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []ErrorJournalEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxJournalLineSize)
+	for scanner.Scan() {
+		var entry ErrorJournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func (j *ErrorJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+func (j *ErrorJournal) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(BearerTokenAuth(j.cfg.AdminTokens...))
+
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultJournalLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				AnswerWithJSONError(w, http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		entries, err := j.Recent(limit)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to read error journal")
+			AnswerWithJSONError(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(entries)
+	})
+
+	return r
+}
+
+func (s *Service) appendJournal(err error) {
+	if s.journal == nil {
+		return
+	}
+	// the errors reported after Stop, e.g. by goroutines outliving it, are logged by their reporters
+	if jerr := s.journal.Append(err); jerr != nil && !errors.Is(jerr, errJournalClosed) {
+		log.Error().Err(jerr).Msg("failed to append to error journal")
+	}
+}
+
+func (s *Service) closeJournal() {
+	if s.journal == nil {
+		return
+	}
+	if err := s.journal.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to close error journal")
+	}
+}
+
+type ErrorJournalOption struct {
+	cfg ErrorJournalConfig
+}
+
+func (w ErrorJournalOption) Apply(s *Service) error {
+	j, err := NewErrorJournal(w.cfg)
+	if err != nil {
+		return err
+	}
+
+	s.journal = j
+	return nil
+}
+
+// WithErrorJournal appends the errors reported on ErrChan, the recovered panics, the failing health checks
+// and the goroutine leaks to a bounded local file, rotated, which survives restarts. The recent entries
+// are served by /admin/errors?limit=100 on the tech server with AdminTokens.
+func WithErrorJournal(cfg ErrorJournalConfig) Option {
+	return ErrorJournalOption{cfg: cfg}
+}
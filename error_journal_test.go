@@ -0,0 +1,115 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func newTestJournal(t *testing.T) *ErrorJournal {
+	t.Helper()
+
+	j, err := NewErrorJournal(ErrorJournalConfig{
+		Path:        filepath.Join(t.TempDir(), "errors.log"),
+		AdminTokens: []string{"secret", ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+func TestErrorJournalAppend(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantKind      string
+		wantComponent string
+	}{
+		{"error", errors.New("boom"), "error", ""},
+		{"panic", &PanicError{Goroutine: "worker", Value: "boom", Stack: []byte("stack")}, "panic", "worker"},
+		{"health check", &HealthCheckError{Check: "redis", Err: errors.New("down")}, "health_check", "redis"},
+		{"goroutine leak", &GoroutineLeakError{}, "goroutine_leak", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := newTestJournal(t)
+
+			if err := j.Append(tt.err); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := j.Recent(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("entries = %+v, want one", entries)
+			}
+			if entries[0].Kind != tt.wantKind || entries[0].Component != tt.wantComponent {
+				t.Errorf("entry = %+v, want kind %q and component %q", entries[0], tt.wantKind, tt.wantComponent)
+			}
+		})
+	}
+}
+
+func TestErrorJournalClosed(t *testing.T) {
+	j := newTestJournal(t)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Append(errors.New("late")); !errors.Is(err, errJournalClosed) {
+		t.Errorf("err = %v, want %v", err, errJournalClosed)
+	}
+}
+
+func TestErrorJournalRoutes(t *testing.T) {
+	tests := []struct {
+		name          string
+		target        string
+		authorization string
+		want          int
+		wantEntries   int
+	}{
+		{"recent", "/", "Bearer secret", http.StatusOK, 3},
+		{"limit", "/?limit=2", "Bearer secret", http.StatusOK, 2},
+		{"invalid limit", "/?limit=0", "Bearer secret", http.StatusBadRequest, 0},
+		{"empty token", "/", "Bearer ", http.StatusUnauthorized, 0},
+		{"wrong token", "/", "Bearer guess", http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := newTestJournal(t)
+			for range 3 {
+				if err := j.Append(errors.New("boom")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Header.Set("Authorization", tt.authorization)
+			rec := httptest.NewRecorder()
+
+			j.routes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var entries []ErrorJournalEntry
+			if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != tt.wantEntries {
+				t.Errorf("entries = %d, want %d", len(entries), tt.wantEntries)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const errorReporterFlushTimeout = 5 * time.Second
+
+// ErrorReporter forwards the errors of the service to an error tracker such as Sentry: the errors
+// reported on ErrChan, the recovered panics (*PanicError, holding the stack) and the failing health
+// checks (*HealthCheckError). Report must not block.
+type ErrorReporter interface {
+	Report(err error)
+	// Flush sends the buffered reports, it is called on Stop once the components are stopped.
+	Flush(ctx context.Context) error
+}
+
+// ErrorReporterFunc is an ErrorReporter without buffering.
+type ErrorReporterFunc func(err error)
+
+func (f ErrorReporterFunc) Report(err error) {
+	f(err)
+}
+
+func (f ErrorReporterFunc) Flush(context.Context) error {
+	return nil
+}
+
+// HealthCheckError is reported when a health check starts failing, it is not reported again until
+// the check passed.
+type HealthCheckError struct {
+	Check       string
+	Criticality HealthCriticality
+	Err         error
+}
+
+func (e *HealthCheckError) Error() string {
+	return fmt.Sprintf("%s health check %s failed: %v", e.Criticality, e.Check, e.Err)
+}
+
+func (e *HealthCheckError) Unwrap() error {
+	return e.Err
+}
+
+func (s *Service) notifyReporter(err error) {
+	s.appendJournal(err)
+	if s.errReporter != nil {
+		s.errReporter.Report(err)
+	}
+}
+
+func (s *Service) flushReporter() {
+	if s.errReporter == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), errorReporterFlushTimeout)
+	defer cancel()
+
+	if err := s.errReporter.Flush(ctx); err != nil {
+		log.Error().Err(err).Msg("failed to flush error reporter")
+	}
+}
+
+type ErrorReporterOption struct {
+	reporter ErrorReporter
+}
+
+func (w ErrorReporterOption) Apply(s *Service) error {
+	if w.reporter == nil {
+		return errors.New("error reporter is nil")
+	}
+	s.errReporter = w.reporter
+	return nil
+}
+
+// WithErrorReporter forwards the errors reported on ErrChan, the panics recovered in the servers and
+// subservices and the failing health checks to reporter, flushed on Stop.
+func WithErrorReporter(reporter ErrorReporter) Option {
+	return ErrorReporterOption{reporter: reporter}
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrServerNotFound is matched by the ServerNotFoundError of an address the service doesn't serve.
+	ErrServerNotFound = errors.New("server not found")
+	// ErrStartupTimeout is wrapped by the error of Start when the startup deadline is exceeded.
+	ErrStartupTimeout = errors.New("startup deadline exceeded")
+	// ErrDependencyUnavailable is matched by DependencyUnavailableError.
+	ErrDependencyUnavailable = errors.New("dependency unavailable")
+	// ErrInvalidOptions is wrapped by the error of New, joining every problem of the options.
+	ErrInvalidOptions = errors.New("invalid service options")
+)
+
+// ServerNotFoundError is returned when a server is looked up by an address which isn't served. It matches
+// ErrServerNotFound with errors.Is.
+type ServerNotFoundError struct {
+	// Kind is http or grpc.
+	Kind string
+	// Name is the address of the server.
+	Name string
+}
+
+func (e *ServerNotFoundError) Error() string {
+	return fmt.Sprintf("%s server %q not found", e.Kind, e.Name)
+}
+
+func (e *ServerNotFoundError) Is(target error) bool {
+	return target == ErrServerNotFound
+}
+
+// DependencyUnavailableError is returned by Start when a dependency can't be reached under a strict
+// StartupPolicy. It matches ErrDependencyUnavailable with errors.Is and unwraps to the cause.
+type DependencyUnavailableError struct {
+	// Dep names the dependency, e.g. "db:orders".
+	Dep string
+	Err error
+}
+
+func (e *DependencyUnavailableError) Error() string {
+	return fmt.Sprintf("%s unavailable: %v", e.Dep, e.Err)
+}
+
+func (e *DependencyUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DependencyUnavailableError) Is(target error) bool {
+	return target == ErrDependencyUnavailable
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// serviceVars are the expvar variables of a service, served by /debug/pprof/vars along with the ones
+// published globally, e.g. cmdline and memstats, but not published globally themselves so several
+// services of a process don't collide.
+type serviceVars struct {
+	mu   sync.Mutex
+	vars expvar.Map
+}
+
+// PublishVar serves v under name on /debug/pprof/vars, the name must not be taken by a global or
+// service variable.
+func (s *Service) PublishVar(name string, v expvar.Var) error {
+	s.vars.mu.Lock()
+	defer s.vars.mu.Unlock()
+
+	if expvar.Get(name) != nil || s.vars.vars.Get(name) != nil {
+		return fmt.Errorf("expvar %s already published", name)
+	}
+	s.vars.vars.Set(name, v)
+	return nil
+}
+
+// PublishVarFunc serves the JSON encoding of the value returned by f, called on each request.
+func (s *Service) PublishVarFunc(name string, f func() any) error {
+	return s.PublishVar(name, expvar.Func(f))
+}
+
+// varsHandler serves the expvar variables like expvar.Handler, plus the ones of the service.
+func (s *Service) varsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := make(map[string]json.RawMessage)
+		expvar.Do(func(kv expvar.KeyValue) {
+			vars[kv.Key] = json.RawMessage(kv.Value.String())
+		})
+		s.vars.vars.Do(func(kv expvar.KeyValue) {
+			vars[kv.Key] = json.RawMessage(kv.Value.String())
+		})
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
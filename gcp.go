@@ -0,0 +1,167 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+)
+
+const gcpCloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+type GCPConfig struct {
+	// CredentialsJSON is a service account key or an external account config, empty means the Application
+	// Default Credentials: GOOGLE_APPLICATION_CREDENTIALS, the gcloud credentials, then the metadata server.
+	CredentialsJSON []byte
+	// Scopes of the tokens, defaults to cloud-platform.
+	Scopes []string
+	// ClientOptions are given to every client, e.g. option.WithEndpoint for an emulator.
+	ClientOptions []option.ClientOption
+}
+
+// GCP holds the credentials shared by the Google Cloud clients of the service, e.g.
+// pubsub.NewClient(ctx, project, service.GCP.ClientOptions()...).
+type GCP struct {
+	// Project is the project given to WithGCP, or the one of the credentials.
+	Project string
+
+	credentials *google.Credentials
+	httpClient  func(name string, opts HTTPClientOptions) *http.Client
+	userAgent   string
+	options     []option.ClientOption
+
+	mu      sync.Mutex
+	clients []*gcpClient
+}
+
+// TokenSource returns the cached, automatically refreshed access tokens of the credentials.
+func (g *GCP) TokenSource() oauth2.TokenSource {
+	return g.credentials.TokenSource
+}
+
+// ClientOptions are the options of the Google Cloud clients: the shared credentials, the request id
+// propagation and the user agent, then GCPConfig.ClientOptions and opts. The clients trace their calls
+// with OpenTelemetry and retry them with their own policies.
+func (g *GCP) ClientOptions(opts ...option.ClientOption) []option.ClientOption {
+	clientOpts := []option.ClientOption{
+		option.WithCredentials(g.credentials),
+		option.WithUserAgent(g.userAgent),
+	}
+	for _, dialOpt := range RequestIDDialOptions() {
+		clientOpts = append(clientOpts, option.WithGRPCDialOption(dialOpt))
+	}
+	clientOpts = append(clientOpts, g.options...)
+	return append(clientOpts, opts...)
+}
+
+// HTTPClient returns a managed HTTP client, see Service.HTTPClient, authorized with the shared credentials,
+// e.g. for NewGCPKMS or the REST APIs without a client library.
+func (g *GCP) HTTPClient(name string, opts HTTPClientOptions) *http.Client {
+	client := g.httpClient(name, opts)
+	client.Transport = &oauth2.Transport{Source: g.TokenSource(), Base: client.Transport}
+	return client
+}
+
+// Manage closes client on Stop, after the consumers using it stopped, e.g.
+// service.GCP.Manage("pubsub", pubsubClient).
+func (g *GCP) Manage(name string, client io.Closer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.clients = append(g.clients, &gcpClient{name: name, client: client})
+}
+
+func (g *GCP) Name() string {
+	return "gcp"
+}
+
+func (g *GCP) ShutdownPriority() int {
+	return ShutdownPriorityProducer
+}
+
+func (g *GCP) Ready() bool {
+	return true
+}
+
+// Close closes the managed clients in the reverse order of their registration.
+func (g *GCP) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var errs []error
+	for i := len(g.clients) - 1; i >= 0; i-- {
+		c := g.clients[i]
+		if err := c.client.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close gcp client %s: %w", c.name, err))
+			continue
+		}
+		log.Debug().Str("client", c.name).Msg("gcp client closed")
+	}
+	g.clients = nil
+	return errors.Join(errs...)
+}
+
+type gcpClient struct {
+	name   string
+	client io.Closer
+}
+
+type GCPOption struct {
+	project string
+	cfg     GCPConfig
+}
+
+func (w GCPOption) Apply(s *Service) error {
+	scopes := w.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{gcpCloudPlatformScope}
+	}
+
+	var (
+		creds *google.Credentials
+		err   error
+	)
+	if len(w.cfg.CredentialsJSON) > 0 {
+		creds, err = google.CredentialsFromJSON(s.ctx, w.cfg.CredentialsJSON, scopes...)
+	} else {
+		creds, err = google.FindDefaultCredentials(s.ctx, scopes...)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find gcp credentials: %w", err)
+	}
+
+	project := w.project
+	if project == "" {
+		project = creds.ProjectID
+	}
+	if project == "" {
+		return errors.New("gcp project not set and not found in the credentials")
+	}
+
+	g := &GCP{
+		Project:     project,
+		credentials: creds,
+		httpClient:  s.HTTPClient,
+		userAgent:   s.Name,
+		options:     w.cfg.ClientOptions,
+	}
+	if version := s.Version(); version != "" {
+		g.userAgent += "/" + version
+	}
+
+	s.GCP = g
+	log.Debug().Str("project", project).Msg("gcp credentials loaded")
+	return s.RegisterSubService(g)
+}
+
+// WithGCP loads the credentials shared by the Google Cloud clients as Service.GCP, project empty meaning
+// the project of the credentials. Clients registered with Service.GCP.Manage are closed on Stop.
+func WithGCP(project string, cfg GCPConfig) Option {
+	return GCPOption{project: project, cfg: cfg}
+}
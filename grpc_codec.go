@@ -0,0 +1,154 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/mem"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// JSONCodecName is the content-subtype of JSONCodec, application/grpc+json.
+const JSONCodecName = "json"
+
+// vtMessage is implemented by the messages generated by protoc-gen-go-vtproto with the marshal, unmarshal
+// and size features.
+type vtMessage interface {
+	SizeVT() int
+	MarshalToSizedBufferVT(data []byte) (int, error)
+	UnmarshalVT(data []byte) error
+}
+
+// VTProtoCodec marshals the messages generated by vtprotobuf with their reflection-free methods, several
+// times faster than the default codec, and the other messages with proto. It keeps the proto wire format
+// and content-subtype so it replaces the default codec transparently on both sides.
+type VTProtoCodec struct{}
+
+func (VTProtoCodec) Name() string {
+	return grpcproto.Name
+}
+
+func (VTProtoCodec) Marshal(v any) (mem.BufferSlice, error) {
+	m, ok := v.(vtMessage)
+	if !ok {
+		return marshalProto(v)
+	}
+
+	size := m.SizeVT()
+	if mem.IsBelowBufferPoolingThreshold(size) {
+		buf := make([]byte, size)
+		if _, err := m.MarshalToSizedBufferVT(buf); err != nil {
+			return nil, err
+		}
+		return mem.BufferSlice{mem.SliceBuffer(buf)}, nil
+	}
+
+	pool := mem.DefaultBufferPool()
+	buf := pool.Get(size)
+	if _, err := m.MarshalToSizedBufferVT((*buf)[:size]); err != nil {
+		pool.Put(buf)
+		return nil, err
+	}
+	return mem.BufferSlice{mem.NewBuffer(buf, pool)}, nil
+}
+
+func (VTProtoCodec) Unmarshal(data mem.BufferSlice, v any) error {
+	m, ok := v.(vtMessage)
+	if !ok {
+		return unmarshalProto(data, v)
+	}
+
+	buf := data.MaterializeToBuffer(mem.DefaultBufferPool())
+	defer buf.Free()
+	return m.UnmarshalVT(buf.ReadOnlyData())
+}
+
+// JSONCodec encodes the messages with protojson, and other values with encoding/json, so endpoints can be
+// debugged with grpcurl -format json or called by clients without the generated code. Clients select it
+// with GRPCJSONCallOption.
+type JSONCodec struct{}
+
+// gRPC codecs can only be registered at initialization, the json content-subtype is then served by every
+// server of the process. The default proto codec is left alone, see GRPCCodec.
+func init() {
+	encoding.RegisterCodecV2(JSONCodec{})
+}
+
+func (JSONCodec) Name() string {
+	return JSONCodecName
+}
+
+func (JSONCodec) Marshal(v any) (mem.BufferSlice, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if m := protoMessage(v); m != nil {
+		b, err = protojson.Marshal(m)
+	} else {
+		b, err = json.Marshal(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mem.BufferSlice{mem.SliceBuffer(b)}, nil
+}
+
+func (JSONCodec) Unmarshal(data mem.BufferSlice, v any) error {
+	b := data.Materialize()
+	if m := protoMessage(v); m != nil {
+		return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(b, m)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// GRPCJSONCallOption makes a client call, or all of them with grpc.WithDefaultCallOptions, use JSONCodec.
+func GRPCJSONCallOption() grpc.CallOption {
+	return grpc.ForceCodecV2(JSONCodec{})
+}
+
+func protoMessage(v any) proto.Message {
+	switch v := v.(type) {
+	case protoadapt.MessageV1:
+		return protoadapt.MessageV2Of(v)
+	case protoadapt.MessageV2:
+		return v
+	}
+	return nil
+}
+
+func marshalProto(v any) (mem.BufferSlice, error) {
+	m := protoMessage(v)
+	if m == nil {
+		return nil, fmt.Errorf("proto: failed to marshal, message is %T, want proto.Message", v)
+	}
+
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return mem.BufferSlice{mem.SliceBuffer(b)}, nil
+}
+
+func unmarshalProto(data mem.BufferSlice, v any) error {
+	m := protoMessage(v)
+	if m == nil {
+		return fmt.Errorf("proto: failed to unmarshal, message is %T, want proto.Message", v)
+	}
+
+	buf := data.MaterializeToBuffer(mem.DefaultBufferPool())
+	defer buf.Free()
+	return proto.Unmarshal(buf.ReadOnlyData(), m)
+}
+
+// GRPCCodec makes the server encode every call with codec, e.g. VTProtoCodec, whatever its
+// content-subtype. Unlike the process-wide codec registry of gRPC it only affects this server; clients
+// use grpc.WithDefaultCallOptions(grpc.ForceCodecV2(codec)). A server with a codec doesn't serve JSONCodec.
+func GRPCCodec(codec encoding.CodecV2) GRPCServerOpt {
+	return func(c *grpcServerConfig) { c.codec = codec }
+}
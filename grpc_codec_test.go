@@ -0,0 +1,217 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/apipb"
+)
+
+// benchAPI stands for a message generated by protoc-gen-go-vtproto: the proto message with hand-written
+// reflection-free methods for the fields set by newBenchAPI.
+type benchAPI struct {
+	*apipb.Api
+}
+
+func newBenchAPI() benchAPI {
+	api := &apipb.Api{Name: "jetbrainer.orders.v1.Orders", Version: "v1"}
+	for i := range 32 {
+		api.Methods = append(api.Methods, &apipb.Method{
+			Name:              fmt.Sprintf("Method%d", i),
+			RequestTypeUrl:    fmt.Sprintf("type.googleapis.com/jetbrainer.orders.v1.Request%d", i),
+			RequestStreaming:  i%2 == 0,
+			ResponseTypeUrl:   fmt.Sprintf("type.googleapis.com/jetbrainer.orders.v1.Response%d", i),
+			ResponseStreaming: i%3 == 0,
+		})
+	}
+	return benchAPI{Api: api}
+}
+
+func sizeString(num protowire.Number, s string) int {
+	if s == "" {
+		return 0
+	}
+	return protowire.SizeTag(num) + protowire.SizeBytes(len(s))
+}
+
+func sizeBool(num protowire.Number, b bool) int {
+	if !b {
+		return 0
+	}
+	return protowire.SizeTag(num) + 1
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return protowire.AppendString(protowire.AppendTag(b, num, protowire.BytesType), s)
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), 1)
+}
+
+func sizeMethod(m *apipb.Method) int {
+	return sizeString(1, m.Name) + sizeString(2, m.RequestTypeUrl) + sizeBool(3, m.RequestStreaming) +
+		sizeString(4, m.ResponseTypeUrl) + sizeBool(5, m.ResponseStreaming)
+}
+
+func (a benchAPI) SizeVT() int {
+	n := sizeString(1, a.Name) + sizeString(4, a.Version)
+	for _, m := range a.Methods {
+		n += protowire.SizeTag(2) + protowire.SizeBytes(sizeMethod(m))
+	}
+	return n
+}
+
+func (a benchAPI) MarshalToSizedBufferVT(data []byte) (int, error) {
+	b := appendString(data[:0], 1, a.Name)
+	for _, m := range a.Methods {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(sizeMethod(m)))
+		b = appendString(b, 1, m.Name)
+		b = appendString(b, 2, m.RequestTypeUrl)
+		b = appendBool(b, 3, m.RequestStreaming)
+		b = appendString(b, 4, m.ResponseTypeUrl)
+		b = appendBool(b, 5, m.ResponseStreaming)
+	}
+	b = appendString(b, 4, a.Version)
+	return len(b), nil
+}
+
+// consumeFields calls field for each field of data, with the value of the bytes fields and the varints.
+func consumeFields(data []byte, field func(num protowire.Number, v []byte, x uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var v []byte
+		var x uint64
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(data)
+		default:
+			return fmt.Errorf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := field(num, v, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a benchAPI) UnmarshalVT(data []byte) error {
+	a.Reset()
+	return consumeFields(data, func(num protowire.Number, v []byte, _ uint64) error {
+		switch num {
+		case 1:
+			a.Name = string(v)
+		case 4:
+			a.Version = string(v)
+		case 2:
+			m := &apipb.Method{}
+			a.Methods = append(a.Methods, m)
+			return consumeFields(v, func(num protowire.Number, v []byte, x uint64) error {
+				switch num {
+				case 1:
+					m.Name = string(v)
+				case 2:
+					m.RequestTypeUrl = string(v)
+				case 3:
+					m.RequestStreaming = x != 0
+				case 4:
+					m.ResponseTypeUrl = string(v)
+				case 5:
+					m.ResponseStreaming = x != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+var benchCodecs = []struct {
+	name  string
+	codec encoding.CodecV2
+}{
+	{"proto", encoding.GetCodecV2(grpcproto.Name)},
+	{"vtproto", VTProtoCodec{}},
+}
+
+func BenchmarkCodecMarshal(b *testing.B) {
+	msg := newBenchAPI()
+	want, err := proto.Marshal(msg.Api)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, c := range benchCodecs {
+		b.Run(c.name, func(b *testing.B) {
+			data, err := c.codec.Marshal(msg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if !bytes.Equal(data.Materialize(), want) {
+				b.Fatal("wire format differs from proto")
+			}
+			data.Free()
+
+			b.ReportAllocs()
+			for b.Loop() {
+				data, err := c.codec.Marshal(msg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				data.Free()
+			}
+		})
+	}
+}
+
+func BenchmarkCodecUnmarshal(b *testing.B) {
+	msg := newBenchAPI()
+	data, err := VTProtoCodec{}.Marshal(msg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer data.Free()
+
+	for _, c := range benchCodecs {
+		b.Run(c.name, func(b *testing.B) {
+			got := benchAPI{Api: &apipb.Api{}}
+			if err := c.codec.Unmarshal(data, got); err != nil {
+				b.Fatal(err)
+			}
+			if !proto.Equal(got.Api, msg.Api) {
+				b.Fatal("unmarshaled message differs")
+			}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := c.codec.Unmarshal(data, got); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const gatewayBufferSize = 1 << 20
+
+// GatewayHandlerFunc registers the handlers of a service on the gateway mux, generated
+// Register<Service>Handler functions have this signature.
+type GatewayHandlerFunc func(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error
+
+type GRPCGatewayConfig struct {
+	// GRPCServer is the address of the gRPC server the gateway calls, as given to WithGRPCServer.
+	// It must serve plaintext since the gateway connects to it without TLS.
+	GRPCServer string
+	// Loopback connects to the bound address of the gRPC server over TCP instead of in-process.
+	Loopback bool
+	// Prefix is the path the gateway is mounted on, "/" by default.
+	Prefix   string
+	Handlers []GatewayHandlerFunc
+	// MuxOptions customize the gateway, e.g. runtime.WithMarshalerOption.
+	MuxOptions []runtime.ServeMuxOption
+	// Middlewares wrap the gateway after the ones of the service, e.g. BearerTokenAuth.
+	Middlewares []func(http.Handler) http.Handler
+}
+
+// gatewayHeaders are forwarded to the gRPC calls so the trace continues, the request id is passed by
+// gatewayMetadata.
+var gatewayHeaders = map[string]bool{
+	"traceparent": true,
+	"tracestate":  true,
+	"baggage":     true,
+}
+
+type grpcGateway struct {
+	server *http.Server
+	conn   *grpc.ClientConn
+	router chi.Router
+	mux    *runtime.ServeMux
+	cfg    GRPCGatewayConfig
+}
+
+func gatewayHeaderMatcher(key string) (string, bool) {
+	if gatewayHeaders[strings.ToLower(key)] {
+		return strings.ToLower(key), true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// gatewayMetadata passes the id set by the RequestID middleware.
+func gatewayMetadata(ctx context.Context, _ *http.Request) metadata.MD {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return metadata.Pairs(requestIDMetadata, id)
+	}
+	return nil
+}
+
+func gatewayErrorHandler(ctx context.Context, mux *runtime.ServeMux, m runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	switch status.Code(err) {
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss, codes.DeadlineExceeded:
+		log.Error().Err(err).Str("request_id", RequestIDFromContext(ctx)).
+			Str("method", r.Method).Str("path", r.URL.Path).Msg("grpc gateway call failed")
+	}
+	runtime.DefaultHTTPErrorHandler(ctx, mux, m, w, r, err)
+}
+
+// dialer connects the gateway to the gRPC server, once Start has bound it.
+func (g *GRPCServer) dialer(loopback bool) func(ctx context.Context, _ string) (net.Conn, error) {
+	if !loopback {
+		g.local = bufconn.Listen(gatewayBufferSize)
+		return func(ctx context.Context, _ string) (net.Conn, error) {
+			return g.local.DialContext(ctx)
+		}
+	}
+
+	return func(ctx context.Context, _ string) (net.Conn, error) {
+		if g.listener == nil {
+			return nil, errors.New("grpc server is not listening")
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", g.listener.Addr().String())
+	}
+}
+
+// gatewayMiddlewares are the middlewares of the service applying to HTTP handlers, resolved once
+// all options are applied.
+func (s *Service) gatewayMiddlewares() []func(http.Handler) http.Handler {
+	mws := []func(http.Handler) http.Handler{RequestID, middleware.Recoverer}
+	if s.SLO != nil {
+		mws = append(mws, s.SLO.Middleware())
+	}
+	if s.Usage != nil {
+		mws = append(mws, s.Usage.Middleware())
+	}
+	if s.LoadShedder != nil {
+		mws = append(mws, s.LoadShedder.Middleware())
+	}
+	if s.Lifeboat != nil {
+		mws = append(mws, s.Lifeboat.Middleware())
+	}
+	if s.Quotas != nil {
+		mws = append(mws, s.Quotas.Middleware())
+	}
+	if s.Plugins != nil {
+		mws = append(mws, s.Plugins.Middleware())
+	}
+	return mws
+}
+
+// mountGateways mounts the gateways once all options are applied, so they share the middlewares
+// of options applied after them.
+func (s *Service) mountGateways() {
+	for _, gw := range s.gateways {
+		prefix := gw.cfg.Prefix
+		if prefix == "" {
+			prefix = "/"
+		}
+		gw.router.Use(s.gatewayMiddlewares()...)
+		gw.router.With(gw.cfg.Middlewares...).Mount(prefix, gw.mux)
+	}
+}
+
+// stopGateways shuts the gateways down before the gRPC servers they call.
+func (s *Service) stopGateways(ctx context.Context) {
+	for _, gw := range s.gateways {
+		if err := s.stopComponent("http_server", gw.server.Addr, func() error {
+			return gw.server.Shutdown(ctx)
+		}); err != nil {
+			log.Error().Err(err).Str("addr", gw.server.Addr).Msg("failed to shutdown grpc gateway")
+		}
+		if err := gw.conn.Close(); err != nil {
+			log.Error().Err(err).Str("addr", gw.server.Addr).Msg("failed to close grpc gateway connection")
+		}
+		log.Debug().Str("addr", gw.server.Addr).Msg("grpc gateway stopped")
+	}
+}
+
+type GRPCGatewayOption struct {
+	address string
+	cfg     GRPCGatewayConfig
+}
+
+func (w GRPCGatewayOption) Apply(s *Service) error {
+	var target *GRPCServer
+	for _, grpcServer := range s.GRPCServers {
+		if grpcServer.address == w.cfg.GRPCServer {
+			target = grpcServer
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("grpc gateway: %w", &ServerNotFoundError{Kind: "grpc", Name: w.cfg.GRPCServer})
+	}
+	if target.tls {
+		return fmt.Errorf("grpc gateway: gRPC server %q serves TLS", w.cfg.GRPCServer)
+	}
+	if !w.cfg.Loopback && target.local != nil {
+		return fmt.Errorf("grpc gateway: gRPC server %q already has an in-process gateway", w.cfg.GRPCServer)
+	}
+
+	// the connection is established on the first call, after Start
+	conn, err := grpc.NewClient("passthrough:///"+w.cfg.GRPCServer,
+		grpc.WithContextDialer(target.dialer(w.cfg.Loopback)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		return fmt.Errorf("grpc gateway: failed to create connection: %w", err)
+	}
+
+	mux := runtime.NewServeMux(append([]runtime.ServeMuxOption{
+		runtime.WithIncomingHeaderMatcher(gatewayHeaderMatcher),
+		runtime.WithMetadata(gatewayMetadata),
+		runtime.WithErrorHandler(gatewayErrorHandler),
+	}, w.cfg.MuxOptions...)...)
+	for _, register := range w.cfg.Handlers {
+		if err := register(s.ctx, mux, conn); err != nil {
+			conn.Close()
+			return fmt.Errorf("grpc gateway: failed to register handler: %w", err)
+		}
+	}
+
+	r := chi.NewRouter()
+	srv := &http.Server{
+		Addr:           w.address,
+		Handler:        r,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   30 * time.Second,
+		MaxHeaderBytes: http.DefaultMaxHeaderBytes,
+	}
+	s.gateways = append(s.gateways, &grpcGateway{server: srv, conn: conn, router: r, mux: mux, cfg: w.cfg})
+	s.HTTPServers = append(s.HTTPServers, srv)
+
+	return nil
+}
+
+// WithGRPCGateway serves a REST facade of the gRPC server cfg.GRPCServer on address, with the
+// handlers generated by grpc-gateway. Requests go through the middlewares of the service (SLO,
+// usage, load shedding, lifeboat and quotas) and then through the interceptors of the gRPC server.
+func WithGRPCGateway(address string, cfg GRPCGatewayConfig) Option {
+	return GRPCGatewayOption{address: address, cfg: cfg}
+}
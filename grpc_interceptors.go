@@ -0,0 +1,91 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// withRequestID takes the request id from the incoming metadata or generates one, stores it with
+// ContextWithRequestID and sends it back in the response header.
+func withRequestID(ctx context.Context) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDMetadata); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	id = requestIDOrNew(id)
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, id))
+	return ContextWithRequestID(ctx, id)
+}
+
+func requestIDInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(withRequestID(ctx), req)
+}
+
+func requestIDStreamInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, contextStream{ServerStream: ss, ctx: withRequestID(ss.Context())})
+}
+
+// logCall logs a finished call, at error level when the status code is a server fault.
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := zerolog.InfoLevel
+	if isServerFault(code) {
+		level = zerolog.ErrorLevel
+	}
+
+	event := log.WithLevel(level).Str("method", method).Str("code", code.String()).
+		Dur("duration", time.Since(start)).Str("request_id", RequestIDFromContext(ctx))
+	if err != nil {
+		event = event.Err(err)
+	}
+	event.Msg("grpc call")
+}
+
+func loggingInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func loggingStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	logCall(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+// recoveryErr turns the panic of a handler, reported like the ones of the service goroutines, into an
+// Internal status so the client doesn't see the details.
+func recoveryErr(err error) error {
+	var perr *PanicError
+	if errors.As(err, &perr) {
+		return status.Error(codes.Internal, "internal error")
+	}
+	return err
+}
+
+func (s *Service) recoveryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	err = s.recoverPanic("grpc "+info.FullMethod, func() error {
+		resp, err = handler(ctx, req)
+		return err
+	})()
+	return resp, recoveryErr(err)
+}
+
+func (s *Service) recoveryStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return recoveryErr(s.recoverPanic("grpc "+info.FullMethod, func() error {
+		return handler(srv, ss)
+	})())
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/keepalive"
+)
+
+type GRPCServerOpt func(*grpcServerConfig)
+
+type grpcServerConfig struct {
+	params       keepalive.ServerParameters
+	enforcement  *keepalive.EnforcementPolicy
+	maxSendBytes int
+	stallTimeout time.Duration
+	codec        encoding.CodecV2
+}
+
+// GRPCMaxConnectionAge sends a GOAWAY to connections older than age, with a 10% jitter, and closes them
+// after grace, so long-lived client connections are cycled and spread over new instances, e.g. behind L4
+// load balancers. In-flight calls get grace to complete, zero means no limit.
+func GRPCMaxConnectionAge(age, grace time.Duration) GRPCServerOpt {
+	return func(c *grpcServerConfig) {
+		c.params.MaxConnectionAge = age
+		c.params.MaxConnectionAgeGrace = grace
+	}
+}
+
+// GRPCMaxConnectionIdle closes connections without calls for d.
+func GRPCMaxConnectionIdle(d time.Duration) GRPCServerOpt {
+	return func(c *grpcServerConfig) { c.params.MaxConnectionIdle = d }
+}
+
+// GRPCKeepalive pings clients after interval without activity, closing the connection when the ping is
+// not acknowledged within timeout. It detects dead peers behind proxies dropping idle connections.
+func GRPCKeepalive(interval, timeout time.Duration) GRPCServerOpt {
+	return func(c *grpcServerConfig) {
+		c.params.Time = interval
+		c.params.Timeout = timeout
+	}
+}
+
+// GRPCKeepaliveEnforcement closes, with GOAWAY "too_many_pings", the connections of clients pinging more
+// often than minTime, and with permitWithoutStream lets them ping without active calls. Clients must
+// use a keepalive time of at least minTime; the gRPC default is 5m without stream pings.
+func GRPCKeepaliveEnforcement(minTime time.Duration, permitWithoutStream bool) GRPCServerOpt {
+	return func(c *grpcServerConfig) {
+		c.enforcement = &keepalive.EnforcementPolicy{MinTime: minTime, PermitWithoutStream: permitWithoutStream}
+	}
+}
+
+func (c *grpcServerConfig) serverOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if c.params != (keepalive.ServerParameters{}) {
+		opts = append(opts, grpc.KeepaliveParams(c.params))
+	}
+	if c.enforcement != nil {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(*c.enforcement))
+	}
+	if c.maxSendBytes > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(c.maxSendBytes))
+	}
+	if c.codec != nil {
+		opts = append(opts, grpc.ForceServerCodecV2(c.codec))
+	}
+	return opts
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/reflection"
+)
+
+// GRPCReflectionEnv overrides WithGRPCReflection: true registers reflection on all gRPC servers, false on
+// none, otherwise it is a comma-separated list of server addresses. An empty value is ignored.
+const GRPCReflectionEnv = "GRPC_REFLECTION"
+
+type grpcReflection struct {
+	all       bool
+	addresses []string
+}
+
+// registerReflection registers the reflection service once all options are applied, so servers added
+// after WithGRPCReflection are covered.
+func (s *Service) registerReflection() error {
+	cfg := s.reflection
+	if value := os.Getenv(GRPCReflectionEnv); value != "" {
+		if enabled, err := strconv.ParseBool(value); err == nil {
+			cfg = nil
+			if enabled {
+				cfg = &grpcReflection{all: true}
+			}
+		} else {
+			cfg = &grpcReflection{addresses: strings.Split(value, ",")}
+		}
+	}
+	if cfg == nil {
+		return nil
+	}
+
+	for _, address := range cfg.addresses {
+		if !slices.ContainsFunc(s.GRPCServers, func(g *GRPCServer) bool { return g.address == address }) {
+			return fmt.Errorf("grpc reflection: %w", &ServerNotFoundError{Kind: "grpc", Name: address})
+		}
+	}
+
+	for _, grpcServer := range s.GRPCServers {
+		if cfg.all || slices.Contains(cfg.addresses, grpcServer.address) {
+			reflection.Register(grpcServer.server)
+			log.Info().Str("server", grpcServer.address).Msg("grpc reflection enabled")
+		}
+	}
+	return nil
+}
+
+type GRPCReflectionOption struct {
+	addresses []string
+}
+
+func (w GRPCReflectionOption) Apply(s *Service) error {
+	if s.reflection == nil {
+		s.reflection = &grpcReflection{}
+	}
+	if len(w.addresses) == 0 {
+		s.reflection.all = true
+	}
+	s.reflection.addresses = append(s.reflection.addresses, w.addresses...)
+	return nil
+}
+
+// WithGRPCReflection registers the reflection service on the gRPC servers with the given addresses, all of
+// them when none is given, so grpcurl or Postman can list and call their services. GRPCReflectionEnv
+// overrides it per environment, e.g. GRPC_REFLECTION=false in production.
+func WithGRPCReflection(addresses ...string) Option {
+	return GRPCReflectionOption{addresses: addresses}
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// grpcStreamStallThreshold is the time a send must block on flow control to count as a stall.
+const grpcStreamStallThreshold = 100 * time.Millisecond
+
+// ErrStreamStalled is the cause of the context of a stream canceled by GRPCStreamLimits, see context.Cause.
+var ErrStreamStalled = errors.New("grpc stream stalled by a slow consumer")
+
+// GRPCStreamLimits bounds the memory held by the server streams of slow consumers. Messages larger than
+// maxMessageBytes are rejected, so a stream holds at most the transport write quota (64KiB) plus one
+// message. A send blocked on flow control for longer than stallTimeout cancels the stream context with
+// ErrStreamStalled and fails with ResourceExhausted, the handler must return so the stream is closed.
+// Zero disables a limit.
+func GRPCStreamLimits(maxMessageBytes int, stallTimeout time.Duration) GRPCServerOpt {
+	return func(c *grpcServerConfig) {
+		c.maxSendBytes = maxMessageBytes
+		c.stallTimeout = stallTimeout
+	}
+}
+
+type grpcStreamMetrics struct {
+	buffered *prometheus.GaugeVec
+	stalls   *prometheus.CounterVec
+	stalled  *prometheus.CounterVec
+	aborted  *prometheus.CounterVec
+}
+
+func newGRPCStreamMetrics(r prometheus.Registerer) *grpcStreamMetrics {
+	return &grpcStreamMetrics{
+		buffered: registerCollector(r, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grpc_server_stream_send_buffer_bytes",
+			Help: "Bytes of the messages sent on server streams and waiting for the flow control window.",
+		}, []string{"method"})),
+		stalls: registerCollector(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_stream_send_stalls_total",
+			Help: "Sends on server streams blocked by flow control for longer than 100ms.",
+		}, []string{"method"})),
+		stalled: registerCollector(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_stream_send_stall_seconds_total",
+			Help: "Time the stalled sends on server streams were blocked by flow control.",
+		}, []string{"method"})),
+		aborted: registerCollector(r, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_stream_stall_aborts_total",
+			Help: "Server streams canceled because a send was blocked for longer than the stall timeout.",
+		}, []string{"method"})),
+	}
+}
+
+// streamFlowInterceptor measures the sends of the server streams and enforces the stall timeout. The
+// messages are only sized for the buffer gauge when limits are set.
+func (s *Service) streamFlowInterceptor(cfg *grpcServerConfig) grpc.StreamServerInterceptor {
+	measure := cfg.maxSendBytes > 0 || cfg.stallTimeout > 0
+
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !info.IsServerStream {
+			return handler(srv, ss)
+		}
+
+		ctx, cancel := context.WithCancelCause(ss.Context())
+		defer cancel(nil)
+
+		f := &flowControlStream{
+			ServerStream: ss,
+			ctx:          ctx,
+			cancel:       cancel,
+			method:       info.FullMethod,
+			metrics:      s.streamMetrics,
+			stallTimeout: cfg.stallTimeout,
+			measure:      measure,
+			report:       s.notifyReporter,
+		}
+		defer f.stop()
+
+		return handler(srv, f)
+	}
+}
+
+type flowControlStream struct {
+	grpc.ServerStream
+	ctx          context.Context
+	cancel       context.CancelCauseFunc
+	method       string
+	metrics      *grpcStreamMetrics
+	stallTimeout time.Duration
+	measure      bool
+	report       func(err error)
+
+	// sends are handed to a single sender, started with the first one, so a stalled send can be given up
+	// on without sends ever being concurrent
+	sends chan flowControlSend
+}
+
+type flowControlSend struct {
+	msg  any
+	sent chan error
+}
+
+func (f *flowControlStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *flowControlStream) SendMsg(m any) error {
+	// a stalled send may still be pending, sends must not be concurrent
+	if context.Cause(f.ctx) == ErrStreamStalled {
+		return status.Error(codes.ResourceExhausted, ErrStreamStalled.Error())
+	}
+	if f.stallTimeout <= 0 {
+		return f.send(m)
+	}
+
+	if f.sends == nil {
+		f.sends = make(chan flowControlSend)
+		goRecover("grpc stream "+f.method, f.report, f.sender)
+	}
+	// the sender owns a copy, a stalled send outlives this call and the caller may reuse m
+	if msg, ok := m.(proto.Message); ok {
+		m = proto.Clone(msg)
+	}
+	req := flowControlSend{msg: m, sent: make(chan error, 1)}
+	f.sends <- req
+
+	timer := time.NewTimer(f.stallTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-req.sent:
+		return err
+	case <-timer.C:
+		// the send is released when the stream is closed, once the handler returned the error
+		f.cancel(ErrStreamStalled)
+		f.metrics.aborted.WithLabelValues(f.method).Inc()
+		return status.Error(codes.ResourceExhausted, ErrStreamStalled.Error())
+	}
+}
+
+// sender sends the messages in order until the handler returned, a panicking send failing alone.
+func (f *flowControlStream) sender() {
+	for req := range f.sends {
+		err := catchPanic("grpc stream "+f.method, func() error { return f.send(req.msg) })
+		reportPanic(f.report, err)
+		req.sent <- err
+	}
+}
+
+// stop ends the sender once the handler returned, SendMsg is no longer called then.
+func (f *flowControlStream) stop() {
+	if f.sends != nil {
+		close(f.sends)
+	}
+}
+
+func (f *flowControlStream) send(m any) error {
+	var size float64
+	if msg, ok := m.(proto.Message); ok && f.measure {
+		size = float64(proto.Size(msg))
+	}
+	buffered := f.metrics.buffered.WithLabelValues(f.method)
+	buffered.Add(size)
+	defer buffered.Sub(size)
+
+	start := time.Now()
+	err := f.ServerStream.SendMsg(m)
+	if elapsed := time.Since(start); elapsed >= grpcStreamStallThreshold {
+		f.metrics.stalls.WithLabelValues(f.method).Inc()
+		f.metrics.stalled.WithLabelValues(f.method).Add(elapsed.Seconds())
+	}
+	return err
+}
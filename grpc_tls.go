@@ -0,0 +1,232 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+type GRPCTLSConfig struct {
+	TLSConfig
+	// ClientCAFile is the PEM bundle client certificates are verified against, enabling mTLS. It is
+	// reloaded like the key pair, so rotated CAs are trusted without a restart.
+	ClientCAFile string
+	// OptionalClientCert accepts clients without certificate, certificates presented are still verified.
+	OptionalClientCert bool
+}
+
+// PeerIdentity is the verified client certificate of an mTLS call.
+type PeerIdentity struct {
+	CommonName string
+	DNSNames   []string
+	// URIs holds the SPIFFE IDs, e.g. spiffe://cluster.local/ns/orders/sa/api.
+	URIs        []*url.URL
+	Certificate *x509.Certificate
+}
+
+type peerIdentityKey struct{}
+
+// PeerIdentityFromContext returns the identity of the client of a gRPC call served with mTLS.
+func PeerIdentityFromContext(ctx context.Context) (PeerIdentity, bool) {
+	id, ok := ctx.Value(peerIdentityKey{}).(PeerIdentity)
+	return id, ok
+}
+
+func withPeerIdentity(ctx context.Context) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ctx
+	}
+
+	cert := info.State.VerifiedChains[0][0]
+	return context.WithValue(ctx, peerIdentityKey{}, PeerIdentity{
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		URIs:        cert.URIs,
+		Certificate: cert,
+	})
+}
+
+func peerIdentityInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(withPeerIdentity(ctx), req)
+}
+
+// contextStream replaces the context of a stream with one carrying values set by an interceptor.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func peerIdentityStreamInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, contextStream{ServerStream: ss, ctx: withPeerIdentity(ss.Context())})
+}
+
+// CAPoolReloader keeps a certificate pool loaded from a PEM bundle and reloads it when the file changes.
+type CAPoolReloader struct {
+	file     string
+	interval time.Duration
+
+	pool    atomic.Pointer[x509.CertPool]
+	modTime time.Time
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+// NewCAPoolReloader loads the bundle, failing when it holds no certificate.
+func NewCAPoolReloader(file string, interval time.Duration) (*CAPoolReloader, error) {
+	if interval == 0 {
+		interval = defaultCertReloadInterval
+	}
+
+	c := &CAPoolReloader{file: file, interval: interval, done: make(chan struct{})}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *CAPoolReloader) load() error {
+	info, err := os.Stat(c.file)
+	if err != nil {
+		return fmt.Errorf("failed to read ca bundle: %w", err)
+	}
+	pem, err := os.ReadFile(c.file)
+	if err != nil {
+		return fmt.Errorf("failed to read ca bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificate in ca bundle %s", c.file)
+	}
+
+	c.modTime = info.ModTime()
+	c.pool.Store(pool)
+	log.Info().Str("ca", c.file).Msg("ca bundle loaded")
+	return nil
+}
+
+func (c *CAPoolReloader) Pool() *x509.CertPool {
+	return c.pool.Load()
+}
+
+func (c *CAPoolReloader) Name() string {
+	return "tls-ca-" + c.file
+}
+
+func (c *CAPoolReloader) Ready() bool {
+	return true
+}
+
+// Run reloads the bundle when the file changes, an invalid bundle is logged and the current one kept.
+func (c *CAPoolReloader) Run(ctx context.Context) error {
+	c.mu.Lock()
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.running.Store(true)
+	c.mu.Unlock()
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(c.file)
+		if err != nil || info.ModTime().Equal(c.modTime) {
+			continue
+		}
+		if err := c.load(); err != nil {
+			log.Warn().Err(err).Msg("failed to reload ca bundle, keeping the current one")
+		}
+	}
+}
+
+func (c *CAPoolReloader) Close() error {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
+
+	if c.running.Load() {
+		<-c.done
+	}
+
+	return nil
+}
+
+// grpcTLSConfig builds the server config, verifying client certificates against the reloaded CA pool.
+func (s *Service) grpcTLSConfig(cfg GRPCTLSConfig) (*tls.Config, error) {
+	base, err := s.tlsConfig(cfg.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	// the config may be the caller's, see TLSConfig.Config
+	base = base.Clone()
+	if !slices.Contains(base.NextProtos, "h2") {
+		base.NextProtos = append(base.NextProtos, "h2")
+	}
+
+	if cfg.ClientCAFile == "" {
+		if cfg.OptionalClientCert {
+			return nil, errors.New("client certificates require a ca file")
+		}
+		return base, nil
+	}
+
+	ca, err := GetSubService[*CAPoolReloader](s, "tls-ca-"+cfg.ClientCAFile)
+	if err != nil {
+		if ca, err = NewCAPoolReloader(cfg.ClientCAFile, cfg.ReloadInterval); err != nil {
+			return nil, err
+		}
+		if err := s.RegisterSubService(ca); err != nil {
+			return nil, err
+		}
+	}
+
+	clientAuth := tls.RequireAndVerifyClientCert
+	if cfg.OptionalClientCert {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	// the config is rebuilt per handshake, so a reloaded pool applies to new connections
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c := base.Clone()
+			c.ClientAuth = clientAuth
+			c.ClientCAs = ca.Pool()
+			return c, nil
+		},
+	}, nil
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// swappableHandler serves the current handler of an HTTP server, see Service.SwapHandler.
+type swappableHandler struct {
+	current atomic.Pointer[http.Handler]
+}
+
+func newSwappableHandler(handler http.Handler) *swappableHandler {
+	h := &swappableHandler{}
+	h.current.Store(&handler)
+	return h
+}
+
+func (h *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*h.current.Load()).ServeHTTP(w, r)
+}
+
+// SwapHandler atomically replaces the handler of the HTTP server on addr, its configured or bound address,
+// without rebinding the listener. Requests in flight complete with the previous handler, e.g. to apply
+// routing changes from a configuration reload. The handler of the tech server can't be swapped.
+func (s *Service) SwapHandler(addr string, handler http.Handler) error {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+
+	s.addrMu.Lock()
+	defer s.addrMu.Unlock()
+
+	for i, httpServer := range s.HTTPServers {
+		bound := i < len(s.httpListeners) && s.httpListeners[i].Addr().String() == addr
+		if httpServer.Addr != addr && !bound {
+			continue
+		}
+		if s.isInternalServer(httpServer) {
+			return fmt.Errorf("the handler of the internal server %s can't be swapped", addr)
+		}
+
+		if swappable, ok := s.swappable[httpServer]; ok {
+			swappable.current.Store(&handler)
+		} else {
+			// not started yet
+			httpServer.Handler = handler
+		}
+		log.Info().Str("addr", addr).Msg("http handler swapped")
+		return nil
+	}
+
+	return &ServerNotFoundError{Kind: "http", Name: addr}
+}
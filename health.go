@@ -1,9 +1,12 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -65,21 +68,148 @@ type HealthStatus struct {
 	Services  map[string]string `json:"services"`
 }
 
+const (
+	componentHealthy   = "healthy"
+	componentUnhealthy = "unhealthy"
+	componentUnknown   = "unknown"
+	componentPaused    = "paused"
+	componentDegraded  = "degraded"
+)
+
+// ComponentStatus is the result of checking a single dependency. Critical components
+// make the service not ready when they are unhealthy.
+type ComponentStatus struct {
+	Name      string        `json:"name"`
+	Status    string        `json:"status"`
+	Critical  bool          `json:"critical"`
+	Error     string        `json:"error,omitempty"`
+	Latency   time.Duration `json:"latency_ns"`
+	CheckedAt time.Time     `json:"checked_at"`
+
+	failed bool
+}
+
+func newComponentStatus(name string, critical bool, err error, latency time.Duration, checkedAt time.Time) ComponentStatus {
+	status := ComponentStatus{
+		Name:      name,
+		Status:    componentHealthy,
+		Critical:  critical,
+		Latency:   latency,
+		CheckedAt: checkedAt,
+	}
+	if err != nil {
+		status.Status = componentUnhealthy
+		status.Error = err.Error()
+		status.failed = true
+	}
+
+	return status
+}
+
 func (s *Service) GetHealthStatus() HealthStatus {
+	status := "ok"
 	services := make(map[string]string)
-
-	if s.DB != nil {
-		if s.checkDBAlive() {
-			services["database"] = "healthy"
-		} else {
-			services["database"] = "unhealthy"
+	for _, component := range s.checkComponents(s.ctx) {
+		services[component.Name] = component.Status
+		if component.Status == componentDegraded {
+			status = componentDegraded
 		}
 	}
 
 	return HealthStatus{
-		Status:    "ok",
+		Status:    status,
 		Timestamp: time.Now(),
 		Uptime:    time.Since(s.startTime),
 		Services:  services,
 	}
 }
+
+// checkComponents checks databases, subservices, registered health checks and system resources.
+func (s *Service) checkComponents(ctx context.Context) []ComponentStatus {
+	var components []ComponentStatus
+
+	timed := func(name string, critical bool, check func() error) {
+		start := time.Now()
+		err := check()
+		components = append(components, newComponentStatus(name, critical, err, time.Since(start), start))
+	}
+
+	for name := range s.DBs {
+		key := "database"
+		if name != DefaultDBName {
+			key = "database_" + name
+		}
+		timed(key, true, func() error { return s.pingDB(ctx, name) })
+	}
+
+	if s.DBRouter != nil {
+		// reads fall back to the primary, so replicas are not critical
+		start := time.Now()
+		for i, err := range s.DBRouter.checkReplicas(ctx) {
+			components = append(components,
+				newComponentStatus(fmt.Sprintf("database_replica_%d", i), false, err, time.Since(start), start))
+		}
+	}
+
+	for name, subService := range s.subServices() {
+		// paused components wait for their dependencies, the instance still serves the rest
+		if p, ok := subService.(Pauser); ok && p.Paused() {
+			components = append(components, ComponentStatus{
+				Name: name, Status: componentPaused, CheckedAt: time.Now(),
+			})
+			continue
+		}
+
+		timed(name, true, func() error {
+			if !subService.Ready() {
+				return errors.New("subservice not ready")
+			}
+			return nil
+		})
+	}
+
+	// an open breaker is the target failing, not this instance
+	for _, breaker := range s.Breakers.list() {
+		var err error
+		if state := breaker.State(); state != BreakerClosed {
+			err = fmt.Errorf("circuit breaker %s", state)
+		}
+		components = append(components, newComponentStatus("circuit_breaker_"+breaker.Name(), false, err, 0, time.Now()))
+	}
+
+	if s.Prober != nil {
+		components = append(components, s.Prober.components()...)
+	}
+	if s.Budgets != nil {
+		components = append(components, s.Budgets.components()...)
+	}
+
+	for name, check := range s.healthCheckSet() {
+		if check.opts.Criticality == HealthStartup {
+			continue
+		}
+
+		result := check.result(ctx)
+		critical := check.opts.Criticality == HealthReadiness || check.opts.Criticality == HealthLiveness
+		components = append(components, newComponentStatus(name, critical, result.err, result.latency, result.checkedAt))
+	}
+
+	if s.resources != nil {
+		now := time.Now()
+		for _, status := range s.resources.Check() {
+			component := newComponentStatus(status.Check, true, status.Err, 0, now)
+			switch {
+			case status.Err != nil:
+				component.Status = componentUnknown
+				component.failed = false
+			default:
+				component.Status = status.Level.String()
+				component.failed = status.Level == ResourceCritical
+			}
+			components = append(components, component)
+		}
+	}
+
+	sort.Slice(components, func(i, j int) bool { return components[i].Name < components[j].Name })
+	return components
+}
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultHealthCheckTimeout  = 5 * time.Second
+	defaultHealthCheckCacheTTL = 10 * time.Second
+	startupCheckRetryInterval  = time.Second
+)
+
+// HealthCriticality decides which probe a failing health check affects.
+type HealthCriticality int
+
+const (
+	// HealthReadiness failures make the service not ready, e.g. a dependency it can't serve without.
+	HealthReadiness HealthCriticality = iota
+	// HealthLiveness failures make the service not alive (and not ready), meaning it should be restarted.
+	HealthLiveness
+	// HealthInformational failures are only reported by the health status.
+	HealthInformational
+	// HealthStartup checks are boot-time gates, e.g. migrations applied or caches warmed. The service
+	// is not started until they all passed once, then they are no longer run.
+	HealthStartup
+)
+
+func (c HealthCriticality) String() string {
+	switch c {
+	case HealthReadiness:
+		return "readiness"
+	case HealthLiveness:
+		return "liveness"
+	case HealthInformational:
+		return "informational"
+	case HealthStartup:
+		return "startup"
+	default:
+		return "unknown"
+	}
+}
+
+type HealthCheckFunc func(ctx context.Context) error
+
+type HealthCheckOptions struct {
+	Criticality HealthCriticality
+	// Timeout bounds a single run of the check, zero means defaultHealthCheckTimeout.
+	Timeout time.Duration
+	// CacheTTL is how long a result is reused, zero means defaultHealthCheckCacheTTL.
+	CacheTTL time.Duration
+}
+
+type healthCheck struct {
+	name string
+	fn   HealthCheckFunc
+	opts HealthCheckOptions
+	// onFailure is called when the check starts failing
+	onFailure func(err error)
+
+	mu   sync.Mutex
+	last healthCheckResult
+}
+
+type healthCheckResult struct {
+	err       error
+	latency   time.Duration
+	checkedAt time.Time
+}
+
+// cached returns the last result without running the check, false when it never ran.
+func (c *healthCheck) cached() (healthCheckResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.last, !c.last.checkedAt.IsZero()
+}
+
+// result returns the cached result or runs the check, concurrent callers wait for the same run.
+func (c *healthCheck) result(ctx context.Context) healthCheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.last.checkedAt.IsZero() && time.Since(c.last.checkedAt) < c.opts.CacheTTL {
+		return c.last
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.run(ctx)
+	wasFailing := c.last.err != nil
+	c.last = healthCheckResult{err: err, latency: time.Since(start), checkedAt: start}
+	if err != nil {
+		log.Debug().Err(err).Str("check", c.name).Msg("health check failed")
+		if !wasFailing && c.onFailure != nil {
+			c.onFailure(&HealthCheckError{Check: c.name, Criticality: c.opts.Criticality, Err: err})
+		}
+	}
+
+	return c.last
+}
+
+func (c *healthCheck) run(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("health check panicked: %v", r)
+		}
+	}()
+
+	return c.fn(ctx)
+}
+
+// RegisterHealthCheck adds a check reported by the health status and, depending on its criticality,
+// taken into account by the startup, liveness or readiness probe. Checks must be registered before Start.
+func (s *Service) RegisterHealthCheck(name string, fn HealthCheckFunc, opts HealthCheckOptions) error {
+	if fn == nil {
+		return fmt.Errorf("health check %q is nil", name)
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultHealthCheckTimeout
+	}
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = defaultHealthCheckCacheTTL
+	}
+
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	if _, ok := s.healthChecks[name]; ok {
+		return fmt.Errorf("health check %q already registered", name)
+	}
+	s.healthChecks[name] = &healthCheck{name: name, fn: fn, opts: opts, onFailure: s.notifyReporter}
+	return nil
+}
+
+func (s *Service) removeHealthCheck(name string) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	delete(s.healthChecks, name)
+}
+
+// healthCheckSet returns a snapshot of the checks, which are run without holding the lock.
+func (s *Service) healthCheckSet() map[string]*healthCheck {
+	s.healthMu.RLock()
+	defer s.healthMu.RUnlock()
+
+	return maps.Clone(s.healthChecks)
+}
+
+// checkHealthChecks runs the checks having one of the given criticalities, returning false if any failed.
+func (s *Service) checkHealthChecks(criticalities ...HealthCriticality) bool {
+	healthy := true
+	for _, check := range s.healthCheckSet() {
+		for _, c := range criticalities {
+			if check.opts.Criticality == c && check.result(s.ctx).err != nil {
+				healthy = false
+			}
+		}
+	}
+
+	return healthy
+}
+
+// waitStartupChecks runs each startup check until it passes, returning false if ctx is done first.
+func (s *Service) waitStartupChecks(ctx context.Context) bool {
+	for _, check := range s.healthCheckSet() {
+		if check.opts.Criticality != HealthStartup {
+			continue
+		}
+
+		for {
+			checkCtx, cancel := context.WithTimeout(ctx, check.opts.Timeout)
+			err := check.run(checkCtx)
+			cancel()
+			if err == nil {
+				log.Info().Str("check", check.name).Msg("startup check passed")
+				break
+			}
+			log.Info().Err(err).Str("check", check.name).Msg("waiting for startup check")
+
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(startupCheckRetryInterval):
+			}
+		}
+	}
+
+	return true
+}
+
+type HealthCheckOption struct {
+	name string
+	fn   HealthCheckFunc
+	opts HealthCheckOptions
+}
+
+func (w HealthCheckOption) Apply(s *Service) error {
+	return s.RegisterHealthCheck(w.name, w.fn, w.opts)
+}
+
+// WithHealthCheck registers a health check at construction, see Service.RegisterHealthCheck.
+func WithHealthCheck(name string, fn HealthCheckFunc, opts HealthCheckOptions) Option {
+	return HealthCheckOption{name: name, fn: fn, opts: opts}
+}
@@ -0,0 +1,351 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultHTTPCacheRetention   = 24 * time.Hour
+	defaultHTTPCacheMaxBodySize = 1 << 20
+)
+
+// HTTPCacheConfig caches the GET responses of a client per RFC 9111: fresh responses are served from the
+// storage, stale ones with an ETag or Last-Modified are revalidated with a conditional request.
+type HTTPCacheConfig struct {
+	// Storage of the responses, e.g. a RedisCache or Service.Cache to share them between the instances,
+	// nil means a MemoryCache of defaultCacheMaxEntries. The responses to requests carrying their own
+	// Authorization header are stored only if public, those authorized by the OAuth2 option are.
+	Storage CacheBackend
+	// Retention keeps the responses with a validator once stale, to revalidate them, zero means
+	// defaultHTTPCacheRetention.
+	Retention time.Duration
+	// MaxBodySize of the responses stored, larger ones are passed through, zero means
+	// defaultHTTPCacheMaxBodySize.
+	MaxBodySize int64
+}
+
+// cacheableStatus are the status codes cacheable by default, RFC 9110 section 15.1.
+var cacheableStatus = map[int]bool{
+	http.StatusOK: true, http.StatusNonAuthoritativeInfo: true, http.StatusNoContent: true,
+	http.StatusMultipleChoices: true, http.StatusMovedPermanently: true, http.StatusPermanentRedirect: true,
+	http.StatusNotFound: true, http.StatusMethodNotAllowed: true, http.StatusGone: true,
+	http.StatusRequestURITooLong: true, http.StatusNotImplemented: true,
+}
+
+type cachedResponse struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt time.Time   `json:"stored_at"`
+	// Vary holds the values of the request headers listed by the Vary header of the response.
+	Vary map[string]string `json:"vary,omitempty"`
+}
+
+// age is the Age of the response when stored plus the time since, RFC 9111 section 4.2.3.
+func (c *cachedResponse) age(now time.Time) time.Duration {
+	age := now.Sub(c.StoredAt)
+	if seconds, err := strconv.Atoi(c.Header.Get("Age")); err == nil && seconds > 0 {
+		age += time.Duration(seconds) * time.Second
+	}
+	return max(age, 0)
+}
+
+func (c *cachedResponse) fresh(req *http.Request, now time.Time) bool {
+	respCC := parseCacheControl(c.Header)
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := respCC["no-cache"]; ok {
+		return false
+	}
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+
+	age := c.age(now)
+	if maxAge, ok := cacheControlSeconds(reqCC, "max-age"); ok && age > maxAge {
+		return false
+	}
+	lifetime := freshnessLifetime(c.Header, respCC, c.StoredAt)
+	if minFresh, ok := cacheControlSeconds(reqCC, "min-fresh"); ok {
+		lifetime -= minFresh
+	}
+	return age < lifetime
+}
+
+// matches reports whether the request selects this response, RFC 9111 section 4.1.
+func (c *cachedResponse) matches(req *http.Request) bool {
+	for name, value := range c.Vary {
+		if strings.Join(req.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *cachedResponse) response(req *http.Request, now time.Time) *http.Response {
+	header := c.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(c.age(now).Seconds())))
+
+	return &http.Response{
+		Status:        strconv.Itoa(c.Status) + " " + http.StatusText(c.Status),
+		StatusCode:    c.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// parseCacheControl returns the directives of the Cache-Control headers, lower cased, with their unquoted
+// argument if any.
+func parseCacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+func cacheControlSeconds(cc map[string]string, directive string) (time.Duration, bool) {
+	value, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// freshnessLifetime is max-age, or Expires minus Date, RFC 9111 section 4.2.1. Responses without either
+// are stale at once: no heuristic freshness, they are revalidated.
+func freshnessLifetime(h http.Header, cc map[string]string, storedAt time.Time) time.Duration {
+	if maxAge, ok := cacheControlSeconds(cc, "max-age"); ok {
+		return maxAge
+	}
+	expires := h.Get("Expires")
+	if expires == "" {
+		return 0
+	}
+	// an invalid Expires means already expired
+	expiresAt, err := http.ParseTime(expires)
+	if err != nil {
+		return 0
+	}
+	date, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		date = storedAt
+	}
+	return expiresAt.Sub(date)
+}
+
+// httpCache is the outermost transport of a client: hits are not sent, so they don't count as attempts.
+type httpCache struct {
+	name     string
+	base     http.RoundTripper
+	cfg      HTTPCacheConfig
+	requests *prometheus.CounterVec
+}
+
+func newHTTPCacheRequests() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_cache_requests_total",
+		Help: "Cacheable outbound HTTP requests by client and result: hit, miss or revalidated.",
+	}, []string{"client", "result"})
+}
+
+func newHTTPCache(name string, cfg HTTPCacheConfig, base http.RoundTripper, requests *prometheus.CounterVec) *httpCache {
+	if cfg.Storage == nil {
+		cfg.Storage = NewMemoryCache(0)
+	}
+	if cfg.Retention == 0 {
+		cfg.Retention = defaultHTTPCacheRetention
+	}
+	if cfg.MaxBodySize == 0 {
+		cfg.MaxBodySize = defaultHTTPCacheMaxBodySize
+	}
+
+	return &httpCache{name: name, base: base, cfg: cfg, requests: requests}
+}
+
+func (c *httpCache) key(req *http.Request) string {
+	return "http:" + c.name + ":" + req.URL.String()
+}
+
+func (c *httpCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		resp, err := c.base.RoundTrip(req)
+		// unsafe methods invalidate the stored response, RFC 9111 section 4.4
+		if err == nil && resp.StatusCode < http.StatusBadRequest && req.Method != http.MethodHead && req.Method != http.MethodOptions {
+			c.delete(req)
+		}
+		return resp, err
+	}
+	if _, ok := parseCacheControl(req.Header)["no-store"]; ok || req.Header.Get("Range") != "" {
+		return c.base.RoundTrip(req)
+	}
+
+	stored := c.load(req)
+	now := time.Now()
+	if stored != nil && stored.fresh(req, now) {
+		c.requests.WithLabelValues(c.name, "hit").Inc()
+		return stored.response(req, now), nil
+	}
+
+	sent := req
+	if stored != nil {
+		sent = conditional(req, stored)
+	}
+
+	resp, err := c.base.RoundTrip(sent)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored != nil && resp.StatusCode == http.StatusNotModified {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		c.requests.WithLabelValues(c.name, "revalidated").Inc()
+
+		// the 304 carries the updated metadata, RFC 9111 section 4.3.4
+		for name, values := range resp.Header {
+			if name != "Content-Length" {
+				stored.Header[name] = values
+			}
+		}
+		stored.StoredAt = time.Now()
+		c.store(req, stored)
+		return stored.response(req, stored.StoredAt), nil
+	}
+
+	c.requests.WithLabelValues(c.name, "miss").Inc()
+	return c.capture(req, resp), nil
+}
+
+// conditional asks the target to validate the stored response instead of sending it again.
+func conditional(req *http.Request, stored *cachedResponse) *http.Request {
+	etag, lastModified := stored.Header.Get("ETag"), stored.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return req
+	}
+
+	req = req.Clone(req.Context())
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return req
+}
+
+// capture stores the response if it is cacheable, RFC 9111 section 3, reading its body up to MaxBodySize.
+func (c *httpCache) capture(req *http.Request, resp *http.Response) *http.Response {
+	respCC := parseCacheControl(resp.Header)
+	if !cacheableStatus[resp.StatusCode] || resp.Header.Get("Vary") == "*" {
+		return resp
+	}
+	if _, ok := respCC["no-store"]; ok {
+		return resp
+	}
+	// a request authorized by the caller may be cached only if the response says so, section 3.5
+	if req.Header.Get("Authorization") != "" {
+		_, public := respCC["public"]
+		_, mustRevalidate := respCC["must-revalidate"]
+		_, sMaxAge := respCC["s-maxage"]
+		if !public && !mustRevalidate && !sMaxAge {
+			return resp
+		}
+	}
+
+	now := time.Now()
+	lifetime := freshnessLifetime(resp.Header, respCC, now)
+	validated := resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+	if lifetime <= 0 && !validated {
+		return resp
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.cfg.MaxBodySize+1))
+	if err != nil || int64(len(body)) > c.cfg.MaxBodySize {
+		// too large or failed, the caller reads the rest or gets the error
+		resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		return resp
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	stored := &cachedResponse{Status: resp.StatusCode, Header: resp.Header.Clone(), Body: body, StoredAt: now}
+	for _, value := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" {
+				if stored.Vary == nil {
+					stored.Vary = make(map[string]string)
+				}
+				stored.Vary[name] = strings.Join(req.Header.Values(name), ", ")
+			}
+		}
+	}
+	c.store(req, stored)
+	return resp
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// load returns the response stored for the request, nil on a miss or a storage error.
+func (c *httpCache) load(req *http.Request) *cachedResponse {
+	data, ok, err := c.cfg.Storage.Get(req.Context(), c.key(req))
+	if err != nil {
+		log.Warn().Err(err).Str("client", c.name).Msg("failed to read the http cache")
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	var stored cachedResponse
+	if err := json.Unmarshal(data, &stored); err != nil || !stored.matches(req) {
+		return nil
+	}
+	return &stored
+}
+
+func (c *httpCache) store(req *http.Request, stored *cachedResponse) {
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return
+	}
+
+	ttl := freshnessLifetime(stored.Header, parseCacheControl(stored.Header), stored.StoredAt)
+	if stored.Header.Get("ETag") != "" || stored.Header.Get("Last-Modified") != "" {
+		ttl = max(ttl, c.cfg.Retention)
+	}
+	if err := c.cfg.Storage.Set(req.Context(), c.key(req), data, ttl); err != nil {
+		log.Warn().Err(err).Str("client", c.name).Msg("failed to write the http cache")
+	}
+}
+
+func (c *httpCache) delete(req *http.Request) {
+	if err := c.cfg.Storage.Delete(req.Context(), c.key(req)); err != nil {
+		log.Warn().Err(err).Str("client", c.name).Msg("failed to invalidate the http cache")
+	}
+}
@@ -0,0 +1,339 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	defaultHTTPClientTimeout     = 30 * time.Second
+	defaultHTTPClientIdleConns   = 100
+	defaultHTTPClientIdleTimeout = 90 * time.Second
+	defaultHTTPClientRetries     = 2
+	defaultHTTPClientBackoff     = 100 * time.Millisecond
+	defaultHTTPClientMaxBackoff  = 2 * time.Second
+)
+
+type HTTPClientOptions struct {
+	// Timeout bounds a request including its retries, zero means defaultHTTPClientTimeout.
+	Timeout time.Duration
+	// MaxIdleConnsPerHost is the pool of keep-alive connections to the target, zero means
+	// defaultHTTPClientIdleConns. MaxConnsPerHost limits all connections, zero means no limit.
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	// IdleConnTimeout closes unused connections, zero means defaultHTTPClientIdleTimeout.
+	IdleConnTimeout time.Duration
+	// MaxRetries of idempotent requests, zero means defaultHTTPClientRetries and a negative value disables them.
+	MaxRetries int
+	// Backoff before the first retry, doubled for each next one up to MaxBackoff, with jitter. Zero values
+	// mean defaultHTTPClientBackoff and defaultHTTPClientMaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Transport is the base transport, cloned, http.DefaultTransport when nil.
+	Transport *http.Transport
+	// CircuitBreaker guards the target with the breaker called like the client, nil disables it. Network
+	// errors and 5xx responses are failures.
+	CircuitBreaker *BreakerConfig
+	// OAuth2 authorizes the requests with the access token of these client credentials, renewed before it
+	// expires or once rejected with 401, nil disables it.
+	OAuth2 *OAuth2Config
+	// Cache serves the GET responses from a cache honoring Cache-Control and revalidating with ETag or
+	// Last-Modified, nil disables it.
+	Cache *HTTPCacheConfig
+	// Locality sends the requests to the endpoints of the local zone in place of the URL host, spilling
+	// over to the other zones while they fail, nil disables it.
+	Locality *LocalityConfig
+	// WarmUp establishes connections to the target when the service starts, the client must be created
+	// before Start, nil disables it.
+	WarmUp *WarmUpConfig
+}
+
+func (o *HTTPClientOptions) setDefaults() {
+	if o.Timeout == 0 {
+		o.Timeout = defaultHTTPClientTimeout
+	}
+	if o.MaxIdleConnsPerHost == 0 {
+		o.MaxIdleConnsPerHost = defaultHTTPClientIdleConns
+	}
+	if o.IdleConnTimeout == 0 {
+		o.IdleConnTimeout = defaultHTTPClientIdleTimeout
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = defaultHTTPClientRetries
+	}
+	if o.Backoff == 0 {
+		o.Backoff = defaultHTTPClientBackoff
+	}
+	if o.MaxBackoff == 0 {
+		o.MaxBackoff = defaultHTTPClientMaxBackoff
+	}
+}
+
+type httpClientMetrics struct {
+	duration *prometheus.HistogramVec
+	retries  *prometheus.CounterVec
+	cache    *prometheus.CounterVec
+}
+
+func newHTTPClientMetrics() httpClientMetrics {
+	return httpClientMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_client_request_duration_seconds",
+			Help:    "Duration of outbound HTTP requests by client, method and result: the status code or error.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"client", "method", "result"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_retries_total",
+			Help: "Retried outbound HTTP requests by client.",
+		}, []string{"client"}),
+		cache: newHTTPCacheRequests(),
+	}
+}
+
+// HTTPClient returns a client for the target called name, which labels its metrics. Connections are pooled
+// per opts, requests carry the trace context and the request id of their context, and idempotent requests
+// failing with a network error, 429, 502, 503 or 504 are retried with backoff. With WithDNSRefresh, idle
+// connections are recycled when the target addresses change. With WithLatencyBudgets, attempts count
+// against the budget of name. With opts.OAuth2, requests carry the access token of the client credentials,
+// with opts.Cache, GET responses are cached per RFC 9111, and with opts.Locality, requests go to the local
+// zone first.
+func (s *Service) HTTPClient(name string, opts HTTPClientOptions) *http.Client {
+	opts.setDefaults()
+
+	m := newHTTPClientMetrics()
+	m.duration = registerCollector(s.registry, m.duration)
+	m.retries = registerCollector(s.registry, m.retries)
+	m.cache = registerCollector(s.registry, m.cache)
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.Transport != nil {
+		base = opts.Transport.Clone()
+	}
+	base.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	if base.MaxIdleConns < opts.MaxIdleConnsPerHost {
+		base.MaxIdleConns = opts.MaxIdleConnsPerHost
+	}
+	base.MaxConnsPerHost = opts.MaxConnsPerHost
+	base.IdleConnTimeout = opts.IdleConnTimeout
+
+	wrap := func(base *http.Transport) http.RoundTripper {
+		if s.DNSRefresher != nil {
+			return s.DNSRefresher.Transport(base)
+		}
+		return base
+	}
+	var transport http.RoundTripper
+	// each attempt is routed, so retries go to another endpoint once one failed
+	if opts.Locality != nil {
+		transport = newLocalityTransport(s.newLocalityRouter(name, *opts.Locality), base, wrap)
+	} else {
+		transport = wrap(base)
+	}
+
+	t := &clientTransport{
+		name:     name,
+		base:     RequestIDTransport(transport),
+		opts:     opts,
+		duration: m.duration.MustCurryWith(prometheus.Labels{"client": name}),
+		retries:  m.retries.WithLabelValues(name),
+		observe:  func(latency time.Duration) { s.observeDependency(name, latency) },
+	}
+	if opts.CircuitBreaker != nil {
+		t.breaker = s.CircuitBreaker(name, *opts.CircuitBreaker)
+	}
+
+	var rt http.RoundTripper = t
+	if opts.OAuth2 != nil {
+		rt = &oauth2Transport{source: s.OAuth2TokenSource(name, *opts.OAuth2), base: rt}
+	}
+	// the responses are cached before authorization, see HTTPCacheConfig
+	if opts.Cache != nil {
+		rt = newHTTPCache(name, *opts.Cache, rt, m.cache)
+	}
+
+	client := &http.Client{Timeout: opts.Timeout, Transport: rt}
+	if opts.WarmUp != nil {
+		run := s.warmUpHTTP(client, *opts.WarmUp, opts.Locality == nil)
+		s.addWarmUp(warmUpTarget{name: name, timeout: opts.WarmUp.Timeout, run: run})
+	}
+	return client
+}
+
+type clientTransport struct {
+	name     string
+	base     http.RoundTripper
+	opts     HTTPClientOptions
+	duration prometheus.ObserverVec
+	retries  prometheus.Counter
+	breaker  *CircuitBreaker
+	observe  func(time.Duration)
+}
+
+func (t *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.attempt(req)
+		if errors.Is(err, ErrBreakerOpen) {
+			return nil, err
+		}
+		if attempt >= t.opts.MaxRetries || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt, resp)
+		if resp != nil {
+			// the connection is reused once the body is drained
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		result := "error"
+		if err == nil {
+			result = strconv.Itoa(resp.StatusCode)
+		}
+		log.Debug().Str("client", t.name).Str("method", req.Method).Str("result", result).
+			Int("attempt", attempt+1).Dur("backoff", wait).Msg("retrying http request")
+		t.retries.Inc()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends the request once, through the circuit breaker if any.
+func (t *clientTransport) attempt(req *http.Request) (*http.Response, error) {
+	var probe bool
+	if t.breaker != nil {
+		var err error
+		if probe, err = t.breaker.allow(); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	latency := time.Since(start)
+
+	result := "error"
+	if err == nil {
+		result = strconv.Itoa(resp.StatusCode)
+	}
+	t.duration.WithLabelValues(req.Method, result).Observe(latency.Seconds())
+	t.observe(latency)
+
+	if t.breaker != nil {
+		result := newBreakerResult(err == nil && resp.StatusCode < http.StatusInternalServerError)
+		if err != nil && callerCanceled(req.Context(), err) {
+			result = breakerIgnored
+		}
+		t.breaker.record(probe, result)
+	}
+	return resp, err
+}
+
+// backoff doubles the delay for each attempt with up to 20% jitter, a Retry-After in seconds takes
+// precedence, both capped at MaxBackoff.
+func (t *clientTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	wait := t.opts.Backoff << attempt
+	wait += time.Duration(rand.Float64() * 0.2 * float64(wait))
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			wait = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return min(wait, t.opts.MaxBackoff)
+}
+
+// retryable reports whether the attempt failed transiently and the request can be sent again: idempotent,
+// or carrying an Idempotency-Key, and with a body which can be replayed.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		if req.Header.Get("Idempotency-Key") == "" {
+			return false
+		}
+	}
+
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// jsonRequest sends a JSON request to a REST API and decodes the JSON response into out. It returns the
+// status of the response, zero when there was none, so callers map it to their own errors.
+func jsonRequest(ctx context.Context, client *http.Client, method, url string, header http.Header, in, out any) (int, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
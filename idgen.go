@@ -0,0 +1,298 @@
+package app
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Snowflake IDs are a sign bit, 41 bits of milliseconds since the epoch (69 years), 10 bits of node
+// and 12 bits of sequence, so 4096 IDs per millisecond and node.
+const (
+	idNodeBits     = 10
+	idSequenceBits = 12
+	idMaxNode      = 1<<idNodeBits - 1
+	idSequenceMask = 1<<idSequenceBits - 1
+
+	defaultIDNodeTTL         = 30 * time.Second
+	idNodeClaimRetryInterval = time.Second
+	idNodeReleaseTimeout     = 5 * time.Second
+)
+
+// DefaultIDEpoch is the epoch of the IDs unless IDGeneratorConfig.Epoch is set.
+var DefaultIDEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var ErrIDGeneratorDisabled = errors.New("id generator is not configured, see WithIDGenerator")
+
+type IDGeneratorConfig struct {
+	// Namespace of the node IDs, the instances sharing it never hold the same node. Defaults to the
+	// service name.
+	Namespace string
+	// Epoch must never change once IDs were generated, defaults to DefaultIDEpoch.
+	Epoch time.Time
+	// NodeTTL is the ttl of the node lock, see Locks.Acquire, zero means 30s.
+	NodeTTL time.Duration
+}
+
+// IDGenerator generates Snowflake IDs: 64-bit, unique across the instances and sortable by time. Each
+// instance claims one of the 1024 nodes of the namespace with a distributed lock, see WithLocks, and
+// holds it while running. It is a subservice, ready once a node is claimed.
+type IDGenerator struct {
+	locks *Locks
+	cfg   IDGeneratorConfig
+
+	mu       sync.Mutex
+	node     int64
+	lock     *Lock
+	claimed  chan struct{}
+	last     int64
+	sequence int64
+
+	generated prometheus.Counter
+	nodeGauge prometheus.Gauge
+}
+
+type idGeneratorMetrics struct {
+	generated prometheus.Counter
+	node      prometheus.Gauge
+}
+
+func NewIDGenerator(locks *Locks, cfg IDGeneratorConfig) *IDGenerator {
+	if cfg.Epoch.IsZero() {
+		cfg.Epoch = DefaultIDEpoch
+	}
+	if cfg.NodeTTL == 0 {
+		cfg.NodeTTL = defaultIDNodeTTL
+	}
+
+	g := &IDGenerator{locks: locks, cfg: cfg, node: -1, claimed: make(chan struct{})}
+	g.setMetrics(newIDGeneratorMetrics())
+	return g
+}
+
+func newIDGeneratorMetrics() idGeneratorMetrics {
+	return idGeneratorMetrics{
+		generated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "id_generator_ids_total",
+			Help: "Snowflake IDs generated.",
+		}),
+		node: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "id_generator_node",
+			Help: "Node claimed by the instance, -1 while none is.",
+		}),
+	}
+}
+
+func (g *IDGenerator) setMetrics(m idGeneratorMetrics) {
+	g.generated = m.generated
+	g.nodeGauge = m.node
+	g.nodeGauge.Set(-1)
+}
+
+func (g *IDGenerator) Name() string {
+	return "id-generator"
+}
+
+// ShutdownPriority releases the node after the producers and consumers generating IDs stopped.
+func (g *IDGenerator) ShutdownPriority() int {
+	return ShutdownPriorityProducer
+}
+
+func (g *IDGenerator) Ready() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.node >= 0
+}
+
+// Run claims a node and claims another one whenever its lock is lost.
+func (g *IDGenerator) Run(ctx context.Context) error {
+	for {
+		lock, err := g.claim(ctx)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-lock.Lost():
+			log.Error().Str("lock", lock.Key()).Msg("id generator node lost, claiming another one")
+			_ = g.release(context.Background())
+		}
+	}
+}
+
+// claim tries the nodes from a random one until it gets one, waiting while they are all held.
+func (g *IDGenerator) claim(ctx context.Context) (*Lock, error) {
+	for {
+		start := rand.IntN(idMaxNode + 1)
+		for i := range idMaxNode + 1 {
+			node := int64((start + i) % (idMaxNode + 1))
+			key := fmt.Sprintf("id-generator/%s/%d", g.cfg.Namespace, node)
+
+			lock, err := g.locks.TryAcquire(ctx, key, g.cfg.NodeTTL)
+			if errors.Is(err, ErrLockNotAcquired) {
+				continue
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				log.Warn().Err(err).Msg("failed to claim an id generator node, retrying")
+				break
+			}
+
+			g.mu.Lock()
+			g.node = node
+			g.lock = lock
+			close(g.claimed)
+			g.mu.Unlock()
+
+			g.nodeGauge.Set(float64(node))
+			log.Info().Int64("node", node).Msg("id generator node claimed")
+			return lock, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(idNodeClaimRetryInterval):
+		}
+	}
+}
+
+func (g *IDGenerator) release(ctx context.Context) error {
+	g.mu.Lock()
+	lock := g.lock
+	g.node = -1
+	g.lock = nil
+	g.claimed = make(chan struct{})
+	g.mu.Unlock()
+
+	g.nodeGauge.Set(-1)
+	if lock == nil {
+		return nil
+	}
+	return lock.Release(ctx)
+}
+
+// Close releases the node, the IDs generated afterwards wait for another one to be claimed.
+func (g *IDGenerator) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), idNodeReleaseTimeout)
+	defer cancel()
+
+	return g.release(ctx)
+}
+
+// Next returns a new ID, waiting until ctx is done for a node to be held, see Lock.Held: no ID is
+// issued once the renewal of the node lock lags, as another instance may claim the node soon. The IDs
+// of an instance are strictly increasing, even when the clock moves backwards.
+func (g *IDGenerator) Next(ctx context.Context) (int64, error) {
+	g.mu.Lock()
+	for g.node < 0 || !g.lock.Held() {
+		claimed := g.claimed
+		var retry <-chan time.Time
+		if g.node >= 0 {
+			// the lagging lock is renewed or lost, then released by Run
+			claimed, retry = nil, time.After(lockRetryInterval)
+		}
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("no id generator node held: %w", ctx.Err())
+		case <-claimed:
+		case <-retry:
+		}
+		g.mu.Lock()
+	}
+	defer g.mu.Unlock()
+
+	now := time.Since(g.cfg.Epoch).Milliseconds()
+	// the clock moved backwards, the last millisecond is kept so the IDs remain ordered
+	now = max(now, g.last)
+	if now == g.last {
+		g.sequence = (g.sequence + 1) & idSequenceMask
+		if g.sequence == 0 {
+			// sequence exhausted, the next millisecond is borrowed
+			now++
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.last = now
+
+	g.generated.Inc()
+	return now<<(idNodeBits+idSequenceBits) | g.node<<idSequenceBits | g.sequence, nil
+}
+
+// Time returns the time an ID was generated, to the millisecond.
+func (g *IDGenerator) Time(id int64) time.Time {
+	return g.cfg.Epoch.Add(time.Duration(id>>(idNodeBits+idSequenceBits)) * time.Millisecond)
+}
+
+// NextID returns a new Snowflake ID, see IDGenerator.Next.
+func (s *Service) NextID(ctx context.Context) (int64, error) {
+	if s.IDs == nil {
+		return 0, ErrIDGeneratorDisabled
+	}
+	return s.IDs.Next(ctx)
+}
+
+// NewUUIDv7 returns a UUID starting with the current Unix time in milliseconds, so sortable by time and
+// friendly to B-tree indexes, when IDs can be 128-bit and no coordination is wanted.
+func NewUUIDv7() uuid.UUID {
+	return uuid.Must(uuid.NewV7())
+}
+
+// UUIDv7Time returns the time a UUIDv7 was generated, to the millisecond.
+func UUIDv7Time(id uuid.UUID) time.Time {
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(id[:8]) >> 16))
+}
+
+// UUIDv7Min returns the lowest UUIDv7 of t, e.g. to select the rows created since t by their ID.
+func UUIDv7Min(t time.Time) uuid.UUID {
+	var id uuid.UUID
+	binary.BigEndian.PutUint64(id[:8], uint64(t.UnixMilli())<<16|0x7000)
+	id[8] = 0x80
+	return id
+}
+
+type IDGeneratorOption struct {
+	cfg IDGeneratorConfig
+}
+
+func (w IDGeneratorOption) Apply(s *Service) error {
+	if s.Locks == nil {
+		return requireOption(errors.New("id generator requires WithLocks to be applied first"), LocksOption{})
+	}
+
+	cfg := w.cfg
+	if cfg.Namespace == "" {
+		cfg.Namespace = s.Name
+	}
+
+	g := NewIDGenerator(s.Locks, cfg)
+	m := newIDGeneratorMetrics()
+	m.generated = registerCollector(s.registry, m.generated)
+	m.node = registerCollector(s.registry, m.node)
+	g.setMetrics(m)
+
+	s.IDs = g
+	return s.RegisterSubService(g)
+}
+
+// WithIDGenerator exposes a Snowflake ID generator as Service.IDs, its nodes coordinated through the
+// locks of WithLocks, Postgres or Redis.
+func WithIDGenerator(cfg IDGeneratorConfig) Option {
+	return IDGeneratorOption{cfg: cfg}
+}
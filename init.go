@@ -6,13 +6,24 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/rs/zerolog/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 type Option interface {
@@ -25,47 +36,212 @@ type SubService interface {
 	Close() error
 }
 
+// Runner is implemented by subservices doing background work, Run is started by Service.Start
+// and is expected to return once Close is called.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
 type GRPCServer struct {
-	address string
-	server  *grpc.Server
+	address  string
+	server   *grpc.Server
+	listener net.Listener
+	tls      bool
+	// local serves the in-process connection of a gateway, see WithGRPCGateway
+	local *bufconn.Listener
 }
 
 type Service struct {
-	Name        string
-	ctx         context.Context
-	GRPCServers []*GRPCServer
-	HTTPServers []*http.Server
-	DB          *pgxpool.Pool
-	isReady     *atomic.Value
-	ErrChan     chan error
-	SubServices map[string]SubService
-	sigHandler  SignalTrap
-	startTime   time.Time
-	version     string
+	Name          string
+	ctx           context.Context
+	GRPCServers   []*GRPCServer
+	HTTPServers   []*http.Server
+	httpListeners []net.Listener
+	addrMu        sync.RWMutex
+	DB            *pgxpool.Pool
+	DBs           map[string]*pgxpool.Pool
+	DBRouter      *DBRouter
+	KafkaProducer *KafkaProducer
+	NATS          *NATSClient
+	ClickHouse    *ClickHouseClient
+	AMQP          *AMQPClient
+	DNSRefresher  *DNSRefresher
+	Scheduler     *Scheduler
+	Encrypter     *PayloadEncrypter
+	Compressor    *PayloadCompressor
+	KMS           KMS
+	Outbox        *Outbox
+	Backups       *Backups
+	SLO           *SLOs
+	Usage         *Usage
+	Quotas        *Quotas
+	Lifeboat      *Lifeboat
+	LoadShedder   *LoadShedder
+	Config        *ConfigReloader
+	Vault         *Vault
+	AWS           *aws.Config
+	ObjectStorage *ObjectStorage
+	ClaimCheck    *ClaimCheck
+	GCP           *GCP
+	Breakers      *CircuitBreakers
+	Leader        *LeaderElector
+	Prober        *Prober
+	Budgets       *LatencyBudgets
+	Locks         *Locks
+	IDs           *IDGenerator
+	Cache         *Cache
+	Plugins       *Plugins
+	isStarted     *atomic.Value
+	isServing     *atomic.Value
+	ErrChan       chan error
+	errMu         sync.RWMutex
+	errClosed     bool
+	// Deprecated: use RegisterSubService and GetSubService, writing the map directly races with the
+	// readers of a running service.
+	SubServices   map[string]SubService
+	subMu         sync.RWMutex
+	runCancels    map[string]context.CancelFunc
+	sigHandler    SignalTrap
+	startTime     time.Time
+	buildInfo     BuildInfo
+	registry      *prometheus.Registry
+	techRouter    chi.Router
+	metricsCfg    MetricsConfig
+	resources     *ResourceChecker
+	shutdownOrder map[string]int
+	healthMu      sync.RWMutex
+	healthChecks  map[string]*healthCheck
+	drainDelay    time.Duration
+	shutdownPhase *prometheus.GaugeVec
+	errReporter   ErrorReporter
+	stopTimeout   time.Duration
+	forcedExit    func(code int)
+	startup       StartupPolicy
+	stopOnce      sync.Once
+	otelLogs      *sdklog.LoggerProvider
+	preStart      []PreStartTask
+	inFlight      *inFlightTracker
+	techServer    *http.Server
+	stopReport    *shutdownRecorder
+	reportPath    string
+	gateways      []*grpcGateway
+	swappable     map[*http.Server]*swappableHandler
+	reflection    *grpcReflection
+	selfTests     []SelfTest
+	exclusions    *telemetryFilter
+	lifecycle     *lifecycleMetrics
+	streamMetrics *grpcStreamMetrics
+	leaks         *leakDetector
+	pprofCfg      PprofConfig
+	debugServer   *http.Server
+	vars          *serviceVars
+	warmUps       *warmUpRegistry
+	journal       *ErrorJournal
+	adminUI       *adminUI
+	failedOptions []reflect.Type
 }
 
 func New(ctx context.Context, name string, options ...Option) (*Service, error) {
-	isReady := &atomic.Value{}
-	isReady.Store(false)
+	isStarted := &atomic.Value{}
+	isStarted.Store(false)
+
+	isServing := &atomic.Value{}
+	isServing.Store(true)
+
+	// adding gometrics
+	prometheusRegistry := prometheus.NewRegistry()
+	prometheusRegistry.MustRegister(collectors.NewGoCollector())
+	prometheusRegistry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	shutdownPhase := newShutdownPhaseMetric()
+	prometheusRegistry.MustRegister(shutdownPhase)
+	prometheusRegistry.MustRegister(dbQueryDuration)
 
 	s := &Service{
-		Name:        name,
-		ErrChan:     make(chan error),
-		ctx:         ctx,
-		isReady:     isReady,
-		SubServices: make(map[string]SubService),
-		sigHandler:  TermSignalTrap(),
+		Name:          name,
+		ErrChan:       make(chan error),
+		ctx:           ctx,
+		isStarted:     isStarted,
+		isServing:     isServing,
+		SubServices:   make(map[string]SubService),
+		runCancels:    make(map[string]context.CancelFunc),
+		DBs:           make(map[string]*pgxpool.Pool),
+		sigHandler:    TermSignalTrap(),
+		registry:      prometheusRegistry,
+		Breakers:      newCircuitBreakers(prometheusRegistry),
+		shutdownOrder: make(map[string]int),
+		healthChecks:  make(map[string]*healthCheck),
+		shutdownPhase: shutdownPhase,
+		stopTimeout:   defaultShutdownTimeout,
+		forcedExit:    os.Exit,
+		inFlight:      &inFlightTracker{},
+		stopReport:    &shutdownRecorder{},
+		swappable:     make(map[*http.Server]*swappableHandler),
+		exclusions:    &telemetryFilter{},
+		vars:          &serviceVars{},
+		warmUps:       &warmUpRegistry{},
 	}
+	s.lifecycle = newLifecycleMetrics(s)
+	prometheusRegistry.MustRegister(s.lifecycle)
 
+	// the version describes the service to the options applied before WithVersion too
 	for _, o := range options {
-		if err := o.Apply(s); err != nil {
-			return nil, err
+		if o, ok := o.(BuildInfoOption); ok {
+			_ = o.Apply(s)
 		}
 	}
 
+	if errs := s.applyOptions(options); len(errs) > 0 {
+		s.release()
+		return nil, invalidOptions(append(errs, s.validate()...))
+	}
+
+	if s.techRouter != nil {
+		s.mountTechRoutes(s.techRouter)
+	}
+	s.mountGateways()
+	s.wireLeaderElection()
+	s.wireClaimCheck()
+	s.registerBuildInfo()
+
+	if err := invalidOptions(s.validate()); err != nil {
+		s.release()
+		return nil, err
+	}
+
+	if err := s.registerReflection(); err != nil {
+		s.release()
+		return nil, err
+	}
+
 	return s, nil
 }
 
+// release closes what the options created when New fails, the service being neither started nor stopped:
+// the subservices (messaging clients, plugin processes, ...), the database pools and the error journal.
+func (s *Service) release() {
+	s.closeSubServices()
+	if s.DBRouter != nil {
+		s.DBRouter.close()
+	}
+	for _, db := range s.DBs {
+		if db != nil {
+			db.Close()
+		}
+	}
+	s.closeJournal()
+	s.releaseSignals()
+}
+
+// releaseSignals restores the default action of the termination signals and of those trapped by
+// WithSignalHandler, so services created one after the other, e.g. by tests, don't pile up traps.
+func (s *Service) releaseSignals() {
+	s.sigHandler.Stop()
+	if h, err := GetSubService[*SignalHandlers](s, signalHandlersName); err == nil {
+		h.Release()
+	}
+}
+
 func (s *Service) GetContext() context.Context {
 	return s.ctx
 }
@@ -74,6 +250,39 @@ func (s *Service) SetContext(ctx context.Context) {
 	s.ctx = ctx
 }
 
+// Registry returns the prometheus registry exposed on the tech server, custom metrics can be registered there.
+func (s *Service) Registry() *prometheus.Registry {
+	return s.registry
+}
+
+// reportError forwards err to ErrChan without blocking callers which can't wait for a reader. Errors
+// reported once Stop closed ErrChan, e.g. by async Kafka deliveries, are logged only.
+func (s *Service) reportError(err error) {
+	s.lifecycle.errors.Inc()
+	s.notifyReporter(err)
+
+	s.errMu.RLock()
+	defer s.errMu.RUnlock()
+
+	if s.errClosed {
+		log.Error().Err(err).Msg("service error occurred")
+		return
+	}
+	select {
+	case s.ErrChan <- err:
+	default:
+		log.Error().Err(err).Msg("service error occurred")
+	}
+}
+
+func (s *Service) closeErrChan() {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+
+	s.errClosed = true
+	close(s.ErrChan)
+}
+
 func (s *Service) AddHTTPServer(httpServer *http.Server) {
 	s.HTTPServers = append(s.HTTPServers, httpServer)
 }
@@ -86,136 +295,344 @@ func (s *Service) AddGRPCService(serverName string, service interface{}, descrip
 			return nil
 		}
 	}
-	return errors.New("gRPC server not found")
+	return &ServerNotFoundError{Kind: "grpc", Name: serverName}
 }
 
+// IsAlive reports the health of the process itself, dependencies are left to readiness
+// so an outage of one doesn't get the service restarted.
 func (s *Service) IsAlive() bool {
-	isGrpcAlive := true
-	if s.GRPCServers != nil {
-		isGrpcAlive = s.checkGRPCServerUp()
-		if !isGrpcAlive {
-			log.Debug().Msg("grpc servers not ready")
-		}
-	}
-
-	areHTTPServersAlive := true
-	for _, httpServer := range s.HTTPServers {
-		if !s.checkHTTPServerUp(httpServer) {
-			areHTTPServersAlive = false
-		}
+	areResourcesAlive := true
+	if s.resources != nil && s.resources.Level() == ResourceCritical {
+		log.Debug().Msg("system resources are critical")
+		areResourcesAlive = false
 	}
 
-	isDBAlive := true
-	if s.DB != nil && !s.checkDBAlive() {
-		isDBAlive = false
-	}
+	areChecksAlive := s.checkHealthChecks(HealthLiveness)
 
-	return isGrpcAlive && areHTTPServersAlive && isDBAlive
+	return areResourcesAlive && areChecksAlive
 }
 
+// Start serves the servers and runs the subservices until a termination signal, the cancellation of
+// the service context, or the first failure of a server or subservice. The service is then stopped, the
+// subservices being closed in priority order rather than canceled with the servers, and the failure returned.
 func (s *Service) Start() error {
 	s.startTime = time.Now()
 	log.Info().Time("start_time", s.startTime).Msg("service starting")
 
-	ctx := s.GetContext()
+	parent := s.GetContext()
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	g, ctx := errgroup.WithContext(ctx)
+	s.SetContext(ctx)
+
+	startCtx := ctx
+	if s.startup.strict() {
+		var cancelStart context.CancelFunc
+		startCtx, cancelStart = context.WithTimeout(ctx, s.startup.Deadline)
+		defer cancelStart()
+
+		if err := s.connectDependencies(startCtx); err != nil {
+			return err
+		}
+	}
+
+	if err := s.runPreStart(ctx); err != nil {
+		return err
+	}
+	s.warmUp(ctx)
+
+	if err := s.listen(); err != nil {
+		return err
+	}
 
-	for _, httpServ := range s.HTTPServers {
-		httpServ := httpServ
-		go func() {
-			log.Info().Msgf("started http server address %s", httpServ.Addr)
+	for i, httpServ := range s.HTTPServers {
+		listener := s.httpListeners[i]
+		if !s.isInternalServer(httpServ) {
+			s.addrMu.Lock()
+			handler := httpServ.Handler
+			if handler == nil {
+				handler = http.DefaultServeMux
+			}
+			// the handler can be replaced by SwapHandler once serving
+			s.swappable[httpServ] = newSwappableHandler(handler)
+			httpServ.Handler = s.inFlight.track(s.swappable[httpServ])
+			s.addrMu.Unlock()
+		}
+		g.Go(s.recoverPanic("http server "+listener.Addr().String(), s.labeled("http server", func() error {
+			log.Info().Msgf("started http server address %s", listener.Addr())
 			defer log.Info().Msg("stopped http server")
 
-			if err := httpServ.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-				s.ErrChan <- fmt.Errorf("http: failed to serve %v", err)
+			serve := httpServ.Serve
+			if httpServ.TLSConfig != nil {
+				// the certificates come from the TLS config
+				serve = func(l net.Listener) error { return httpServ.ServeTLS(l, "", "") }
 			}
-		}()
+
+			if err := serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return fmt.Errorf("http: failed to serve: %w", err)
+			}
+			return nil
+		})))
 	}
 
 	for _, grpcServer := range s.GRPCServers {
-		grpcServer := grpcServer
-
-		go func() {
-			log.Info().Msgf("started grpc server address %s", grpcServer.address)
+		g.Go(s.recoverPanic("grpc server "+grpcServer.listener.Addr().String(), s.labeled("grpc server", func() error {
+			log.Info().Msgf("started grpc server address %s", grpcServer.listener.Addr())
 			defer log.Info().Msg("stopped grpc server")
 
-			listener, err := net.Listen("tcp", grpcServer.address)
-			if err != nil {
-				s.ErrChan <- fmt.Errorf("failed to listenn %v", err)
-				return
+			if err := grpcServer.server.Serve(grpcServer.listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+				return fmt.Errorf("grpc: failed to serve: %w", err)
 			}
+			return nil
+		})))
+
+		if grpcServer.local != nil {
+			g.Go(s.recoverPanic("grpc server in-process "+grpcServer.address, s.labeled("grpc server", func() error {
+				if err := grpcServer.server.Serve(grpcServer.local); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+					return fmt.Errorf("grpc: failed to serve in-process: %w", err)
+				}
+				return nil
+			})))
+		}
+	}
+
+	for _, subService := range s.subServices() {
+		runner, ok := subService.(Runner)
+		if !ok {
+			continue
+		}
+		name := subService.Name()
 
-			if err = grpcServer.server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
-				s.ErrChan <- fmt.Errorf("grpc: failed to serve %v", err)
+		// subservices outlive the servers, closeSubServices cancels them in priority order once drained
+		runCtx, cancelRun := context.WithCancel(context.WithoutCancel(ctx))
+		s.subMu.Lock()
+		s.runCancels[name] = cancelRun
+		s.subMu.Unlock()
+
+		g.Go(s.recoverPanic(name, s.labeled(name, func() error {
+			log.Info().Msgf("started subservice %s", name)
+			defer log.Info().Msgf("stopped subservice %s", name)
+
+			if err := runner.Run(runCtx); err != nil && !errors.Is(err, context.Canceled) {
+				return fmt.Errorf("%s: failed to run: %w", name, err)
 			}
-		}()
+			return nil
+		})))
 	}
 
-	go s.Ready()
+	g.Go(func() error {
+		err := s.sigHandler.Wait(ctx)
+		if errors.Is(err, ErrTermSig) {
+			log.Info().Msg("termination signal received")
+		}
+		return err
+	})
+
+	var startErr error
+	if s.startup.strict() {
+		if startErr = s.awaitStartup(startCtx); startErr != nil {
+			cancel()
+		}
+	} else {
+		g.Go(s.recoverPanic("startup gates", s.labeled("startup gates", func() error {
+			s.Ready()
+			return nil
+		})))
+	}
+
+	<-ctx.Done()
+	s.Stop()
+
+	err := g.Wait()
+	switch {
+	case errors.Is(err, ErrTermSig):
+		return nil
+	case startErr != nil && errors.Is(err, context.Canceled):
+		return startErr
+	case err != nil && errors.Is(err, parent.Err()):
+		// the service context was canceled by the caller
+	case err != nil:
+		log.Error().Err(err).Msg("service failed")
+	}
+
+	return err
+}
 
-	{
-		if err := s.sigHandler.Wait(ctx); err != nil && !errors.Is(err, ErrTermSig) {
-			log.Error().Msgf("failed to caught signal %v", log.Err(err))
+// listen binds every server before serving them, so the ports resolved for ":0" are known.
+func (s *Service) listen() error {
+	s.addrMu.Lock()
+	defer s.addrMu.Unlock()
+
+	// checked again for the servers added after New
+	if err := s.checkConflicts(); err != nil {
+		return err
+	}
+
+	var listeners []net.Listener
+	bind := func(addr string) (net.Listener, error) {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		listeners = append(listeners, listener)
+		return listener, nil
+	}
+
+	httpListeners := make([]net.Listener, 0, len(s.HTTPServers))
+	for _, httpServer := range s.HTTPServers {
+		addr := httpServer.Addr
+		if addr == "" {
+			addr = ":http"
+		}
+
+		listener, err := bind(addr)
+		if err != nil {
 			return err
 		}
-		log.Info().Msg("termination signal received")
+		httpListeners = append(httpListeners, listener)
 	}
 
-	go func() {
-		for err := range s.ErrChan {
-			log.Error().Err(err).Msg("service error occurred")
+	for _, grpcServer := range s.GRPCServers {
+		listener, err := bind(grpcServer.address)
+		if err != nil {
+			return err
 		}
-	}()
+		grpcServer.listener = listener
+	}
 
+	s.httpListeners = httpListeners
 	return nil
 }
 
-func (s *Service) Stop() {
-	log.Info().Msg("initiating graceful shutdown...")
+// Addresses are the bound addresses of the servers, in the order they were added.
+type Addresses struct {
+	HTTP []string
+	GRPC []string
+}
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// Addresses returns the addresses the servers listen on once Start bound them,
+// which differ from the configured ones when a server listens on port 0.
+func (s *Service) Addresses() Addresses {
+	s.addrMu.RLock()
+	defer s.addrMu.RUnlock()
 
-	for _, service := range s.SubServices {
-		if err := service.Close(); err != nil {
-			log.Error().Err(err).Str("service", service.Name()).Msg("failed to stop service")
-		} else {
-			log.Debug().Str("service", service.Name()).Msg("subservice stopped")
+	var addrs Addresses
+	for _, listener := range s.httpListeners {
+		addrs.HTTP = append(addrs.HTTP, listener.Addr().String())
+	}
+	for _, grpcServer := range s.GRPCServers {
+		if grpcServer.listener != nil {
+			addrs.GRPC = append(addrs.GRPC, grpcServer.listener.Addr().String())
 		}
 	}
 
+	return addrs
+}
+
+// Stop shuts the service down once, concurrent calls wait for the first one. Start calls it
+// before returning. The shutdown is forced when it doesn't complete in time, see WithShutdownTimeout.
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() {
+		func() {
+			stopped := make(chan struct{})
+			defer close(stopped)
+
+			go s.escalate(stopped)
+			s.stop()
+		}()
+
+		s.detectLeaks()
+		// after the leaks, which are journaled as well
+		s.closeJournal()
+		s.releaseSignals()
+	})
+}
+
+func (s *Service) stop() {
+	log.Info().Msg("initiating graceful shutdown...")
+	stopStart := time.Now()
+	s.stopReport.mu.Lock()
+	s.stopReport.startedAt = stopStart
+	s.stopReport.mu.Unlock()
+
+	_ = s.stopComponent("drain", "drain", func() error {
+		s.drain()
+		return nil
+	})
+	s.enterShutdownPhase("shutdown")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.stopTimeout)
+	defer cancel()
+
+	s.closeSubServices()
+	s.stopGateways(shutdownCtx)
+
 	for _, grpcServer := range s.GRPCServers {
-		grpcServer.server.GracefulStop()
+		_ = s.stopComponent("grpc_server", grpcServer.address, func() error {
+			grpcServer.server.GracefulStop()
+			return nil
+		})
 		log.Debug().Msg("grpc server stopped")
 	}
 
-	for _, httpServer := range s.HTTPServers {
-		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+	// the tech server is stopped last, it serves the drain status meanwhile
+	httpServers := slices.DeleteFunc(slices.Clone(s.HTTPServers), func(srv *http.Server) bool {
+		return srv == s.techServer || slices.ContainsFunc(s.gateways, func(gw *grpcGateway) bool {
+			return gw.server == srv
+		})
+	})
+	if s.techServer != nil {
+		httpServers = append(httpServers, s.techServer)
+	}
+	for _, httpServer := range httpServers {
+		if err := s.stopComponent("http_server", httpServer.Addr, func() error {
+			return httpServer.Shutdown(shutdownCtx)
+		}); err != nil {
 			log.Error().Err(err).Str("addr", httpServer.Addr).Msg("failed to shutdown http server")
 		} else {
 			log.Debug().Str("addr", httpServer.Addr).Msg("http server stopped")
 		}
 	}
 
-	if s.DB != nil {
-		s.DB.Close()
-		log.Debug().Msg("db connection closed")
+	if s.DBRouter != nil {
+		s.DBRouter.close()
+		log.Debug().Msg("db replica connections closed")
 	}
 
-	close(s.ErrChan)
+	for name, db := range s.DBs {
+		_ = s.stopComponent("database", name, func() error {
+			db.Close()
+			return nil
+		})
+		log.Debug().Str("db", name).Msg("db connection closed")
+	}
+
+	s.closeErrChan()
 
+	s.enterShutdownPhase("completed")
+	s.lifecycle.shutdown.Set(time.Since(stopStart).Seconds())
 	log.Info().Msg("graceful shutdown completed")
+	s.emitShutdownReport(false, "")
+
+	s.flushReporter()
+	s.flushLogs()
+}
+
+// Started reports whether the startup gates passed, see Ready.
+func (s *Service) Started() bool {
+	return s.isStarted.Load().(bool)
 }
 
+// Ready runs the startup gates: it waits for the servers to listen and the startup health checks
+// to pass, runs the self-tests, then marks the service as started. Dependencies are checked by the readiness probe.
 func (s *Service) Ready() {
-	areSubServicesReady := true
-	for _, subService := range s.SubServices {
-		if !subService.Ready() {
-			log.Error().Msgf("subservice not ready subservice %s", subService.Name())
-			areSubServicesReady = false
-		}
-		log.Info().Msgf("subservice is ready subservice %s", subService.Name())
-	}
+	s.runStartupGates(s.ctx)
+}
 
+func (s *Service) runStartupGates(ctx context.Context) {
 	isGRPCReady := true
 	if s.GRPCServers != nil {
 		isGRPCReady = s.checkGRPCServerUp()
@@ -225,20 +642,24 @@ func (s *Service) Ready() {
 	}
 
 	areHTTPServersReady := true
-	for _, httpServer := range s.HTTPServers {
-		if !s.checkHTTPServerUp(httpServer) {
+	for _, addr := range s.Addresses().HTTP {
+		if !s.checkHTTPServerUp(ctx, addr) {
 			areHTTPServersReady = false
 		}
 	}
 
-	isDBReady := true
-	if s.DB != nil && !s.checkDBAlive() {
-		isDBReady = false
-	}
+	areChecksPassed := s.waitStartupChecks(ctx)
 
-	s.isReady.Swap(areSubServicesReady && isGRPCReady && areHTTPServersReady && isDBReady)
+	// self-tests exercise the dependencies for real, once everything else is up
+	areSelfTestsPassed := isGRPCReady && areHTTPServersReady && areChecksPassed && s.runSelfTests(ctx)
+
+	s.isStarted.Swap(areSelfTestsPassed)
+	if areSelfTestsPassed && !s.startTime.IsZero() {
+		s.lifecycle.startup.Set(time.Since(s.startTime).Seconds())
+	}
 }
-func (s *Service) checkHTTPServerUp(httpServer *http.Server) bool {
+
+func (s *Service) checkHTTPServerUp(ctx context.Context, addr string) bool {
 	err := errors.New("http server not ready")
 	var conn net.Conn
 	defer func() {
@@ -247,7 +668,10 @@ func (s *Service) checkHTTPServerUp(httpServer *http.Server) bool {
 		}
 	}()
 	for err != nil {
-		if conn, err = net.DialTimeout("tcp", httpServer.Addr, 1*time.Second); err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		if conn, err = net.DialTimeout("tcp", addr, 1*time.Second); err != nil {
 			log.Debug().Msg(err.Error())
 		}
 	}
@@ -263,31 +687,25 @@ func (s *Service) checkGRPCServerUp() bool {
 		}
 	}()
 
-	for _, server := range s.GRPCServers {
+	for _, addr := range s.Addresses().GRPC {
 		var err error
-		if conn, err = grpc.NewClient(server.address, grpc.WithTransportCredentials(insecure.NewCredentials())); err != nil {
+		if conn, err = grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials())); err != nil {
 			log.Debug().Msg(err.Error())
 			return false
 		}
 
-		log.Debug().Msgf("grpc server ready %s", server.address)
+		log.Debug().Msgf("grpc server ready %s", addr)
 	}
 	return true
 }
 
-func (s *Service) checkDBAlive() bool {
-	if s.DB == nil {
-		return true
-	}
-
-	err := s.DB.Ping(s.ctx)
-	if err != nil {
-		log.Debug().Err(err).Msg("db is not ready")
-		return false
+func (s *Service) pingDB(ctx context.Context, name string) error {
+	db, ok := s.DBs[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrDBNotFound, name)
 	}
 
-	log.Debug().Msg("db is ready")
-	return true
+	return db.Ping(ctx)
 }
 
 func (s *Service) checkRedisAlive() bool {
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const defaultJSONStreamFlushEvery = 100
+
+type JSONStreamOpt func(*JSONStream)
+
+// JSONStreamFlushEvery flushes the response every n items, defaults to defaultJSONStreamFlushEvery.
+// Zero leaves flushing to the server buffers and explicit Flush calls.
+func JSONStreamFlushEvery(n int) JSONStreamOpt {
+	return func(s *JSONStream) { s.flushEvery = n }
+}
+
+// JSONStreamOnItemError decides what happens when an item can't be encoded: returning nil skips the item,
+// an error aborts the stream. By default the stream is aborted.
+func JSONStreamOnItemError(fn func(index int, err error) error) JSONStreamOpt {
+	return func(s *JSONStream) { s.onItemError = fn }
+}
+
+// JSONStream writes a JSON array to an HTTP response item by item, so large result sets are sent without
+// being buffered. The status and the opening bracket are written with the first item, or by Close for an
+// empty array, so errors before that can still be answered normally. Once started, an error can only cut
+// the response short, which the client sees as invalid JSON.
+type JSONStream struct {
+	ctx         context.Context
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	flushEvery  int
+	onItemError func(index int, err error) error
+
+	index   int
+	written int
+	started bool
+	closed  bool
+}
+
+func NewJSONStream(w http.ResponseWriter, r *http.Request, opts ...JSONStreamOpt) *JSONStream {
+	s := &JSONStream{
+		ctx:        r.Context(),
+		w:          w,
+		rc:         http.NewResponseController(w),
+		flushEvery: defaultJSONStreamFlushEvery,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Write appends an item to the array. It returns the context error once the client went away.
+func (s *JSONStream) Write(item any) error {
+	if s.closed {
+		return errors.New("json stream is closed")
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	index := s.index
+	s.index++
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return s.itemError(index, fmt.Errorf("failed to encode item %d: %w", index, err))
+	}
+
+	if err := s.start(); err != nil {
+		return err
+	}
+	if s.written > 0 {
+		data = append([]byte{','}, data...)
+	}
+	if _, err := s.w.Write(data); err != nil {
+		return fmt.Errorf("failed to write item %d: %w", index, err)
+	}
+	s.written++
+
+	if s.flushEvery > 0 && s.written%s.flushEvery == 0 {
+		return s.Flush()
+	}
+	return nil
+}
+
+func (s *JSONStream) itemError(index int, err error) error {
+	if s.onItemError == nil {
+		return err
+	}
+	return s.onItemError(index, err)
+}
+
+func (s *JSONStream) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+
+	s.w.Header().Set("Content-Type", "application/json")
+	s.w.WriteHeader(http.StatusOK)
+	if _, err := s.w.Write([]byte{'['}); err != nil {
+		return fmt.Errorf("failed to start json stream: %w", err)
+	}
+	return nil
+}
+
+// Flush sends the items written so far to the client.
+func (s *JSONStream) Flush() error {
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return fmt.Errorf("failed to flush json stream: %w", err)
+	}
+	return nil
+}
+
+// Close ends the array and flushes it.
+func (s *JSONStream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if err := s.start(); err != nil {
+		return err
+	}
+	if _, err := s.w.Write([]byte{']'}); err != nil {
+		return fmt.Errorf("failed to end json stream: %w", err)
+	}
+	return s.Flush()
+}
+
+// Count is the number of items written.
+func (s *JSONStream) Count() int {
+	return s.written
+}
+
+// StreamRows writes the rows of a query as a JSON array, scanning them with scan, e.g.
+// pgx.RowToStructByName[Order]. Scan errors are item errors, see JSONStreamOnItemError. The rows are closed.
+func StreamRows[T any](w http.ResponseWriter, r *http.Request, rows pgx.Rows, scan pgx.RowToFunc[T], opts ...JSONStreamOpt) error {
+	defer rows.Close()
+
+	s := NewJSONStream(w, r, opts...)
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			index := s.index
+			s.index++
+			if err := s.itemError(index, fmt.Errorf("failed to scan row %d: %w", index, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.Write(item); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return s.Close()
+}
@@ -0,0 +1,263 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	kafkaPingTimeout    = 2 * time.Second
+	kafkaCommitTimeout  = 10 * time.Second
+	kafkaMaxRetryDelay  = 10 * time.Second
+	kafkaInitRetryDelay = 100 * time.Millisecond
+)
+
+// KafkaHandler processes a single record. The record offset is committed only after
+// the handler returns nil, failed records are retried with backoff.
+type KafkaHandler func(ctx context.Context, record *kgo.Record) error
+
+// DedupKafkaHandler skips records already processed by consumer, records are identified by topic, partition and offset.
+func DedupKafkaHandler(store CheckpointStore, consumer string, handler KafkaHandler) KafkaHandler {
+	return func(ctx context.Context, record *kgo.Record) error {
+		id := fmt.Sprintf("%s/%d/%d", record.Topic, record.Partition, record.Offset)
+		return ProcessOnce(ctx, store, consumer, id, func(ctx context.Context) error {
+			return handler(ctx, record)
+		})
+	}
+}
+
+type KafkaConsumer struct {
+	name    string
+	group   string
+	topics  []string
+	client  *kgo.Client
+	handler KafkaHandler
+	lag     *prometheus.GaugeVec
+	paused  atomic.Bool
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewKafkaConsumer(brokers []string, group string, topics []string, handler KafkaHandler, opts ...kgo.Opt) (*KafkaConsumer, error) {
+	c := &KafkaConsumer{
+		name:    "kafka-consumer-" + group,
+		group:   group,
+		topics:  topics,
+		handler: handler,
+		lag:     newKafkaLagGauge(),
+		done:    make(chan struct{}),
+	}
+
+	clientOpts := append([]kgo.Opt{
+		kgo.SeedBrokers(brokers...),
+		kgo.ConsumerGroup(group),
+		kgo.ConsumeTopics(topics...),
+		kgo.AutoCommitMarks(),
+		kgo.BlockRebalanceOnPoll(),
+		kgo.OnPartitionsAssigned(c.onAssigned),
+		kgo.OnPartitionsRevoked(c.onRevoked),
+		kgo.OnPartitionsLost(c.onLost),
+	}, opts...)
+
+	client, err := kgo.NewClient(clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kafka client: %w", err)
+	}
+	c.client = client
+
+	return c, nil
+}
+
+func (c *KafkaConsumer) Name() string {
+	return c.name
+}
+
+func (c *KafkaConsumer) ShutdownPriority() int {
+	return ShutdownPriorityConsumer
+}
+
+func (c *KafkaConsumer) Ready() bool {
+	if c.paused.Load() {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaPingTimeout)
+	defer cancel()
+
+	if err := c.client.Ping(ctx); err != nil {
+		log.Debug().Err(err).Str("group", c.group).Msg("kafka consumer not ready")
+		return false
+	}
+
+	return true
+}
+
+// Pause stops fetching records, the partitions stay assigned so the group doesn't rebalance.
+func (c *KafkaConsumer) Pause() {
+	c.paused.Store(true)
+	c.client.PauseFetchTopics(c.topics...)
+}
+
+func (c *KafkaConsumer) Resume() {
+	c.client.ResumeFetchTopics(c.topics...)
+	c.paused.Store(false)
+}
+
+func (c *KafkaConsumer) Paused() bool {
+	return c.paused.Load()
+}
+
+// Run polls the group until ctx is done or Close is called.
+func (c *KafkaConsumer) Run(ctx context.Context) error {
+	c.mu.Lock()
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.running.Store(true)
+	c.mu.Unlock()
+	defer close(c.done)
+
+	for {
+		fetches := c.client.PollFetches(ctx)
+		if fetches.IsClientClosed() || ctx.Err() != nil {
+			c.client.AllowRebalance()
+			return nil
+		}
+
+		fetches.EachError(func(topic string, partition int32, err error) {
+			log.Error().Err(err).Str("topic", topic).Int32("partition", partition).Msg("kafka fetch failed")
+		})
+
+		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+			if ctx.Err() != nil {
+				return
+			}
+
+			for _, record := range p.Records {
+				if !c.handle(ctx, record) {
+					return
+				}
+				c.client.MarkCommitRecords(record)
+				c.lag.WithLabelValues(c.group, p.Topic, strconv.Itoa(int(p.Partition))).
+					Set(float64(p.HighWatermark - record.Offset - 1))
+			}
+		})
+
+		c.client.AllowRebalance()
+	}
+}
+
+// handle retries the handler until it succeeds or the consumer is stopping.
+func (c *KafkaConsumer) handle(ctx context.Context, record *kgo.Record) bool {
+	delay := kafkaInitRetryDelay
+	for {
+		// in-flight handlers are not interrupted by shutdown
+		err := c.handler(context.WithoutCancel(ctx), record)
+		if err == nil {
+			return true
+		}
+
+		log.Error().Err(err).Str("topic", record.Topic).Int32("partition", record.Partition).
+			Int64("offset", record.Offset).Msg("kafka handler failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, kafkaMaxRetryDelay)
+	}
+}
+
+// Close stops polling, waits for in-flight records, commits marked offsets and leaves the group.
+func (c *KafkaConsumer) Close() error {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
+
+	if c.running.Load() {
+		<-c.done
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaCommitTimeout)
+	defer cancel()
+
+	var err error
+	if commitErr := c.client.CommitMarkedOffsets(ctx); commitErr != nil && !errors.Is(commitErr, kgo.ErrClientClosed) {
+		err = fmt.Errorf("failed to commit kafka offsets: %w", commitErr)
+	}
+	c.client.Close()
+
+	return err
+}
+
+func (c *KafkaConsumer) onAssigned(_ context.Context, _ *kgo.Client, assigned map[string][]int32) {
+	log.Info().Str("group", c.group).Interface("partitions", assigned).Msg("kafka partitions assigned")
+}
+
+func (c *KafkaConsumer) onRevoked(ctx context.Context, cl *kgo.Client, revoked map[string][]int32) {
+	log.Info().Str("group", c.group).Interface("partitions", revoked).Msg("kafka partitions revoked")
+	if err := cl.CommitMarkedOffsets(ctx); err != nil {
+		log.Error().Err(err).Str("group", c.group).Msg("failed to commit kafka offsets on revoke")
+	}
+	c.deleteLag(revoked)
+}
+
+func (c *KafkaConsumer) onLost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	log.Warn().Str("group", c.group).Interface("partitions", lost).Msg("kafka partitions lost")
+	c.deleteLag(lost)
+}
+
+func (c *KafkaConsumer) deleteLag(partitions map[string][]int32) {
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			c.lag.DeleteLabelValues(c.group, topic, strconv.Itoa(int(p)))
+		}
+	}
+}
+
+func newKafkaLagGauge() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_consumer_lag",
+		Help: "Number of records between the last processed offset and the high watermark.",
+	}, []string{"group", "topic", "partition"})
+}
+
+type KafkaConsumerOption struct {
+	brokers []string
+	group   string
+	topics  []string
+	handler KafkaHandler
+	opts    []kgo.Opt
+}
+
+func (w KafkaConsumerOption) Apply(s *Service) error {
+	if w.handler == nil {
+		return fmt.Errorf("kafka consumer %s: handler is nil", w.group)
+	}
+	c, err := NewKafkaConsumer(w.brokers, w.group, w.topics, w.handler, w.opts...)
+	if err != nil {
+		return err
+	}
+
+	c.lag = registerCollector(s.registry, c.lag)
+
+	return s.RegisterSubService(c)
+}
+
+// WithKafkaConsumer runs a consumer group as a subservice, extra kgo options (TLS, SASL, ...) are passed to the client.
+func WithKafkaConsumer(brokers []string, group string, topics []string, handler KafkaHandler, opts ...kgo.Opt) Option {
+	return KafkaConsumerOption{brokers: brokers, group: group, topics: topics, handler: handler, opts: opts}
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const kafkaFlushTimeout = 30 * time.Second
+
+// KafkaProducer is a managed producer. The client defaults apply: acks from all in-sync replicas
+// and idempotent writes, so a successful Produce means the record is durably stored.
+type KafkaProducer struct {
+	client  *kgo.Client
+	latency *prometheus.HistogramVec
+	onError func(error)
+	claims  *ClaimCheck
+}
+
+func NewKafkaProducer(brokers []string, opts ...kgo.Opt) (*KafkaProducer, error) {
+	client, err := kgo.NewClient(append([]kgo.Opt{kgo.SeedBrokers(brokers...)}, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kafka client: %w", err)
+	}
+
+	return &KafkaProducer{
+		client:  client,
+		latency: newKafkaProduceLatency(),
+		onError: func(err error) {
+			log.Error().Err(err).Msg("kafka delivery failed")
+		},
+	}, nil
+}
+
+func (p *KafkaProducer) Name() string {
+	return "kafka-producer"
+}
+
+func (p *KafkaProducer) ShutdownPriority() int {
+	return ShutdownPriorityProducer
+}
+
+func (p *KafkaProducer) Ready() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaPingTimeout)
+	defer cancel()
+
+	if err := p.client.Ping(ctx); err != nil {
+		log.Debug().Err(err).Msg("kafka producer not ready")
+		return false
+	}
+
+	return true
+}
+
+// Client gives access to the underlying client for transactions or admin requests.
+func (p *KafkaProducer) Client() *kgo.Client {
+	return p.client
+}
+
+// Produce blocks until the records are acknowledged.
+func (p *KafkaProducer) Produce(ctx context.Context, records ...*kgo.Record) error {
+	start := time.Now()
+	for _, record := range records {
+		if err := p.offload(ctx, record); err != nil {
+			return err
+		}
+	}
+
+	results := p.client.ProduceSync(ctx, records...)
+	for _, result := range results {
+		p.observe(result.Record, result.Err, start)
+	}
+
+	if err := results.FirstErr(); err != nil {
+		return fmt.Errorf("failed to produce kafka records: %w", err)
+	}
+	return nil
+}
+
+// ProduceAsync buffers the record and returns immediately. Delivery failures are reported
+// to Service.ErrChan, then passed to the optional callback.
+func (p *KafkaProducer) ProduceAsync(ctx context.Context, record *kgo.Record, callbacks ...func(*kgo.Record, error)) {
+	start := time.Now()
+	if err := p.offload(ctx, record); err != nil {
+		p.onError(err)
+		for _, callback := range callbacks {
+			callback(record, err)
+		}
+		return
+	}
+
+	p.client.Produce(ctx, record, func(r *kgo.Record, err error) {
+		p.observe(r, err, start)
+		if err != nil {
+			p.onError(fmt.Errorf("kafka: failed to deliver record to %s: %w", r.Topic, err))
+		}
+
+		for _, callback := range callbacks {
+			callback(r, err)
+		}
+	})
+}
+
+// Flush waits until all buffered records are delivered.
+func (p *KafkaProducer) Flush(ctx context.Context) error {
+	return p.client.Flush(ctx)
+}
+
+// Close flushes buffered records and closes the client.
+func (p *KafkaProducer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaFlushTimeout)
+	defer cancel()
+
+	var err error
+	if flushErr := p.client.Flush(ctx); flushErr != nil {
+		err = fmt.Errorf("failed to flush kafka producer: %w", flushErr)
+	}
+	p.client.Close()
+
+	return err
+}
+
+// offload replaces large values by a claim check reference, see WithClaimCheck.
+func (p *KafkaProducer) offload(ctx context.Context, record *kgo.Record) error {
+	if p.claims == nil {
+		return nil
+	}
+	if err := p.claims.offloadRecord(ctx, record); err != nil {
+		return fmt.Errorf("kafka: failed to offload record to %s: %w", record.Topic, err)
+	}
+	return nil
+}
+
+func (p *KafkaProducer) observe(r *kgo.Record, err error, start time.Time) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	p.latency.WithLabelValues(r.Topic, result).Observe(time.Since(start).Seconds())
+}
+
+func newKafkaProduceLatency() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kafka_produce_duration_seconds",
+		Help:    "Time from producing a record until it is acknowledged or failed.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"topic", "result"})
+}
+
+type KafkaProducerOption struct {
+	brokers []string
+	opts    []kgo.Opt
+}
+
+func (w KafkaProducerOption) Apply(s *Service) error {
+	p, err := NewKafkaProducer(w.brokers, w.opts...)
+	if err != nil {
+		return err
+	}
+
+	p.latency = registerCollector(s.registry, p.latency)
+	p.onError = s.reportError
+
+	if err := s.RegisterSubService(p); err != nil {
+		p.client.Close()
+		return err
+	}
+
+	s.KafkaProducer = p
+	return nil
+}
+
+// WithKafkaProducer exposes a managed producer as Service.KafkaProducer, flushed on Stop.
+func WithKafkaProducer(brokers []string, opts ...kgo.Opt) Option {
+	return KafkaProducerOption{brokers: brokers, opts: opts}
+}
@@ -0,0 +1,171 @@
+package app
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrKMSKeyNotFound   = errors.New("kms key not found")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// KMS is a key management service holding keys which never leave it. Keys are referenced by
+// backend specific IDs (AWS key ARN or alias, GCP key resource name, Vault transit key name).
+//
+// Backends encrypt with the current primary version of a key and embed the version in the ciphertext,
+// so rotated keys keep decrypting older ciphertexts; ReencryptWithKMS migrates them to the primary version.
+type KMS interface {
+	// Encrypt seals plaintext, aad is authenticated but not stored and must be given back to Decrypt.
+	Encrypt(ctx context.Context, keyID string, plaintext, aad []byte) ([]byte, error)
+	Decrypt(ctx context.Context, keyID string, ciphertext, aad []byte) ([]byte, error)
+	// Sign signs a SHA-256 digest.
+	Sign(ctx context.Context, keyID string, digest []byte) ([]byte, error)
+	// Verify returns ErrInvalidSignature when signature doesn't match the SHA-256 digest.
+	Verify(ctx context.Context, keyID string, digest, signature []byte) error
+}
+
+// ReencryptWithKMS decrypts ciphertext and encrypts it again with the primary version of the key.
+func ReencryptWithKMS(ctx context.Context, kms KMS, keyID string, ciphertext, aad []byte) ([]byte, error) {
+	plaintext, err := kms.Decrypt(ctx, keyID, ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	return kms.Encrypt(ctx, keyID, plaintext, aad)
+}
+
+// LocalKMS keeps AES-256 keys in memory, encrypting with AES-GCM and signing with HMAC-SHA256.
+// It is meant for development and tests, keys are not versioned.
+type LocalKMS struct {
+	keys map[string][]byte
+}
+
+func NewLocalKMS(keys map[string][]byte) (*LocalKMS, error) {
+	for id, key := range keys {
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("local kms key %s must be %d bytes", id, dataKeySize)
+		}
+	}
+
+	return &LocalKMS{keys: keys}, nil
+}
+
+func (k *LocalKMS) key(keyID string) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKMSKeyNotFound, keyID)
+	}
+	return key, nil
+}
+
+func (k *LocalKMS) aead(keyID string) (cipher.AEAD, error) {
+	key, err := k.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(key)
+}
+
+func (k *LocalKMS) Encrypt(_ context.Context, keyID string, plaintext, aad []byte) ([]byte, error) {
+	aead, err := k.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return seal(aead, plaintext, aad)
+}
+
+func (k *LocalKMS) Decrypt(_ context.Context, keyID string, ciphertext, aad []byte) ([]byte, error) {
+	aead, err := k.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, ciphertext, aad)
+}
+
+func (k *LocalKMS) Sign(_ context.Context, keyID string, digest []byte) ([]byte, error) {
+	key, err := k.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(digest)
+	return mac.Sum(nil), nil
+}
+
+func (k *LocalKMS) Verify(ctx context.Context, keyID string, digest, signature []byte) error {
+	expected, err := k.Sign(ctx, keyID, digest)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(expected, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// KMSKeyProvider is a DataKeyProvider generating data keys locally and wrapping them with a KMS key,
+// so PayloadEncrypter calls the KMS only when rotating or unwrapping data keys.
+type KMSKeyProvider struct {
+	kms   KMS
+	keyID string
+}
+
+func NewKMSKeyProvider(kms KMS, keyID string) *KMSKeyProvider {
+	return &KMSKeyProvider{kms: kms, keyID: keyID}
+}
+
+func (p *KMSKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := p.kms.Encrypt(ctx, p.keyID, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, wrapped, nil
+}
+
+func (p *KMSKeyProvider) DecryptDataKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return p.kms.Decrypt(ctx, p.keyID, wrapped, nil)
+}
+
+// kmsRequest sends a JSON request to a KMS REST API and decodes the JSON response into out.
+func kmsRequest(ctx context.Context, client *http.Client, method, url string, header http.Header, in, out any) error {
+	status, err := jsonRequest(ctx, client, method, url, header, in, out)
+	if status == http.StatusNotFound {
+		return ErrKMSKeyNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("kms request failed: %w", err)
+	}
+	return nil
+}
+
+type KMSOption struct {
+	kms KMS
+}
+
+func (w KMSOption) Apply(s *Service) error {
+	if w.kms == nil {
+		return errors.New("kms is nil")
+	}
+	s.KMS = w.kms
+	return nil
+}
+
+// WithKMS exposes the key management service as Service.KMS to the components signing or encrypting data.
+func WithKMS(kms KMS) Option {
+	return KMSOption{kms: kms}
+}
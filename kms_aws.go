@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// AWSKMSClient is the subset of *kms.Client used by AWSKMS.
+type AWSKMSClient interface {
+	Encrypt(ctx context.Context, in *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error)
+	Decrypt(ctx context.Context, in *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+	Sign(ctx context.Context, in *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error)
+	Verify(ctx context.Context, in *kms.VerifyInput, optFns ...func(*kms.Options)) (*kms.VerifyOutput, error)
+}
+
+// AWSKMS uses AWS KMS, aad is passed as encryption context. Rotation is handled by AWS,
+// ciphertexts reference the key material they were encrypted with.
+type AWSKMS struct {
+	client AWSKMSClient
+	// SigningAlgorithm must match the asymmetric signing keys, defaults to ECDSA_SHA_256.
+	SigningAlgorithm types.SigningAlgorithmSpec
+}
+
+func NewAWSKMS(client AWSKMSClient) *AWSKMS {
+	return &AWSKMS{client: client, SigningAlgorithm: types.SigningAlgorithmSpecEcdsaSha256}
+}
+
+func awsEncryptionContext(aad []byte) map[string]string {
+	if len(aad) == 0 {
+		return nil
+	}
+	return map[string]string{"aad": base64.StdEncoding.EncodeToString(aad)}
+}
+
+func (k *AWSKMS) Encrypt(ctx context.Context, keyID string, plaintext, aad []byte) ([]byte, error) {
+	out, err := k.client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:             &keyID,
+		Plaintext:         plaintext,
+		EncryptionContext: awsEncryptionContext(aad),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("aws kms: failed to encrypt: %w", err)
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (k *AWSKMS) Decrypt(ctx context.Context, keyID string, ciphertext, aad []byte) ([]byte, error) {
+	out, err := k.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             &keyID,
+		CiphertextBlob:    ciphertext,
+		EncryptionContext: awsEncryptionContext(aad),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("aws kms: failed to decrypt: %w", err)
+	}
+	return out.Plaintext, nil
+}
+
+func (k *AWSKMS) Sign(ctx context.Context, keyID string, digest []byte) ([]byte, error) {
+	out, err := k.client.Sign(ctx, &kms.SignInput{
+		KeyId:            &keyID,
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: k.SigningAlgorithm,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("aws kms: failed to sign: %w", err)
+	}
+	return out.Signature, nil
+}
+
+func (k *AWSKMS) Verify(ctx context.Context, keyID string, digest, signature []byte) error {
+	out, err := k.client.Verify(ctx, &kms.VerifyInput{
+		KeyId:            &keyID,
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		Signature:        signature,
+		SigningAlgorithm: k.SigningAlgorithm,
+	})
+	if err != nil {
+		var invalid *types.KMSInvalidSignatureException
+		if errors.As(err, &invalid) {
+			return ErrInvalidSignature
+		}
+		return fmt.Errorf("aws kms: failed to verify: %w", err)
+	}
+
+	if !out.SignatureValid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
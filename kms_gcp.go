@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const gcpKMSEndpoint = "https://cloudkms.googleapis.com/v1/"
+
+// GCPKMS uses the Cloud KMS REST API. Encryption key IDs are key resource names
+// (projects/*/locations/*/keyRings/*/cryptoKeys/*) encrypting with the primary version, signing key IDs
+// are key version resource names (.../cryptoKeyVersions/*). Signatures are verified locally with the
+// public key, as Cloud KMS does.
+type GCPKMS struct {
+	client     *http.Client
+	endpoint   string
+	publicKeys sync.Map
+}
+
+// NewGCPKMS takes an authenticated client, e.g. from golang.org/x/oauth2/google.DefaultClient.
+func NewGCPKMS(client *http.Client) *GCPKMS {
+	return &GCPKMS{client: client, endpoint: gcpKMSEndpoint}
+}
+
+func (k *GCPKMS) call(ctx context.Context, method, resource, action string, in, out any) error {
+	url := k.endpoint + resource
+	if action != "" {
+		url += ":" + action
+	}
+
+	if err := kmsRequest(ctx, k.client, method, url, nil, in, out); err != nil {
+		return fmt.Errorf("gcp kms: %s %s: %w", action, resource, err)
+	}
+	return nil
+}
+
+func (k *GCPKMS) Encrypt(ctx context.Context, keyID string, plaintext, aad []byte) ([]byte, error) {
+	in := struct {
+		Plaintext []byte `json:"plaintext"`
+		AAD       []byte `json:"additionalAuthenticatedData,omitempty"`
+	}{plaintext, aad}
+
+	var out struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	if err := k.call(ctx, http.MethodPost, keyID, "encrypt", in, &out); err != nil {
+		return nil, err
+	}
+	return out.Ciphertext, nil
+}
+
+func (k *GCPKMS) Decrypt(ctx context.Context, keyID string, ciphertext, aad []byte) ([]byte, error) {
+	in := struct {
+		Ciphertext []byte `json:"ciphertext"`
+		AAD        []byte `json:"additionalAuthenticatedData,omitempty"`
+	}{ciphertext, aad}
+
+	var out struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	if err := k.call(ctx, http.MethodPost, keyID, "decrypt", in, &out); err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+func (k *GCPKMS) Sign(ctx context.Context, keyID string, digest []byte) ([]byte, error) {
+	type sha256Digest struct {
+		SHA256 []byte `json:"sha256"`
+	}
+	in := struct {
+		Digest sha256Digest `json:"digest"`
+	}{sha256Digest{digest}}
+
+	var out struct {
+		Signature []byte `json:"signature"`
+	}
+	if err := k.call(ctx, http.MethodPost, keyID, "asymmetricSign", in, &out); err != nil {
+		return nil, err
+	}
+	return out.Signature, nil
+}
+
+func (k *GCPKMS) Verify(ctx context.Context, keyID string, digest, signature []byte) error {
+	pub, err := k.publicKey(ctx, keyID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case pub.ecdsa != nil:
+		if !ecdsa.VerifyASN1(pub.ecdsa, digest, signature) {
+			return ErrInvalidSignature
+		}
+	case pub.pss:
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+		if rsa.VerifyPSS(pub.rsa, crypto.SHA256, digest, signature, opts) != nil {
+			return ErrInvalidSignature
+		}
+	default:
+		if rsa.VerifyPKCS1v15(pub.rsa, crypto.SHA256, digest, signature) != nil {
+			return ErrInvalidSignature
+		}
+	}
+	return nil
+}
+
+type gcpPublicKey struct {
+	ecdsa *ecdsa.PublicKey
+	rsa   *rsa.PublicKey
+	pss   bool
+}
+
+// publicKey fetches and caches the public key of a key version, versions are immutable.
+func (k *GCPKMS) publicKey(ctx context.Context, keyID string) (*gcpPublicKey, error) {
+	if pub, ok := k.publicKeys.Load(keyID); ok {
+		return pub.(*gcpPublicKey), nil
+	}
+
+	var out struct {
+		PEM       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := k.call(ctx, http.MethodGet, keyID+"/publicKey", "", nil, &out); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(out.Algorithm, "_SHA256") {
+		return nil, fmt.Errorf("gcp kms: unsupported signing algorithm %s", out.Algorithm)
+	}
+
+	block, _ := pem.Decode([]byte(out.PEM))
+	if block == nil {
+		return nil, fmt.Errorf("gcp kms: invalid public key of %s", keyID)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("gcp kms: invalid public key of %s: %w", keyID, err)
+	}
+
+	pub := &gcpPublicKey{pss: strings.HasPrefix(out.Algorithm, "RSA_SIGN_PSS_")}
+	switch key := parsed.(type) {
+	case *ecdsa.PublicKey:
+		pub.ecdsa = key
+	case *rsa.PublicKey:
+		pub.rsa = key
+	default:
+		return nil, fmt.Errorf("gcp kms: unsupported public key type %T", parsed)
+	}
+
+	k.publicKeys.Store(keyID, pub)
+	return pub, nil
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const defaultVaultTransitMount = "transit"
+
+type VaultKMSConfig struct {
+	// Address of the Vault server, e.g. https://vault:8200.
+	Address string
+	Token   string
+	// Mount is the transit secrets engine path, empty means defaultVaultTransitMount.
+	Mount string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// VaultKMS uses the Vault transit secrets engine. Ciphertexts and signatures carry the key version
+// (vault:vN:...), so rotated keys keep decrypting and verifying older data up to min_decryption_version.
+type VaultKMS struct {
+	cfg VaultKMSConfig
+}
+
+func NewVaultKMS(cfg VaultKMSConfig) *VaultKMS {
+	if cfg.Mount == "" {
+		cfg.Mount = defaultVaultTransitMount
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+
+	return &VaultKMS{cfg: cfg}
+}
+
+func (k *VaultKMS) call(ctx context.Context, action, keyID string, in map[string]any, out any) error {
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", k.cfg.Address, k.cfg.Mount, action, keyID)
+	header := http.Header{"X-Vault-Token": {k.cfg.Token}}
+
+	resp := struct {
+		Data any `json:"data"`
+	}{Data: out}
+	if err := kmsRequest(ctx, k.cfg.Client, http.MethodPost, url, header, in, &resp); err != nil {
+		return fmt.Errorf("vault: %s %s: %w", action, keyID, err)
+	}
+	return nil
+}
+
+func (k *VaultKMS) Encrypt(ctx context.Context, keyID string, plaintext, aad []byte) ([]byte, error) {
+	in := map[string]any{"plaintext": base64.StdEncoding.EncodeToString(plaintext)}
+	if len(aad) > 0 {
+		in["associated_data"] = base64.StdEncoding.EncodeToString(aad)
+	}
+
+	var out struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := k.call(ctx, "encrypt", keyID, in, &out); err != nil {
+		return nil, err
+	}
+	return []byte(out.Ciphertext), nil
+}
+
+func (k *VaultKMS) Decrypt(ctx context.Context, keyID string, ciphertext, aad []byte) ([]byte, error) {
+	in := map[string]any{"ciphertext": string(ciphertext)}
+	if len(aad) > 0 {
+		in["associated_data"] = base64.StdEncoding.EncodeToString(aad)
+	}
+
+	var out struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := k.call(ctx, "decrypt", keyID, in, &out); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(out.Plaintext)
+}
+
+func (k *VaultKMS) Sign(ctx context.Context, keyID string, digest []byte) ([]byte, error) {
+	in := map[string]any{
+		"input":     base64.StdEncoding.EncodeToString(digest),
+		"prehashed": true,
+	}
+
+	var out struct {
+		Signature string `json:"signature"`
+	}
+	if err := k.call(ctx, "sign", keyID+"/sha2-256", in, &out); err != nil {
+		return nil, err
+	}
+	return []byte(out.Signature), nil
+}
+
+func (k *VaultKMS) Verify(ctx context.Context, keyID string, digest, signature []byte) error {
+	in := map[string]any{
+		"input":     base64.StdEncoding.EncodeToString(digest),
+		"prehashed": true,
+		"signature": string(signature),
+	}
+
+	var out struct {
+		Valid bool `json:"valid"`
+	}
+	if err := k.call(ctx, "verify", keyID+"/sha2-256", in, &out); err != nil {
+		return err
+	}
+
+	if !out.Valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
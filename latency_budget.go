@@ -0,0 +1,267 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+const (
+	defaultBudgetMaxViolations = 0.05
+	defaultBudgetWindow        = time.Minute
+)
+
+// LatencyBudget is the latency a dependency is allowed: calls slower than Threshold violate it, and the
+// dependency is degraded when more than MaxViolations of its calls did within the window.
+type LatencyBudget struct {
+	// Dependency is "db:<pool>" for the pools, e.g. "db:default", the name of the client for
+	// Service.HTTPClient, and the name given to Observe or UnaryClientInterceptor otherwise.
+	Dependency string
+	Threshold  time.Duration
+	// MaxViolations is the fraction of calls allowed above Threshold, zero means defaultBudgetMaxViolations.
+	MaxViolations float64
+	// Window over which violations are counted, zero means defaultBudgetWindow. The ratio covers the
+	// current and the previous window, so it doesn't reset abruptly.
+	Window time.Duration
+}
+
+type latencyBudget struct {
+	LatencyBudget
+
+	mu          sync.Mutex
+	windowStart time.Time
+	calls       [2]int64
+	violations  [2]int64
+}
+
+// rotate starts a new window once the current one elapsed, must be called with the lock held.
+func (b *latencyBudget) rotate(now time.Time) {
+	switch elapsed := now.Sub(b.windowStart); {
+	case elapsed >= 2*b.Window:
+		b.calls, b.violations = [2]int64{}, [2]int64{}
+		b.windowStart = now
+	case elapsed >= b.Window:
+		b.calls = [2]int64{b.calls[1], 0}
+		b.violations = [2]int64{b.violations[1], 0}
+		b.windowStart = b.windowStart.Add(b.Window)
+	}
+}
+
+func (b *latencyBudget) observe(latency time.Duration) (violated bool, ratio float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rotate(time.Now())
+	violated = latency > b.Threshold
+	b.calls[1]++
+	if violated {
+		b.violations[1]++
+	}
+	return violated, b.ratio()
+}
+
+// ratio must be called with the lock held.
+func (b *latencyBudget) ratio() float64 {
+	calls := b.calls[0] + b.calls[1]
+	if calls == 0 {
+		return 0
+	}
+	return float64(b.violations[0]+b.violations[1]) / float64(calls)
+}
+
+func (b *latencyBudget) status() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rotate(time.Now())
+	if ratio := b.ratio(); ratio > b.MaxViolations {
+		return fmt.Errorf("%.1f%% of calls slower than %s, budget %.1f%%", 100*ratio, b.Threshold, 100*b.MaxViolations)
+	}
+	return nil
+}
+
+// LatencyBudgets compares the latencies of the dependencies with their budget. Dependencies over budget
+// are reported as degraded by the health status, without failing the probes.
+type LatencyBudgets struct {
+	budgets map[string]*latencyBudget
+
+	calls      *prometheus.CounterVec
+	violations *prometheus.CounterVec
+	ratio      *prometheus.GaugeVec
+	threshold  *prometheus.GaugeVec
+}
+
+func NewLatencyBudgets(budgets ...LatencyBudget) (*LatencyBudgets, error) {
+	b := &LatencyBudgets{budgets: make(map[string]*latencyBudget, len(budgets))}
+	for _, budget := range budgets {
+		if budget.Dependency == "" || budget.Threshold <= 0 {
+			return nil, errors.New("latency budgets require a dependency and a threshold")
+		}
+		if budget.MaxViolations == 0 {
+			budget.MaxViolations = defaultBudgetMaxViolations
+		}
+		if budget.Window == 0 {
+			budget.Window = defaultBudgetWindow
+		}
+		b.budgets[budget.Dependency] = &latencyBudget{LatencyBudget: budget, windowStart: time.Now()}
+	}
+	b.setMetrics(newLatencyBudgetMetrics())
+
+	return b, nil
+}
+
+type latencyBudgetMetrics struct {
+	calls      *prometheus.CounterVec
+	violations *prometheus.CounterVec
+	ratio      *prometheus.GaugeVec
+	threshold  *prometheus.GaugeVec
+}
+
+func newLatencyBudgetMetrics() latencyBudgetMetrics {
+	return latencyBudgetMetrics{
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dependency_budget_calls_total",
+			Help: "Calls to dependencies with a latency budget.",
+		}, []string{"dependency"}),
+		violations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dependency_budget_violations_total",
+			Help: "Calls to dependencies slower than their latency budget.",
+		}, []string{"dependency"}),
+		ratio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dependency_budget_violation_ratio",
+			Help: "Fraction of the recent calls slower than the latency budget.",
+		}, []string{"dependency"}),
+		threshold: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dependency_latency_budget_seconds",
+			Help: "Latency budget of the dependencies.",
+		}, []string{"dependency"}),
+	}
+}
+
+func (b *LatencyBudgets) setMetrics(m latencyBudgetMetrics) {
+	b.calls = m.calls
+	b.violations = m.violations
+	b.ratio = m.ratio
+	b.threshold = m.threshold
+
+	for name, budget := range b.budgets {
+		b.threshold.WithLabelValues(name).Set(budget.Threshold.Seconds())
+	}
+}
+
+// Observe records the latency of a call to dependency, dependencies without a budget are ignored.
+func (b *LatencyBudgets) Observe(dependency string, latency time.Duration) {
+	budget, ok := b.budgets[dependency]
+	if !ok {
+		return
+	}
+
+	violated, ratio := budget.observe(latency)
+	b.calls.WithLabelValues(dependency).Inc()
+	if violated {
+		b.violations.WithLabelValues(dependency).Inc()
+	}
+	b.ratio.WithLabelValues(dependency).Set(ratio)
+}
+
+// UnaryClientInterceptor records the latency of the calls of a gRPC client connection to dependency.
+func (b *LatencyBudgets) UnaryClientInterceptor(dependency string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		b.Observe(dependency, time.Since(start))
+		return err
+	}
+}
+
+func (b *LatencyBudgets) components() []ComponentStatus {
+	now := time.Now()
+	components := make([]ComponentStatus, 0, len(b.budgets))
+	for name, budget := range b.budgets {
+		component := newComponentStatus("latency_budget_"+name, false, budget.status(), 0, now)
+		if component.failed {
+			component.Status = componentDegraded
+		}
+		components = append(components, component)
+	}
+	sort.Slice(components, func(i, j int) bool { return components[i].Name < components[j].Name })
+	return components
+}
+
+// observeDependency records a latency if budgets are set up.
+func (s *Service) observeDependency(dependency string, latency time.Duration) {
+	if s.Budgets != nil {
+		s.Budgets.Observe(dependency, latency)
+	}
+}
+
+type queryStartKey struct{}
+
+// budgetTracer reports the query latencies of a pool, once it is registered under a name.
+type budgetTracer struct {
+	observe atomic.Pointer[func(time.Duration)]
+}
+
+func (t *budgetTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, time.Now())
+}
+
+func (t *budgetTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryEndData) {
+	observe := t.observe.Load()
+	start, ok := ctx.Value(queryStartKey{}).(time.Time)
+	if observe != nil && ok {
+		(*observe)(time.Since(start))
+	}
+}
+
+// traceBudget binds the budget tracer of the pool to the dependency "db:<name>".
+func (s *Service) traceBudget(name string, db *pgxpool.Pool) {
+	m, ok := db.Config().ConnConfig.Tracer.(*MultiQueryTracer)
+	if !ok {
+		return
+	}
+
+	dependency := "db:" + name
+	observe := func(latency time.Duration) { s.observeDependency(dependency, latency) }
+	for _, tracer := range m.Tracers {
+		if t, ok := tracer.(*budgetTracer); ok {
+			t.observe.Store(&observe)
+		}
+	}
+}
+
+type LatencyBudgetOption struct {
+	budgets []LatencyBudget
+}
+
+func (w LatencyBudgetOption) Apply(s *Service) error {
+	b, err := NewLatencyBudgets(w.budgets...)
+	if err != nil {
+		return err
+	}
+
+	m := newLatencyBudgetMetrics()
+	m.calls = registerCollector(s.registry, m.calls)
+	m.violations = registerCollector(s.registry, m.violations)
+	m.ratio = registerCollector(s.registry, m.ratio)
+	m.threshold = registerCollector(s.registry, m.threshold)
+	b.setMetrics(m)
+
+	s.Budgets = b
+	return nil
+}
+
+// WithLatencyBudgets checks the latencies of the database pools, the HTTP clients of the service and
+// other dependencies reported with Service.Budgets.Observe against their budget.
+func WithLatencyBudgets(budgets ...LatencyBudget) Option {
+	return LatencyBudgetOption{budgets: budgets}
+}
@@ -0,0 +1,318 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultLeaderRenewInterval = 5 * time.Second
+
+// LeaderLock is the backend of the leader election, a lock held by at most one instance.
+type LeaderLock interface {
+	// TryAcquire acquires the lock for identity or renews it, returning whether identity holds it.
+	TryAcquire(ctx context.Context, identity string) (bool, error)
+	// Release gives the lock up, if identity holds it.
+	Release(ctx context.Context, identity string) error
+}
+
+// PostgresLeaderLock is a session advisory lock held on a dedicated connection of the pool: it is
+// released by Postgres as soon as the connection of the leader is lost.
+type PostgresLeaderLock struct {
+	db  *pgxpool.Pool
+	key int64
+
+	mu   sync.Mutex
+	conn *pgxpool.Conn
+}
+
+// NewPostgresLeaderLock returns the advisory lock of the given name, hashed to the lock key.
+func NewPostgresLeaderLock(db *pgxpool.Pool, name string) *PostgresLeaderLock {
+	return &PostgresLeaderLock{db: db, key: advisoryLockKey(name)}
+}
+
+func (l *PostgresLeaderLock) TryAcquire(ctx context.Context, _ string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the lock lives as long as the session holding it
+	if l.conn != nil {
+		if err := l.conn.Ping(ctx); err != nil {
+			l.drop()
+			return false, fmt.Errorf("leader lock connection lost: %w", err)
+		}
+		return true, nil
+	}
+
+	conn, err := l.db.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire a connection: %w", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, l.key).Scan(&acquired); err != nil {
+		conn.Release()
+		return false, fmt.Errorf("failed to try the advisory lock: %w", err)
+	}
+	if !acquired {
+		conn.Release()
+		return false, nil
+	}
+
+	l.conn = conn
+	return true, nil
+}
+
+func (l *PostgresLeaderLock) Release(ctx context.Context, _ string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+	defer func() { l.conn.Release(); l.conn = nil }()
+
+	if _, err := l.conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, l.key); err != nil {
+		// closing the session releases the lock as well
+		_ = l.conn.Conn().Close(ctx)
+		return fmt.Errorf("failed to release the advisory lock: %w", err)
+	}
+	return nil
+}
+
+// drop closes a broken connection, must be called with the lock held.
+func (l *PostgresLeaderLock) drop() {
+	_ = l.conn.Conn().Close(context.Background())
+	l.conn.Release()
+	l.conn = nil
+}
+
+type LeaderElectionConfig struct {
+	// Lock is the backend, nil means a PostgresLeaderLock named after the service on the DB pool.
+	Lock LeaderLock
+	// DB is the name of the pool of the default lock, empty means the default pool.
+	DB string
+	// Identity of the instance, defaults to the host name, e.g. the pod name.
+	Identity string
+	// RenewInterval is how often the lock is acquired or renewed, zero means defaultLeaderRenewInterval. It
+	// must be well below the lease duration of backends which expire.
+	RenewInterval time.Duration
+	// OnAcquire is called when the instance becomes the leader, ctx is canceled when it stops leading. It
+	// must not block, leader work is started in a goroutine bound to ctx.
+	OnAcquire func(ctx context.Context)
+	// OnLose is called when the instance stops leading, including on Close.
+	OnLose func()
+}
+
+// LeaderElector is a subservice electing one leader among the instances. Leadership is lost as soon as
+// the lock can't be renewed: two instances may briefly both be followers, never both leaders.
+type LeaderElector struct {
+	cfg    LeaderElectionConfig
+	leader atomic.Bool
+	stop   context.CancelFunc
+
+	gauge       prometheus.Gauge
+	transitions prometheus.Counter
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewLeaderElector(cfg LeaderElectionConfig) (*LeaderElector, error) {
+	if cfg.Lock == nil {
+		return nil, errors.New("leader election requires a lock")
+	}
+	if cfg.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the host name: %w", err)
+		}
+		cfg.Identity = hostname
+	}
+	if cfg.RenewInterval == 0 {
+		cfg.RenewInterval = defaultLeaderRenewInterval
+	}
+
+	return &LeaderElector{
+		cfg: cfg,
+		gauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "leader_election_is_leader",
+			Help: "1 while this instance is the leader.",
+		}),
+		transitions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "leader_election_transitions_total",
+			Help: "Number of times this instance became or stopped being the leader.",
+		}),
+		done: make(chan struct{}),
+	}, nil
+}
+
+func (e *LeaderElector) Name() string {
+	return "leader-elector"
+}
+
+// ShutdownPriority releases the lock after the leader only subservices stopped.
+func (e *LeaderElector) ShutdownPriority() int {
+	return ShutdownPriorityProducer
+}
+
+func (e *LeaderElector) Ready() bool {
+	return true
+}
+
+// IsLeader reports whether this instance currently leads.
+func (e *LeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+func (e *LeaderElector) Run(ctx context.Context) error {
+	e.mu.Lock()
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.running.Store(true)
+	e.mu.Unlock()
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.cfg.RenewInterval)
+	defer ticker.Stop()
+
+	for {
+		e.renew(ctx)
+
+		select {
+		case <-ctx.Done():
+			e.resign()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *LeaderElector) renew(ctx context.Context) {
+	renewCtx, cancel := context.WithTimeout(ctx, e.cfg.RenewInterval)
+	defer cancel()
+
+	held, err := e.cfg.Lock.TryAcquire(renewCtx, e.cfg.Identity)
+	if err != nil && ctx.Err() == nil {
+		log.Error().Err(err).Str("identity", e.cfg.Identity).Msg("failed to renew leadership")
+	}
+
+	switch {
+	case held && err == nil && !e.leader.Load():
+		e.transition(ctx, true)
+	case (!held || err != nil) && e.leader.Load():
+		e.transition(ctx, false)
+	}
+}
+
+// resign releases the lock on shutdown, so another instance takes over without waiting for it to expire.
+func (e *LeaderElector) resign() {
+	if !e.leader.Load() {
+		return
+	}
+
+	e.transition(context.Background(), false)
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.RenewInterval)
+	defer cancel()
+	if err := e.cfg.Lock.Release(ctx, e.cfg.Identity); err != nil {
+		log.Error().Err(err).Msg("failed to release leadership")
+	}
+}
+
+func (e *LeaderElector) transition(ctx context.Context, leader bool) {
+	e.leader.Store(leader)
+	e.transitions.Inc()
+
+	if leader {
+		log.Info().Str("identity", e.cfg.Identity).Msg("became the leader")
+		e.gauge.Set(1)
+
+		var leaderCtx context.Context
+		leaderCtx, e.stop = context.WithCancel(ctx)
+		if e.cfg.OnAcquire != nil {
+			e.cfg.OnAcquire(leaderCtx)
+		}
+		return
+	}
+
+	log.Warn().Str("identity", e.cfg.Identity).Msg("stopped being the leader")
+	e.gauge.Set(0)
+	if e.stop != nil {
+		e.stop()
+	}
+	if e.cfg.OnLose != nil {
+		e.cfg.OnLose()
+	}
+}
+
+func (e *LeaderElector) Close() error {
+	e.mu.Lock()
+	if e.cancel != nil {
+		e.cancel()
+	}
+	e.mu.Unlock()
+
+	if e.running.Load() {
+		<-e.done
+	}
+	return nil
+}
+
+// wireLeaderElection gates the leader only jobs of the scheduler and the outbox relay on the leadership,
+// once all options are applied.
+func (s *Service) wireLeaderElection() {
+	if s.Leader == nil {
+		return
+	}
+	if s.Scheduler != nil {
+		s.Scheduler.SetLeaderCheck(s.Leader.IsLeader)
+	}
+	if s.Outbox != nil {
+		s.Outbox.SetLeaderCheck(s.Leader.IsLeader)
+	}
+}
+
+type LeaderElectionOption struct {
+	cfg LeaderElectionConfig
+}
+
+func (w LeaderElectionOption) Apply(s *Service) error {
+	cfg := w.cfg
+	if cfg.Lock == nil {
+		name := cfg.DB
+		if name == "" {
+			name = DefaultDBName
+		}
+		db, err := s.NamedDB(name)
+		if err != nil {
+			return fmt.Errorf("leader election: %w", err)
+		}
+		cfg.Lock = NewPostgresLeaderLock(db, s.Name+"-leader")
+	}
+
+	e, err := NewLeaderElector(cfg)
+	if err != nil {
+		return err
+	}
+	e.gauge = registerCollector(s.registry, e.gauge)
+	e.transitions = registerCollector(s.registry, e.transitions)
+
+	s.Leader = e
+	return s.RegisterSubService(e)
+}
+
+// WithLeaderElection elects a leader among the instances of the service, see Service.Leader.IsLeader.
+// LeaderOnly scheduler jobs and the outbox relay run on the leader only. Without a lock, the DB option
+// must be applied before it.
+func WithLeaderElection(cfg LeaderElectionConfig) Option {
+	return LeaderElectionOption{cfg: cfg}
+}
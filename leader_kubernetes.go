@@ -0,0 +1,245 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLeaseDuration = 15 * time.Second
+	serviceAccountDir    = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// leaseTimeFormat is the MicroTime format of the Kubernetes API.
+	leaseTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+type KubernetesLeaseConfig struct {
+	// Name of the coordination.k8s.io/v1 Lease, created when it doesn't exist.
+	Name string
+	// Namespace of the lease, empty means the namespace of the pod.
+	Namespace string
+	// Duration after which a lease which wasn't renewed can be taken over, zero means defaultLeaseDuration.
+	Duration time.Duration
+}
+
+// KubernetesLeaseLock holds a Lease through the API server with the service account of the pod, which
+// needs get, create and update on leases. Updates are conditional on the resource version, so concurrent
+// candidates can't both win.
+type KubernetesLeaseLock struct {
+	cfg    KubernetesLeaseConfig
+	url    string
+	client *http.Client
+}
+
+// NewKubernetesLeaseLock uses the in-cluster configuration.
+func NewKubernetesLeaseLock(cfg KubernetesLeaseConfig) (*KubernetesLeaseLock, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a kubernetes cluster")
+	}
+	if cfg.Name == "" {
+		return nil, errors.New("kubernetes lease requires a name")
+	}
+	if cfg.Namespace == "" {
+		namespace, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the pod namespace: %w", err)
+		}
+		cfg.Namespace = strings.TrimSpace(string(namespace))
+	}
+	if cfg.Duration == 0 {
+		cfg.Duration = defaultLeaseDuration
+	}
+
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid cluster CA")
+	}
+
+	return &KubernetesLeaseLock{
+		cfg: cfg,
+		url: fmt.Sprintf("https://%s/apis/coordination.k8s.io/v1/namespaces/%s/leases",
+			net.JoinHostPort(host, port), cfg.Namespace),
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+		},
+	}, nil
+}
+
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       *string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int32  `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *string `json:"acquireTime,omitempty"`
+	RenewTime            *string `json:"renewTime,omitempty"`
+	LeaseTransitions     *int32  `json:"leaseTransitions,omitempty"`
+}
+
+func (l lease) holder() string {
+	if l.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *l.Spec.HolderIdentity
+}
+
+func (l lease) expired(now time.Time) bool {
+	if l.Spec.RenewTime == nil || l.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	renewed, err := time.Parse(leaseTimeFormat, *l.Spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(*l.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+func (k *KubernetesLeaseLock) TryAcquire(ctx context.Context, identity string) (bool, error) {
+	current, err := k.get(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	if current == nil {
+		return k.write(ctx, http.MethodPost, k.url, k.claim(lease{}, identity, now))
+	}
+
+	holder := current.holder()
+	if holder != "" && holder != identity && !current.expired(now) {
+		return false, nil
+	}
+	return k.write(ctx, http.MethodPut, k.url+"/"+k.cfg.Name, k.claim(*current, identity, now))
+}
+
+func (k *KubernetesLeaseLock) Release(ctx context.Context, identity string) error {
+	current, err := k.get(ctx)
+	if err != nil || current == nil || current.holder() != identity {
+		return err
+	}
+
+	// an empty holder with a short duration lets the next candidate take over at once
+	empty, duration := "", int32(1)
+	current.Spec.HolderIdentity = &empty
+	current.Spec.LeaseDurationSeconds = &duration
+	_, err = k.write(ctx, http.MethodPut, k.url+"/"+k.cfg.Name, *current)
+	return err
+}
+
+// claim renews the lease for identity, counting a transition when the holder changes.
+func (k *KubernetesLeaseLock) claim(l lease, identity string, now time.Time) lease {
+	l.APIVersion, l.Kind = "coordination.k8s.io/v1", "Lease"
+	l.Metadata.Name, l.Metadata.Namespace = k.cfg.Name, k.cfg.Namespace
+
+	at := now.UTC().Format(leaseTimeFormat)
+	if l.holder() != identity {
+		transitions := int32(0)
+		if l.Spec.LeaseTransitions != nil && l.holder() != "" {
+			transitions = *l.Spec.LeaseTransitions + 1
+		}
+		l.Spec.LeaseTransitions = &transitions
+		l.Spec.AcquireTime = &at
+	}
+	duration := int32(k.cfg.Duration / time.Second)
+	l.Spec.HolderIdentity = &identity
+	l.Spec.LeaseDurationSeconds = &duration
+	l.Spec.RenewTime = &at
+
+	return l
+}
+
+func (k *KubernetesLeaseLock) get(ctx context.Context) (*lease, error) {
+	resp, err := k.do(ctx, http.MethodGet, k.url+"/"+k.cfg.Name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var l lease
+		if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+			return nil, fmt.Errorf("failed to decode lease %s: %w", k.cfg.Name, err)
+		}
+		return &l, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, k.apiError(resp)
+	}
+}
+
+// write creates or updates the lease, a conflict means another candidate changed it first.
+func (k *KubernetesLeaseLock) write(ctx context.Context, method, url string, l lease) (bool, error) {
+	body, err := json.Marshal(l)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := k.do(ctx, method, url, body)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return true, nil
+	case http.StatusConflict:
+		return false, nil
+	default:
+		return false, k.apiError(resp)
+	}
+}
+
+func (k *KubernetesLeaseLock) do(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// the token is rotated by the kubelet, it is read for every request
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the service account token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call the kubernetes api: %w", err)
+	}
+	return resp, nil
+}
+
+func (k *KubernetesLeaseLock) apiError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("kubernetes api answered %d for lease %s: %s", resp.StatusCode, k.cfg.Name, bytes.TrimSpace(msg))
+}
@@ -0,0 +1,201 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"runtime/pprof"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultLeakGrace   = 5 * time.Second
+	leakPollInterval   = 50 * time.Millisecond
+	leakComponentLabel = "app_component"
+	leakInstanceLabel  = "app_instance"
+	stopFrame          = "app.(*Service).Stop"
+)
+
+var pprofLabelPattern = regexp.MustCompile(`"((?:[^"\\]|\\.)*)":"((?:[^"\\]|\\.)*)"`)
+
+type LeakDetectionConfig struct {
+	// Grace is how long the goroutines get to exit after Stop, zero means 5s.
+	Grace time.Duration
+}
+
+// GoroutineLeak is a group of goroutines with the same stack left running after Stop.
+type GoroutineLeak struct {
+	// Component is the server or subservice which spawned the goroutines, directly or not.
+	Component string
+	Count     int
+	Stack     string
+}
+
+// GoroutineLeakError is reported to the error reporter when goroutines leaked.
+type GoroutineLeakError struct {
+	Leaks []GoroutineLeak
+}
+
+func (e *GoroutineLeakError) Error() string {
+	count := 0
+	var components []string
+	for _, leak := range e.Leaks {
+		count += leak.Count
+		if !slices.Contains(components, leak.Component) {
+			components = append(components, leak.Component)
+		}
+	}
+	return fmt.Sprintf("%d goroutines leaked by %s", count, strings.Join(components, ", "))
+}
+
+// leakDetector labels the goroutines of the servers and subservices, and the ones they spawn, so the
+// ones still running after Stop are found in the goroutine profile.
+type leakDetector struct {
+	instance string
+	grace    time.Duration
+
+	mu    sync.Mutex
+	leaks []GoroutineLeak
+}
+
+// labeled sets the labels of the detector on the goroutine running fn, inherited by its children.
+func (s *Service) labeled(component string, fn func() error) func() error {
+	if s.leaks == nil {
+		return fn
+	}
+
+	return func() error {
+		ctx := pprof.WithLabels(context.Background(),
+			pprof.Labels(leakComponentLabel, component, leakInstanceLabel, s.leaks.instance))
+		pprof.SetGoroutineLabels(ctx)
+		return fn()
+	}
+}
+
+// GoroutineLeaks returns the goroutines leaked after Stop, see WithLeakDetection.
+func (s *Service) GoroutineLeaks() []GoroutineLeak {
+	if s.leaks == nil {
+		return nil
+	}
+
+	s.leaks.mu.Lock()
+	defer s.leaks.mu.Unlock()
+	return slices.Clone(s.leaks.leaks)
+}
+
+// detectLeaks waits for the labeled goroutines to exit, then reports the remaining ones.
+func (s *Service) detectLeaks() {
+	if s.leaks == nil {
+		return
+	}
+
+	deadline := time.Now().Add(s.leaks.grace)
+	leaks := labeledGoroutines(s.leaks.instance)
+	for len(leaks) > 0 && time.Now().Before(deadline) {
+		time.Sleep(leakPollInterval)
+		leaks = labeledGoroutines(s.leaks.instance)
+	}
+
+	s.leaks.mu.Lock()
+	s.leaks.leaks = leaks
+	s.leaks.mu.Unlock()
+
+	if len(leaks) == 0 {
+		log.Debug().Msg("no goroutine leaked")
+		return
+	}
+	for _, leak := range leaks {
+		log.Error().Str("component", leak.Component).Int("count", leak.Count).Str("stack", leak.Stack).
+			Msg("goroutine leaked")
+	}
+	s.notifyReporter(&GoroutineLeakError{Leaks: leaks})
+}
+
+// labeledGoroutines parses the goroutine profile, whose records are
+//
+//	<count> @ <pcs>
+//	# labels: {"key":"value", ...}
+//	#	<pc>	<function>+<offset>	<file>:<line>
+func labeledGoroutines(instance string) []GoroutineLeak {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		log.Error().Err(err).Msg("failed to read goroutine profile")
+		return nil
+	}
+
+	var leaks []GoroutineLeak
+	for _, record := range strings.Split(buf.String(), "\n\n") {
+		var (
+			leak   GoroutineLeak
+			labels map[string]string
+			stack  strings.Builder
+		)
+		for _, line := range strings.Split(record, "\n") {
+			switch {
+			case strings.HasPrefix(line, "# labels: "):
+				labels = parsePprofLabels(line)
+			case strings.HasPrefix(line, "#\t"):
+				fields := strings.Split(strings.TrimPrefix(line, "#\t"), "\t")
+				if len(fields) == 3 {
+					fmt.Fprintf(&stack, "%s\n\t%s\n", fields[1], fields[2])
+				}
+			default:
+				_, _ = fmt.Sscanf(line, "%d @", &leak.Count)
+			}
+		}
+
+		// the goroutines stopping the service may be labeled ones
+		if labels[leakInstanceLabel] != instance || strings.Contains(stack.String(), stopFrame) {
+			continue
+		}
+		leak.Component = labels[leakComponentLabel]
+		leak.Stack = stack.String()
+		leaks = append(leaks, leak)
+	}
+	return leaks
+}
+
+func parsePprofLabels(line string) map[string]string {
+	labels := make(map[string]string)
+	for _, match := range pprofLabelPattern.FindAllStringSubmatch(line, -1) {
+		key, err := strconv.Unquote(`"` + match[1] + `"`)
+		if err != nil {
+			continue
+		}
+		value, err := strconv.Unquote(`"` + match[2] + `"`)
+		if err != nil {
+			continue
+		}
+		labels[key] = value
+	}
+	return labels
+}
+
+type LeakDetectionOption struct {
+	cfg LeakDetectionConfig
+}
+
+func (w LeakDetectionOption) Apply(s *Service) error {
+	grace := w.cfg.Grace
+	if grace == 0 {
+		grace = defaultLeakGrace
+	}
+
+	s.leaks = &leakDetector{instance: fmt.Sprintf("%p", s), grace: grace}
+	return nil
+}
+
+// WithLeakDetection reports the goroutines of the servers and subservices, and the ones they spawned,
+// still running after Stop: they are logged with the component which spawned them, reported to the
+// error reporter and returned by Service.GoroutineLeaks. Meant for development and tests, apptest
+// enables it.
+func WithLeakDetection(cfg LeakDetectionConfig) Option {
+	return LeakDetectionOption{cfg: cfg}
+}
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LifeboatRoute is an endpoint kept serving in lifeboat mode.
+type LifeboatRoute struct {
+	// Method is the HTTP method, empty matches every method. gRPC calls are matched on Route only.
+	Method string
+	// Route is the chi route pattern, e.g. "/orders/{id}", or the gRPC full method, e.g. "/orders.v1.Orders/Get".
+	Route string
+}
+
+type LifeboatConfig struct {
+	// Allow lists the critical endpoints, the others are rejected with 503 (Unavailable) in lifeboat mode.
+	Allow []LifeboatRoute
+	// AdminTokens enable the /admin/lifeboat endpoints on the tech server.
+	AdminTokens []string
+}
+
+type LifeboatStatus struct {
+	Enabled bool      `json:"enabled"`
+	Reason  string    `json:"reason,omitempty"`
+	Since   time.Time `json:"since,omitzero"`
+}
+
+// Lifeboat lets a struggling instance protect its most important functionality: once enabled,
+// only the allowlisted endpoints are served.
+type Lifeboat struct {
+	allow  map[LifeboatRoute]struct{}
+	tokens []string
+
+	status LifeboatStatus
+	mu     sync.RWMutex
+
+	enabled  prometheus.Gauge
+	rejected *prometheus.CounterVec
+}
+
+func NewLifeboat(cfg LifeboatConfig) *Lifeboat {
+	l := &Lifeboat{
+		allow:  make(map[LifeboatRoute]struct{}, len(cfg.Allow)),
+		tokens: cfg.AdminTokens,
+		enabled: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "lifeboat_mode_enabled",
+			Help: "1 when only the allowlisted endpoints are served.",
+		}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "lifeboat_rejected_requests_total",
+			Help: "Number of requests rejected in lifeboat mode.",
+		}, []string{"protocol"}),
+	}
+	for _, route := range cfg.Allow {
+		l.allow[route] = struct{}{}
+	}
+
+	return l
+}
+
+// Enable starts rejecting the endpoints which are not allowlisted.
+func (l *Lifeboat) Enable(reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.status.Enabled {
+		return
+	}
+	l.status = LifeboatStatus{Enabled: true, Reason: reason, Since: time.Now()}
+	l.enabled.Set(1)
+	log.Warn().Str("reason", reason).Msg("lifeboat mode enabled, serving allowlisted endpoints only")
+}
+
+func (l *Lifeboat) Disable() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.status.Enabled {
+		return
+	}
+	log.Info().Dur("duration", time.Since(l.status.Since)).Msg("lifeboat mode disabled")
+	l.status = LifeboatStatus{}
+	l.enabled.Set(0)
+}
+
+func (l *Lifeboat) Status() LifeboatStatus {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.status
+}
+
+func (l *Lifeboat) allowed(method, route string) bool {
+	if !l.Status().Enabled {
+		return true
+	}
+
+	_, ok := l.allow[LifeboatRoute{Method: method, Route: route}]
+	if !ok {
+		_, ok = l.allow[LifeboatRoute{Route: route}]
+	}
+	return ok
+}
+
+// Middleware rejects the requests to endpoints which are not allowlisted while lifeboat mode is enabled.
+// The route pattern is resolved with the chi router the middleware is mounted on, the path otherwise.
+func (l *Lifeboat) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.allowed(r.Method, lifeboatRoute(r)) {
+				l.rejected.WithLabelValues("http").Inc()
+				AnswerWithJSONError(w, http.StatusServiceUnavailable)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// lifeboatRoute resolves the pattern of the route, middlewares run before chi routes the request.
+func lifeboatRoute(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.Routes != nil {
+		path := rctx.RoutePath
+		if path == "" {
+			path = r.URL.Path
+		}
+		if pattern := rctx.Routes.Find(chi.NewRouteContext(), r.Method, path); pattern != "" {
+			return pattern
+		}
+	}
+
+	return r.URL.Path
+}
+
+func (l *Lifeboat) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !l.allowed("", info.FullMethod) {
+			l.rejected.WithLabelValues("grpc").Inc()
+			return nil, status.Error(codes.Unavailable, "lifeboat mode: endpoint disabled")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (l *Lifeboat) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !l.allowed("", info.FullMethod) {
+			l.rejected.WithLabelValues("grpc").Inc()
+			return status.Error(codes.Unavailable, "lifeboat mode: endpoint disabled")
+		}
+		return handler(srv, ss)
+	}
+}
+
+// lifeboatInterceptor lets gRPC servers honor lifeboat mode whatever the order WithLifeboat is applied in.
+func (s *Service) lifeboatInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if s.Lifeboat == nil {
+		return handler(ctx, req)
+	}
+	return s.Lifeboat.UnaryServerInterceptor()(ctx, req, info, handler)
+}
+
+func (s *Service) lifeboatStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if s.Lifeboat == nil {
+		return handler(srv, ss)
+	}
+	return s.Lifeboat.StreamServerInterceptor()(srv, ss, info, handler)
+}
+
+func (l *Lifeboat) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(BearerTokenAuth(l.tokens...))
+
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(l.Status())
+	})
+
+	r.Put("/", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		l.Enable(body.Reason)
+		log.Info().Bool("audit", true).Str("action", "enable_lifeboat").Str("remote_addr", r.RemoteAddr).
+			Str("request_id", middleware.GetReqID(r.Context())).Msg("lifeboat mode enabled by admin")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.Delete("/", func(w http.ResponseWriter, r *http.Request) {
+		l.Disable()
+		log.Info().Bool("audit", true).Str("action", "disable_lifeboat").Str("remote_addr", r.RemoteAddr).
+			Str("request_id", middleware.GetReqID(r.Context())).Msg("lifeboat mode disabled by admin")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return r
+}
+
+type LifeboatOption struct {
+	cfg LifeboatConfig
+}
+
+func (w LifeboatOption) Apply(s *Service) error {
+	l := NewLifeboat(w.cfg)
+	l.enabled = registerCollector(s.registry, l.enabled)
+	l.rejected = registerCollector(s.registry, l.rejected)
+
+	s.Lifeboat = l
+	return nil
+}
+
+// WithLifeboat adds lifeboat mode to the gRPC servers and to the HTTP handlers wrapped with
+// Service.Lifeboat.Middleware(). It is toggled with Service.Lifeboat.Enable and Disable, or through
+// /admin/lifeboat on the tech server when AdminTokens are set.
+func WithLifeboat(cfg LifeboatConfig) Option {
+	return LifeboatOption{cfg: cfg}
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLifeboatRoutes(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		want          int
+		wantEnabled   bool
+	}{
+		{"enable", http.MethodPut, "Bearer secret", http.StatusNoContent, true},
+		{"enable with empty token", http.MethodPut, "Bearer ", http.StatusUnauthorized, false},
+		{"enable with wrong token", http.MethodPut, "Bearer guess", http.StatusUnauthorized, false},
+		{"enable without token", http.MethodPut, "", http.StatusUnauthorized, false},
+		{"status", http.MethodGet, "Bearer secret", http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLifeboat(LifeboatConfig{AdminTokens: []string{"secret", ""}})
+
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(`{"reason":"incident"}`))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			l.routes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if status := l.Status(); status.Enabled != tt.wantEnabled {
+				t.Errorf("enabled = %v, want %v", status.Enabled, tt.wantEnabled)
+			}
+		})
+	}
+}
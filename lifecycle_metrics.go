@@ -0,0 +1,101 @@
+package app
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lifecycleMetrics describes the service itself, so dashboards work the same for every service. The
+// readiness and the uptime are computed on scrape from the state at hand, the health checks being read
+// from their last run rather than run by every scrape. The durations and the errors are recorded as they
+// happen.
+type lifecycleMetrics struct {
+	s *Service
+
+	ready           *prometheus.Desc
+	subServiceReady *prometheus.Desc
+	uptime          *prometheus.Desc
+
+	startup  prometheus.Gauge
+	shutdown prometheus.Gauge
+	errors   prometheus.Counter
+}
+
+func newLifecycleMetrics(s *Service) *lifecycleMetrics {
+	return &lifecycleMetrics{
+		s: s,
+		ready: prometheus.NewDesc("service_ready",
+			"1 while the service is started, serving and its critical components are ready.", nil, nil),
+		subServiceReady: prometheus.NewDesc("service_subservice_ready",
+			"1 while the subservice is ready, 0 when not ready or paused.", []string{"subservice"}, nil),
+		uptime: prometheus.NewDesc("service_uptime_seconds_total",
+			"Seconds since Start was called.", nil, nil),
+		startup: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "service_startup_duration_seconds",
+			Help: "Time from Start until the startup gates passed.",
+		}),
+		shutdown: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "service_shutdown_duration_seconds",
+			Help: "Time the graceful shutdown took, from the drain to the last component stopped.",
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "service_errors_total",
+			Help: "Errors reported on Service.ErrChan.",
+		}),
+	}
+}
+
+func (m *lifecycleMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.ready
+	ch <- m.subServiceReady
+	ch <- m.uptime
+	m.startup.Describe(ch)
+	m.shutdown.Describe(ch)
+	m.errors.Describe(ch)
+}
+
+func (m *lifecycleMetrics) Collect(ch chan<- prometheus.Metric) {
+	ready := m.s.Started() && m.s.isServing.Load().(bool) && m.checksPassed()
+
+	for name, subService := range m.s.subServices() {
+		p, ok := subService.(Pauser)
+		paused := ok && p.Paused()
+		isReady := !paused && subService.Ready()
+		// paused subservices don't make the service unready, see checkComponents
+		if !isReady && !paused {
+			ready = false
+		}
+		ch <- prometheus.MustNewConstMetric(m.subServiceReady, prometheus.GaugeValue, boolToFloat(isReady), name)
+	}
+	ch <- prometheus.MustNewConstMetric(m.ready, prometheus.GaugeValue, boolToFloat(ready))
+
+	if !m.s.startTime.IsZero() {
+		ch <- prometheus.MustNewConstMetric(m.uptime, prometheus.CounterValue, time.Since(m.s.startTime).Seconds())
+	}
+
+	m.startup.Collect(ch)
+	m.shutdown.Collect(ch)
+	m.errors.Collect(ch)
+}
+
+// checksPassed reports whether the last run of every critical health check passed, those which never ran
+// being left to the probes.
+func (m *lifecycleMetrics) checksPassed() bool {
+	for _, check := range m.s.healthCheckSet() {
+		if check.opts.Criticality != HealthReadiness && check.opts.Criticality != HealthLiveness {
+			continue
+		}
+		if result, ok := check.cached(); ok && result.err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,266 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errLoadShed = errors.New("load shed")
+
+const (
+	defaultLoadShedQueueTimeout = 500 * time.Millisecond
+	defaultLoadShedTargetDelay  = 5 * time.Millisecond
+	defaultLoadShedInterval     = 100 * time.Millisecond
+)
+
+type LoadShedConfig struct {
+	// MaxInFlight is the number of requests served concurrently, zero means 100 per CPU.
+	MaxInFlight int
+	// MaxQueue is the number of requests waiting for a slot, further ones are shed. Zero means MaxInFlight.
+	MaxQueue int
+	// QueueTimeout is how long a request waits for a slot, zero means defaultLoadShedQueueTimeout.
+	QueueTimeout time.Duration
+	// TargetDelay is the acceptable queueing delay, zero means defaultLoadShedTargetDelay. When even the
+	// shortest wait of an interval exceeds it the queue is standing, the service is overloaded and requests
+	// only wait TargetDelay, shedding the excess early instead of letting latency grow.
+	TargetDelay time.Duration
+}
+
+// LoadShedder bounds the requests in flight and rejects the excess with 503, or Unavailable for gRPC,
+// before the service collapses under load. Queueing delay is tracked as in CoDel to detect overload.
+type LoadShedder struct {
+	cfg   LoadShedConfig
+	slots chan struct{}
+	queue atomic.Int64
+
+	mu            sync.Mutex
+	intervalStart time.Time
+	minDelay      time.Duration
+	// sampled is set once minDelay holds a delay of the interval
+	sampled    bool
+	overloaded atomic.Bool
+
+	shed     *prometheus.CounterVec
+	canceled *prometheus.CounterVec
+	inFlight prometheus.Gauge
+	wait     prometheus.Histogram
+	overload prometheus.Gauge
+}
+
+func NewLoadShedder(cfg LoadShedConfig) *LoadShedder {
+	if cfg.MaxInFlight == 0 {
+		cfg.MaxInFlight = 100 * runtime.NumCPU()
+	}
+	if cfg.MaxQueue == 0 {
+		cfg.MaxQueue = cfg.MaxInFlight
+	}
+	if cfg.QueueTimeout == 0 {
+		cfg.QueueTimeout = defaultLoadShedQueueTimeout
+	}
+	if cfg.TargetDelay == 0 {
+		cfg.TargetDelay = defaultLoadShedTargetDelay
+	}
+
+	l := &LoadShedder{
+		cfg:           cfg,
+		slots:         make(chan struct{}, cfg.MaxInFlight),
+		intervalStart: time.Now(),
+	}
+	l.setMetrics(newLoadShedMetrics())
+
+	return l
+}
+
+func (l *LoadShedder) setMetrics(m loadShedMetrics) {
+	l.shed = m.shed
+	l.canceled = m.canceled
+	l.inFlight = m.inFlight
+	l.wait = m.wait
+	l.overload = m.overload
+}
+
+// acquire waits for a slot, failing with errLoadShed when the request is shed, or with the error of ctx
+// when the caller gave up meanwhile.
+func (l *LoadShedder) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		l.observeDelay(0)
+		l.inFlight.Inc()
+		return nil
+	default:
+	}
+
+	if l.queue.Add(1) > int64(l.cfg.MaxQueue) {
+		l.queue.Add(-1)
+		return errLoadShed
+	}
+	defer l.queue.Add(-1)
+
+	timeout := l.cfg.QueueTimeout
+	if l.overloaded.Load() {
+		timeout = l.cfg.TargetDelay
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	start := time.Now()
+	select {
+	case l.slots <- struct{}{}:
+		l.observeDelay(time.Since(start))
+		l.inFlight.Inc()
+		return nil
+	case <-timer.C:
+		l.observeDelay(time.Since(start))
+		return errLoadShed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *LoadShedder) release() {
+	<-l.slots
+	l.inFlight.Dec()
+}
+
+// observeDelay tracks the shortest queueing delay of each interval, the service is overloaded while it
+// exceeds the target.
+func (l *LoadShedder) observeDelay(delay time.Duration) {
+	l.wait.Observe(delay.Seconds())
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Since(l.intervalStart) < defaultLoadShedInterval {
+		if !l.sampled || delay < l.minDelay {
+			l.minDelay, l.sampled = delay, true
+		}
+		return
+	}
+
+	overloaded := l.minDelay > l.cfg.TargetDelay
+	if overloaded != l.overloaded.Swap(overloaded) {
+		log.Warn().Bool("overloaded", overloaded).Dur("delay", l.minDelay).Msg("load shedding state changed")
+	}
+	if overloaded {
+		l.overload.Set(1)
+	} else {
+		l.overload.Set(0)
+	}
+	l.intervalStart, l.minDelay, l.sampled = time.Now(), delay, true
+}
+
+// Middleware sheds HTTP requests with 503 Service Unavailable and a Retry-After header.
+func (l *LoadShedder) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := l.acquire(r.Context()); err != nil {
+				// nobody waits for the answer of a canceled request
+				if !errors.Is(err, errLoadShed) {
+					l.canceled.WithLabelValues("http").Inc()
+					return
+				}
+				l.shed.WithLabelValues("http").Inc()
+				w.Header().Set("Retry-After", "1")
+				AnswerWithJSONError(w, http.StatusServiceUnavailable)
+				return
+			}
+			defer l.release()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// UnaryServerInterceptor sheds gRPC calls with codes.Unavailable.
+func (l *LoadShedder) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := l.acquire(ctx); err != nil {
+			if !errors.Is(err, errLoadShed) {
+				l.canceled.WithLabelValues("grpc").Inc()
+				return nil, status.FromContextError(err).Err()
+			}
+			l.shed.WithLabelValues("grpc").Inc()
+			return nil, status.Error(codes.Unavailable, "overloaded, retry later")
+		}
+		defer l.release()
+
+		return handler(ctx, req)
+	}
+}
+
+func (s *Service) loadShedInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if s.LoadShedder == nil {
+		return handler(ctx, req)
+	}
+	return s.LoadShedder.UnaryServerInterceptor()(ctx, req, info, handler)
+}
+
+type loadShedMetrics struct {
+	shed     *prometheus.CounterVec
+	canceled *prometheus.CounterVec
+	inFlight prometheus.Gauge
+	wait     prometheus.Histogram
+	overload prometheus.Gauge
+}
+
+func newLoadShedMetrics() loadShedMetrics {
+	return loadShedMetrics{
+		shed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "load_shed_requests_total",
+			Help: "Requests rejected because the service was overloaded, by protocol.",
+		}, []string{"protocol"}),
+		canceled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "load_shed_canceled_requests_total",
+			Help: "Requests whose caller gave up while they waited for a slot, by protocol.",
+		}, []string{"protocol"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "load_shed_in_flight_requests",
+			Help: "Requests holding a slot of the load shedder.",
+		}),
+		wait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "load_shed_queue_wait_seconds",
+			Help:    "Time requests waited for a slot.",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+		}),
+		overload: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "load_shed_overloaded",
+			Help: "1 while the queueing delay exceeds the target.",
+		}),
+	}
+}
+
+type LoadShedOption struct {
+	cfg LoadShedConfig
+}
+
+func (w LoadShedOption) Apply(s *Service) error {
+	l := NewLoadShedder(w.cfg)
+
+	m := newLoadShedMetrics()
+	m.shed = registerCollector(s.registry, m.shed)
+	m.canceled = registerCollector(s.registry, m.canceled)
+	m.inFlight = registerCollector(s.registry, m.inFlight)
+	m.wait = registerCollector(s.registry, m.wait)
+	m.overload = registerCollector(s.registry, m.overload)
+	l.setMetrics(m)
+
+	s.LoadShedder = l
+	return nil
+}
+
+// WithLoadShedding protects the unary calls of the gRPC servers, and the HTTP handlers wrapped with
+// Service.LoadShedder.Middleware(), from overload. Streams are not limited.
+func WithLoadShedding(cfg LoadShedConfig) Option {
+	return LoadShedOption{cfg: cfg}
+}
@@ -0,0 +1,355 @@
+package app
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ZoneEnv holds the zone of the instance, e.g. set from the topology.kubernetes.io/zone label of the
+	// node or the availability zone of the task.
+	ZoneEnv = "ZONE"
+
+	// LocalityScheme is the gRPC target scheme of the connections dialed with LocalityDialOptions.
+	LocalityScheme = "locality"
+
+	localityBalancerName    = "app_locality"
+	defaultLocalityCooldown = 10 * time.Second
+)
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(localityBalancerName, localityPickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+type LocalityConfig struct {
+	// Zone of the instance, defaults to the ZoneEnv environment variable. Without zone, the endpoints of
+	// all zones are used alike.
+	Zone string
+	// Endpoints of the target by zone, as host:port.
+	Endpoints map[string][]string
+	// Cooldown is how long an endpoint which failed is skipped, zero means 10s.
+	Cooldown time.Duration
+}
+
+// localityRouter picks the endpoints of a target in the zone of the instance, spilling over to the other
+// zones while the local endpoints are failing.
+type localityRouter struct {
+	name     string
+	cooldown time.Duration
+	local    []string
+	remote   []string
+	// localAddrs labels the requests local or remote
+	localAddrs map[string]bool
+
+	mu     sync.Mutex
+	next   int
+	failed map[string]time.Time
+
+	requests  *prometheus.CounterVec
+	ejections prometheus.Counter
+}
+
+type localityMetrics struct {
+	requests  *prometheus.CounterVec
+	ejections *prometheus.CounterVec
+}
+
+func newLocalityMetrics() localityMetrics {
+	return localityMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "client_locality_requests_total",
+			Help: "Outbound requests routed with locality by client and locality: local or remote zone.",
+		}, []string{"client", "locality"}),
+		ejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "client_locality_ejections_total",
+			Help: "Endpoints skipped for the cooldown after failing, by client.",
+		}, []string{"client"}),
+	}
+}
+
+// newLocalityRouter skips the invalid endpoints, a router without endpoint fails every request.
+func (s *Service) newLocalityRouter(name string, cfg LocalityConfig) *localityRouter {
+	if cfg.Zone == "" {
+		cfg.Zone = os.Getenv(ZoneEnv)
+	}
+	if cfg.Cooldown == 0 {
+		cfg.Cooldown = defaultLocalityCooldown
+	}
+
+	r := &localityRouter{
+		name:       name,
+		cooldown:   cfg.Cooldown,
+		failed:     make(map[string]time.Time),
+		localAddrs: make(map[string]bool),
+	}
+	for zone, endpoints := range cfg.Endpoints {
+		for _, endpoint := range endpoints {
+			if _, _, err := net.SplitHostPort(endpoint); err != nil {
+				log.Error().Err(err).Str("client", name).Str("endpoint", endpoint).Msg("invalid locality endpoint")
+				continue
+			}
+			if cfg.Zone == "" || zone == cfg.Zone {
+				r.local = append(r.local, endpoint)
+				r.localAddrs[endpoint] = true
+			} else {
+				r.remote = append(r.remote, endpoint)
+			}
+		}
+	}
+	switch {
+	case len(r.local)+len(r.remote) == 0:
+		log.Error().Str("client", name).Msg("no locality endpoint")
+	case cfg.Zone == "":
+		log.Warn().Str("client", name).Msgf("zone unknown, set %s to route to the local zone", ZoneEnv)
+	case len(r.local) == 0:
+		log.Warn().Str("client", name).Str("zone", cfg.Zone).Msg("no endpoint in the local zone")
+	}
+
+	m := newLocalityMetrics()
+	m.requests = registerCollector(s.registry, m.requests)
+	m.ejections = registerCollector(s.registry, m.ejections)
+	r.requests = m.requests.MustCurryWith(prometheus.Labels{"client": name})
+	r.ejections = m.ejections.WithLabelValues(name)
+	return r
+}
+
+// pick returns an endpoint among the available ones, nil meaning all: a local one which didn't fail
+// recently, else a remote one, else any of them rather than none.
+func (r *localityRouter) pick(available func(endpoint string) bool) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	usable := func(endpoint string) bool {
+		return available == nil || available(endpoint)
+	}
+	healthy := func(endpoint string) bool {
+		return usable(endpoint) && now.After(r.failed[endpoint])
+	}
+
+	for _, filter := range []func(string) bool{healthy, usable} {
+		if endpoint, ok := r.roundRobin(r.local, filter); ok {
+			return endpoint, true
+		}
+		if endpoint, ok := r.roundRobin(r.remote, filter); ok {
+			return endpoint, true
+		}
+	}
+	return "", false
+}
+
+func (r *localityRouter) roundRobin(endpoints []string, filter func(string) bool) (string, bool) {
+	if len(endpoints) == 0 {
+		return "", false
+	}
+
+	r.next++
+	for i := range len(endpoints) {
+		endpoint := endpoints[(r.next+i)%len(endpoints)]
+		if filter(endpoint) {
+			return endpoint, true
+		}
+	}
+	return "", false
+}
+
+func (r *localityRouter) routed(endpoint string) {
+	locality := "remote"
+	if r.localAddrs[endpoint] {
+		locality = "local"
+	}
+	r.requests.WithLabelValues(locality).Inc()
+}
+
+// fail skips endpoint for the cooldown.
+func (r *localityRouter) fail(endpoint string) {
+	r.mu.Lock()
+	ejected := time.Now().After(r.failed[endpoint])
+	r.failed[endpoint] = time.Now().Add(r.cooldown)
+	r.mu.Unlock()
+
+	if ejected {
+		r.ejections.Inc()
+		log.Debug().Str("client", r.name).Str("endpoint", endpoint).Msg("endpoint failed, skipped for the cooldown")
+	}
+}
+
+// localityTransport sends each request to an endpoint picked by the router in place of the URL host, the
+// Host header keeping the original host. HTTPS requests go through a transport verifying the certificate
+// against the original host, the endpoint being an address the certificate isn't issued for.
+type localityTransport struct {
+	router *localityRouter
+	base   http.RoundTripper
+	// newTLS returns the transport of the https requests to serverName
+	newTLS func(serverName string) http.RoundTripper
+
+	mu  sync.Mutex
+	tls map[string]http.RoundTripper
+}
+
+func newLocalityTransport(router *localityRouter, base *http.Transport, wrap func(*http.Transport) http.RoundTripper) *localityTransport {
+	return &localityTransport{
+		router: router,
+		base:   wrap(base),
+		newTLS: func(serverName string) http.RoundTripper {
+			clone := base.Clone()
+			if clone.TLSClientConfig == nil {
+				clone.TLSClientConfig = &tls.Config{}
+			}
+			// an explicit server name is kept
+			if clone.TLSClientConfig.ServerName == "" {
+				clone.TLSClientConfig.ServerName = serverName
+			}
+			return wrap(clone)
+		},
+		tls: make(map[string]http.RoundTripper),
+	}
+}
+
+// transport returns the transport of req, created once per server name for https.
+func (t *localityTransport) transport(req *http.Request) http.RoundTripper {
+	if req.URL.Scheme != "https" {
+		return t.base
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	serverName := req.URL.Hostname()
+	rt, ok := t.tls[serverName]
+	if !ok {
+		rt = t.newTLS(serverName)
+		t.tls[serverName] = rt
+	}
+	return rt
+}
+
+func (t *localityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint, ok := t.router.pick(nil)
+	if !ok {
+		return nil, fmt.Errorf("no locality endpoint for %s", t.router.name)
+	}
+
+	routed := req.Clone(req.Context())
+	if routed.Host == "" {
+		routed.Host = req.URL.Host
+	}
+	routed.URL.Host = endpoint
+	t.router.routed(endpoint)
+
+	resp, err := t.transport(req).RoundTrip(routed)
+	if err != nil && req.Context().Err() == nil {
+		t.router.fail(endpoint)
+	} else if err == nil {
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			t.router.fail(endpoint)
+		}
+	}
+	return resp, err
+}
+
+// LocalityDialOptions balance the calls of a gRPC client connection to the target called name over
+// cfg.Endpoints, preferring the ready endpoints of the local zone. Endpoints failing calls with Unavailable
+// are skipped for the cooldown. Dial LocalityScheme + ":///" + name with them.
+func (s *Service) LocalityDialOptions(name string, cfg LocalityConfig) []grpc.DialOption {
+	router := s.newLocalityRouter(name, cfg)
+	return []grpc.DialOption{
+		grpc.WithResolvers(localityResolverBuilder{router: router}),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{%q:{}}]}`, localityBalancerName)),
+	}
+}
+
+type localityRouterKey struct{}
+
+type localityResolverBuilder struct {
+	router *localityRouter
+}
+
+func (b localityResolverBuilder) Scheme() string {
+	return LocalityScheme
+}
+
+func (b localityResolverBuilder) Build(_ resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	if len(b.router.local)+len(b.router.remote) == 0 {
+		return nil, fmt.Errorf("no locality endpoint for %s", b.router.name)
+	}
+
+	state := resolver.State{}
+	for _, endpoint := range append(append([]string{}, b.router.local...), b.router.remote...) {
+		state.Addresses = append(state.Addresses, resolver.Address{
+			Addr:               endpoint,
+			BalancerAttributes: attributes.New(localityRouterKey{}, b.router),
+		})
+	}
+	if err := cc.UpdateState(state); err != nil {
+		return nil, err
+	}
+	return localityResolver{}, nil
+}
+
+// localityResolver serves static endpoints.
+type localityResolver struct{}
+
+func (localityResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (localityResolver) Close() {}
+
+type localityPickerBuilder struct{}
+
+func (localityPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	p := &localityPicker{subConns: make(map[string]balancer.SubConn, len(info.ReadySCs))}
+	for sc, scInfo := range info.ReadySCs {
+		p.subConns[scInfo.Address.Addr] = sc
+		p.router, _ = scInfo.Address.BalancerAttributes.Value(localityRouterKey{}).(*localityRouter)
+	}
+	if p.router == nil {
+		return base.NewErrPicker(errors.New("locality balancer requires LocalityDialOptions"))
+	}
+	return p
+}
+
+type localityPicker struct {
+	router   *localityRouter
+	subConns map[string]balancer.SubConn
+}
+
+func (p *localityPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	endpoint, ok := p.router.pick(func(endpoint string) bool {
+		_, ready := p.subConns[endpoint]
+		return ready
+	})
+	if !ok {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	p.router.routed(endpoint)
+
+	return balancer.PickResult{
+		SubConn: p.subConns[endpoint],
+		Done: func(info balancer.DoneInfo) {
+			if status.Code(info.Err) == codes.Unavailable {
+				p.router.fail(endpoint)
+			}
+		},
+	}, nil
+}
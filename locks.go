@@ -0,0 +1,419 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const lockRetryInterval = 100 * time.Millisecond
+
+var (
+	ErrLockNotAcquired = errors.New("lock held by another owner")
+	ErrLocksDisabled   = errors.New("locks are not configured, see WithLocks")
+)
+
+// LockBackend stores the locks. A lock is held by the owner of token until it is released or its ttl
+// expires without being renewed.
+type LockBackend interface {
+	// Acquire takes the lock if it is free, returning false when another owner holds it.
+	Acquire(ctx context.Context, key, token string, ttl time.Duration) (bool, error)
+	// Renew extends the ttl, returning false when the lock was lost.
+	Renew(ctx context.Context, key, token string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, key, token string) error
+}
+
+// RedisLockBackend holds locks as keys of a single Redis deployment, set with NX and compared to the token
+// before being renewed or deleted. It doesn't survive a failover losing writes, as Redlock would with
+// independent masters, which is enough for efficiency locks but not for correctness ones.
+type RedisLockBackend struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+var (
+	redisLockRenew = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	redisLockRelease = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// NewRedisLockBackend stores the locks under prefix, e.g. "orders:lock:".
+func NewRedisLockBackend(client redis.UniversalClient, prefix string) *RedisLockBackend {
+	return &RedisLockBackend{client: client, prefix: prefix}
+}
+
+func (r *RedisLockBackend) Acquire(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	err := r.client.SetArgs(ctx, r.prefix+key, token, redis.SetArgs{Mode: "NX", TTL: ttl}).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to set lock %s: %w", key, err)
+	}
+	return true, nil
+}
+
+func (r *RedisLockBackend) Renew(ctx context.Context, key, token string, ttl time.Duration) (bool, error) {
+	renewed, err := redisLockRenew.Run(ctx, r.client, []string{r.prefix + key}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew lock %s: %w", key, err)
+	}
+	return renewed == 1, nil
+}
+
+func (r *RedisLockBackend) Release(ctx context.Context, key, token string) error {
+	if err := redisLockRelease.Run(ctx, r.client, []string{r.prefix + key}, token).Err(); err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", key, err)
+	}
+	return nil
+}
+
+// PostgresLockBackend holds session advisory locks, each on a dedicated connection of the pool, so the
+// ttl is not needed: a lock is released by Postgres as soon as the connection of its owner is lost. Each
+// held lock takes a connection out of the pool.
+type PostgresLockBackend struct {
+	db *pgxpool.Pool
+
+	mu    sync.Mutex
+	conns map[string]*pgxpool.Conn
+}
+
+func NewPostgresLockBackend(db *pgxpool.Pool) *PostgresLockBackend {
+	return &PostgresLockBackend{db: db, conns: make(map[string]*pgxpool.Conn)}
+}
+
+func advisoryLockKey(key string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return int64(h.Sum64())
+}
+
+func (p *PostgresLockBackend) Acquire(ctx context.Context, key, token string, _ time.Duration) (bool, error) {
+	conn, err := p.db.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire a connection: %w", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, advisoryLockKey(key)).Scan(&acquired); err != nil {
+		conn.Release()
+		return false, fmt.Errorf("failed to try lock %s: %w", key, err)
+	}
+	if !acquired {
+		conn.Release()
+		return false, nil
+	}
+
+	p.mu.Lock()
+	p.conns[token] = conn
+	p.mu.Unlock()
+	return true, nil
+}
+
+func (p *PostgresLockBackend) Renew(ctx context.Context, _, token string, _ time.Duration) (bool, error) {
+	p.mu.Lock()
+	conn, ok := p.conns[token]
+	p.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	// the lock lives as long as the session holding it
+	if err := conn.Ping(ctx); err != nil {
+		p.mu.Lock()
+		delete(p.conns, token)
+		p.mu.Unlock()
+		_ = conn.Conn().Close(context.Background())
+		conn.Release()
+		return false, nil
+	}
+	return true, nil
+}
+
+func (p *PostgresLockBackend) Release(ctx context.Context, key, token string) error {
+	p.mu.Lock()
+	conn, ok := p.conns[token]
+	delete(p.conns, token)
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, advisoryLockKey(key)); err != nil {
+		// closing the session releases the lock as well
+		_ = conn.Conn().Close(ctx)
+		return fmt.Errorf("failed to release lock %s: %w", key, err)
+	}
+	return nil
+}
+
+type lockMetrics struct {
+	wait      *prometheus.HistogramVec
+	contended prometheus.Counter
+	held      prometheus.Gauge
+	lost      prometheus.Counter
+}
+
+func newLockMetrics() lockMetrics {
+	return lockMetrics{
+		wait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "distributed_lock_wait_seconds",
+			Help:    "Time spent acquiring locks by result: acquired, not_acquired or error.",
+			Buckets: []float64{.001, .01, .1, .5, 1, 5, 10, 30, 60},
+		}, []string{"result"}),
+		contended: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "distributed_lock_contended_total",
+			Help: "Attempts finding the lock held by another owner.",
+		}),
+		held: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "distributed_lock_held",
+			Help: "Locks currently held by this instance.",
+		}),
+		lost: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "distributed_lock_lost_total",
+			Help: "Locks lost before being released, their renewal having failed.",
+		}),
+	}
+}
+
+// Locks hands out distributed locks renewed in the background while held.
+type Locks struct {
+	backend LockBackend
+	metrics lockMetrics
+	report  func(err error)
+}
+
+func NewLocks(backend LockBackend) *Locks {
+	return &Locks{backend: backend, metrics: newLockMetrics()}
+}
+
+// Acquire waits for the lock until ctx is done. The lock is renewed every third of ttl until released,
+// Lost tells when renewal failed, see Lock.Held.
+func (l *Locks) Acquire(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	return l.acquire(ctx, key, ttl, true)
+}
+
+// TryAcquire returns ErrLockNotAcquired at once when another owner holds the lock.
+func (l *Locks) TryAcquire(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	return l.acquire(ctx, key, ttl, false)
+}
+
+func (l *Locks) acquire(ctx context.Context, key string, ttl time.Duration, wait bool) (*Lock, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("lock %s: ttl must be positive, got %s", key, ttl)
+	}
+
+	start := time.Now()
+	token := uuid.NewString()
+
+	for {
+		attempt := time.Now()
+		acquired, err := l.backend.Acquire(ctx, key, token, ttl)
+		if err != nil {
+			l.metrics.wait.WithLabelValues("error").Observe(time.Since(start).Seconds())
+			return nil, err
+		}
+		if acquired {
+			l.metrics.wait.WithLabelValues("acquired").Observe(time.Since(start).Seconds())
+			return l.hold(key, token, ttl, attempt), nil
+		}
+		l.metrics.contended.Inc()
+
+		if !wait {
+			l.metrics.wait.WithLabelValues("not_acquired").Observe(time.Since(start).Seconds())
+			return nil, fmt.Errorf("%w: %s", ErrLockNotAcquired, key)
+		}
+		select {
+		case <-ctx.Done():
+			l.metrics.wait.WithLabelValues("not_acquired").Observe(time.Since(start).Seconds())
+			return nil, fmt.Errorf("%w: %s: %w", ErrLockNotAcquired, key, ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// hold starts renewing a lock acquired by a request sent at acquired, the earliest its ttl can run from.
+func (l *Locks) hold(key, token string, ttl time.Duration, acquired time.Time) *Lock {
+	ctx, cancel := context.WithCancel(context.Background())
+	lock := &Lock{
+		locks:  l,
+		key:    key,
+		token:  token,
+		ttl:    ttl,
+		cancel: cancel,
+		lost:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	lock.renewed.Store(acquired.UnixNano())
+	l.metrics.held.Inc()
+
+	goRecover("lock "+key, l.report, func() { lock.renew(ctx) })
+	return lock
+}
+
+// Lock is a held distributed lock.
+type Lock struct {
+	locks   *Locks
+	key     string
+	token   string
+	ttl     time.Duration
+	renewed atomic.Int64
+	cancel  context.CancelFunc
+	lost    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// safeUntil is when the lock must be considered lost unless renewed: two thirds of the ttl after the last
+// renewal was sent, leaving the rest of the ttl for the holder to stop before the key expires.
+func (l *Lock) safeUntil() time.Time {
+	return time.Unix(0, l.renewed.Load()).Add(l.ttl * 2 / 3)
+}
+
+// renew extends the lock every third of the ttl until it is released. Renewal errors are retried until
+// safeUntil, the lock is lost then, as it is when the renewal stops for another reason, e.g. a panic.
+func (l *Lock) renew(ctx context.Context) {
+	defer close(l.done)
+	defer func() {
+		if ctx.Err() == nil {
+			l.locks.metrics.lost.Inc()
+			close(l.lost)
+		}
+	}()
+
+	wait := l.ttl / 3
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		deadline := l.safeUntil()
+		if !time.Now().Before(deadline) {
+			log.Error().Str("lock", l.key).Msg("lock lost, not renewed in time")
+			return
+		}
+
+		start := time.Now()
+		renewCtx, cancel := context.WithDeadline(ctx, deadline)
+		ok, err := l.locks.backend.Renew(renewCtx, l.key, l.token, l.ttl)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err == nil && ok:
+			l.renewed.Store(start.UnixNano())
+			wait = l.ttl / 3
+		case err == nil:
+			log.Error().Str("lock", l.key).Msg("lock lost, held by another owner")
+			return
+		case time.Now().Before(deadline):
+			log.Warn().Err(err).Str("lock", l.key).Msg("failed to renew lock, retrying")
+			wait = min(lockRetryInterval, time.Until(deadline))
+		default:
+			log.Error().Err(err).Str("lock", l.key).Msg("lock lost")
+			return
+		}
+	}
+}
+
+// Held reports whether the lock is still safely held: neither released nor lost, and renewed recently
+// enough that the key can't have expired. Work protected by the lock checks it right before acting.
+func (l *Lock) Held() bool {
+	select {
+	case <-l.lost:
+		return false
+	case <-l.done:
+		return false
+	default:
+	}
+	return time.Now().Before(l.safeUntil())
+}
+
+// Key returns the key of the lock.
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Lost is closed when the lock could not be renewed, the work it protects must stop.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release stops renewing the lock and releases it, calling it again does nothing.
+func (l *Lock) Release(ctx context.Context) error {
+	var err error
+	l.once.Do(func() {
+		l.cancel()
+		<-l.done
+		l.locks.metrics.held.Dec()
+
+		select {
+		case <-l.lost:
+		default:
+			err = l.locks.backend.Release(ctx, l.key, l.token)
+		}
+	})
+	return err
+}
+
+// Lock waits for the distributed lock key, see Locks.Acquire:
+//
+//	lock, err := service.Lock(ctx, "invoice:"+id, 30*time.Second)
+//	if err != nil {
+//		return err
+//	}
+//	defer lock.Release(context.WithoutCancel(ctx))
+func (s *Service) Lock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	if s.Locks == nil {
+		return nil, ErrLocksDisabled
+	}
+	return s.Locks.Acquire(ctx, key, ttl)
+}
+
+type LocksOption struct {
+	backend LockBackend
+}
+
+func (w LocksOption) Apply(s *Service) error {
+	backend := w.backend
+	if backend == nil {
+		if s.DB == nil {
+			return requireOption(fmt.Errorf("locks: %w: %s", ErrDBNotFound, DefaultDBName), DBOption{})
+		}
+		backend = NewPostgresLockBackend(s.DB)
+	}
+
+	l := NewLocks(backend)
+	l.metrics.wait = registerCollector(s.registry, l.metrics.wait)
+	l.metrics.contended = registerCollector(s.registry, l.metrics.contended)
+	l.metrics.held = registerCollector(s.registry, l.metrics.held)
+	l.metrics.lost = registerCollector(s.registry, l.metrics.lost)
+	l.report = s.notifyReporter
+
+	s.Locks = l
+	return nil
+}
+
+// WithLocks enables Service.Lock with the backend, nil means advisory locks of the default pool, which
+// must be set up before.
+func WithLocks(backend LockBackend) Option {
+	return LocksOption{backend: backend}
+}
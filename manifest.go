@@ -0,0 +1,349 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v3"
+)
+
+// manifestEnvRef matches the ${VAR} references of the manifest values, a bare $ being kept as is.
+var manifestEnvRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+const (
+	manifestRedisName        = "redis"
+	manifestRedisCheck       = "redis_ping"
+	defaultManifestRedisPing = time.Second
+)
+
+// Manifest describes the topology of a service, so operators can change its servers, databases,
+// consumers and jobs without a code change. Handlers are referenced by the names registered in
+// ManifestHandlers.
+type Manifest struct {
+	Name string `json:"name" yaml:"name"`
+
+	Tech        *ManifestTech        `json:"tech" yaml:"tech"`
+	HTTPServers []ManifestHTTPServer `json:"http_servers" yaml:"http_servers"`
+	GRPCServers []ManifestGRPCServer `json:"grpc_servers" yaml:"grpc_servers"`
+	// Databases by name, "default" being Service.DB.
+	Databases      map[string]ManifestDatabase `json:"databases" yaml:"databases"`
+	Redis          *ManifestRedis              `json:"redis" yaml:"redis"`
+	KafkaConsumers []ManifestKafkaConsumer     `json:"kafka_consumers" yaml:"kafka_consumers"`
+	Jobs           []ManifestJob               `json:"jobs" yaml:"jobs"`
+
+	// ShutdownTimeout is a duration such as "30s", empty keeps the default.
+	ShutdownTimeout string `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+}
+
+type ManifestTech struct {
+	Address string `json:"address" yaml:"address"`
+}
+
+type ManifestHTTPServer struct {
+	Address string `json:"address" yaml:"address"`
+	// Handler is a name of ManifestHandlers.HTTP.
+	Handler string `json:"handler" yaml:"handler"`
+}
+
+type ManifestGRPCServer struct {
+	// Address names the server for Service.AddGRPCService.
+	Address string `json:"address" yaml:"address"`
+}
+
+type ManifestDatabase struct {
+	URL string `json:"url" yaml:"url"`
+}
+
+// ManifestRedis declares a client shared by the cache and the locks, closed with the service and
+// checked by the "redis_ping" health check.
+type ManifestRedis struct {
+	Addrs    []string `json:"addrs" yaml:"addrs"`
+	Username string   `json:"username" yaml:"username"`
+	Password string   `json:"password" yaml:"password"`
+	DB       int      `json:"db" yaml:"db"`
+	// Cache enables WithCache backed by this client.
+	Cache bool `json:"cache" yaml:"cache"`
+	// Locks enables WithLocks backed by this client.
+	Locks bool `json:"locks" yaml:"locks"`
+}
+
+type ManifestKafkaConsumer struct {
+	Brokers []string `json:"brokers" yaml:"brokers"`
+	Group   string   `json:"group" yaml:"group"`
+	Topics  []string `json:"topics" yaml:"topics"`
+	// Handler is a name of ManifestHandlers.Kafka.
+	Handler string `json:"handler" yaml:"handler"`
+}
+
+type ManifestJob struct {
+	Name string `json:"name" yaml:"name"`
+	// Schedule is a cron spec, see Job.Spec.
+	Schedule string `json:"schedule" yaml:"schedule"`
+	// Handler is a name of ManifestHandlers.Jobs.
+	Handler    string `json:"handler" yaml:"handler"`
+	LeaderOnly bool   `json:"leader_only" yaml:"leader_only"`
+}
+
+// ManifestHandlers registers the code a manifest can reference by name.
+type ManifestHandlers struct {
+	HTTP  map[string]http.Handler
+	Kafka map[string]KafkaHandler
+	Jobs  map[string]JobFunc
+}
+
+// LoadManifest reads a JSON or YAML manifest. ${VAR} references in its values are replaced with the
+// environment variables once it is parsed, so secrets such as database URLs stay out of the file.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var m Manifest
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		err = json.Unmarshal(data, &m)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &m)
+	default:
+		return nil, fmt.Errorf("unsupported manifest format %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+
+	expandManifestEnv(reflect.ValueOf(&m).Elem())
+	return &m, nil
+}
+
+// expandManifestEnv replaces the ${VAR} references in the strings of v. Expanding the parsed values
+// rather than the file keeps the environment from changing the structure of the manifest.
+func expandManifestEnv(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(manifestEnvRef.ReplaceAllStringFunc(v.String(), func(ref string) string {
+			return os.Getenv(ref[2 : len(ref)-1])
+		}))
+	case reflect.Pointer:
+		if !v.IsNil() {
+			expandManifestEnv(v.Elem())
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			expandManifestEnv(v.Field(i))
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			expandManifestEnv(v.Index(i))
+		}
+	case reflect.Map:
+		// map values aren't addressable, they are expanded in a copy
+		iter := v.MapRange()
+		for iter.Next() {
+			value := reflect.New(iter.Value().Type()).Elem()
+			value.Set(iter.Value())
+			expandManifestEnv(value)
+			v.SetMapIndex(iter.Key(), value)
+		}
+	}
+}
+
+// NewFromManifest builds the service described by the manifest at path, options being applied after it.
+func NewFromManifest(ctx context.Context, path string, handlers ManifestHandlers, options ...Option) (*Service, error) {
+	m, err := LoadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	if m.Name == "" {
+		return nil, fmt.Errorf("manifest %s has no name", path)
+	}
+
+	return New(ctx, m.Name, append([]Option{WithManifest(m, handlers)}, options...)...)
+}
+
+// options translates the manifest, reporting every unknown handler and invalid value at once.
+func (m *Manifest) options(handlers ManifestHandlers) ([]Option, error) {
+	var (
+		opts []Option
+		errs []error
+	)
+
+	if m.Tech != nil {
+		opts = append(opts, WithTechHTTPServerOption(m.Tech.Address))
+	}
+	for _, srv := range m.HTTPServers {
+		handler, ok := handlers.HTTP[srv.Handler]
+		if !ok {
+			errs = append(errs, fmt.Errorf("http server %s: unknown handler %q", srv.Address, srv.Handler))
+			continue
+		}
+		opts = append(opts, manifestHTTPServerOption{address: srv.Address, handler: handler})
+	}
+	for _, srv := range m.GRPCServers {
+		opts = append(opts, WithGRPCServer(srv.Address))
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(m.Databases)) {
+		cfg, err := pgxpool.ParseConfig(m.Databases[name].URL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("database %s: invalid url: %w", name, err))
+			continue
+		}
+		if name == DefaultDBName {
+			opts = append(opts, WithDB(*cfg))
+		} else {
+			opts = append(opts, WithNamedDB(name, *cfg))
+		}
+	}
+
+	if m.Redis != nil {
+		if len(m.Redis.Addrs) == 0 {
+			errs = append(errs, errors.New("redis: no addrs"))
+		} else {
+			opts = append(opts, manifestRedisOption{cfg: *m.Redis})
+		}
+	}
+
+	for _, c := range m.KafkaConsumers {
+		handler, ok := handlers.Kafka[c.Handler]
+		if !ok {
+			errs = append(errs, fmt.Errorf("kafka consumer %s: unknown handler %q", c.Group, c.Handler))
+			continue
+		}
+		opts = append(opts, WithKafkaConsumer(c.Brokers, c.Group, c.Topics, handler))
+	}
+
+	var jobs []Job
+	for _, j := range m.Jobs {
+		run, ok := handlers.Jobs[j.Handler]
+		if !ok {
+			errs = append(errs, fmt.Errorf("job %s: unknown handler %q", j.Name, j.Handler))
+			continue
+		}
+		jobs = append(jobs, Job{Name: j.Name, Spec: j.Schedule, Run: run, LeaderOnly: j.LeaderOnly})
+	}
+	if len(jobs) > 0 {
+		opts = append(opts, WithScheduler(jobs...))
+	}
+
+	if m.ShutdownTimeout != "" {
+		timeout, err := time.ParseDuration(m.ShutdownTimeout)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("shutdown_timeout: %w", err))
+		} else {
+			opts = append(opts, WithShutdownTimeout(timeout))
+		}
+	}
+
+	return opts, errors.Join(errs...)
+}
+
+type manifestHTTPServerOption struct {
+	address string
+	handler http.Handler
+}
+
+func (w manifestHTTPServerOption) Apply(s *Service) error {
+	s.AddHTTPServer(&http.Server{
+		Addr:           w.address,
+		Handler:        w.handler,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: http.DefaultMaxHeaderBytes,
+	})
+	return nil
+}
+
+type manifestRedisOption struct {
+	cfg ManifestRedis
+}
+
+func (w manifestRedisOption) Apply(s *Service) error {
+	client := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:    w.cfg.Addrs,
+		Username: w.cfg.Username,
+		Password: w.cfg.Password,
+		DB:       w.cfg.DB,
+	})
+
+	if err := s.RegisterSubService(&redisClientService{client: client}); err != nil {
+		client.Close()
+		return err
+	}
+	if err := s.RegisterHealthCheck(manifestRedisCheck, func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	}, HealthCheckOptions{Timeout: defaultManifestRedisPing}); err != nil {
+		s.removeSubService(manifestRedisName)
+		client.Close()
+		return err
+	}
+
+	if w.cfg.Cache {
+		if err := WithCache(CacheConfig{Redis: client}).Apply(s); err != nil {
+			return err
+		}
+	}
+	if w.cfg.Locks {
+		if err := WithLocks(NewRedisLockBackend(client, s.Name+":locks:")).Apply(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redisClientService closes the client once the components using it are closed.
+type redisClientService struct {
+	client redis.UniversalClient
+}
+
+func (r *redisClientService) Name() string {
+	return manifestRedisName
+}
+
+// Ready leaves the reachability of Redis to the health check.
+func (r *redisClientService) Ready() bool {
+	return true
+}
+
+func (r *redisClientService) ShutdownPriority() int {
+	return ShutdownPriorityProducer
+}
+
+func (r *redisClientService) Close() error {
+	return r.client.Close()
+}
+
+type ManifestOption struct {
+	manifest *Manifest
+	handlers ManifestHandlers
+}
+
+func (w ManifestOption) Apply(s *Service) error {
+	opts, err := w.manifest.options(w.handlers)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+
+	errs := s.applyOptions(opts)
+	if len(errs) > 0 {
+		return fmt.Errorf("manifest: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// WithManifest configures the servers, databases, Redis, Kafka consumers and jobs declared in the manifest,
+// see LoadManifest and NewFromManifest.
+func WithManifest(m *Manifest, handlers ManifestHandlers) Option {
+	return ManifestOption{manifest: m, handlers: handlers}
+}
@@ -0,0 +1,192 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	stdlog "log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/mdns"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	mdnsHTTPService = "_http._tcp"
+	mdnsGRPCService = "_grpc._tcp"
+)
+
+type MDNSConfig struct {
+	// Instance names the advertised instances, empty means "<service name>-<hostname>".
+	Instance string
+	// TXT records added to every advertised endpoint, e.g. "env=dev".
+	TXT []string
+	// Iface restricts the multicast listener to an interface, nil means the system default.
+	Iface *net.Interface
+}
+
+// MDNSAdvertiser advertises the HTTP (_http._tcp) and gRPC (_grpc._tcp) servers over multicast DNS,
+// so local tooling can discover running instances without a registry. It is meant for development.
+type MDNSAdvertiser struct {
+	cfg       MDNSConfig
+	name      string
+	version   func() string
+	addresses func() Addresses
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func (a *MDNSAdvertiser) Name() string {
+	return "mdns"
+}
+
+// Ready is always true, advertising is a development aid which must not fail readiness.
+func (a *MDNSAdvertiser) Ready() bool {
+	return true
+}
+
+// zone builds the advertised records from the bound addresses, known once the service listens.
+func (a *MDNSAdvertiser) zone() (mdnsZones, error) {
+	instance := a.cfg.Instance
+	if instance == "" {
+		hostname, _ := os.Hostname()
+		instance = a.name + "-" + hostname
+	}
+
+	txt := append([]string{"service=" + a.name, "pid=" + strconv.Itoa(os.Getpid())}, a.cfg.TXT...)
+	if version := a.version(); version != "" {
+		txt = append(txt, "version="+version)
+	}
+
+	var zones mdnsZones
+	add := func(service string, addrs []string) error {
+		for i, addr := range addrs {
+			host, portStr, err := net.SplitHostPort(addr)
+			if err != nil {
+				return err
+			}
+			port, err := strconv.Atoi(portStr)
+			if err != nil {
+				return err
+			}
+
+			// nil lets mdns resolve the host addresses when listening on every interface
+			var ips []net.IP
+			if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+				ips = []net.IP{ip}
+			}
+
+			name := instance
+			if i > 0 {
+				name = fmt.Sprintf("%s-%d", instance, i)
+			}
+
+			zone, err := mdns.NewMDNSService(name, service, "", "", port, ips, txt)
+			if err != nil {
+				return fmt.Errorf("failed to advertise %s: %w", addr, err)
+			}
+			zones = append(zones, zone)
+		}
+		return nil
+	}
+
+	addrs := a.addresses()
+	if err := add(mdnsHTTPService, addrs.HTTP); err != nil {
+		return nil, err
+	}
+	if err := add(mdnsGRPCService, addrs.GRPC); err != nil {
+		return nil, err
+	}
+
+	return zones, nil
+}
+
+func (a *MDNSAdvertiser) Run(ctx context.Context) error {
+	a.mu.Lock()
+	ctx, a.cancel = context.WithCancel(ctx)
+	a.running.Store(true)
+	a.mu.Unlock()
+	defer close(a.done)
+
+	server, err := a.serve()
+	if err != nil {
+		// advertising is best effort, it must not stop the service
+		log.Warn().Err(err).Msg("failed to advertise service over mdns")
+		<-ctx.Done()
+		return nil
+	}
+
+	<-ctx.Done()
+	return server.Shutdown()
+}
+
+func (a *MDNSAdvertiser) serve() (*mdns.Server, error) {
+	zones, err := a.zone()
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := mdns.NewServer(&mdns.Config{
+		Zone:   zones,
+		Iface:  a.cfg.Iface,
+		Logger: stdlog.New(log.Logger, "", 0),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start mdns server: %w", err)
+	}
+
+	log.Info().Int("endpoints", len(zones)).Msg("advertising service over mdns")
+	return server, nil
+}
+
+func (a *MDNSAdvertiser) Close() error {
+	a.mu.Lock()
+	if a.cancel != nil {
+		a.cancel()
+	}
+	a.mu.Unlock()
+
+	if a.running.Load() {
+		<-a.done
+	}
+	return nil
+}
+
+// mdnsZones answers for every advertised endpoint from a single multicast listener.
+type mdnsZones []mdns.Zone
+
+func (z mdnsZones) Records(q dns.Question) []dns.RR {
+	var records []dns.RR
+	for _, zone := range z {
+		records = append(records, zone.Records(q)...)
+	}
+	return records
+}
+
+type MDNSOption struct {
+	cfg MDNSConfig
+}
+
+func (w MDNSOption) Apply(s *Service) error {
+	a := &MDNSAdvertiser{
+		cfg:       w.cfg,
+		name:      s.Name,
+		version:   s.Version,
+		addresses: s.Addresses,
+		done:      make(chan struct{}),
+	}
+
+	return s.RegisterSubService(a)
+}
+
+// WithMDNS advertises the HTTP and gRPC servers over mDNS once they listen, enable it in development only.
+func WithMDNS(cfg MDNSConfig) Option {
+	return MDNSOption{cfg: cfg}
+}
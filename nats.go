@@ -0,0 +1,132 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+const natsDrainTimeout = 30 * time.Second
+
+// NATSClient is a managed NATS connection. It reconnects forever, including when the server
+// is not reachable at startup, and drains subscriptions on Close.
+type NATSClient struct {
+	conn   *nats.Conn
+	closed chan struct{}
+
+	jsOnce sync.Once
+	js     jetstream.JetStream
+	jsErr  error
+}
+
+func NewNATSClient(url string, opts ...nats.Option) (*NATSClient, error) {
+	c := &NATSClient{closed: make(chan struct{})}
+
+	natsOpts := append([]nats.Option{
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.DrainTimeout(natsDrainTimeout),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.Warn().Err(err).Msg("nats disconnected")
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Info().Str("url", nc.ConnectedUrlRedacted()).Msg("nats reconnected")
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			event := log.Error().Err(err)
+			if sub != nil {
+				event = event.Str("subject", sub.Subject)
+			}
+			event.Msg("nats async error")
+		}),
+	}, opts...)
+
+	// set last so user options can't prevent Close from returning
+	natsOpts = append(natsOpts, nats.ClosedHandler(func(*nats.Conn) {
+		log.Debug().Msg("nats connection closed")
+		close(c.closed)
+	}))
+
+	conn, err := nats.Connect(url, natsOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	c.conn = conn
+
+	return c, nil
+}
+
+func (c *NATSClient) Name() string {
+	return "nats"
+}
+
+func (c *NATSClient) Ready() bool {
+	if status := c.conn.Status(); status != nats.CONNECTED {
+		log.Debug().Str("status", status.String()).Msg("nats not ready")
+		return false
+	}
+
+	return true
+}
+
+func (c *NATSClient) Conn() *nats.Conn {
+	return c.conn
+}
+
+// JetStream returns the JetStream context, created on first use.
+func (c *NATSClient) JetStream(opts ...jetstream.JetStreamOpt) (jetstream.JetStream, error) {
+	c.jsOnce.Do(func() {
+		c.js, c.jsErr = jetstream.New(c.conn, opts...)
+	})
+
+	return c.js, c.jsErr
+}
+
+// Close drains subscriptions, flushes pending publishes and waits for the connection to close.
+func (c *NATSClient) Close() error {
+	if c.conn.IsClosed() {
+		return nil
+	}
+
+	if err := c.conn.Drain(); err != nil {
+		c.conn.Close()
+		return fmt.Errorf("failed to drain nats connection: %w", err)
+	}
+
+	select {
+	case <-c.closed:
+		return nil
+	case <-time.After(natsDrainTimeout + time.Second):
+		c.conn.Close()
+		return fmt.Errorf("nats drain timed out")
+	}
+}
+
+type NATSOption struct {
+	url  string
+	opts []nats.Option
+}
+
+func (w NATSOption) Apply(s *Service) error {
+	c, err := NewNATSClient(w.url, w.opts...)
+	if err != nil {
+		return err
+	}
+
+	if err := s.RegisterSubService(c); err != nil {
+		c.conn.Close()
+		return err
+	}
+
+	s.NATS = c
+	return nil
+}
+
+// WithNATS exposes a managed connection as Service.NATS, JetStream is available through Service.NATS.JetStream().
+func WithNATS(url string, opts ...nats.Option) Option {
+	return NATSOption{url: url, opts: opts}
+}
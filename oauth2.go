@@ -0,0 +1,279 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultOAuth2ExpiryMargin = 30 * time.Second
+	oauth2TokenTimeout        = 30 * time.Second
+)
+
+// OAuth2Config are the client credentials of a target, RFC 6749 section 4.4.
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Audience is sent as the audience parameter required by some providers, e.g. Auth0, when set.
+	Audience string
+	// ExpiryMargin renews the token this long before it expires, zero means defaultOAuth2ExpiryMargin.
+	ExpiryMargin time.Duration
+	// Insecure sends the token over gRPC connections without TLS, e.g. behind a mesh doing mTLS.
+	Insecure bool
+}
+
+// OAuth2TokenSource fetches the access token of a client and caches it until it is about to expire.
+// Concurrent callers share a single token request.
+type OAuth2TokenSource struct {
+	name     string
+	cfg      OAuth2Config
+	client   *http.Client
+	requests *prometheus.CounterVec
+	group    singleflight.Group
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func newOAuth2TokenRequests() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "oauth2_token_requests_total",
+		Help: "OAuth2 token requests by client and result: success or error.",
+	}, []string{"client", "result"})
+}
+
+// NewOAuth2TokenSource requests the tokens with client, http.DefaultClient when nil. Its metrics are
+// labeled with the client id.
+func NewOAuth2TokenSource(cfg OAuth2Config, client *http.Client) *OAuth2TokenSource {
+	if cfg.ExpiryMargin == 0 {
+		cfg.ExpiryMargin = defaultOAuth2ExpiryMargin
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &OAuth2TokenSource{name: cfg.ClientID, cfg: cfg, client: client, requests: newOAuth2TokenRequests()}
+}
+
+// Token returns the cached access token, or requests a new one once it is about to expire. Callers stop
+// waiting for the request when ctx is done, the request itself is bounded by oauth2TokenTimeout.
+func (t *OAuth2TokenSource) Token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	token, expiresAt := t.token, t.expiresAt
+	t.mu.Unlock()
+	if token != "" && time.Now().Before(expiresAt) {
+		return token, nil
+	}
+
+	// the request is shared, it doesn't fail when the caller which started it gives up
+	results := t.group.DoChan("token", func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), oauth2TokenTimeout)
+		defer cancel()
+		return t.renew(ctx)
+	})
+	select {
+	case res := <-results:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// renew requests a token and caches it.
+func (t *OAuth2TokenSource) renew(ctx context.Context) (string, error) {
+	token, expiresIn, err := t.fetch(ctx)
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	t.requests.WithLabelValues(t.name, result).Inc()
+	if err != nil {
+		return "", err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.token = token
+	// tokens without expires_in are kept until rejected
+	t.expiresAt = time.Now().Add(24 * time.Hour)
+	if expiresIn > 0 {
+		t.expiresAt = time.Now().Add(max(expiresIn-t.cfg.ExpiryMargin, 0))
+	}
+	return token, nil
+}
+
+// Invalidate drops token once it is rejected by the target, unless it was renewed meanwhile.
+func (t *OAuth2TokenSource) Invalidate(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token == token {
+		t.token = ""
+	}
+}
+
+func (t *OAuth2TokenSource) fetch(ctx context.Context) (string, time.Duration, error) {
+	if t.cfg.TokenURL == "" || t.cfg.ClientID == "" {
+		return "", 0, errors.New("oauth2 requires a token url and a client id")
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(t.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(t.cfg.Scopes, " "))
+	}
+	if t.cfg.Audience != "" {
+		form.Set("audience", t.cfg.Audience)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create the oauth2 token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// section 2.3.1, the credentials are form encoded before being used as basic auth
+	req.SetBasicAuth(url.QueryEscape(t.cfg.ClientID), url.QueryEscape(t.cfg.ClientSecret))
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to request an oauth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil && resp.StatusCode == http.StatusOK {
+		return "", 0, fmt.Errorf("failed to decode the oauth2 token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", 0, fmt.Errorf("oauth2 token request failed: %s: %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.AccessToken == "" || (body.TokenType != "" && !strings.EqualFold(body.TokenType, "bearer")) {
+		return "", 0, fmt.Errorf("oauth2 token request returned no bearer token")
+	}
+
+	return body.AccessToken, time.Duration(body.ExpiresIn) * time.Second, nil
+}
+
+// OAuth2TokenSource returns a token source for the target called name, which labels its metrics. Tokens
+// are requested with a managed HTTP client called "<name>-oauth2".
+func (s *Service) OAuth2TokenSource(name string, cfg OAuth2Config) *OAuth2TokenSource {
+	t := NewOAuth2TokenSource(cfg, s.HTTPClient(name+"-oauth2", HTTPClientOptions{}))
+	t.name = name
+	t.requests = registerCollector(s.registry, t.requests)
+	return t
+}
+
+// oauth2Transport authorizes the requests of a client. A request rejected with 401 is sent once more with
+// a new token, if its body can be replayed.
+type oauth2Transport struct {
+	source *OAuth2TokenSource
+	base   http.RoundTripper
+}
+
+func (t *oauth2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(authorized(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	t.source.Invalidate(token)
+	if token, err = t.source.Token(req.Context()); err != nil {
+		log.Warn().Err(err).Str("client", t.source.name).Msg("failed to renew the rejected oauth2 token")
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	retry := authorized(req, token)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(retry)
+}
+
+func authorized(req *http.Request, token string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+type oauth2Credentials struct {
+	source *OAuth2TokenSource
+}
+
+func (c oauth2Credentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token, err := c.source.Token(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+func (c oauth2Credentials) RequireTransportSecurity() bool {
+	return !c.source.cfg.Insecure
+}
+
+// oauth2ClientInterceptor retries a call rejected with Unauthenticated once, with a new token.
+func oauth2ClientInterceptor(source *OAuth2TokenSource) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		token, err := source.Token(ctx)
+		if err != nil {
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		if status.Code(err) != codes.Unauthenticated {
+			return err
+		}
+		source.Invalidate(token)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// OAuth2DialOptions authorize the calls of a gRPC client connection to the target called name with its
+// client credentials. Unary calls rejected with Unauthenticated are retried once with a new token.
+func (s *Service) OAuth2DialOptions(name string, cfg OAuth2Config) []grpc.DialOption {
+	source := s.OAuth2TokenSource(name, cfg)
+	return []grpc.DialOption{
+		grpc.WithPerRPCCredentials(oauth2Credentials{source: source}),
+		grpc.WithChainUnaryInterceptor(oauth2ClientInterceptor(source)),
+	}
+}
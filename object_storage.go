@@ -0,0 +1,318 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const objectStoragePingTimeout = 2 * time.Second
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// objectNotFoundError is returned by Get for a missing key. It matches ErrObjectNotFound and, the
+// storage being usable as a BackupStore, ErrBackupNotFound with errors.Is.
+type objectNotFoundError struct {
+	key string
+}
+
+func (e *objectNotFoundError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrObjectNotFound, e.key)
+}
+
+func (e *objectNotFoundError) Is(target error) bool {
+	return target == ErrObjectNotFound || target == ErrBackupNotFound
+}
+
+type ObjectStorageConfig struct {
+	Bucket string
+	// Endpoint of an S3 compatible store, e.g. http://minio:9000, empty means AWS S3.
+	Endpoint string
+	// Region defaults to the one of Service.AWS, or us-east-1 for the other stores.
+	Region string
+	// AccessKeyID and SecretAccessKey are static credentials, e.g. of MinIO, empty means the credentials
+	// of Service.AWS or the default chain.
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses the bucket in the path rather than the host name, as MinIO requires.
+	PathStyle bool
+}
+
+type objectStorageMetrics struct {
+	duration *prometheus.HistogramVec
+	bytes    *prometheus.CounterVec
+}
+
+func newObjectStorageMetrics() objectStorageMetrics {
+	return objectStorageMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "object_storage_operation_duration_seconds",
+			Help:    "Duration of the object storage operations by bucket, operation and result.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"bucket", "operation", "result"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "object_storage_bytes_total",
+			Help: "Bytes uploaded to and downloaded from the object storage by bucket and direction.",
+		}, []string{"bucket", "direction"}),
+	}
+}
+
+// ObjectStorage is a bucket of S3 or an S3 compatible store such as MinIO, usable as a BackupStore. It is
+// a subservice ready while the bucket is reachable.
+type ObjectStorage struct {
+	client  *s3.Client
+	presign *s3.PresignClient
+	bucket  string
+
+	duration *prometheus.HistogramVec
+	bytes    *prometheus.CounterVec
+}
+
+// NewObjectStorage uses the client for the bucket.
+func NewObjectStorage(client *s3.Client, bucket string) *ObjectStorage {
+	o := &ObjectStorage{client: client, presign: s3.NewPresignClient(client), bucket: bucket}
+	o.setMetrics(newObjectStorageMetrics())
+	return o
+}
+
+func (o *ObjectStorage) setMetrics(m objectStorageMetrics) {
+	o.duration = m.duration
+	o.bytes = m.bytes
+}
+
+func (o *ObjectStorage) Name() string {
+	return "object-storage"
+}
+
+func (o *ObjectStorage) Ready() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), objectStoragePingTimeout)
+	defer cancel()
+
+	if err := o.CheckBucket(ctx); err != nil {
+		log.Debug().Err(err).Str("bucket", o.bucket).Msg("object storage not ready")
+		return false
+	}
+	return true
+}
+
+func (o *ObjectStorage) Close() error {
+	return nil
+}
+
+// Client gives access to the underlying client, its calls are not recorded in the object storage metrics.
+func (o *ObjectStorage) Client() *s3.Client {
+	return o.client
+}
+
+// CheckBucket returns an error if the bucket doesn't exist or isn't accessible.
+func (o *ObjectStorage) CheckBucket(ctx context.Context) error {
+	_, err := o.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(o.bucket)})
+	if err != nil {
+		return fmt.Errorf("bucket %s not accessible: %w", o.bucket, err)
+	}
+	return nil
+}
+
+// Put uploads r under key. Readers which can't seek are spooled to a temporary file first, as the
+// request is signed with the length of the body.
+func (o *ObjectStorage) Put(ctx context.Context, key string, r io.Reader) (err error) {
+	start := time.Now()
+	defer func() { o.observe("put", err, start) }()
+
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		tmp, err := os.CreateTemp("", "object-*")
+		if err != nil {
+			return err
+		}
+		defer func() { tmp.Close(); os.Remove(tmp.Name()) }()
+
+		if _, err := io.Copy(tmp, r); err != nil {
+			return fmt.Errorf("failed to spool object %s: %w", key, err)
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		body = tmp
+	}
+
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err = o.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(o.bucket),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	o.bytes.WithLabelValues(o.bucket, "upload").Add(float64(size))
+	return nil
+}
+
+// Get downloads the object, an error matching ErrObjectNotFound and ErrBackupNotFound when the key is missing.
+func (o *ObjectStorage) Get(ctx context.Context, key string) (_ io.ReadCloser, err error) {
+	start := time.Now()
+	defer func() { o.observe("get", err, start) }()
+
+	out, err := o.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(o.bucket), Key: aws.String(key)})
+	var noSuchKey *s3types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, &objectNotFoundError{key: key}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+
+	return &countingReadCloser{ReadCloser: out.Body, counter: o.bytes.WithLabelValues(o.bucket, "download")}, nil
+}
+
+// List returns the keys starting with prefix in ascending order.
+func (o *ObjectStorage) List(ctx context.Context, prefix string) (keys []string, err error) {
+	start := time.Now()
+	defer func() { o.observe("list", err, start) }()
+
+	pages := s3.NewListObjectsV2Paginator(o.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(o.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects %s: %w", prefix, err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+	return keys, nil
+}
+
+func (o *ObjectStorage) Delete(ctx context.Context, key string) (err error) {
+	start := time.Now()
+	defer func() { o.observe("delete", err, start) }()
+
+	if _, err := o.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(o.bucket), Key: aws.String(key)}); err != nil {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+	return nil
+}
+
+// PresignGet returns a URL downloading the object without credentials until ttl elapsed.
+func (o *ObjectStorage) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	req, err := o.presign.PresignGetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(o.bucket), Key: aws.String(key)},
+		s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign get of %s: %w", key, err)
+	}
+	return req.URL, nil
+}
+
+// PresignPut returns a URL uploading the object with a PUT request without credentials until ttl
+// elapsed, e.g. directly from a browser.
+func (o *ObjectStorage) PresignPut(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	req, err := o.presign.PresignPutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(o.bucket), Key: aws.String(key)},
+		s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign put of %s: %w", key, err)
+	}
+	return req.URL, nil
+}
+
+func (o *ObjectStorage) observe(operation string, err error, start time.Time) {
+	result := "success"
+	if err != nil {
+		result = "error"
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			result = apiErr.ErrorCode()
+		}
+	}
+	o.duration.WithLabelValues(o.bucket, operation, result).Observe(time.Since(start).Seconds())
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	counter prometheus.Counter
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.counter.Add(float64(n))
+	return n, err
+}
+
+type ObjectStorageOption struct {
+	cfg ObjectStorageConfig
+}
+
+func (w ObjectStorageOption) Apply(s *Service) error {
+	if w.cfg.Bucket == "" {
+		return errors.New("object storage requires a bucket")
+	}
+
+	var awsCfg aws.Config
+	if s.AWS != nil {
+		awsCfg = s.AWS.Copy()
+	} else {
+		var err error
+		if awsCfg, err = config.LoadDefaultConfig(s.ctx); err != nil {
+			return fmt.Errorf("failed to load aws config: %w", err)
+		}
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if w.cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(w.cfg.Endpoint)
+			if o.Region == "" {
+				o.Region = "us-east-1"
+			}
+		}
+		if w.cfg.Region != "" {
+			o.Region = w.cfg.Region
+		}
+		if w.cfg.AccessKeyID != "" {
+			o.Credentials = credentials.NewStaticCredentialsProvider(w.cfg.AccessKeyID, w.cfg.SecretAccessKey, "")
+		}
+		o.UsePathStyle = w.cfg.PathStyle
+	})
+
+	o := NewObjectStorage(client, w.cfg.Bucket)
+	m := newObjectStorageMetrics()
+	m.duration = registerCollector(s.registry, m.duration)
+	m.bytes = registerCollector(s.registry, m.bytes)
+	o.setMetrics(m)
+
+	// the service doesn't start before the bucket is reachable
+	if err := s.RegisterHealthCheck("object_storage_bucket", o.CheckBucket, HealthCheckOptions{Criticality: HealthStartup}); err != nil {
+		return err
+	}
+
+	s.ObjectStorage = o
+	return s.RegisterSubService(o)
+}
+
+// WithObjectStorage exposes a bucket of S3 or MinIO as Service.ObjectStorage, checked at startup and by the
+// readiness probe. It uses the config of WithAWS when applied before it.
+func WithObjectStorage(cfg ObjectStorageConfig) Option {
+	return ObjectStorageOption{cfg: cfg}
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestObjectStorageGet(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		want     string
+		notFound bool
+	}{
+		{"found", http.StatusOK, "archive", "archive", false},
+		{"missing", http.StatusNotFound, `<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			client := s3.New(s3.Options{
+				BaseEndpoint: aws.String(server.URL),
+				Region:       "us-east-1",
+				UsePathStyle: true,
+				Credentials:  aws.AnonymousCredentials{},
+			})
+			o := NewObjectStorage(client, "backups")
+
+			r, err := o.Get(context.Background(), "db/20260101T000000.000000000Z.bak")
+			if tt.notFound {
+				// a missing backup is reported the same by every BackupStore
+				if !errors.Is(err, ErrObjectNotFound) || !errors.Is(err, ErrBackupNotFound) {
+					t.Fatalf("err = %v, want %v and %v", err, ErrObjectNotFound, ErrBackupNotFound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if b, _ := io.ReadAll(r); string(b) != tt.want {
+				t.Errorf("body = %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package app
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/pprof"
@@ -13,60 +15,140 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/tracelog"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/rs/zerolog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type GRPCServerOption struct {
 	address string
+	tls     *GRPCTLSConfig
+	opts    []GRPCServerOpt
 }
 
 func (w GRPCServerOption) Apply(s *Service) error {
-	grpcSrv := grpc.NewServer()
+	// handler panics are recovered innermost so the other interceptors see the Internal status
+	unary := []grpc.UnaryServerInterceptor{
+		requestIDInterceptor, s.loggingInterceptor,
+		s.drainInterceptor, s.sloInterceptor, s.loadShedInterceptor, s.lifeboatInterceptor, s.recoveryInterceptor,
+	}
+	cfg := &grpcServerConfig{}
+	for _, opt := range w.opts {
+		opt(cfg)
+	}
+
+	if s.streamMetrics == nil {
+		s.streamMetrics = newGRPCStreamMetrics(s.registry)
+	}
+	stream := []grpc.StreamServerInterceptor{
+		requestIDStreamInterceptor, s.loggingStreamInterceptor,
+		s.drainStreamInterceptor, s.lifeboatStreamInterceptor, s.streamFlowInterceptor(cfg),
+		s.recoveryStreamInterceptor,
+	}
+
+	var opts []grpc.ServerOption
+	if w.tls != nil {
+		tlsCfg, err := s.grpcTLSConfig(*w.tls)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+		unary = append([]grpc.UnaryServerInterceptor{peerIdentityInterceptor}, unary...)
+		stream = append([]grpc.StreamServerInterceptor{peerIdentityStreamInterceptor}, stream...)
+	}
+
+	opts = append(opts, cfg.serverOptions()...)
+
+	grpcSrv := grpc.NewServer(append(opts,
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	)...)
 
 	s.GRPCServers = append(s.GRPCServers, &GRPCServer{
-		server: grpcSrv, address: w.address,
+		server: grpcSrv, address: w.address, tls: w.tls != nil,
 	})
 	return nil
 }
-func WithGRPCServer(address string) Option {
-	return GRPCServerOption{address: address}
+func WithGRPCServer(address string, opts ...GRPCServerOpt) Option {
+	return GRPCServerOption{address: address, opts: opts}
+}
+
+// WithGRPCServerTLS serves gRPC over TLS, and with ClientCAFile verifies the client certificates (mTLS).
+// Handlers get the client identity with PeerIdentityFromContext.
+func WithGRPCServerTLS(address string, cfg GRPCTLSConfig, opts ...GRPCServerOpt) Option {
+	return GRPCServerOption{address: address, tls: &cfg, opts: opts}
 }
 
 type TechHTTPServerOption struct {
 	address string
+	tls     *TLSConfig
 }
 
 func (w TechHTTPServerOption) Apply(s *Service) error {
+	var tlsCfg *tls.Config
+	if w.tls != nil {
+		var err error
+		if tlsCfg, err = s.tlsConfig(*w.tls); err != nil {
+			return err
+		}
+	}
+
 	r := chi.NewRouter()
 
 	r.Use(middleware.Recoverer)
 
-	// adding pprof routes
-	r.Mount("/debug/pprof", pprofRoutes())
-
-	// adding gometrics
-	prometheusRegistry := prometheus.NewRegistry()
-	prometheusRegistry.MustRegister(collectors.NewGoCollector())
-	prometheusRegistry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
-	NewTelemtryHandler(prometheusRegistry).Register(r)
-	NewReadinessHandler(s.isReady).Register(r)
-	NewHealthHandler(s.IsAlive).Register(r)
+	// routes are mounted once all options are applied, see Service.mountTechRoutes
+	s.techRouter = r
 
-	s.HTTPServers = append(s.HTTPServers, &http.Server{
+	s.techServer = &http.Server{
 		Addr:           w.address,
 		Handler:        r,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: http.DefaultMaxHeaderBytes,
-	})
+		TLSConfig:      tlsCfg,
+	}
+	s.HTTPServers = append(s.HTTPServers, s.techServer)
 
 	return nil
 }
 
-func pprofRoutes() http.Handler {
+func (s *Service) mountTechRoutes(r chi.Router) {
+	// adding pprof routes, unless disabled or served by the debug server
+	if !s.pprofCfg.Disabled && s.debugServer == nil {
+		s.mountPprof(r)
+	}
+
+	if !s.metricsCfg.Disabled {
+		NewTelemtryHandler(s.registry).WithConfig(s.metricsCfg).Register(r)
+	}
+	NewStartupHandler(s.isStarted).Register(r)
+	NewReadinessHandler(s.isStarted, s.isServing).WithReport(s.ReadinessReport).Register(r)
+	NewHealthHandler(s.IsAlive).Register(r)
+	s.registerDrainStatus(r)
+	s.registerInfo(r)
+
+	if s.Backups != nil && len(s.Backups.cfg.AdminTokens) > 0 {
+		r.Mount("/admin/backups", s.Backups.routes())
+	}
+	if s.Quotas != nil && len(s.Quotas.cfg.AdminTokens) > 0 {
+		r.Mount("/admin/quotas", s.Quotas.routes())
+	}
+	if s.Lifeboat != nil && len(s.Lifeboat.tokens) > 0 {
+		r.Mount("/admin/lifeboat", s.Lifeboat.routes())
+	}
+	if s.journal != nil && len(s.journal.cfg.AdminTokens) > 0 {
+		r.Mount("/admin/errors", s.journal.routes())
+	}
+	if s.Plugins != nil && len(s.Plugins.cfg.AdminTokens) > 0 {
+		r.Mount("/admin/commands", s.Plugins.routes())
+	}
+	if s.adminUI != nil {
+		r.Mount("/admin/ui", s.adminUI.routes())
+	}
+}
+
+func (s *Service) pprofRoutes() http.Handler {
 	router := chi.NewRouter()
 	router.HandleFunc("/", pprof.Index)
 	router.HandleFunc("/cmdline", pprof.Cmdline)
@@ -80,7 +162,7 @@ func pprofRoutes() http.Handler {
 	router.Handle("/heap", pprof.Handler("heap"))
 	router.Handle("/threadcreate", pprof.Handler("threadcreate"))
 	router.Handle("/block", pprof.Handler("block"))
-	router.Handle("/vars", http.DefaultServeMux) // this might be tricky, test thoroughly
+	router.Handle("/vars", s.varsHandler())
 
 	return router
 }
@@ -89,14 +171,36 @@ func WithTechHTTPServerOption(address string) Option {
 	return TechHTTPServerOption{address: address}
 }
 
+// WithTechHTTPSServer serves the tech server over TLS, see TLSConfig.
+func WithTechHTTPSServer(address string, cfg TLSConfig) Option {
+	return TechHTTPServerOption{address: address, tls: &cfg}
+}
+
 type DBOption struct {
 	cfg pgxpool.Config
 }
 
 func (w DBOption) Apply(s *Service) error {
-	poolConfig, err := pgxpool.ParseConfig(w.cfg.ConnString())
+	p, err := newDBPool(w.cfg)
 	if err != nil {
-		return err
+		return fmt.Errorf("db %s: %w", DefaultDBName, err)
+	}
+
+	return s.addDB(DefaultDBName, p)
+}
+
+// newDBPool creates a traced pool, configure adjusts the config parsed from the connection string.
+func newDBPool(cfg pgxpool.Config, configure ...func(*pgxpool.Config)) (*pgxpool.Pool, error) {
+	// a zero config panics, one parsed from "" connects to the libpq defaults, e.g. localhost:5432
+	if cfg.ConnConfig == nil || cfg.ConnString() == "" {
+		return nil, errors.New("connection string missing, build the config with pgxpool.ParseConfig(dsn)")
+	}
+	poolConfig, err := pgxpool.ParseConfig(cfg.ConnString())
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range configure {
+		fn(poolConfig)
 	}
 
 	l := &zerolog.Logger{}
@@ -109,6 +213,8 @@ func (w DBOption) Apply(s *Service) error {
 				Logger:   NewLogger(l),
 				LogLevel: tracelog.LogLevelTrace,
 			},
+
+			&budgetTracer{},
 		},
 	}
 
@@ -116,21 +222,43 @@ func (w DBOption) Apply(s *Service) error {
 
 	p, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := otelpgx.RecordStats(p); err != nil {
-		return fmt.Errorf("unable to record database stats: %w", err)
+		return nil, fmt.Errorf("unable to record database stats: %w", err)
 	}
 
-	s.DB = p
-	return nil
+	return p, nil
 }
 
 func WithDB(cfg pgxpool.Config) Option {
 	return DBOption{cfg: cfg}
 }
 
+type NamedDBOption struct {
+	name string
+	cfg  pgxpool.Config
+}
+
+func (w NamedDBOption) Apply(s *Service) error {
+	p, err := newDBPool(w.cfg)
+	if err != nil {
+		return fmt.Errorf("db %s: %w", w.name, err)
+	}
+
+	if err := s.addDB(w.name, p); err != nil {
+		p.Close()
+		return err
+	}
+	return nil
+}
+
+// WithNamedDB registers an additional pool reachable through Service.NamedDB(name).
+func WithNamedDB(name string, cfg pgxpool.Config) Option {
+	return NamedDBOption{name: name, cfg: cfg}
+}
+
 type RedisOption struct {
 }
 
@@ -0,0 +1,243 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	otelLogsScope           = "github.com/jetbrainer/app"
+	otelLogsShutdownTimeout = 5 * time.Second
+
+	traceIDFieldName = "trace_id"
+	spanIDFieldName  = "span_id"
+)
+
+// TraceContextHook adds the trace and span ids of the span in the event context, set with
+// log.Info().Ctx(ctx), so log lines can be correlated with traces.
+type TraceContextHook struct{}
+
+func (TraceContextHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	spanCtx := trace.SpanContextFromContext(e.GetCtx())
+	if !spanCtx.IsValid() {
+		return
+	}
+
+	e.Str(traceIDFieldName, spanCtx.TraceID().String()).Str(spanIDFieldName, spanCtx.SpanID().String())
+}
+
+// OTelLogWriter turns zerolog JSON lines into OTel log records: the message becomes the body,
+// the other fields attributes, and the trace_id and span_id fields the record trace context.
+type OTelLogWriter struct {
+	logger otellog.Logger
+}
+
+func NewOTelLogWriter(logger otellog.Logger) *OTelLogWriter {
+	return &OTelLogWriter{logger: logger}
+}
+
+func (w *OTelLogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *OTelLogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	fields := make(map[string]any)
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return 0, fmt.Errorf("failed to decode log line: %w", err)
+	}
+
+	var record otellog.Record
+	record.SetObservedTimestamp(time.Now())
+	record.SetSeverity(otelSeverity(level))
+	if level != zerolog.NoLevel {
+		record.SetSeverityText(level.String())
+	}
+	delete(fields, zerolog.LevelFieldName)
+
+	if ts, ok := fields[zerolog.TimestampFieldName].(string); ok {
+		if t, err := time.Parse(zerolog.TimeFieldFormat, ts); err == nil {
+			record.SetTimestamp(t)
+			delete(fields, zerolog.TimestampFieldName)
+		}
+	}
+
+	if msg, ok := fields[zerolog.MessageFieldName].(string); ok {
+		record.SetBody(otellog.StringValue(msg))
+		delete(fields, zerolog.MessageFieldName)
+	}
+
+	ctx := context.Background()
+	traceID, _ := fields[traceIDFieldName].(string)
+	spanID, _ := fields[spanIDFieldName].(string)
+	if spanCtx, ok := spanContext(traceID, spanID); ok {
+		ctx = trace.ContextWithSpanContext(ctx, spanCtx)
+		delete(fields, traceIDFieldName)
+		delete(fields, spanIDFieldName)
+	}
+
+	attrs := make([]otellog.KeyValue, 0, len(fields))
+	for key, value := range fields {
+		attrs = append(attrs, otellog.KeyValue{Key: key, Value: otelLogValue(value)})
+	}
+	record.AddAttributes(attrs...)
+
+	w.logger.Emit(ctx, record)
+	return len(p), nil
+}
+
+func spanContext(traceID, spanID string) (trace.SpanContext, bool) {
+	tid, err := trace.TraceIDFromHex(traceID)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+	sid, err := trace.SpanIDFromHex(spanID)
+	if err != nil {
+		return trace.SpanContext{}, false
+	}
+
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid}), true
+}
+
+func otelSeverity(level zerolog.Level) otellog.Severity {
+	switch level {
+	case zerolog.TraceLevel:
+		return otellog.SeverityTrace
+	case zerolog.DebugLevel:
+		return otellog.SeverityDebug
+	case zerolog.InfoLevel:
+		return otellog.SeverityInfo
+	case zerolog.WarnLevel:
+		return otellog.SeverityWarn
+	case zerolog.ErrorLevel:
+		return otellog.SeverityError
+	case zerolog.FatalLevel:
+		return otellog.SeverityFatal
+	case zerolog.PanicLevel:
+		return otellog.SeverityFatal4
+	default:
+		return otellog.SeverityUndefined
+	}
+}
+
+func otelLogValue(v any) otellog.Value {
+	switch v := v.(type) {
+	case string:
+		return otellog.StringValue(v)
+	case bool:
+		return otellog.BoolValue(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return otellog.Int64Value(i)
+		}
+		f, _ := v.Float64()
+		return otellog.Float64Value(f)
+	case []any:
+		values := make([]otellog.Value, 0, len(v))
+		for _, item := range v {
+			values = append(values, otelLogValue(item))
+		}
+		return otellog.SliceValue(values...)
+	case map[string]any:
+		kvs := make([]otellog.KeyValue, 0, len(v))
+		for key, item := range v {
+			kvs = append(kvs, otellog.KeyValue{Key: key, Value: otelLogValue(item)})
+		}
+		return otellog.MapValue(kvs...)
+	default:
+		return otellog.Value{}
+	}
+}
+
+type OTelLogsConfig struct {
+	// Endpoint of the OTLP gRPC receiver, empty means OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317.
+	Endpoint string
+	Insecure bool
+	Headers  map[string]string
+	// Exporter replaces the OTLP gRPC exporter, e.g. with otlploghttp.
+	Exporter sdklog.Exporter
+	// Tee keeps writing the JSON lines to a writer, e.g. os.Stderr, by default logs are only exported.
+	Tee io.Writer
+}
+
+type OTelLogsOption struct {
+	cfg OTelLogsConfig
+}
+
+func (w OTelLogsOption) Apply(s *Service) error {
+	exporter := w.cfg.Exporter
+	if exporter == nil {
+		var opts []otlploggrpc.Option
+		if w.cfg.Endpoint != "" {
+			opts = append(opts, otlploggrpc.WithEndpoint(w.cfg.Endpoint))
+		}
+		if w.cfg.Insecure {
+			opts = append(opts, otlploggrpc.WithInsecure())
+		}
+		if len(w.cfg.Headers) > 0 {
+			opts = append(opts, otlploggrpc.WithHeaders(w.cfg.Headers))
+		}
+
+		var err error
+		if exporter, err = otlploggrpc.New(s.ctx, opts...); err != nil {
+			return fmt.Errorf("failed to create otlp log exporter: %w", err)
+		}
+	}
+
+	attrs := []attribute.KeyValue{attribute.String("service.name", s.Name)}
+	if version := s.Version(); version != "" {
+		attrs = append(attrs, attribute.String("service.version", version))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attrs...))
+	if err != nil {
+		return fmt.Errorf("failed to build otel resource: %w", err)
+	}
+
+	provider := sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)),
+		sdklog.WithResource(res),
+	)
+
+	var out io.Writer = NewOTelLogWriter(provider.Logger(otelLogsScope))
+	if w.cfg.Tee != nil {
+		out = zerolog.MultiLevelWriter(w.cfg.Tee, out)
+	}
+	log.Logger = log.Logger.Output(out).Hook(TraceContextHook{})
+
+	s.otelLogs = provider
+	return nil
+}
+
+// WithOTelLogs exports the global zerolog logger as OTel log records over OTLP, correlated with
+// the trace of events logged with .Ctx(ctx). Pending records are flushed at the end of Stop.
+func WithOTelLogs(cfg OTelLogsConfig) Option {
+	return OTelLogsOption{cfg: cfg}
+}
+
+// flushLogs exports the buffered log records, it runs last so the shutdown is logged.
+func (s *Service) flushLogs() {
+	if s.otelLogs == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), otelLogsShutdownTimeout)
+	defer cancel()
+
+	if err := s.otelLogs.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("failed to flush otel logs")
+	}
+}
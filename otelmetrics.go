@@ -0,0 +1,345 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	otelMetricsScope          = "github.com/jetbrainer/app"
+	defaultOTelMetricsAddress = "localhost:4317"
+	defaultOTelMetricsPeriod  = 30 * time.Second
+	defaultOTelMetricsTimeout = 10 * time.Second
+)
+
+type OTelMetricsConfig struct {
+	// Endpoint of the OTLP gRPC receiver, empty means OTEL_EXPORTER_OTLP_METRICS_ENDPOINT,
+	// OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317.
+	Endpoint string
+	Insecure bool
+	Headers  map[string]string
+	// Interval between exports, zero means 30s.
+	Interval time.Duration
+	// Timeout bounds an export, zero means 10s.
+	Timeout time.Duration
+}
+
+// OTelMetricsExporter periodically gathers the metrics registered on a prometheus registry and exports
+// them over OTLP, so instruments are declared once with the prometheus API whatever the backend is.
+// Counters become monotonic sums, gauges gauges, histograms explicit bucket histograms and summaries
+// summaries, all with cumulative temporality.
+type OTelMetricsExporter struct {
+	cfg      OTelMetricsConfig
+	gatherer prometheus.Gatherer
+	conn     *grpc.ClientConn
+	client   colmetricspb.MetricsServiceClient
+	resource *resourcepb.Resource
+	start    time.Time
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewOTelMetricsExporter(cfg OTelMetricsConfig, gatherer prometheus.Gatherer, res *resource.Resource) (*OTelMetricsExporter, error) {
+	if cfg.Endpoint == "" {
+		cfg.Endpoint, cfg.Insecure = otelMetricsEndpointFromEnv(cfg.Insecure)
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultOTelMetricsPeriod
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultOTelMetricsTimeout
+	}
+
+	creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	if cfg.Insecure {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.NewClient(cfg.Endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp metrics client for %s: %w", cfg.Endpoint, err)
+	}
+
+	return &OTelMetricsExporter{
+		cfg:      cfg,
+		gatherer: gatherer,
+		conn:     conn,
+		client:   colmetricspb.NewMetricsServiceClient(conn),
+		resource: &resourcepb.Resource{Attributes: otlpAttributes(res.Attributes())},
+		start:    time.Now(),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// otelMetricsEndpointFromEnv reads the endpoint URL of the environment, its http scheme meaning insecure.
+func otelMetricsEndpointFromEnv(insecure bool) (string, bool) {
+	for _, env := range []string{"OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		if u, err := url.Parse(value); err == nil && u.Host != "" {
+			return u.Host, insecure || u.Scheme == "http"
+		}
+		return value, insecure
+	}
+	return defaultOTelMetricsAddress, insecure
+}
+
+func (e *OTelMetricsExporter) Name() string {
+	return "otel-metrics"
+}
+
+func (e *OTelMetricsExporter) Ready() bool {
+	return true
+}
+
+func (e *OTelMetricsExporter) Run(ctx context.Context) error {
+	e.mu.Lock()
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.running.Store(true)
+	e.mu.Unlock()
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := e.Export(ctx); err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Msg("failed to export otel metrics")
+			}
+		}
+	}
+}
+
+// Close exports the metrics one last time and closes the connection.
+func (e *OTelMetricsExporter) Close() error {
+	e.mu.Lock()
+	if e.cancel != nil {
+		e.cancel()
+	}
+	e.mu.Unlock()
+
+	if e.running.Load() {
+		<-e.done
+	}
+
+	err := e.Export(context.Background())
+	if closeErr := e.conn.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Export gathers and sends all metrics once.
+func (e *OTelMetricsExporter) Export(ctx context.Context) error {
+	mfs, err := e.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+
+	now := uint64(time.Now().UnixNano())
+	metrics := make([]*metricspb.Metric, 0, len(mfs))
+	for _, mf := range mfs {
+		if metric := e.convert(mf, now); metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+	if len(e.cfg.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(e.cfg.Headers))
+	}
+
+	resp, err := e.client.Export(ctx, &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: e.resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: otelMetricsScope},
+				Metrics: metrics,
+			}},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export metrics: %w", err)
+	}
+	if rejected := resp.GetPartialSuccess().GetRejectedDataPoints(); rejected > 0 {
+		log.Warn().Int64("rejected", rejected).Str("reason", resp.GetPartialSuccess().GetErrorMessage()).
+			Msg("otel metrics partially rejected")
+	}
+
+	return nil
+}
+
+func (e *OTelMetricsExporter) convert(mf *dto.MetricFamily, now uint64) *metricspb.Metric {
+	metric := &metricspb.Metric{Name: mf.GetName(), Description: mf.GetHelp()}
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		sum := &metricspb.Sum{AggregationTemporality: cumulative, IsMonotonic: true}
+		for _, m := range mf.GetMetric() {
+			sum.DataPoints = append(sum.DataPoints, &metricspb.NumberDataPoint{
+				Attributes:        otlpLabels(m.GetLabel()),
+				StartTimeUnixNano: e.startTime(m.GetCounter().GetCreatedTimestamp().AsTime()),
+				TimeUnixNano:      now,
+				Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: m.GetCounter().GetValue()},
+			})
+		}
+		metric.Data = &metricspb.Metric_Sum{Sum: sum}
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		gauge := &metricspb.Gauge{}
+		for _, m := range mf.GetMetric() {
+			value := m.GetGauge().GetValue()
+			if mf.GetType() == dto.MetricType_UNTYPED {
+				value = m.GetUntyped().GetValue()
+			}
+			gauge.DataPoints = append(gauge.DataPoints, &metricspb.NumberDataPoint{
+				Attributes:   otlpLabels(m.GetLabel()),
+				TimeUnixNano: now,
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+			})
+		}
+		metric.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+	case dto.MetricType_HISTOGRAM:
+		histogram := &metricspb.Histogram{AggregationTemporality: cumulative}
+		for _, m := range mf.GetMetric() {
+			h := m.GetHistogram()
+			point := &metricspb.HistogramDataPoint{
+				Attributes:        otlpLabels(m.GetLabel()),
+				StartTimeUnixNano: e.startTime(h.GetCreatedTimestamp().AsTime()),
+				TimeUnixNano:      now,
+				Count:             h.GetSampleCount(),
+				Sum:               proto64(h.GetSampleSum()),
+			}
+			// prometheus buckets are cumulative, OTLP ones are not and the +Inf bucket is implicit
+			var previous uint64
+			for _, b := range h.GetBucket() {
+				if math.IsInf(b.GetUpperBound(), 1) {
+					continue
+				}
+				point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+				point.BucketCounts = append(point.BucketCounts, b.GetCumulativeCount()-previous)
+				previous = b.GetCumulativeCount()
+			}
+			point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-previous)
+			histogram.DataPoints = append(histogram.DataPoints, point)
+		}
+		metric.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+	case dto.MetricType_SUMMARY:
+		summary := &metricspb.Summary{}
+		for _, m := range mf.GetMetric() {
+			s := m.GetSummary()
+			point := &metricspb.SummaryDataPoint{
+				Attributes:        otlpLabels(m.GetLabel()),
+				StartTimeUnixNano: e.startTime(s.GetCreatedTimestamp().AsTime()),
+				TimeUnixNano:      now,
+				Count:             s.GetSampleCount(),
+				Sum:               s.GetSampleSum(),
+			}
+			for _, q := range s.GetQuantile() {
+				point.QuantileValues = append(point.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+					Quantile: q.GetQuantile(),
+					Value:    q.GetValue(),
+				})
+			}
+			summary.DataPoints = append(summary.DataPoints, point)
+		}
+		metric.Data = &metricspb.Metric_Summary{Summary: summary}
+	default:
+		return nil
+	}
+
+	return metric
+}
+
+// startTime is the creation time of the series when the collector exposes it, else the exporter start.
+func (e *OTelMetricsExporter) startTime(created time.Time) uint64 {
+	if created.Unix() <= 0 {
+		created = e.start
+	}
+	return uint64(created.UnixNano())
+}
+
+func proto64(v float64) *float64 {
+	return &v
+}
+
+func otlpLabels(labels []*dto.LabelPair) []*commonpb.KeyValue {
+	kvs := make([]*commonpb.KeyValue, 0, len(labels))
+	for _, l := range labels {
+		kvs = append(kvs, &commonpb.KeyValue{
+			Key:   l.GetName(),
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: l.GetValue()}},
+		})
+	}
+	return kvs
+}
+
+func otlpAttributes(attrs []attribute.KeyValue) []*commonpb.KeyValue {
+	kvs := make([]*commonpb.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, &commonpb.KeyValue{
+			Key:   string(a.Key),
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: a.Value.Emit()}},
+		})
+	}
+	return kvs
+}
+
+type OTelMetricsOption struct {
+	cfg OTelMetricsConfig
+}
+
+func (w OTelMetricsOption) Apply(s *Service) error {
+	attrs := []attribute.KeyValue{attribute.String("service.name", s.Name)}
+	if version := s.Version(); version != "" {
+		attrs = append(attrs, attribute.String("service.version", version))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attrs...))
+	if err != nil {
+		return fmt.Errorf("failed to build otel resource: %w", err)
+	}
+
+	e, err := NewOTelMetricsExporter(w.cfg, s.registry, res)
+	if err != nil {
+		return err
+	}
+
+	return s.RegisterSubService(e)
+}
+
+// WithOTelMetrics exports every metric registered on Service.Registry() over OTLP, alongside the /metrics
+// endpoint or instead of it with MetricsConfig.Disabled.
+func WithOTelMetrics(cfg OTelMetricsConfig) Option {
+	return OTelMetricsOption{cfg: cfg}
+}
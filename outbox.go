@@ -0,0 +1,451 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	defaultOutboxInterval  = time.Second
+	defaultOutboxBatchSize = 100
+	defaultOutboxAttempts  = 5
+)
+
+// OutboxSchema creates the table read by the outbox relay.
+const OutboxSchema = `
+CREATE TABLE IF NOT EXISTS app_outbox (
+	id           BIGSERIAL   PRIMARY KEY,
+	topic        TEXT        NOT NULL,
+	key          BYTEA,
+	payload      BYTEA       NOT NULL,
+	headers      JSONB,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	processed_at TIMESTAMPTZ
+);
+
+ALTER TABLE app_outbox ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE app_outbox ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE app_outbox ADD COLUMN IF NOT EXISTS failed_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS app_outbox_pending ON app_outbox (id) WHERE processed_at IS NULL;`
+
+type OutboxEvent struct {
+	ID        int64
+	Topic     string
+	Key       []byte
+	Payload   []byte
+	Headers   map[string]string
+	CreatedAt time.Time
+}
+
+// OutboxSink publishes relayed events, it must return an error unless every event was published.
+type OutboxSink interface {
+	Publish(ctx context.Context, events []OutboxEvent) error
+}
+
+type OutboxSinkFunc func(ctx context.Context, events []OutboxEvent) error
+
+func (f OutboxSinkFunc) Publish(ctx context.Context, events []OutboxEvent) error {
+	return f(ctx, events)
+}
+
+// KafkaOutboxSink publishes events to the Kafka topic named by OutboxEvent.Topic.
+func KafkaOutboxSink(producer *KafkaProducer) OutboxSink {
+	return OutboxSinkFunc(func(ctx context.Context, events []OutboxEvent) error {
+		records := make([]*kgo.Record, 0, len(events))
+		for _, event := range events {
+			record := &kgo.Record{Topic: event.Topic, Key: event.Key, Value: event.Payload}
+			for k, v := range event.Headers {
+				record.Headers = append(record.Headers, kgo.RecordHeader{Key: k, Value: []byte(v)})
+			}
+			records = append(records, record)
+		}
+
+		return producer.Produce(ctx, records...)
+	})
+}
+
+// NATSOutboxSink publishes events through JetStream to the subject named by OutboxEvent.Topic,
+// the outbox ID is used as message ID for JetStream deduplication.
+func NATSOutboxSink(js jetstream.JetStream) OutboxSink {
+	return OutboxSinkFunc(func(ctx context.Context, events []OutboxEvent) error {
+		for _, event := range events {
+			msg := nats.NewMsg(event.Topic)
+			msg.Data = event.Payload
+			for k, v := range event.Headers {
+				msg.Header.Set(k, v)
+			}
+
+			if _, err := js.PublishMsg(ctx, msg, jetstream.WithMsgID(strconv.FormatInt(event.ID, 10))); err != nil {
+				return fmt.Errorf("failed to publish outbox event %d: %w", event.ID, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+type OutboxConfig struct {
+	Sink OutboxSink
+	// DB is the name of the pool holding the outbox table, empty means the default pool.
+	DB string
+	// Interval between polls when the outbox is empty, zero means defaultOutboxInterval.
+	Interval time.Duration
+	// BatchSize bounds the events published at once, zero means defaultOutboxBatchSize.
+	BatchSize int
+	// MaxAttempts bounds the relays of an event failing to decrypt or decompress, after which it is
+	// marked failed and left out, zero means defaultOutboxAttempts.
+	MaxAttempts int
+}
+
+// Outbox relays events written in business transactions to a sink. Rows are locked with
+// SKIP LOCKED so several replicas can relay concurrently, at the cost of ordering between batches,
+// unless only the leader relays, see SetLeaderCheck.
+type Outbox struct {
+	cfg        OutboxConfig
+	db         *pgxpool.Pool
+	encrypter  *PayloadEncrypter
+	compressor *PayloadCompressor
+
+	pending   prometheus.Gauge
+	oldest    prometheus.Gauge
+	published prometheus.Counter
+	failures  prometheus.Counter
+	failed    prometheus.Counter
+	paused    atomic.Bool
+	isLeader  atomic.Pointer[func() bool]
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewOutbox(db *pgxpool.Pool, cfg OutboxConfig) *Outbox {
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultOutboxInterval
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = defaultOutboxBatchSize
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = defaultOutboxAttempts
+	}
+
+	return &Outbox{
+		cfg: cfg,
+		db:  db,
+		pending: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "outbox_pending_events",
+			Help: "Number of outbox events not published yet.",
+		}),
+		oldest: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "outbox_oldest_pending_age_seconds",
+			Help: "Age of the oldest outbox event not published yet.",
+		}),
+		published: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "outbox_published_total",
+			Help: "Number of outbox events published.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "outbox_publish_failures_total",
+			Help: "Number of failed outbox batches.",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "outbox_failed_events_total",
+			Help: "Number of outbox events marked failed after failing to decrypt or decompress MaxAttempts times.",
+		}),
+		done: make(chan struct{}),
+	}
+}
+
+func (o *Outbox) EnsureSchema(ctx context.Context) error {
+	if _, err := o.db.Exec(ctx, OutboxSchema); err != nil {
+		return fmt.Errorf("failed to create outbox table: %w", err)
+	}
+	return nil
+}
+
+// Enqueue writes the event with tx, so it is published only if the business transaction commits.
+func (o *Outbox) Enqueue(ctx context.Context, tx DBTX, event OutboxEvent) error {
+	var err error
+	payload := event.Payload
+	if o.compressor != nil {
+		if payload, err = o.compressor.Compress(payload); err != nil {
+			return fmt.Errorf("failed to compress outbox payload: %w", err)
+		}
+	}
+	if o.encrypter != nil {
+		if payload, err = o.encrypter.Encrypt(ctx, payload, []byte(event.Topic)); err != nil {
+			return fmt.Errorf("failed to encrypt outbox payload: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO app_outbox (topic, key, payload, headers) VALUES ($1, $2, $3, $4)`,
+		event.Topic, event.Key, payload, event.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to write outbox event: %w", err)
+	}
+	return nil
+}
+
+func (o *Outbox) Name() string {
+	return "outbox"
+}
+
+func (o *Outbox) Ready() bool {
+	return !o.paused.Load()
+}
+
+// Pause stops relaying after the current batch, events keep being enqueued.
+func (o *Outbox) Pause() {
+	o.paused.Store(true)
+}
+
+func (o *Outbox) Resume() {
+	o.paused.Store(false)
+}
+
+func (o *Outbox) Paused() bool {
+	return o.paused.Load()
+}
+
+// SetLeaderCheck makes the relay run on the leader only, e.g. to keep events ordered.
+func (o *Outbox) SetLeaderCheck(isLeader func() bool) {
+	o.isLeader.Store(&isLeader)
+}
+
+// idle reports whether the relay must not run, being paused or not leading.
+func (o *Outbox) idle() bool {
+	if o.paused.Load() {
+		return true
+	}
+	isLeader := o.isLeader.Load()
+	return isLeader != nil && !(*isLeader)()
+}
+
+func (o *Outbox) Run(ctx context.Context) error {
+	o.mu.Lock()
+	ctx, o.cancel = context.WithCancel(ctx)
+	o.running.Store(true)
+	o.mu.Unlock()
+	defer close(o.done)
+
+	for {
+		if o.idle() {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(o.cfg.Interval):
+			}
+			continue
+		}
+
+		relayed, err := o.relay(ctx)
+		if err != nil && ctx.Err() == nil {
+			o.failures.Inc()
+			log.Error().Err(err).Msg("failed to relay outbox events")
+		}
+		o.updateLag(ctx)
+
+		// a full batch means more events are likely waiting
+		if relayed == o.cfg.BatchSize && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(o.cfg.Interval):
+		}
+	}
+}
+
+// relay publishes one batch and marks it processed within a single transaction.
+func (o *Outbox) relay(ctx context.Context) (int, error) {
+	tx, err := o.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	rows, err := tx.Query(ctx,
+		`SELECT id, topic, key, payload, headers, created_at, attempts FROM app_outbox
+		WHERE processed_at IS NULL AND failed_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`,
+		o.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	stored, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (outboxRow, error) {
+		var r outboxRow
+		err := row.Scan(&r.ID, &r.Topic, &r.Key, &r.Payload, &r.Headers, &r.CreatedAt, &r.attempts)
+		return r, err
+	})
+	if err != nil || len(stored) == 0 {
+		return 0, err
+	}
+
+	events, err := o.decode(ctx, tx, stored)
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]int64, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+
+	if len(events) > 0 {
+		if err := o.cfg.Sink.Publish(ctx, events); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE app_outbox SET processed_at = now() WHERE id = ANY($1)`, ids); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	o.published.Add(float64(len(events)))
+	return len(events), nil
+}
+
+// outboxRow is an event as stored, its payload still encrypted and compressed.
+type outboxRow struct {
+	OutboxEvent
+	attempts int
+}
+
+// decode decrypts and decompresses the payloads of rows. A row failing to is left out of the batch
+// rather than stalling the relay: its attempt is recorded with tx and, after MaxAttempts, it is marked
+// failed for good.
+func (o *Outbox) decode(ctx context.Context, tx DBTX, rows []outboxRow) ([]OutboxEvent, error) {
+	events := make([]OutboxEvent, 0, len(rows))
+	for _, row := range rows {
+		event, err := o.decodeRow(ctx, row.OutboxEvent)
+		if err == nil {
+			events = append(events, event)
+			continue
+		}
+
+		failed := row.attempts+1 >= o.cfg.MaxAttempts
+		_, execErr := tx.Exec(ctx,
+			`UPDATE app_outbox SET attempts = attempts + 1, last_error = $2,
+			failed_at = CASE WHEN $3 THEN now() END WHERE id = $1`,
+			row.ID, err.Error(), failed)
+		if execErr != nil {
+			return nil, fmt.Errorf("failed to record the outbox event %d failure: %w", row.ID, execErr)
+		}
+
+		if failed {
+			o.failed.Inc()
+			log.Error().Err(err).Int64("event", row.ID).Int("attempts", row.attempts+1).Msg("outbox event marked failed")
+		} else {
+			log.Warn().Err(err).Int64("event", row.ID).Int("attempts", row.attempts+1).Msg("outbox event skipped")
+		}
+	}
+	return events, nil
+}
+
+func (o *Outbox) decodeRow(ctx context.Context, event OutboxEvent) (OutboxEvent, error) {
+	var err error
+	if o.encrypter != nil {
+		if event.Payload, err = o.encrypter.Decrypt(ctx, event.Payload, []byte(event.Topic)); err != nil {
+			return event, fmt.Errorf("failed to decrypt outbox event %d: %w", event.ID, err)
+		}
+	}
+	// compression may have been turned off since the event was enqueued
+	if event.Payload, err = decompressPayload(o.compressor, event.Payload); err != nil {
+		return event, fmt.Errorf("failed to decompress outbox event %d: %w", event.ID, err)
+	}
+	return event, nil
+}
+
+func (o *Outbox) updateLag(ctx context.Context) {
+	var (
+		pending int64
+		oldest  *time.Time
+	)
+	err := o.db.QueryRow(ctx,
+		`SELECT count(*), min(created_at) FROM app_outbox WHERE processed_at IS NULL AND failed_at IS NULL`).Scan(&pending, &oldest)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			log.Debug().Err(err).Msg("failed to measure outbox lag")
+		}
+		return
+	}
+
+	o.pending.Set(float64(pending))
+	if oldest != nil {
+		o.oldest.Set(time.Since(*oldest).Seconds())
+	} else {
+		o.oldest.Set(0)
+	}
+}
+
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	if o.cancel != nil {
+		o.cancel()
+	}
+	o.mu.Unlock()
+
+	if o.running.Load() {
+		<-o.done
+	}
+	return nil
+}
+
+type OutboxOption struct {
+	cfg OutboxConfig
+}
+
+func (w OutboxOption) Apply(s *Service) error {
+	if w.cfg.Sink == nil {
+		return errors.New("outbox requires a sink")
+	}
+
+	name := w.cfg.DB
+	if name == "" {
+		name = DefaultDBName
+	}
+	db, err := s.NamedDB(name)
+	if err != nil {
+		return fmt.Errorf("outbox: %w", err)
+	}
+
+	o := NewOutbox(db, w.cfg)
+	o.encrypter = s.Encrypter
+	o.compressor = s.Compressor
+	o.pending = registerCollector(s.registry, o.pending)
+	o.oldest = registerCollector(s.registry, o.oldest)
+	o.published = registerCollector(s.registry, o.published)
+	o.failures = registerCollector(s.registry, o.failures)
+	o.failed = registerCollector(s.registry, o.failed)
+
+	s.Outbox = o
+	return s.RegisterSubService(o)
+}
+
+// WithOutbox relays events enqueued with Service.Outbox.Enqueue to the sink. The DB option and,
+// for encrypted or compressed payloads, WithPayloadEncryption and WithPayloadCompression must be
+// applied before it.
+func WithOutbox(cfg OutboxConfig) Option {
+	return OutboxOption{cfg: cfg}
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOutboxDecode(t *testing.T) {
+	// the compression magic followed by no valid frame fails to decompress
+	corrupt := append(append([]byte{}, compressionMagic...), "garbage"...)
+
+	tests := []struct {
+		name       string
+		rows       []outboxRow
+		err        error
+		wantEvents []int64
+		// wantFailed are the failed flags of the attempts recorded, in row order
+		wantFailed []bool
+		wantErr    bool
+	}{
+		{"valid", []outboxRow{{OutboxEvent: OutboxEvent{ID: 1, Payload: []byte("a")}}}, nil, []int64{1}, nil, false},
+		{"corrupt retried", []outboxRow{{OutboxEvent: OutboxEvent{ID: 1, Payload: corrupt}}}, nil, nil, []bool{false}, false},
+		{"corrupt marked failed", []outboxRow{{OutboxEvent: OutboxEvent{ID: 1, Payload: corrupt}, attempts: 4}}, nil, nil, []bool{true}, false},
+		{"corrupt among valid", []outboxRow{
+			{OutboxEvent: OutboxEvent{ID: 1, Payload: []byte("a")}},
+			{OutboxEvent: OutboxEvent{ID: 2, Payload: corrupt}, attempts: 2},
+			{OutboxEvent: OutboxEvent{ID: 3, Payload: []byte("c")}},
+		}, nil, []int64{1, 3}, []bool{false}, false},
+		{"attempt not recorded", []outboxRow{{OutboxEvent: OutboxEvent{ID: 1, Payload: corrupt}}}, errors.New("down"), nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := NewOutbox(nil, OutboxConfig{MaxAttempts: 5})
+			db := &execDB{err: tt.err}
+
+			events, err := o.decode(context.Background(), db, tt.rows)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var ids []int64
+			for _, event := range events {
+				ids = append(ids, event.ID)
+			}
+			if !slices.Equal(ids, tt.wantEvents) {
+				t.Errorf("events = %v, want %v", ids, tt.wantEvents)
+			}
+
+			if len(db.args) != len(tt.wantFailed) {
+				t.Fatalf("recorded %d attempts, want %d", len(db.args), len(tt.wantFailed))
+			}
+			failed := 0
+			for i, args := range db.args {
+				if args[2] != tt.wantFailed[i] {
+					t.Errorf("attempt %d marked failed = %v, want %v", i, args[2], tt.wantFailed[i])
+				}
+				if tt.wantFailed[i] {
+					failed++
+				}
+			}
+			if got := testutil.ToFloat64(o.failed); got != float64(failed) {
+				t.Errorf("failed events = %v, want %d", got, failed)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page is the response envelope of paginated list endpoints. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// PageRequest is the position and size of the requested page, Cursor is empty for the first one.
+type PageRequest struct {
+	Cursor string
+	Limit  int
+}
+
+// PageRequestFromHTTP reads the cursor and limit query parameters. The limit defaults to DefaultPageLimit
+// and is capped at MaxPageLimit.
+func PageRequestFromHTTP(r *http.Request) (PageRequest, error) {
+	req := PageRequest{Cursor: r.URL.Query().Get("cursor")}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return PageRequest{}, fmt.Errorf("invalid limit %q", limit)
+		}
+		req.Limit = n
+	}
+
+	return req, nil
+}
+
+func (r PageRequest) limit() int {
+	switch {
+	case r.Limit <= 0:
+		return DefaultPageLimit
+	case r.Limit > MaxPageLimit:
+		return MaxPageLimit
+	default:
+		return r.Limit
+	}
+}
+
+// EncodeCursor encodes the keyset position, the ordering columns of the last item of a page, into an
+// opaque URL-safe cursor.
+func EncodeCursor(key any) (string, error) {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeCursor decodes a cursor made by EncodeCursor into key, returning ErrInvalidCursor for cursors
+// which weren't.
+func DecodeCursor(cursor string, key any) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	if err := json.Unmarshal(data, key); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	return nil
+}
+
+// KeysetQuery builds the query of a page: after is the key of the last item of the previous page, nil for
+// the first one, and the query must return at most limit rows ordered by the key. The SQL is the caller's,
+// there is no registry of named queries to look it up in.
+type KeysetQuery[K any] func(after *K, limit int) (sql string, args []any)
+
+// QueryPage runs a keyset paginated query, scanning the rows with scan and taking the cursor of the next page
+// from the key of the last item. One more row than the limit is fetched to know whether there are more.
+// Works with the named pools, replicas and transactions through DBTX.
+//
+//	page, err := app.QueryPage(ctx, service.DB, req,
+//	    func(after *OrderKey, limit int) (string, []any) {
+//	        if after == nil {
+//	            return "SELECT * FROM orders ORDER BY created_at, id LIMIT $1", []any{limit}
+//	        }
+//	        return "SELECT * FROM orders WHERE (created_at, id) > ($1, $2) ORDER BY created_at, id LIMIT $3",
+//	            []any{after.CreatedAt, after.ID, limit}
+//	    },
+//	    pgx.RowToStructByName[Order],
+//	    func(o Order) OrderKey { return OrderKey{CreatedAt: o.CreatedAt, ID: o.ID} },
+//	)
+func QueryPage[T, K any](ctx context.Context, db DBTX, req PageRequest, query KeysetQuery[K], scan pgx.RowToFunc[T], key func(T) K) (Page[T], error) {
+	var after *K
+	if req.Cursor != "" {
+		after = new(K)
+		if err := DecodeCursor(req.Cursor, after); err != nil {
+			return Page[T]{}, err
+		}
+	}
+
+	limit := req.limit()
+	sql, args := query(after, limit+1)
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return Page[T]{}, fmt.Errorf("failed to query page: %w", err)
+	}
+	items, err := pgx.CollectRows(rows, scan)
+	if err != nil {
+		return Page[T]{}, fmt.Errorf("failed to collect page: %w", err)
+	}
+
+	page := Page[T]{Items: items}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.HasMore = true
+		if page.NextCursor, err = EncodeCursor(key(items[limit-1])); err != nil {
+			return Page[T]{}, err
+		}
+	}
+
+	return page, nil
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PanicError is returned by Start when a server or subservice panicked, the service is stopped
+// gracefully instead of crashing.
+type PanicError struct {
+	// Goroutine names what panicked, e.g. the subservice name.
+	Goroutine string
+	Value     any
+	Stack     []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s panicked: %v", e.Goroutine, e.Value)
+}
+
+// catchPanic runs fn, turning a panic into a *PanicError which is logged and returned.
+func catchPanic(goroutine string, fn func() error) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		perr := &PanicError{Goroutine: goroutine, Value: r, Stack: debug.Stack()}
+		log.Error().Str("goroutine", goroutine).Str("stack", string(perr.Stack)).Msgf("recovered panic: %v", r)
+		err = perr
+	}()
+
+	return fn()
+}
+
+// reportPanic passes err to report when it is a recovered panic, report being optional.
+func reportPanic(report func(err error), err error) {
+	if perr, ok := err.(*PanicError); ok && report != nil {
+		report(perr)
+	}
+}
+
+// recoverPanic turns a panic of fn into a *PanicError, so the errgroup of Start shuts the service down.
+func (s *Service) recoverPanic(goroutine string, fn func() error) func() error {
+	return func() error {
+		err := catchPanic(goroutine, fn)
+		reportPanic(s.notifyReporter, err)
+		return err
+	}
+}
+
+// goRecover runs fn in a goroutine which doesn't crash the process when it panics, the *PanicError being
+// passed to report.
+func goRecover(goroutine string, report func(err error), fn func()) {
+	go func() {
+		reportPanic(report, catchPanic(goroutine, func() error {
+			fn()
+			return nil
+		}))
+	}()
+}
@@ -0,0 +1,548 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"plugin"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// PluginRegisterSymbol is the function a Go plugin exports, a func(*app.PluginRegistrar) error.
+	PluginRegisterSymbol = "Register"
+
+	pluginExtensionName    = "extension"
+	pluginSubServicePrefix = "plugin-"
+	maxPluginCommandArgs   = 1 << 20
+)
+
+// PluginHandshake is shared by the service and the plugin executables served with ServePlugin, which
+// refuse to run when started by anything else.
+var PluginHandshake = goplugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "APP_PLUGIN",
+	MagicCookieValue: "github.com/jetbrainer/app",
+}
+
+// AdminCommand is run by POST /admin/commands/{name} on the tech server with the request body as args,
+// its result is answered as JSON.
+type AdminCommand func(ctx context.Context, args json.RawMessage) (any, error)
+
+type PluginsConfig struct {
+	// Paths of the plugins: Go plugins, ending with .so, and executables served with ServePlugin.
+	Paths []string
+	// Dir holds more plugins, loaded in name order after Paths: the files ending with .so and the
+	// executables.
+	Dir string
+	// AdminTokens enable the /admin/commands endpoints on the tech server.
+	AdminTokens []string
+}
+
+// Plugins holds the extensions loaded at startup, for platform teams shipping organization-wide health
+// checks, middlewares and admin commands without forking this package.
+type Plugins struct {
+	cfg PluginsConfig
+	svc *Service
+
+	mu          sync.RWMutex
+	loaded      []string
+	middlewares []func(http.Handler) http.Handler
+	commands    map[string]AdminCommand
+	checks      []string
+	processes   []*rpcPlugin
+}
+
+func newPlugins(s *Service, cfg PluginsConfig) *Plugins {
+	return &Plugins{cfg: cfg, svc: s, commands: make(map[string]AdminCommand)}
+}
+
+// Loaded returns the names of the loaded plugins.
+func (p *Plugins) Loaded() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return slices.Clone(p.loaded)
+}
+
+// Middleware chains the middlewares registered by the plugins, in load order.
+func (p *Plugins) Middleware() func(http.Handler) http.Handler {
+	p.mu.RLock()
+	mws := slices.Clone(p.middlewares)
+	p.mu.RUnlock()
+
+	return func(next http.Handler) http.Handler {
+		for _, mw := range slices.Backward(mws) {
+			next = mw(next)
+		}
+		return next
+	}
+}
+
+// Command runs the admin command called name.
+func (p *Plugins) Command(ctx context.Context, name string, args json.RawMessage) (any, error) {
+	p.mu.RLock()
+	cmd, ok := p.commands[name]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("admin command %q not found", name)
+	}
+	return cmd(ctx, args)
+}
+
+func (p *Plugins) commandNames() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return slices.Sorted(maps.Keys(p.commands))
+}
+
+func (p *Plugins) addCommand(name string, cmd AdminCommand) error {
+	if cmd == nil {
+		return fmt.Errorf("admin command %q is nil", name)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.commands[name]; ok {
+		return fmt.Errorf("admin command %q already registered", name)
+	}
+	p.commands[name] = cmd
+	return nil
+}
+
+// registerHealthCheck registers a health check of a plugin, removed by kill.
+func (p *Plugins) registerHealthCheck(name string, fn HealthCheckFunc, opts HealthCheckOptions) error {
+	if err := p.svc.RegisterHealthCheck(name, fn, opts); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.checks = append(p.checks, name)
+	return nil
+}
+
+// paths lists cfg.Paths then the Go plugins and the executables of cfg.Dir, so the data files a plugin
+// may keep next to it aren't run.
+func (p *Plugins) paths() ([]string, error) {
+	paths := slices.Clone(p.cfg.Paths)
+	if p.cfg.Dir == "" {
+		return paths, nil
+	}
+
+	entries, err := os.ReadDir(p.cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin dir: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read plugin dir: %w", err)
+		}
+		if filepath.Ext(entry.Name()) == ".so" || info.Mode().Perm()&0o111 != 0 {
+			paths = append(paths, filepath.Join(p.cfg.Dir, entry.Name()))
+		}
+	}
+	return paths, nil
+}
+
+func (p *Plugins) load(ctx context.Context, path string) error {
+	name := strings.TrimSuffix(filepath.Base(path), ".so")
+
+	p.mu.Lock()
+	duplicate := slices.Contains(p.loaded, name)
+	p.mu.Unlock()
+	if duplicate {
+		return fmt.Errorf("plugin %s already loaded", name)
+	}
+
+	var err error
+	if filepath.Ext(path) == ".so" {
+		err = p.loadGoPlugin(name, path)
+	} else {
+		err = p.loadRPCPlugin(ctx, name, path)
+	}
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", name, err)
+	}
+
+	p.mu.Lock()
+	p.loaded = append(p.loaded, name)
+	p.mu.Unlock()
+	log.Info().Str("plugin", name).Str("path", path).Msg("plugin loaded")
+	return nil
+}
+
+// loadGoPlugin opens a plugin built with -buildmode=plugin, which requires cgo and the exact versions of
+// Go and of the dependencies of the service, and calls its Register function.
+func (p *Plugins) loadGoPlugin(name, path string) error {
+	lib, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	sym, err := lib.Lookup(PluginRegisterSymbol)
+	if err != nil {
+		return err
+	}
+	register, ok := sym.(func(*PluginRegistrar) error)
+	if !ok {
+		return fmt.Errorf("%s is %T, want func(*app.PluginRegistrar) error", PluginRegisterSymbol, sym)
+	}
+
+	return register(&PluginRegistrar{plugins: p, name: name})
+}
+
+// PluginRegistrar is passed to the Register function of Go plugins.
+type PluginRegistrar struct {
+	plugins *Plugins
+	name    string
+}
+
+// Name of the plugin, its file name without .so.
+func (r *PluginRegistrar) Name() string {
+	return r.name
+}
+
+// HealthCheck registers a health check, see Service.RegisterHealthCheck.
+func (r *PluginRegistrar) HealthCheck(name string, fn HealthCheckFunc, opts HealthCheckOptions) error {
+	return r.plugins.registerHealthCheck(name, fn, opts)
+}
+
+// Middleware adds HTTP middlewares to Plugins.Middleware.
+func (r *PluginRegistrar) Middleware(mws ...func(http.Handler) http.Handler) {
+	r.plugins.mu.Lock()
+	defer r.plugins.mu.Unlock()
+
+	r.plugins.middlewares = append(r.plugins.middlewares, mws...)
+}
+
+// AdminCommand registers a command run by POST /admin/commands/{name}.
+func (r *PluginRegistrar) AdminCommand(name string, cmd AdminCommand) error {
+	return r.plugins.addCommand(name, cmd)
+}
+
+// PluginManifest declares what a plugin executable serves.
+type PluginManifest struct {
+	// HealthChecks are registered by name, each run calling Check.
+	HealthChecks []string
+	// Commands are registered as admin commands, each run calling Command with the JSON args.
+	Commands []string
+}
+
+// PluginExtension is implemented by plugin executables and served with ServePlugin. Middlewares can't be
+// served across processes, they require a Go plugin.
+type PluginExtension interface {
+	Manifest() (PluginManifest, error)
+	Check(name string) error
+	// Command returns the JSON result of the command.
+	Command(name string, args []byte) ([]byte, error)
+}
+
+// ServePlugin serves ext from the main function of a plugin executable until the service stops it.
+//
+//	func main() {
+//		app.ServePlugin(&orgExtension{})
+//	}
+func ServePlugin(ext PluginExtension) {
+	goplugin.Serve(&goplugin.ServeConfig{
+		HandshakeConfig: PluginHandshake,
+		Plugins:         goplugin.PluginSet{pluginExtensionName: &extensionPlugin{impl: ext}},
+	})
+}
+
+// loadRPCPlugin starts the executable, registers the health checks and commands of its manifest and a
+// subservice killing it on shutdown.
+func (p *Plugins) loadRPCPlugin(ctx context.Context, name, path string) error {
+	client := goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig:  PluginHandshake,
+		Plugins:          goplugin.PluginSet{pluginExtensionName: &extensionPlugin{}},
+		Cmd:              exec.Command(path),
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolNetRPC},
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:        name,
+			Output:      pluginLogWriter(name),
+			Level:       hclog.Info,
+			DisableTime: true,
+		}),
+	})
+
+	ext, err := dispenseExtension(client)
+	if err != nil {
+		client.Kill()
+		return err
+	}
+	manifest, err := ext.manifest(ctx)
+	if err != nil {
+		client.Kill()
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var errs []error
+	for _, check := range manifest.HealthChecks {
+		errs = append(errs, p.registerHealthCheck(check, func(ctx context.Context) error {
+			return ext.check(ctx, check)
+		}, HealthCheckOptions{}))
+	}
+	for _, command := range manifest.Commands {
+		errs = append(errs, p.addCommand(command, func(ctx context.Context, args json.RawMessage) (any, error) {
+			result, err := ext.command(ctx, command, args)
+			if err != nil {
+				return nil, err
+			}
+			return json.RawMessage(result), nil
+		}))
+	}
+	if err := errors.Join(errs...); err != nil {
+		client.Kill()
+		return err
+	}
+
+	sub := &rpcPlugin{name: pluginSubServicePrefix + name, client: client}
+	if err := p.svc.RegisterSubService(sub); err != nil {
+		client.Kill()
+		return err
+	}
+	p.mu.Lock()
+	p.processes = append(p.processes, sub)
+	p.mu.Unlock()
+	return nil
+}
+
+// kill stops the plugin executables started when the loading of a plugin failed, and removes the health
+// checks and commands the plugins registered.
+func (p *Plugins) kill() {
+	p.mu.Lock()
+	processes, checks := p.processes, p.checks
+	p.processes, p.checks = nil, nil
+	clear(p.commands)
+	p.mu.Unlock()
+
+	for _, process := range processes {
+		_ = process.Close()
+		p.svc.removeSubService(process.Name())
+	}
+	for _, check := range checks {
+		p.svc.removeHealthCheck(check)
+	}
+}
+
+func dispenseExtension(client *goplugin.Client) (*extensionRPCClient, error) {
+	rpcClient, err := client.Client()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := rpcClient.Dispense(pluginExtensionName)
+	if err != nil {
+		return nil, err
+	}
+	ext, ok := raw.(*extensionRPCClient)
+	if !ok {
+		return nil, fmt.Errorf("unexpected plugin client %T", raw)
+	}
+	return ext, nil
+}
+
+// pluginLogWriter logs the lines written by the plugin process, relayed by go-plugin.
+func pluginLogWriter(name string) io.Writer {
+	return log.Logger.With().Str("plugin", name).Logger()
+}
+
+// rpcPlugin is the subservice of a plugin executable.
+type rpcPlugin struct {
+	name   string
+	client *goplugin.Client
+}
+
+func (r *rpcPlugin) Name() string {
+	return r.name
+}
+
+func (r *rpcPlugin) Ready() bool {
+	return !r.client.Exited()
+}
+
+// Close asks the plugin to exit, killing it after a grace period.
+func (r *rpcPlugin) Close() error {
+	r.client.Kill()
+	return nil
+}
+
+// extensionPlugin serves PluginExtension over net/rpc.
+type extensionPlugin struct {
+	impl PluginExtension
+}
+
+func (e *extensionPlugin) Server(*goplugin.MuxBroker) (any, error) {
+	return &extensionRPCServer{impl: e.impl}, nil
+}
+
+func (e *extensionPlugin) Client(_ *goplugin.MuxBroker, client *rpc.Client) (any, error) {
+	return &extensionRPCClient{client: client}, nil
+}
+
+// pluginCommandArgs is unnamed for net/rpc, which only accepts exported or builtin types.
+type pluginCommandArgs = struct {
+	Name string
+	Args []byte
+}
+
+type extensionRPCServer struct {
+	impl PluginExtension
+}
+
+func (s *extensionRPCServer) Manifest(_ struct{}, resp *PluginManifest) error {
+	manifest, err := s.impl.Manifest()
+	*resp = manifest
+	return err
+}
+
+func (s *extensionRPCServer) Check(name string, _ *struct{}) error {
+	return s.impl.Check(name)
+}
+
+func (s *extensionRPCServer) Command(args pluginCommandArgs, resp *[]byte) error {
+	result, err := s.impl.Command(args.Name, args.Args)
+	*resp = result
+	return err
+}
+
+type extensionRPCClient struct {
+	client *rpc.Client
+}
+
+// call returns once ctx is done, net/rpc calls can't be canceled.
+func (c *extensionRPCClient) call(ctx context.Context, method string, args, reply any) error {
+	call := c.client.Go("Plugin."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-call.Done:
+		return call.Error
+	}
+}
+
+func (c *extensionRPCClient) manifest(ctx context.Context) (PluginManifest, error) {
+	var manifest PluginManifest
+	err := c.call(ctx, "Manifest", struct{}{}, &manifest)
+	return manifest, err
+}
+
+func (c *extensionRPCClient) check(ctx context.Context, name string) error {
+	return c.call(ctx, "Check", name, &struct{}{})
+}
+
+func (c *extensionRPCClient) command(ctx context.Context, name string, args []byte) ([]byte, error) {
+	var result []byte
+	err := c.call(ctx, "Command", pluginCommandArgs{Name: name, Args: args}, &result)
+	return result, err
+}
+
+func (p *Plugins) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(BearerTokenAuth(p.cfg.AdminTokens...))
+
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Plugins  []string `json:"plugins"`
+			Commands []string `json:"commands"`
+		}{Plugins: p.Loaded(), Commands: p.commandNames()})
+	})
+
+	r.Post("/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+
+		p.mu.RLock()
+		_, ok := p.commands[name]
+		p.mu.RUnlock()
+		if !ok {
+			AnswerWithJSONError(w, http.StatusNotFound)
+			return
+		}
+
+		args, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPluginCommandArgs))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				AnswerWithJSONError(w, http.StatusRequestEntityTooLarge)
+				return
+			}
+			AnswerWithJSONError(w, http.StatusBadRequest)
+			return
+		}
+		if len(args) == 0 {
+			args = []byte("null")
+		}
+		if !json.Valid(args) {
+			AnswerWithJSONError(w, http.StatusBadRequest)
+			return
+		}
+
+		result, err := p.Command(r.Context(), name, args)
+		if err != nil {
+			log.Error().Err(err).Str("command", name).Msg("admin command failed")
+			AnswerWithJSONError(w, http.StatusInternalServerError)
+			return
+		}
+		log.Info().Str("command", name).Str("remote_addr", r.RemoteAddr).Msg("admin command run")
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(result)
+	})
+
+	return r
+}
+
+type PluginsOption struct {
+	cfg PluginsConfig
+}
+
+func (w PluginsOption) Apply(s *Service) error {
+	if s.Plugins != nil {
+		return errors.New("plugins already configured")
+	}
+
+	p := newPlugins(s, w.cfg)
+	paths, err := p.paths()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := p.load(s.ctx, path); err != nil {
+			p.kill()
+			return err
+		}
+	}
+
+	s.Plugins = p
+	return nil
+}
+
+// WithPlugins loads extensions at construction: Go plugins exporting Register, and executables served
+// with ServePlugin, run as subservices. Their middlewares are applied with Service.Plugins.Middleware()
+// and to the gateways, their commands are run by POST /admin/commands/{name} on the tech server with
+// AdminTokens.
+func WithPlugins(cfg PluginsConfig) Option {
+	return PluginsOption{cfg: cfg}
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestPlugins(t *testing.T) *Plugins {
+	t.Helper()
+
+	s := &Service{healthChecks: make(map[string]*healthCheck)}
+	p := newPlugins(s, PluginsConfig{AdminTokens: []string{"secret", ""}})
+	registrar := &PluginRegistrar{plugins: p, name: "org"}
+	err := registrar.AdminCommand("echo", func(_ context.Context, args json.RawMessage) (any, error) {
+		return args, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPluginCommandRoutes(t *testing.T) {
+	tests := []struct {
+		name          string
+		command       string
+		authorization string
+		body          string
+		want          int
+	}{
+		{"run", "echo", "Bearer secret", `{"n":1}`, http.StatusOK},
+		{"no args", "echo", "Bearer secret", "", http.StatusOK},
+		{"empty token", "echo", "Bearer ", `{"n":1}`, http.StatusUnauthorized},
+		{"wrong token", "echo", "Bearer guess", `{"n":1}`, http.StatusUnauthorized},
+		{"unknown command", "missing", "Bearer secret", `{}`, http.StatusNotFound},
+		{"invalid json", "echo", "Bearer secret", `{`, http.StatusBadRequest},
+		{"args too large", "echo", "Bearer secret", `"` + strings.Repeat("a", maxPluginCommandArgs) + `"`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPlugins(t)
+
+			req := httptest.NewRequest(http.MethodPost, "/"+tt.command, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", tt.authorization)
+			rec := httptest.NewRecorder()
+
+			p.routes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+// A failed load leaves none of the health checks and commands registered by the plugins loaded before.
+func TestPluginsKill(t *testing.T) {
+	p := newTestPlugins(t)
+	registrar := &PluginRegistrar{plugins: p, name: "org"}
+	check := func(context.Context) error { return nil }
+	if err := registrar.HealthCheck("org", check, HealthCheckOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	p.kill()
+
+	if checks := p.svc.healthCheckSet(); len(checks) != 0 {
+		t.Errorf("health checks %v left registered", checks)
+	}
+	if names := p.commandNames(); len(names) != 0 {
+		t.Errorf("commands %v left registered", names)
+	}
+	// the names are free again for a retry
+	if err := p.svc.RegisterHealthCheck("org", check, HealthCheckOptions{}); err != nil {
+		t.Error(err)
+	}
+}
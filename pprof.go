@@ -0,0 +1,61 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// PprofConfig controls the /debug/pprof endpoints, served by the tech server by default.
+type PprofConfig struct {
+	// Disabled removes the endpoints.
+	Disabled bool
+	// Middlewares protect the endpoints separately from the rest of the tech server, e.g. BearerTokenAuth
+	// or BasicAuth.
+	Middlewares []func(http.Handler) http.Handler
+	// Address serves the endpoints on a server of their own instead of the tech server, e.g. 127.0.0.1:6060
+	// so they are only reachable from the host or through kubectl port-forward.
+	Address string
+}
+
+// mountPprof mounts the pprof routes on r, the tech router or the one of the debug server.
+func (s *Service) mountPprof(r chi.Router) {
+	r.With(s.pprofCfg.Middlewares...).Mount("/debug/pprof", s.pprofRoutes())
+}
+
+// isInternalServer reports whether srv is served by the framework rather than the application.
+func (s *Service) isInternalServer(srv *http.Server) bool {
+	return srv == s.techServer || (srv != nil && srv == s.debugServer)
+}
+
+type PprofOption struct {
+	cfg PprofConfig
+}
+
+func (w PprofOption) Apply(s *Service) error {
+	s.pprofCfg = w.cfg
+	if w.cfg.Disabled || w.cfg.Address == "" {
+		return nil
+	}
+
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	s.mountPprof(r)
+
+	// no write timeout, CPU profiles and traces last for the requested seconds
+	s.debugServer = &http.Server{
+		Addr:              w.cfg.Address,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.HTTPServers = append(s.HTTPServers, s.debugServer)
+	return nil
+}
+
+// WithPprof disables the pprof endpoints, protects them, or moves them to a server of their own, e.g.
+// bound to localhost in production.
+func WithPprof(cfg PprofConfig) Option {
+	return PprofOption{cfg: cfg}
+}
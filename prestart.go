@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultPreStartTimeout = time.Minute
+	defaultPreStartBackoff = time.Second
+)
+
+// PreStartTask is a one-shot task run by Start before the servers, e.g. a migration or the creation
+// of a topic. Tasks must be idempotent, they run again on every start and on retries.
+type PreStartTask struct {
+	Name string
+	Run  func(ctx context.Context) error
+	// Timeout bounds each attempt, zero means defaultPreStartTimeout.
+	Timeout time.Duration
+	// Retries is the number of attempts after the first one.
+	Retries int
+	// Backoff is the first delay between attempts, doubled after each one, zero means defaultPreStartBackoff.
+	Backoff time.Duration
+}
+
+// runPreStart runs the tasks in order, stopping at the first one failing all its attempts.
+func (s *Service) runPreStart(ctx context.Context) error {
+	for _, task := range s.preStart {
+		if err := s.runPreStartTask(ctx, task); err != nil {
+			return fmt.Errorf("pre-start task %s: %w", task.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) runPreStartTask(ctx context.Context, task PreStartTask) error {
+	backoff := task.Backoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := s.recoverPanic(task.Name, func() error {
+			taskCtx, cancel := context.WithTimeout(ctx, task.Timeout)
+			defer cancel()
+
+			return task.Run(taskCtx)
+		})()
+		if err == nil {
+			log.Info().Str("task", task.Name).Dur("duration", time.Since(start)).Msg("pre-start task done")
+			return nil
+		}
+
+		if attempt > task.Retries || ctx.Err() != nil {
+			return err
+		}
+		log.Warn().Err(err).Str("task", task.Name).Int("attempt", attempt).Dur("retry_in", backoff).
+			Msg("pre-start task failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// RunPreStart runs the tasks as Start would, without a service, e.g. from a "bootstrap" subcommand
+// provisioning the infrastructure.
+func RunPreStart(ctx context.Context, tasks ...PreStartTask) error {
+	s := &Service{}
+	if err := (PreStartOption{tasks: tasks}).Apply(s); err != nil {
+		return err
+	}
+
+	return s.runPreStart(ctx)
+}
+
+type PreStartOption struct {
+	tasks []PreStartTask
+}
+
+func (w PreStartOption) Apply(s *Service) error {
+	for _, task := range w.tasks {
+		if task.Name == "" || task.Run == nil {
+			return errors.New("pre-start tasks require a name and a run function")
+		}
+		if task.Timeout == 0 {
+			task.Timeout = defaultPreStartTimeout
+		}
+		if task.Backoff == 0 {
+			task.Backoff = defaultPreStartBackoff
+		}
+		s.preStart = append(s.preStart, task)
+	}
+
+	return nil
+}
+
+// WithPreStart runs the tasks in order when the service starts, before the servers and subservices.
+// Start fails when a task fails all its attempts. Tasks of several WithPreStart run in option order.
+func WithPreStart(tasks ...PreStartTask) Option {
+	return PreStartOption{tasks: tasks}
+}
@@ -0,0 +1,260 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultProbeInterval = 30 * time.Second
+	defaultProbeTimeout  = 10 * time.Second
+)
+
+// Probe is a synthetic check exercising a user journey, e.g. an endpoint of the service itself or a
+// critical downstream, the way a client would.
+type Probe struct {
+	Name string
+	Run  func(ctx context.Context) error
+	// Interval between runs, zero means defaultProbeInterval.
+	Interval time.Duration
+	// Timeout bounds each run, zero means defaultProbeTimeout.
+	Timeout time.Duration
+}
+
+// HTTPProbe sends a GET request to url with client, http.DefaultClient when nil, and expects the status.
+func HTTPProbe(name string, client *http.Client, url string, status int) Probe {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return Probe{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			_, _ = io.Copy(io.Discard, resp.Body)
+
+			if resp.StatusCode != status {
+				return fmt.Errorf("GET %s answered %d, expected %d", url, resp.StatusCode, status)
+			}
+			return nil
+		},
+	}
+}
+
+type probeResult struct {
+	err       error
+	latency   time.Duration
+	checkedAt time.Time
+}
+
+type probeMetrics struct {
+	duration    *prometheus.HistogramVec
+	success     *prometheus.GaugeVec
+	lastSuccess *prometheus.GaugeVec
+}
+
+func newProbeMetrics() probeMetrics {
+	return probeMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "synthetic_probe_duration_seconds",
+			Help:    "Duration of synthetic probe runs by result: success or failure.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"probe", "result"}),
+		success: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "synthetic_probe_success",
+			Help: "1 when the last run of the probe succeeded.",
+		}, []string{"probe"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "synthetic_probe_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful run of the probe.",
+		}, []string{"probe"}),
+	}
+}
+
+// Prober runs the probes as a subservice, each on its own interval, once the service is started so
+// probes of its own endpoints don't fail during startup.
+type Prober struct {
+	probes  []Probe
+	started func() bool
+	metrics probeMetrics
+
+	resultMu sync.RWMutex
+	results  map[string]probeResult
+
+	wg      sync.WaitGroup
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewProber(probes ...Probe) (*Prober, error) {
+	p := &Prober{
+		metrics: newProbeMetrics(),
+		results: make(map[string]probeResult),
+		done:    make(chan struct{}),
+	}
+
+	for _, probe := range probes {
+		if probe.Name == "" || probe.Run == nil {
+			return nil, errors.New("probes require a name and a run function")
+		}
+		if probe.Interval == 0 {
+			probe.Interval = defaultProbeInterval
+		}
+		if probe.Timeout == 0 {
+			probe.Timeout = defaultProbeTimeout
+		}
+		p.probes = append(p.probes, probe)
+	}
+
+	return p, nil
+}
+
+func (p *Prober) Name() string {
+	return "prober"
+}
+
+func (p *Prober) Ready() bool {
+	return true
+}
+
+func (p *Prober) Run(ctx context.Context) error {
+	p.mu.Lock()
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.running.Store(true)
+	p.mu.Unlock()
+	defer close(p.done)
+
+	for _, probe := range p.probes {
+		p.wg.Add(1)
+		go p.loop(ctx, probe)
+	}
+
+	<-ctx.Done()
+	p.wg.Wait()
+
+	return nil
+}
+
+func (p *Prober) loop(ctx context.Context, probe Probe) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(probe.Interval)
+	defer ticker.Stop()
+
+	for {
+		if p.started == nil || p.started() {
+			p.probe(ctx, probe)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Prober) probe(ctx context.Context, probe Probe) {
+	start := time.Now()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("probe panicked: %v", r)
+			}
+		}()
+
+		probeCtx, cancel := context.WithTimeout(ctx, probe.Timeout)
+		defer cancel()
+
+		return probe.Run(probeCtx)
+	}()
+	if ctx.Err() != nil {
+		return
+	}
+	latency := time.Since(start)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+		log.Warn().Err(err).Str("probe", probe.Name).Dur("latency", latency).Msg("synthetic probe failed")
+		p.metrics.success.WithLabelValues(probe.Name).Set(0)
+	} else {
+		p.metrics.success.WithLabelValues(probe.Name).Set(1)
+		p.metrics.lastSuccess.WithLabelValues(probe.Name).SetToCurrentTime()
+	}
+	p.metrics.duration.WithLabelValues(probe.Name, result).Observe(latency.Seconds())
+
+	p.resultMu.Lock()
+	p.results[probe.Name] = probeResult{err: err, latency: latency, checkedAt: start}
+	p.resultMu.Unlock()
+}
+
+// components reports the last result of the probes which ran, failures don't make the service unhealthy.
+func (p *Prober) components() []ComponentStatus {
+	p.resultMu.RLock()
+	defer p.resultMu.RUnlock()
+
+	components := make([]ComponentStatus, 0, len(p.results))
+	for name, result := range p.results {
+		components = append(components, newComponentStatus("probe_"+name, false, result.err, result.latency, result.checkedAt))
+	}
+	sort.Slice(components, func(i, j int) bool { return components[i].Name < components[j].Name })
+	return components
+}
+
+func (p *Prober) Close() error {
+	p.mu.Lock()
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.mu.Unlock()
+
+	if p.running.Load() {
+		<-p.done
+	}
+	return nil
+}
+
+type ProbeOption struct {
+	probes []Probe
+}
+
+func (w ProbeOption) Apply(s *Service) error {
+	p, err := NewProber(w.probes...)
+	if err != nil {
+		return err
+	}
+	p.started = s.Started
+	p.metrics.duration = registerCollector(s.registry, p.metrics.duration)
+	p.metrics.success = registerCollector(s.registry, p.metrics.success)
+	p.metrics.lastSuccess = registerCollector(s.registry, p.metrics.lastSuccess)
+
+	s.Prober = p
+	return s.RegisterSubService(p)
+}
+
+// WithProbes runs synthetic probes in the background, exporting their success and latency and reporting
+// them in the health status, without an external prober.
+func WithProbes(probes ...Probe) Option {
+	return ProbeOption{probes: probes}
+}
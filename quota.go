@@ -0,0 +1,537 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// maxMemoryQuotaBuckets triggers pruning of the full buckets, which are equivalent to no bucket.
+const maxMemoryQuotaBuckets = 100000
+
+// redisQuotaTake refills and takes from the bucket at KEYS[1] atomically, ARGV being the rate, the burst,
+// the tokens taken and the time in milliseconds. The bucket expires once it would be full again.
+var redisQuotaTake = redis.NewScript(`local rate, burst = tonumber(ARGV[1]), tonumber(ARGV[2])
+local n, now = tonumber(ARGV[3]), tonumber(ARGV[4])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated_at")
+local tokens = burst
+if bucket[1] then
+	local elapsed = math.max(0, now - tonumber(bucket[2])) / 1000
+	tokens = math.min(burst, tonumber(bucket[1]) + elapsed * rate)
+end
+local allowed = 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated_at", ARGV[4])
+if rate > 0 then
+	redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1)
+end
+return {allowed, tostring(tokens)}`)
+
+// QuotaLimit is a token bucket refilled at Rate tokens per second up to Burst tokens.
+type QuotaLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+type QuotaBucket struct {
+	Client string     `json:"client"`
+	Tokens float64    `json:"tokens"`
+	Limit  QuotaLimit `json:"limit"`
+	// Override tells whether Limit was set for the client rather than the default one.
+	Override  bool      `json:"override"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// QuotaStore keeps the buckets and the per-client limit overrides. MemoryQuotaStore is local to the
+// instance, a store shared by the replicas enforces quotas and applies admin changes service-wide.
+type QuotaStore interface {
+	// Take consumes n tokens from the client bucket, which is refilled with the client override or
+	// limit, and reports whether there were enough tokens.
+	Take(ctx context.Context, client string, limit QuotaLimit, n int) (QuotaBucket, bool, error)
+	// Buckets returns the buckets refilled up to now, with limit for the clients without override.
+	Buckets(ctx context.Context, limit QuotaLimit) ([]QuotaBucket, error)
+	// Reset refills the client bucket.
+	Reset(ctx context.Context, client string) error
+	SetLimit(ctx context.Context, client string, limit QuotaLimit) error
+	DeleteLimit(ctx context.Context, client string) error
+}
+
+type memoryQuotaBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type MemoryQuotaStore struct {
+	buckets   map[string]*memoryQuotaBucket
+	overrides map[string]QuotaLimit
+	mu        sync.Mutex
+}
+
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{
+		buckets:   make(map[string]*memoryQuotaBucket),
+		overrides: make(map[string]QuotaLimit),
+	}
+}
+
+func (m *MemoryQuotaStore) limit(client string, limit QuotaLimit) (QuotaLimit, bool) {
+	if override, ok := m.overrides[client]; ok {
+		return override, true
+	}
+	return limit, false
+}
+
+// refill tops the bucket up to now, a missing bucket is full.
+func (m *MemoryQuotaStore) refill(client string, limit QuotaLimit, now time.Time) *memoryQuotaBucket {
+	b, ok := m.buckets[client]
+	if !ok {
+		b = &memoryQuotaBucket{tokens: float64(limit.Burst), updatedAt: now}
+		m.buckets[client] = b
+		return b
+	}
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updatedAt).Seconds()*limit.Rate)
+	b.updatedAt = now
+	return b
+}
+
+func (m *MemoryQuotaStore) Take(_ context.Context, client string, limit QuotaLimit, n int) (QuotaBucket, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.buckets) >= maxMemoryQuotaBuckets {
+		m.prune(limit)
+	}
+
+	limit, override := m.limit(client, limit)
+	now := time.Now()
+	b := m.refill(client, limit, now)
+
+	allowed := b.tokens >= float64(n)
+	if allowed {
+		b.tokens -= float64(n)
+	}
+
+	return QuotaBucket{Client: client, Tokens: b.tokens, Limit: limit, Override: override, UpdatedAt: now}, allowed, nil
+}
+
+func (m *MemoryQuotaStore) prune(limit QuotaLimit) {
+	now := time.Now()
+	for client := range m.buckets {
+		clientLimit, _ := m.limit(client, limit)
+		if b := m.refill(client, clientLimit, now); b.tokens >= float64(clientLimit.Burst) {
+			delete(m.buckets, client)
+		}
+	}
+}
+
+func (m *MemoryQuotaStore) Buckets(_ context.Context, limit QuotaLimit) ([]QuotaBucket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// clients with an override are listed even without a bucket yet
+	clients := make(map[string]struct{}, len(m.buckets)+len(m.overrides))
+	for client := range m.buckets {
+		clients[client] = struct{}{}
+	}
+	for client := range m.overrides {
+		clients[client] = struct{}{}
+	}
+
+	now := time.Now()
+	buckets := make([]QuotaBucket, 0, len(clients))
+	for client := range clients {
+		clientLimit, override := m.limit(client, limit)
+		b := m.refill(client, clientLimit, now)
+		buckets = append(buckets, QuotaBucket{
+			Client: client, Tokens: b.tokens, Limit: clientLimit, Override: override, UpdatedAt: now,
+		})
+	}
+
+	slices.SortFunc(buckets, func(a, b QuotaBucket) int { return strings.Compare(a.Client, b.Client) })
+	return buckets, nil
+}
+
+func (m *MemoryQuotaStore) Reset(_ context.Context, client string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.buckets, client)
+	return nil
+}
+
+func (m *MemoryQuotaStore) SetLimit(_ context.Context, client string, limit QuotaLimit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.overrides[client] = limit
+	return nil
+}
+
+func (m *MemoryQuotaStore) DeleteLimit(_ context.Context, client string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.overrides, client)
+	return nil
+}
+
+// RedisQuotaStore shares the buckets and the overrides between the replicas, so a quota is enforced
+// service-wide. Buckets are refilled on the clock of the instance taking from them.
+type RedisQuotaStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisQuotaStore stores the buckets and the overrides under prefix, e.g. "orders:quota:". The client
+// can be the one of the cache and the locks.
+func NewRedisQuotaStore(client redis.UniversalClient, prefix string) *RedisQuotaStore {
+	return &RedisQuotaStore{client: client, prefix: prefix}
+}
+
+func (r *RedisQuotaStore) bucketKey(client string) string {
+	return r.prefix + "bucket:" + client
+}
+
+// limitsKey is a hash of the overrides by client, read before the script so it only touches the bucket,
+// which a cluster may store on another node.
+func (r *RedisQuotaStore) limitsKey() string {
+	return r.prefix + "limits"
+}
+
+func (r *RedisQuotaStore) limit(ctx context.Context, client string, limit QuotaLimit) (QuotaLimit, bool, error) {
+	data, err := r.client.HGet(ctx, r.limitsKey(), client).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return limit, false, nil
+	}
+	if err != nil {
+		return limit, false, fmt.Errorf("failed to get quota limit of %s: %w", client, err)
+	}
+
+	var override QuotaLimit
+	if err := json.Unmarshal(data, &override); err != nil {
+		return limit, false, fmt.Errorf("invalid quota limit of %s: %w", client, err)
+	}
+	return override, true, nil
+}
+
+func (r *RedisQuotaStore) Take(ctx context.Context, client string, limit QuotaLimit, n int) (QuotaBucket, bool, error) {
+	limit, override, err := r.limit(ctx, client, limit)
+	if err != nil {
+		return QuotaBucket{}, false, err
+	}
+
+	now := time.Now()
+	result, err := redisQuotaTake.Run(ctx, r.client, []string{r.bucketKey(client)},
+		limit.Rate, limit.Burst, n, now.UnixMilli()).Slice()
+	if err != nil {
+		return QuotaBucket{}, false, fmt.Errorf("failed to take quota of %s: %w", client, err)
+	}
+	allowed, _ := result[0].(int64)
+	tokens, err := strconv.ParseFloat(fmt.Sprint(result[1]), 64)
+	if err != nil {
+		return QuotaBucket{}, false, fmt.Errorf("invalid quota bucket of %s: %w", client, err)
+	}
+
+	return QuotaBucket{Client: client, Tokens: tokens, Limit: limit, Override: override, UpdatedAt: now}, allowed == 1, nil
+}
+
+func (r *RedisQuotaStore) Buckets(ctx context.Context, limit QuotaLimit) ([]QuotaBucket, error) {
+	overrides, err := r.client.HGetAll(ctx, r.limitsKey()).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quota limits: %w", err)
+	}
+
+	// clients with an override are listed even without a bucket yet
+	clients := make(map[string]struct{}, len(overrides))
+	for client := range overrides {
+		clients[client] = struct{}{}
+	}
+	keys, err := r.bucketKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		clients[strings.TrimPrefix(key, r.bucketKey(""))] = struct{}{}
+	}
+
+	now := time.Now()
+	buckets := make([]QuotaBucket, 0, len(clients))
+	for client := range clients {
+		bucket := QuotaBucket{Client: client, Limit: limit, UpdatedAt: now}
+		if data, ok := overrides[client]; ok {
+			if err := json.Unmarshal([]byte(data), &bucket.Limit); err != nil {
+				return nil, fmt.Errorf("invalid quota limit of %s: %w", client, err)
+			}
+			bucket.Override = true
+		}
+
+		// a missing bucket, e.g. expired meanwhile, is full
+		bucket.Tokens = float64(bucket.Limit.Burst)
+		values, err := r.client.HMGet(ctx, r.bucketKey(client), "tokens", "updated_at").Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get quota bucket of %s: %w", client, err)
+		}
+		if tokens, ok := values[0].(string); ok {
+			bucket.Tokens, _ = strconv.ParseFloat(tokens, 64)
+			updatedAt, _ := strconv.ParseInt(fmt.Sprint(values[1]), 10, 64)
+			elapsed := max(0, now.Sub(time.UnixMilli(updatedAt)).Seconds())
+			bucket.Tokens = math.Min(float64(bucket.Limit.Burst), bucket.Tokens+elapsed*bucket.Limit.Rate)
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	slices.SortFunc(buckets, func(a, b QuotaBucket) int { return strings.Compare(a.Client, b.Client) })
+	return buckets, nil
+}
+
+// bucketKeys scans every master of a cluster, the buckets being spread over them.
+func (r *RedisQuotaStore) bucketKeys(ctx context.Context) ([]string, error) {
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	scan := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, r.bucketKey("")+"*", 0).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+
+	var err error
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			return scan(ctx, master)
+		})
+	} else {
+		err = scan(ctx, r.client)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quota buckets: %w", err)
+	}
+	return keys, nil
+}
+
+func (r *RedisQuotaStore) Reset(ctx context.Context, client string) error {
+	if err := r.client.Del(ctx, r.bucketKey(client)).Err(); err != nil {
+		return fmt.Errorf("failed to reset quota of %s: %w", client, err)
+	}
+	return nil
+}
+
+func (r *RedisQuotaStore) SetLimit(ctx context.Context, client string, limit QuotaLimit) error {
+	data, err := json.Marshal(limit)
+	if err != nil {
+		return err
+	}
+	if err := r.client.HSet(ctx, r.limitsKey(), client, data).Err(); err != nil {
+		return fmt.Errorf("failed to set quota limit of %s: %w", client, err)
+	}
+	return nil
+}
+
+func (r *RedisQuotaStore) DeleteLimit(ctx context.Context, client string) error {
+	if err := r.client.HDel(ctx, r.limitsKey(), client).Err(); err != nil {
+		return fmt.Errorf("failed to delete quota limit of %s: %w", client, err)
+	}
+	return nil
+}
+
+type QuotaConfig struct {
+	// Default applies to the clients without override.
+	Default QuotaLimit
+	// Store defaults to a MemoryQuotaStore, local to the instance, see NewRedisQuotaStore for quotas
+	// enforced by every replica together.
+	Store QuotaStore
+	// ClientID identifies the client of a request, defaults to the X-Client-ID header.
+	ClientID func(r *http.Request) string
+	// AdminTokens enable the /admin/quotas endpoints on the tech server.
+	AdminTokens []string
+}
+
+// Quotas rate limits clients with token buckets. Requests are admitted when the store fails,
+// an outage of a shared store must not take the service down.
+type Quotas struct {
+	cfg QuotaConfig
+
+	requests *prometheus.CounterVec
+}
+
+func NewQuotas(cfg QuotaConfig) *Quotas {
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryQuotaStore()
+	}
+	if cfg.ClientID == nil {
+		cfg.ClientID = func(r *http.Request) string { return r.Header.Get("X-Client-ID") }
+	}
+
+	return &Quotas{
+		cfg: cfg,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "quota_requests_total",
+			Help: "Requests checked against quotas by result: allowed, limited, or error when the store failed.",
+		}, []string{"result"}),
+	}
+}
+
+// Allow takes a token from the client bucket.
+func (q *Quotas) Allow(ctx context.Context, client string) (QuotaBucket, bool) {
+	bucket, allowed, err := q.cfg.Store.Take(ctx, client, q.cfg.Default, 1)
+	switch {
+	case err != nil:
+		q.requests.WithLabelValues("error").Inc()
+		log.Error().Err(err).Str("client", client).Msg("failed to check quota")
+		return bucket, true
+	case !allowed:
+		q.requests.WithLabelValues("limited").Inc()
+	default:
+		q.requests.WithLabelValues("allowed").Inc()
+	}
+
+	return bucket, allowed
+}
+
+// Middleware answers 429 Too Many Requests with a Retry-After header when the client quota is exhausted.
+func (q *Quotas) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := q.cfg.ClientID(r)
+			if client == "" {
+				client = UsageAnonymousClient
+			}
+
+			bucket, allowed := q.Allow(r.Context(), client)
+			if !allowed {
+				if bucket.Limit.Rate > 0 {
+					retry := math.Ceil((1 - bucket.Tokens) / bucket.Limit.Rate)
+					w.Header().Set("Retry-After", strconv.Itoa(int(retry)))
+				}
+				AnswerWithJSONError(w, http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// audit logs an admin change with what identifies the caller, tokens are shared between admins.
+func (q *Quotas) audit(r *http.Request, action, client string) *zerolog.Event {
+	return log.Info().
+		Bool("audit", true).
+		Str("action", action).
+		Str("client", client).
+		Str("remote_addr", r.RemoteAddr).
+		Str("user_agent", r.UserAgent()).
+		Str("request_id", middleware.GetReqID(r.Context()))
+}
+
+func (q *Quotas) routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(BearerTokenAuth(q.cfg.AdminTokens...))
+
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		buckets, err := q.cfg.Store.Buckets(r.Context(), q.cfg.Default)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to list quota buckets")
+			AnswerWithJSONError(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Default QuotaLimit    `json:"default"`
+			Buckets []QuotaBucket `json:"buckets"`
+		}{Default: q.cfg.Default, Buckets: buckets})
+	})
+
+	r.Put("/{client}/limit", func(w http.ResponseWriter, r *http.Request) {
+		client := chi.URLParam(r, "client")
+
+		var limit QuotaLimit
+		if err := json.NewDecoder(r.Body).Decode(&limit); err != nil || limit.Rate < 0 || limit.Burst < 0 {
+			AnswerWithJSONError(w, http.StatusBadRequest)
+			return
+		}
+
+		if err := q.cfg.Store.SetLimit(r.Context(), client, limit); err != nil {
+			log.Error().Err(err).Str("client", client).Msg("failed to set quota limit")
+			AnswerWithJSONError(w, http.StatusInternalServerError)
+			return
+		}
+		q.audit(r, "set_limit", client).Float64("rate", limit.Rate).Int("burst", limit.Burst).Msg("quota limit set")
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.Delete("/{client}/limit", func(w http.ResponseWriter, r *http.Request) {
+		client := chi.URLParam(r, "client")
+
+		if err := q.cfg.Store.DeleteLimit(r.Context(), client); err != nil {
+			log.Error().Err(err).Str("client", client).Msg("failed to delete quota limit")
+			AnswerWithJSONError(w, http.StatusInternalServerError)
+			return
+		}
+		q.audit(r, "delete_limit", client).Msg("quota limit deleted")
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.Post("/{client}/reset", func(w http.ResponseWriter, r *http.Request) {
+		client := chi.URLParam(r, "client")
+
+		if err := q.cfg.Store.Reset(r.Context(), client); err != nil {
+			log.Error().Err(err).Str("client", client).Msg("failed to reset quota bucket")
+			AnswerWithJSONError(w, http.StatusInternalServerError)
+			return
+		}
+		q.audit(r, "reset", client).Msg("quota bucket reset")
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return r
+}
+
+type QuotasOption struct {
+	cfg QuotaConfig
+}
+
+func (w QuotasOption) Apply(s *Service) error {
+	if w.cfg.Default.Burst <= 0 {
+		return errors.New("quotas require a default burst")
+	}
+
+	q := NewQuotas(w.cfg)
+	q.requests = registerCollector(s.registry, q.requests)
+
+	s.Quotas = q
+	return nil
+}
+
+// WithQuotas rate limits the HTTP handlers wrapped with Service.Quotas.Middleware() per client. With
+// AdminTokens, buckets are inspected and limits adjusted live under /admin/quotas on the tech server.
+func WithQuotas(cfg QuotaConfig) Option {
+	return QuotasOption{cfg: cfg}
+}
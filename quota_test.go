@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) redis.UniversalClient {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+var quotaStores = []struct {
+	name string
+	new  func(t *testing.T) QuotaStore
+}{
+	{"memory", func(*testing.T) QuotaStore { return NewMemoryQuotaStore() }},
+	{"redis", func(t *testing.T) QuotaStore { return NewRedisQuotaStore(newTestRedis(t), "test:quota:") }},
+}
+
+func TestQuotaStores(t *testing.T) {
+	limit := QuotaLimit{Burst: 2}
+
+	tests := []struct {
+		name string
+		// prepare changes the store before the client takes a token once more than its burst
+		prepare func(ctx context.Context, store QuotaStore) error
+		burst   int
+	}{
+		{"default limit", nil, 2},
+		{"override", func(ctx context.Context, store QuotaStore) error {
+			return store.SetLimit(ctx, "acme", QuotaLimit{Burst: 4})
+		}, 4},
+		{"deleted override", func(ctx context.Context, store QuotaStore) error {
+			if err := store.SetLimit(ctx, "acme", QuotaLimit{Burst: 4}); err != nil {
+				return err
+			}
+			return store.DeleteLimit(ctx, "acme")
+		}, 2},
+	}
+	for _, store := range quotaStores {
+		for _, tt := range tests {
+			t.Run(store.name+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				s := store.new(t)
+				if tt.prepare != nil {
+					if err := tt.prepare(ctx, s); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				for i := range tt.burst {
+					if _, allowed, err := s.Take(ctx, "acme", limit, 1); err != nil || !allowed {
+						t.Fatalf("take %d: allowed = %v, err = %v", i, allowed, err)
+					}
+				}
+				bucket, allowed, err := s.Take(ctx, "acme", limit, 1)
+				if err != nil || allowed {
+					t.Fatalf("take beyond burst: allowed = %v, err = %v", allowed, err)
+				}
+				if bucket.Limit.Burst != tt.burst {
+					t.Errorf("burst = %d, want %d", bucket.Limit.Burst, tt.burst)
+				}
+
+				// other clients have their own bucket
+				if _, allowed, err := s.Take(ctx, "other", limit, 1); err != nil || !allowed {
+					t.Errorf("other client: allowed = %v, err = %v", allowed, err)
+				}
+
+				if err := s.Reset(ctx, "acme"); err != nil {
+					t.Fatal(err)
+				}
+				if _, allowed, err := s.Take(ctx, "acme", limit, 1); err != nil || !allowed {
+					t.Errorf("take after reset: allowed = %v, err = %v", allowed, err)
+				}
+
+				buckets, err := s.Buckets(ctx, limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(buckets) != 2 || buckets[0].Client != "acme" || buckets[1].Client != "other" {
+					t.Errorf("buckets = %+v, want acme and other", buckets)
+				}
+			})
+		}
+	}
+}
+
+// Replicas sharing a Redis store enforce the quota together rather than each its own.
+func TestRedisQuotaStoreShared(t *testing.T) {
+	ctx := context.Background()
+	client := newTestRedis(t)
+	replicas := []QuotaStore{NewRedisQuotaStore(client, "test:quota:"), NewRedisQuotaStore(client, "test:quota:")}
+	limit := QuotaLimit{Burst: 3}
+
+	allowed := 0
+	for i := range 6 {
+		if _, ok, err := replicas[i%2].Take(ctx, "acme", limit, 1); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			allowed++
+		}
+	}
+	if allowed != limit.Burst {
+		t.Errorf("allowed %d requests over the replicas, want %d", allowed, limit.Burst)
+	}
+}
+
+func TestQuotaRoutesAuth(t *testing.T) {
+	q := NewQuotas(QuotaConfig{Default: QuotaLimit{Burst: 1}, AdminTokens: []string{"secret", ""}})
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"valid token", "Bearer secret", http.StatusOK},
+		{"empty token", "Bearer ", http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", http.StatusUnauthorized},
+		{"no header", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			q.routes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, strings.TrimSpace(rec.Body.String()))
+			}
+		})
+	}
+}
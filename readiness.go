@@ -1,14 +1,19 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
+	"strconv"
 	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type ReadinessHandler struct {
 	areReady []*atomic.Value
+	report   func(ctx context.Context) ReadinessReport
 }
 
 func NewReadinessHandler(areReady ...*atomic.Value) ReadinessHandler {
@@ -17,20 +22,103 @@ func NewReadinessHandler(areReady ...*atomic.Value) ReadinessHandler {
 	}
 }
 
+// WithReport makes the readiness probe check components with report, answering with the
+// per-component JSON when called with ?verbose=true.
+func (h ReadinessHandler) WithReport(report func(ctx context.Context) ReadinessReport) ReadinessHandler {
+	h.report = report
+	return h
+}
+
 func (h ReadinessHandler) Register(r chi.Router) {
-	r.Get("/ready", ready(h.areReady))
-	r.Get("/health/ready", ready(h.areReady))
+	handler := ready(h.areReady)
+	if h.report != nil {
+		handler = readyReport(h.areReady, h.report)
+	}
+
+	r.Get("/ready", handler)
+	r.Get("/health/ready", handler)
 }
 
 func ready(areReady []*atomic.Value) http.HandlerFunc {
 	return func(w http.ResponseWriter, _ *http.Request) {
-		for _, isReady := range areReady {
-			if isReady == nil || !isReady.Load().(bool) {
-				AnswerWithJSONError(w, http.StatusServiceUnavailable)
-				return
-			}
+		if !allReady(areReady) {
+			AnswerWithJSONError(w, http.StatusServiceUnavailable)
+			return
 		}
 
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+func allReady(areReady []*atomic.Value) bool {
+	for _, isReady := range areReady {
+		if isReady == nil || !isReady.Load().(bool) {
+			return false
+		}
+	}
+	return true
+}
+
+// StartupHandler answers the startup probe, which succeeds once the startup gates passed.
+type StartupHandler struct {
+	areStarted []*atomic.Value
+}
+
+func NewStartupHandler(areStarted ...*atomic.Value) StartupHandler {
+	return StartupHandler{areStarted: areStarted}
+}
+
+func (h StartupHandler) Register(r chi.Router) {
+	r.Get("/health/startup", ready(h.areStarted))
+}
+
+type ReadinessReport struct {
+	Status     string            `json:"status"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Components []ComponentStatus `json:"components"`
+}
+
+// Ready reports whether no critical component failed.
+func (r ReadinessReport) Ready() bool {
+	return r.Status == "ready"
+}
+
+func readyReport(areReady []*atomic.Value, report func(ctx context.Context) ReadinessReport) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rep := report(r.Context())
+		if !allReady(areReady) {
+			rep.Status = "not_ready"
+		}
+
+		code := http.StatusOK
+		if !rep.Ready() {
+			code = http.StatusServiceUnavailable
+		}
+
+		if verbose, _ := strconv.ParseBool(r.URL.Query().Get("verbose")); !verbose {
+			w.WriteHeader(code)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(rep)
+	}
+}
+
+// ReadinessReport checks every component, the service is ready when no critical component failed.
+func (s *Service) ReadinessReport(ctx context.Context) ReadinessReport {
+	report := ReadinessReport{
+		Status:     "ready",
+		Timestamp:  time.Now(),
+		Components: s.checkComponents(ctx),
+	}
+
+	for _, component := range report.Components {
+		if component.Critical && component.failed {
+			report.Status = "not_ready"
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	MediaTypeJSON     = "application/json"
+	MediaTypeProtobuf = "application/x-protobuf"
+	MediaTypeMsgPack  = "application/msgpack"
+
+	// MaxBindBodySize bounds the request bodies read by Bind.
+	MaxBindBodySize = 4 << 20
+)
+
+var (
+	ErrNotAcceptable        = errors.New("no acceptable media type")
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// codec encodes one media type. msgpack uses the json struct tags, so a single set of tags serves both.
+type codec struct {
+	mediaType string
+	aliases   []string
+	// proto codecs only handle proto.Message values
+	proto     bool
+	marshal   func(v any) ([]byte, error)
+	unmarshal func(data []byte, v any) error
+}
+
+func (c codec) is(mediaType string) bool {
+	return mediaType == c.mediaType || slices.Contains(c.aliases, mediaType)
+}
+
+// accepts matches an Accept media range.
+func (c codec) accepts(mediaRange string) bool {
+	return c.is(mediaRange) || mediaRange == "*/*" || mediaRange == "application/*"
+}
+
+// codecs are listed by preference, for wildcards and missing headers.
+var codecs = []codec{
+	{
+		mediaType: MediaTypeJSON,
+		marshal:   json.Marshal,
+		unmarshal: json.Unmarshal,
+	},
+	{
+		mediaType: MediaTypeProtobuf,
+		aliases:   []string{"application/protobuf", "application/vnd.google.protobuf"},
+		proto:     true,
+		marshal: func(v any) ([]byte, error) {
+			return proto.Marshal(v.(proto.Message))
+		},
+		unmarshal: func(data []byte, v any) error {
+			return proto.Unmarshal(data, v.(proto.Message))
+		},
+	},
+	{
+		mediaType: MediaTypeMsgPack,
+		aliases:   []string{"application/x-msgpack", "application/vnd.msgpack"},
+		marshal: func(v any) ([]byte, error) {
+			var buf bytes.Buffer
+			enc := msgpack.NewEncoder(&buf)
+			enc.SetCustomStructTag("json")
+			err := enc.Encode(v)
+			return buf.Bytes(), err
+		},
+		unmarshal: func(data []byte, v any) error {
+			dec := msgpack.NewDecoder(bytes.NewReader(data))
+			dec.SetCustomStructTag("json")
+			return dec.Decode(v)
+		},
+	},
+}
+
+type acceptedType struct {
+	mediaType string
+	q         float64
+}
+
+// negotiate picks the codec for the Accept header, by quality then by the order of the header.
+func negotiate(accept string, v any) (codec, bool) {
+	_, isProto := v.(proto.Message)
+	if strings.TrimSpace(accept) == "" {
+		return codecs[0], true
+	}
+
+	var accepted []acceptedType
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			accepted = append(accepted, acceptedType{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+
+	for _, a := range accepted {
+		for _, c := range codecs {
+			if c.accepts(a.mediaType) && (!c.proto || isProto) {
+				return c, true
+			}
+		}
+	}
+	return codec{}, false
+}
+
+// Render writes v with the status code, encoded as JSON, protobuf or msgpack depending on the Accept header
+// of the request; JSON without one. Protobuf is only negotiated for proto.Message values. When no accepted
+// media type is supported it answers 406 and returns ErrNotAcceptable.
+func Render(w http.ResponseWriter, r *http.Request, code int, v any) error {
+	c, ok := negotiate(r.Header.Get("Accept"), v)
+	if !ok {
+		AnswerWithJSONError(w, http.StatusNotAcceptable)
+		return fmt.Errorf("%w: %s", ErrNotAcceptable, r.Header.Get("Accept"))
+	}
+
+	body, err := c.marshal(v)
+	if err != nil {
+		AnswerWithJSONError(w, http.StatusInternalServerError)
+		return fmt.Errorf("failed to encode %s response: %w", c.mediaType, err)
+	}
+
+	w.Header().Set("Content-Type", c.mediaType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(code)
+	if _, err := w.Write(body); err != nil {
+		return fmt.Errorf("failed to write response: %w", err)
+	}
+	return nil
+}
+
+// Bind decodes the request body into v according to its Content-Type, JSON without one. It returns
+// ErrUnsupportedMediaType for other media types, or protobuf bodies bound to values which are not
+// proto.Message, the caller answering 415. Bodies larger than MaxBindBodySize fail with an
+// *http.MaxBytesError (413), the bodies which don't decode with another error (400).
+func Bind(r *http.Request, v any) error {
+	mediaType := MediaTypeJSON
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return fmt.Errorf("%w: %w", ErrUnsupportedMediaType, err)
+		}
+	}
+
+	_, isProto := v.(proto.Message)
+	for _, c := range codecs {
+		if !c.is(mediaType) {
+			continue
+		}
+		if c.proto && !isProto {
+			break
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, MaxBindBodySize))
+		if err != nil {
+			return fmt.Errorf("failed to read request body: %w", err)
+		}
+		if err := c.unmarshal(body, v); err != nil {
+			return fmt.Errorf("failed to decode %s request: %w", c.mediaType, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+}
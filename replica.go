@@ -0,0 +1,287 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dbReplicaMonitorName          = "db-replicas"
+	defaultReplicaRecheckInterval = 10 * time.Second
+)
+
+// DBRouter sends read-only queries to replica pools and everything else to the primary.
+// Replicas failing with connection level errors are skipped until a health check, or the
+// periodic re-check of the unhealthy replicas, succeeds again. Reads fall back to the primary
+// when no replica is usable.
+type DBRouter struct {
+	primary  *pgxpool.Pool
+	replicas []*dbReplica
+	next     atomic.Uint64
+}
+
+type dbReplica struct {
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+func NewDBRouter(primary *pgxpool.Pool, replicas ...*pgxpool.Pool) *DBRouter {
+	r := &DBRouter{primary: primary}
+	for _, p := range replicas {
+		replica := &dbReplica{pool: p}
+		replica.healthy.Store(true)
+		r.replicas = append(r.replicas, replica)
+	}
+
+	return r
+}
+
+func (r *DBRouter) Primary() *pgxpool.Pool {
+	return r.primary
+}
+
+func (r *DBRouter) Replicas() []*pgxpool.Pool {
+	pools := make([]*pgxpool.Pool, 0, len(r.replicas))
+	for _, replica := range r.replicas {
+		pools = append(pools, replica.pool)
+	}
+
+	return pools
+}
+
+// Exec always runs on the primary.
+func (r *DBRouter) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return r.primary.Exec(ctx, sql, args...)
+}
+
+// Begin always starts a transaction on the primary.
+func (r *DBRouter) Begin(ctx context.Context) (pgx.Tx, error) {
+	return r.primary.Begin(ctx)
+}
+
+// Query runs a read-only query on a healthy replica, failing over to the other
+// replicas and finally to the primary on connection errors.
+func (r *DBRouter) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	var (
+		rows pgx.Rows
+		err  error
+	)
+	for _, pool := range r.readPools() {
+		if rows, err = pool.Query(ctx, sql, args...); err == nil || !isConnError(err) {
+			return rows, err
+		}
+		r.markUnhealthy(pool, err)
+	}
+
+	return rows, err
+}
+
+// QueryRow is the single row counterpart of Query. Failover happens on Scan.
+func (r *DBRouter) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return routedRow{ctx: ctx, router: r, sql: sql, args: args}
+}
+
+type routedRow struct {
+	ctx    context.Context
+	router *DBRouter
+	sql    string
+	args   []any
+}
+
+func (row routedRow) Scan(dest ...any) error {
+	var err error
+	for _, pool := range row.router.readPools() {
+		if err = pool.QueryRow(row.ctx, row.sql, row.args...).Scan(dest...); err == nil || !isConnError(err) {
+			return err
+		}
+		row.router.markUnhealthy(pool, err)
+	}
+
+	return err
+}
+
+// readPools returns healthy replicas in round-robin order followed by the primary.
+func (r *DBRouter) readPools() []*pgxpool.Pool {
+	pools := make([]*pgxpool.Pool, 0, len(r.replicas)+1)
+	if n := len(r.replicas); n > 0 {
+		start := int(r.next.Add(1) % uint64(n))
+		for i := 0; i < n; i++ {
+			replica := r.replicas[(start+i)%n]
+			if replica.healthy.Load() {
+				pools = append(pools, replica.pool)
+			}
+		}
+	}
+
+	return append(pools, r.primary)
+}
+
+func (r *DBRouter) markUnhealthy(pool *pgxpool.Pool, err error) {
+	for _, replica := range r.replicas {
+		if replica.pool == pool && replica.healthy.Swap(false) {
+			log.Warn().Err(err).Msg("db replica marked unhealthy, failing over")
+		}
+	}
+}
+
+// checkReplicas pings every replica and updates its health flag.
+func (r *DBRouter) checkReplicas(ctx context.Context) []error {
+	statuses := make([]error, 0, len(r.replicas))
+	for i, replica := range r.replicas {
+		err := replica.pool.Ping(ctx)
+		if err != nil {
+			log.Debug().Err(err).Int("replica", i).Msg("db replica is not ready")
+		}
+		replica.healthy.Store(err == nil)
+		statuses = append(statuses, err)
+	}
+
+	return statuses
+}
+
+// recheckReplicas pings the unhealthy replicas, restoring those which answer.
+func (r *DBRouter) recheckReplicas(ctx context.Context) {
+	for i, replica := range r.replicas {
+		if replica.healthy.Load() {
+			continue
+		}
+		if err := replica.pool.Ping(ctx); err != nil {
+			log.Debug().Err(err).Int("replica", i).Msg("db replica is still unhealthy")
+			continue
+		}
+		if !replica.healthy.Swap(true) {
+			log.Info().Int("replica", i).Msg("db replica recovered")
+		}
+	}
+}
+
+func (r *DBRouter) close() {
+	for _, replica := range r.replicas {
+		replica.pool.Close()
+	}
+}
+
+func isConnError(err error) bool {
+	var (
+		connectErr *pgconn.ConnectError
+		netErr     net.Error
+	)
+
+	return errors.As(err, &connectErr) || errors.As(err, &netErr) || pgconn.SafeToRetry(err) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// dbReplicaMonitor re-checks the unhealthy replicas of a DBRouter periodically, so they come back
+// even when no health check runs.
+type dbReplicaMonitor struct {
+	router   *DBRouter
+	interval time.Duration
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func (m *dbReplicaMonitor) Name() string {
+	return dbReplicaMonitorName
+}
+
+func (m *dbReplicaMonitor) Ready() bool {
+	return true
+}
+
+func (m *dbReplicaMonitor) Run(ctx context.Context) error {
+	m.mu.Lock()
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.running.Store(true)
+	m.mu.Unlock()
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			m.router.recheckReplicas(ctx)
+		}
+	}
+}
+
+// Close stops the re-checks, the pools are closed with the databases once the servers stopped.
+func (m *dbReplicaMonitor) Close() error {
+	m.mu.Lock()
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.mu.Unlock()
+
+	if m.running.Load() {
+		<-m.done
+	}
+
+	return nil
+}
+
+type DBReplicasOption struct {
+	primary  pgxpool.Config
+	replicas []pgxpool.Config
+}
+
+func (w DBReplicasOption) Apply(s *Service) error {
+	primary, err := newDBPool(w.primary)
+	if err != nil {
+		return fmt.Errorf("db primary: %w", err)
+	}
+
+	replicas := make([]*pgxpool.Pool, 0, len(w.replicas))
+	for i, cfg := range w.replicas {
+		p, err := newDBPool(cfg)
+		if err != nil {
+			primary.Close()
+			for _, replica := range replicas {
+				replica.Close()
+			}
+			return fmt.Errorf("db replica %d: %w", i, err)
+		}
+		replicas = append(replicas, p)
+	}
+
+	if err := s.addDB(DefaultDBName, primary); err != nil {
+		primary.Close()
+		for _, replica := range replicas {
+			replica.Close()
+		}
+		return err
+	}
+
+	s.DBRouter = NewDBRouter(primary, replicas...)
+	if len(replicas) == 0 {
+		return nil
+	}
+	return s.RegisterSubService(&dbReplicaMonitor{
+		router:   s.DBRouter,
+		interval: defaultReplicaRecheckInterval,
+		done:     make(chan struct{}),
+	})
+}
+
+// WithDBReplicas configures the primary pool as Service.DB and read replicas
+// reachable through Service.DBRouter.
+func WithDBReplicas(primaryCfg pgxpool.Config, replicaCfgs ...pgxpool.Config) Option {
+	return DBReplicasOption{primary: primaryCfg, replicas: replicaCfgs}
+}
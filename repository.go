@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrVersionConflict = errors.New("version conflict")
+
+// VersionConflictError is returned when a row was changed since it was read: its version is no longer the
+// expected one. It matches ErrVersionConflict with errors.Is.
+type VersionConflictError struct {
+	Table    string
+	ID       any
+	Expected int64
+	Current  int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s %v: expected version %d, found %d", e.Table, e.ID, e.Expected, e.Current)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// TxBeginner is satisfied by *pgxpool.Pool, *pgx.Conn, *DBRouter, and pgx.Tx for nested transactions
+// run in a savepoint.
+type TxBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// InTx runs fn in a transaction, committed when fn returns nil and rolled back otherwise, e.g. on a
+// VersionConflictError.
+func InTx(ctx context.Context, db TxBeginner, fn func(tx pgx.Tx) error) error {
+	return pgx.BeginFunc(ctx, db, fn)
+}
+
+// Table implements the soft-delete and optimistic locking conventions: deleted rows have a deleted_at
+// timestamp and are hidden rather than removed, and a version column is incremented by each update made
+// with UpdateVersioned. Empty columns mean id, version and deleted_at.
+type Table struct {
+	// Name is the table, qualified by its schema or not, e.g. "billing.invoices".
+	Name          string
+	IDColumn      string
+	VersionColumn string
+	DeletedColumn string
+}
+
+func (t Table) columns() (table, id, version, deleted string) {
+	id, version, deleted = t.IDColumn, t.VersionColumn, t.DeletedColumn
+	if id == "" {
+		id = "id"
+	}
+	if version == "" {
+		version = "version"
+	}
+	if deleted == "" {
+		deleted = "deleted_at"
+	}
+
+	return pgx.Identifier(strings.Split(t.Name, ".")).Sanitize(), pgx.Identifier{id}.Sanitize(),
+		pgx.Identifier{version}.Sanitize(), pgx.Identifier{deleted}.Sanitize()
+}
+
+// NotDeleted is the filter of the rows which are not soft-deleted, for the WHERE clause of queries.
+func (t Table) NotDeleted() string {
+	_, _, _, deleted := t.columns()
+	return deleted + " IS NULL"
+}
+
+// SoftDelete marks the row as deleted. It returns an error wrapping pgx.ErrNoRows when there is no such
+// row or it is already deleted.
+func (t Table) SoftDelete(ctx context.Context, db DBTX, id any) error {
+	table, idCol, _, deleted := t.columns()
+	tag, err := db.Exec(ctx, fmt.Sprintf(
+		`UPDATE %s SET %[3]s = now() WHERE %[2]s = $1 AND %[3]s IS NULL`, table, idCol, deleted), id)
+	if err != nil {
+		return fmt.Errorf("failed to soft-delete %s %v: %w", t.Name, id, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s %v: %w", t.Name, id, pgx.ErrNoRows)
+	}
+	return nil
+}
+
+// Restore undoes SoftDelete. It returns an error wrapping pgx.ErrNoRows when there is no such deleted row.
+func (t Table) Restore(ctx context.Context, db DBTX, id any) error {
+	table, idCol, _, deleted := t.columns()
+	tag, err := db.Exec(ctx, fmt.Sprintf(
+		`UPDATE %s SET %[3]s = NULL WHERE %[2]s = $1 AND %[3]s IS NOT NULL`, table, idCol, deleted), id)
+	if err != nil {
+		return fmt.Errorf("failed to restore %s %v: %w", t.Name, id, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s %v: %w", t.Name, id, pgx.ErrNoRows)
+	}
+	return nil
+}
+
+// UpdateVersioned sets the columns of the row if its version is still the expected one, and returns the
+// incremented version. It returns a VersionConflictError when the row was updated meanwhile, and an error
+// wrapping pgx.ErrNoRows when it doesn't exist or is soft-deleted.
+func (t Table) UpdateVersioned(ctx context.Context, db DBTX, id any, version int64, set map[string]any) (int64, error) {
+	if len(set) == 0 {
+		return 0, errors.New("no columns to update")
+	}
+	table, idCol, versionCol, deleted := t.columns()
+
+	var assignments []string
+	var args []any
+	for _, column := range slices.Sorted(maps.Keys(set)) {
+		args = append(args, set[column])
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", pgx.Identifier{column}.Sanitize(), len(args)))
+	}
+	args = append(args, id, version)
+
+	var next int64
+	err := db.QueryRow(ctx, fmt.Sprintf(
+		`UPDATE %[1]s SET %[2]s, %[4]s = %[4]s + 1 WHERE %[3]s = $%[6]d AND %[4]s = $%[7]d AND %[5]s IS NULL RETURNING %[4]s`,
+		table, strings.Join(assignments, ", "), idCol, versionCol, deleted, len(args)-1, len(args),
+	), args...).Scan(&next)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, t.conflict(ctx, db, id, version)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to update %s %v: %w", t.Name, id, err)
+	}
+
+	return next, nil
+}
+
+// conflict finds out why a versioned update matched no row.
+func (t Table) conflict(ctx context.Context, db DBTX, id any, expected int64) error {
+	table, idCol, versionCol, deleted := t.columns()
+
+	var current int64
+	err := db.QueryRow(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE %s = $1 AND %s IS NULL`, versionCol, table, idCol, deleted), id).
+		Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%s %v: %w", t.Name, id, pgx.ErrNoRows)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the version of %s %v: %w", t.Name, id, err)
+	}
+
+	return &VersionConflictError{Table: t.Name, ID: id, Expected: expected, Current: current}
+}
+
+// SoftDeletedRetention removes the rows soft-deleted more than age ago, see WithRetention.
+func SoftDeletedRetention(db DBTX, t Table, age time.Duration) RetentionTask {
+	deleted := t.DeletedColumn
+	if deleted == "" {
+		deleted = "deleted_at"
+	}
+	return RetentionTask{Name: "soft-deleted-" + t.Name, Delete: DeleteOlderThan(db, t.Name, deleted, age)}
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	// requestIDMetadata carries the request id of gRPC calls, like RequestIDHeader for HTTP requests.
+	requestIDMetadata = "x-request-id"
+)
+
+// ContextWithRequestID stores the request id where RequestIDFromContext, and middleware.GetReqID, find it
+// and adds it to the events of the logger returned by log.Ctx(ctx).
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, middleware.RequestIDKey, id)
+
+	logger := zerolog.Ctx(ctx)
+	if logger.GetLevel() == zerolog.Disabled {
+		// no logger in the context yet
+		logger = &log.Logger
+	}
+	return logger.With().Str("request_id", id).Logger().WithContext(ctx)
+}
+
+// RequestIDFromContext returns the id of the HTTP request or gRPC call being served, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	return middleware.GetReqID(ctx)
+}
+
+func requestIDOrNew(id string) string {
+	if id == "" {
+		return uuid.NewString()
+	}
+	return id
+}
+
+// RequestID takes the request id from the X-Request-ID header or generates a UUID, stores it in the
+// request context with ContextWithRequestID and sends it back in the response header.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestIDOrNew(r.Header.Get(RequestIDHeader))
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(ContextWithRequestID(r.Context(), id)))
+	})
+}
+
+// RequestIDTransport propagates the request id of the request context to outbound HTTP calls.
+func RequestIDTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return requestIDTransport{base: base}
+}
+
+type requestIDTransport struct {
+	base http.RoundTripper
+}
+
+func (t requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if id := RequestIDFromContext(req.Context()); id != "" && req.Header.Get(RequestIDHeader) == "" {
+		// a RoundTripper must not modify the request
+		req = req.Clone(req.Context())
+		req.Header.Set(RequestIDHeader, id)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func outgoingRequestID(ctx context.Context) context.Context {
+	id := RequestIDFromContext(ctx)
+	if id == "" {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(requestIDMetadata)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, requestIDMetadata, id)
+}
+
+// RequestIDClientInterceptor propagates the request id of the context to outbound gRPC calls, see
+// RequestIDDialOptions.
+func RequestIDClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(outgoingRequestID(ctx), method, req, reply, cc, opts...)
+}
+
+// RequestIDStreamClientInterceptor is the stream counterpart of RequestIDClientInterceptor.
+func RequestIDStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(outgoingRequestID(ctx), desc, cc, method, opts...)
+}
+
+// RequestIDDialOptions add the request id interceptors to a gRPC client connection.
+func RequestIDDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(RequestIDClientInterceptor),
+		grpc.WithChainStreamInterceptor(RequestIDStreamClientInterceptor),
+	}
+}
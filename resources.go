@@ -0,0 +1,197 @@
+package app
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultResourceWarning  = 0.8
+	defaultResourceCritical = 0.95
+)
+
+type ResourceLevel int
+
+const (
+	ResourceOK ResourceLevel = iota
+	ResourceWarning
+	ResourceCritical
+)
+
+func (l ResourceLevel) String() string {
+	switch l {
+	case ResourceWarning:
+		return "warning"
+	case ResourceCritical:
+		return "critical"
+	default:
+		return "healthy"
+	}
+}
+
+// ResourceThresholds are usage ratios in the [0, 1] range, zero values fall back to 0.8 and 0.95.
+type ResourceThresholds struct {
+	Warning  float64
+	Critical float64
+}
+
+func (t ResourceThresholds) level(usage float64) ResourceLevel {
+	warning, critical := t.Warning, t.Critical
+	if warning == 0 {
+		warning = defaultResourceWarning
+	}
+	if critical == 0 {
+		critical = defaultResourceCritical
+	}
+
+	switch {
+	case usage >= critical:
+		return ResourceCritical
+	case usage >= warning:
+		return ResourceWarning
+	default:
+		return ResourceOK
+	}
+}
+
+type ResourceCheckConfig struct {
+	// DiskPaths are checked for free space and inodes.
+	DiskPaths []string
+	Disk      ResourceThresholds
+	Inodes    ResourceThresholds
+	// FDs compares open file descriptors with the RLIMIT_NOFILE soft limit.
+	FDs ResourceThresholds
+}
+
+type ResourceStatus struct {
+	Check string
+	Usage float64
+	Level ResourceLevel
+	Err   error
+}
+
+// ResourceChecker checks system resources that silently kill long-running services. It is also
+// a prometheus collector exposing usage ratios and check levels at scrape time.
+type ResourceChecker struct {
+	cfg ResourceCheckConfig
+
+	freeBytes *prometheus.Desc
+	usage     *prometheus.Desc
+	level     *prometheus.Desc
+}
+
+func NewResourceChecker(cfg ResourceCheckConfig) *ResourceChecker {
+	return &ResourceChecker{
+		cfg: cfg,
+		freeBytes: prometheus.NewDesc("resource_disk_free_bytes",
+			"Free disk space available to the process.", []string{"path"}, nil),
+		usage: prometheus.NewDesc("resource_usage_ratio",
+			"Used share of the resource.", []string{"check"}, nil),
+		level: prometheus.NewDesc("resource_check_level",
+			"Resource check level: 0 healthy, 1 warning, 2 critical.", []string{"check"}, nil),
+	}
+}
+
+// Check evaluates every configured resource.
+func (c *ResourceChecker) Check() []ResourceStatus {
+	statuses := make([]ResourceStatus, 0, 2*len(c.cfg.DiskPaths)+1)
+
+	for _, path := range c.cfg.DiskPaths {
+		usage, err := diskUsage(path)
+		statuses = append(statuses,
+			c.status("disk:"+path, usage.bytesRatio(), c.cfg.Disk, err),
+			c.status("inodes:"+path, usage.inodesRatio(), c.cfg.Inodes, err),
+		)
+	}
+
+	open, limit, err := fdUsage()
+	ratio := 0.0
+	if limit > 0 {
+		ratio = float64(open) / float64(limit)
+	}
+	statuses = append(statuses, c.status("fds", ratio, c.cfg.FDs, err))
+
+	return statuses
+}
+
+// Level returns the worst level across all checks.
+func (c *ResourceChecker) Level() ResourceLevel {
+	worst := ResourceOK
+	for _, status := range c.Check() {
+		worst = max(worst, status.Level)
+	}
+
+	return worst
+}
+
+func (c *ResourceChecker) status(check string, usage float64, thresholds ResourceThresholds, err error) ResourceStatus {
+	if err != nil {
+		log.Debug().Err(err).Str("check", check).Msg("resource check failed")
+		return ResourceStatus{Check: check, Err: err}
+	}
+
+	level := thresholds.level(usage)
+	if level != ResourceOK {
+		log.Warn().Str("check", check).Float64("usage", usage).Str("level", level.String()).Msg("resource usage is high")
+	}
+
+	return ResourceStatus{Check: check, Usage: usage, Level: level}
+}
+
+func (c *ResourceChecker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.freeBytes
+	ch <- c.usage
+	ch <- c.level
+}
+
+func (c *ResourceChecker) Collect(ch chan<- prometheus.Metric) {
+	for _, path := range c.cfg.DiskPaths {
+		if usage, err := diskUsage(path); err == nil {
+			ch <- prometheus.MustNewConstMetric(c.freeBytes, prometheus.GaugeValue, float64(usage.freeBytes), path)
+		}
+	}
+
+	for _, status := range c.Check() {
+		if status.Err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.usage, prometheus.GaugeValue, status.Usage, status.Check)
+		ch <- prometheus.MustNewConstMetric(c.level, prometheus.GaugeValue, float64(status.Level), status.Check)
+	}
+}
+
+type diskStats struct {
+	totalBytes  uint64
+	freeBytes   uint64
+	totalInodes uint64
+	freeInodes  uint64
+}
+
+func (d diskStats) bytesRatio() float64 {
+	if d.totalBytes == 0 {
+		return 0
+	}
+	return 1 - float64(d.freeBytes)/float64(d.totalBytes)
+}
+
+func (d diskStats) inodesRatio() float64 {
+	if d.totalInodes == 0 {
+		return 0
+	}
+	return 1 - float64(d.freeInodes)/float64(d.totalInodes)
+}
+
+type ResourceCheckOption struct {
+	cfg ResourceCheckConfig
+}
+
+func (w ResourceCheckOption) Apply(s *Service) error {
+	s.resources = registerCollector(s.registry, NewResourceChecker(w.cfg))
+	return nil
+}
+
+// WithResourceChecks adds disk space, inode and file descriptor checks to the service health.
+// Critical usage makes the service not alive, warnings are only reported.
+func WithResourceChecks(cfg ResourceCheckConfig) Option {
+	return ResourceCheckOption{cfg: cfg}
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+
+package app
+
+import (
+	"errors"
+)
+
+var errResourceCheckUnsupported = errors.New("resource checks are not supported on this platform")
+
+func diskUsage(string) (diskStats, error) {
+	return diskStats{}, errResourceCheckUnsupported
+}
+
+func fdUsage() (uint64, uint64, error) {
+	return 0, 0, errResourceCheckUnsupported
+}
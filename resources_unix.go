@@ -0,0 +1,43 @@
+//go:build linux || darwin
+
+package app
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+func diskUsage(path string) (diskStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return diskStats{}, fmt.Errorf("failed to stat filesystem %s: %w", path, err)
+	}
+
+	return diskStats{
+		totalBytes:  st.Blocks * uint64(st.Bsize),
+		freeBytes:   st.Bavail * uint64(st.Bsize),
+		totalInodes: st.Files,
+		freeInodes:  st.Ffree,
+	}, nil
+}
+
+func fdUsage() (open, limit uint64, err error) {
+	var rlimit syscall.Rlimit
+	if err = syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0, 0, fmt.Errorf("failed to get fd limit: %w", err)
+	}
+
+	dir := "/proc/self/fd"
+	if runtime.GOOS == "darwin" {
+		dir = "/dev/fd"
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count open fds: %w", err)
+	}
+
+	return uint64(len(entries)), rlimit.Cur, nil
+}
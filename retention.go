@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRetentionSpec      = "@hourly"
+	defaultRetentionBatchSize = 1000
+)
+
+// RetentionDeleteFunc deletes at most limit expired rows and returns how many were deleted.
+type RetentionDeleteFunc func(ctx context.Context, limit int) (int64, error)
+
+// RetentionTask is a cleanup run as a leader only scheduler job. Delete is called in batches
+// until it deletes fewer than BatchSize rows, keeping transactions and locks short.
+type RetentionTask struct {
+	Name   string
+	Delete RetentionDeleteFunc
+	// Spec is the cron schedule, empty means defaultRetentionSpec.
+	Spec string
+	// BatchSize bounds the rows deleted per statement, zero means defaultRetentionBatchSize.
+	BatchSize int
+}
+
+// DeleteOlderThan deletes the rows of table, optionally schema-qualified, e.g. "audit.logs", whose column is
+// older than age, rows where column is NULL are kept.
+func DeleteOlderThan(db DBTX, table, column string, age time.Duration) RetentionDeleteFunc {
+	t, c := pgx.Identifier(strings.Split(table, ".")).Sanitize(), pgx.Identifier{column}.Sanitize()
+	query := fmt.Sprintf(
+		`DELETE FROM %[1]s WHERE ctid IN (SELECT ctid FROM %[1]s WHERE %[2]s < now() - $1::interval LIMIT $2)`, t, c)
+
+	return func(ctx context.Context, limit int) (int64, error) {
+		tag, err := db.Exec(ctx, query, age, limit)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete from %s: %w", table, err)
+		}
+		return tag.RowsAffected(), nil
+	}
+}
+
+// OutboxRetention deletes outbox events published more than age ago.
+func OutboxRetention(db DBTX, age time.Duration) RetentionTask {
+	return RetentionTask{Name: "outbox", Delete: DeleteOlderThan(db, "app_outbox", "processed_at", age)}
+}
+
+// ProcessedMessagesRetention deletes dedup claims of PostgresCheckpointStore older than age,
+// age must exceed the longest redelivery window of the consumers.
+func ProcessedMessagesRetention(db DBTX, age time.Duration) RetentionTask {
+	return RetentionTask{
+		Name:   "processed_messages",
+		Delete: DeleteOlderThan(db, "app_processed_messages", "processed_at", age),
+	}
+}
+
+func (t RetentionTask) job(deleted *prometheus.CounterVec) Job {
+	return Job{
+		Name:       "retention-" + t.Name,
+		Spec:       t.Spec,
+		LeaderOnly: true,
+		Run: func(ctx context.Context) error {
+			var total int64
+			for {
+				n, err := t.Delete(ctx, t.BatchSize)
+				total += n
+				deleted.WithLabelValues(t.Name).Add(float64(n))
+				if err != nil {
+					return err
+				}
+				if n < int64(t.BatchSize) {
+					break
+				}
+			}
+
+			log.Debug().Str("task", t.Name).Int64("deleted", total).Msg("retention task finished")
+			return nil
+		},
+	}
+}
+
+type RetentionOption struct {
+	tasks []RetentionTask
+}
+
+func (w RetentionOption) Apply(s *Service) error {
+	deleted := registerCollector(s.registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "retention_deleted_rows_total",
+		Help: "Rows deleted by retention tasks.",
+	}, []string{"task"}))
+
+	jobs := make([]Job, 0, len(w.tasks))
+	for _, t := range w.tasks {
+		if t.Delete == nil {
+			return fmt.Errorf("retention task %s has no delete function", t.Name)
+		}
+		if t.Spec == "" {
+			t.Spec = defaultRetentionSpec
+		}
+		if t.BatchSize == 0 {
+			t.BatchSize = defaultRetentionBatchSize
+		}
+		jobs = append(jobs, t.job(deleted))
+	}
+
+	return SchedulerOption{jobs: jobs}.Apply(s)
+}
+
+// WithRetention runs the cleanup tasks on the scheduler, only on the leader once leader election is set up.
+func WithRetention(tasks ...RetentionTask) Option {
+	return RetentionOption{tasks: tasks}
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// execDB records the statements executed and their arguments, each affecting the next count of rows.
+type execDB struct {
+	queries []string
+	args    [][]any
+	rows    []int64
+	err     error
+}
+
+func (d *execDB) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	d.queries = append(d.queries, sql)
+	d.args = append(d.args, args)
+	if d.err != nil {
+		return pgconn.CommandTag{}, d.err
+	}
+
+	var n int64
+	if len(d.rows) > 0 {
+		n, d.rows = d.rows[0], d.rows[1:]
+	}
+	return pgconn.NewCommandTag(fmt.Sprintf("DELETE %d", n)), nil
+}
+
+func (d *execDB) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (d *execDB) QueryRow(context.Context, string, ...any) pgx.Row {
+	return nil
+}
+
+func TestDeleteOlderThan(t *testing.T) {
+	tests := []struct {
+		name   string
+		table  string
+		column string
+		want   string
+	}{
+		{"table", "logs", "created_at", `DELETE FROM "logs" WHERE ctid IN (SELECT ctid FROM "logs" WHERE "created_at" <`},
+		{"schema-qualified table", "audit.logs", "created_at", `DELETE FROM "audit"."logs" WHERE ctid IN (SELECT ctid FROM "audit"."logs" WHERE "created_at" <`},
+		{"quoted names", `we"ird`, `c"ol`, `DELETE FROM "we""ird" WHERE ctid IN (SELECT ctid FROM "we""ird" WHERE "c""ol" <`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &execDB{rows: []int64{3}}
+
+			n, err := DeleteOlderThan(db, tt.table, tt.column, time.Hour)(context.Background(), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 3 {
+				t.Errorf("deleted = %d, want 3", n)
+			}
+			if !strings.HasPrefix(db.queries[0], tt.want) {
+				t.Errorf("query = %s, want prefix %s", db.queries[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestRetentionJob(t *testing.T) {
+	tests := []struct {
+		name        string
+		rows        []int64
+		err         error
+		wantBatches int
+		wantErr     bool
+	}{
+		{"nothing expired", []int64{0}, nil, 1, false},
+		{"partial batch", []int64{4}, nil, 1, false},
+		{"full batches", []int64{10, 10, 2}, nil, 3, false},
+		{"exact multiple", []int64{10, 10, 0}, nil, 3, false},
+		{"failure", nil, errors.New("down"), 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &execDB{rows: tt.rows, err: tt.err}
+			task := RetentionTask{Name: "logs", Delete: DeleteOlderThan(db, "logs", "created_at", time.Hour), BatchSize: 10}
+			deleted := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "deleted"}, []string{"task"})
+
+			err := task.job(deleted).Run(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if len(db.queries) != tt.wantBatches {
+				t.Errorf("batches = %d, want %d", len(db.queries), tt.wantBatches)
+			}
+		})
+	}
+}
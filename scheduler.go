@@ -0,0 +1,277 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+const schedulerQueueSize = 10
+
+type JobFunc func(ctx context.Context) error
+
+// OverlapPolicy decides what happens when a job is due while its previous run is still in progress.
+type OverlapPolicy int
+
+const (
+	// OverlapSkip drops the run.
+	OverlapSkip OverlapPolicy = iota
+	// OverlapQueue runs it after the current one, up to schedulerQueueSize pending runs.
+	OverlapQueue
+)
+
+// Job is a function run on a cron schedule. Spec accepts the standard five fields
+// as well as descriptors such as "@hourly" or "@every 5m".
+type Job struct {
+	Name    string
+	Spec    string
+	Run     JobFunc
+	Overlap OverlapPolicy
+	// LeaderOnly jobs are skipped on instances which are not the leader, see Scheduler.SetLeaderCheck.
+	LeaderOnly bool
+}
+
+type scheduledJob struct {
+	Job
+	schedule cron.Schedule
+	triggers chan time.Time
+}
+
+// Scheduler runs registered jobs as a subservice. On Close it stops triggering new runs,
+// drops queued ones and waits for in-flight runs to complete.
+type Scheduler struct {
+	jobMu    sync.Mutex
+	jobs     []*scheduledJob
+	isLeader func() bool
+
+	duration *prometheus.HistogramVec
+	runs     *prometheus.CounterVec
+	success  *prometheus.GaugeVec
+
+	active atomic.Int64
+	report func(err error)
+
+	wg      sync.WaitGroup
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "scheduler_job_duration_seconds",
+			Help:    "Duration of scheduled job runs.",
+			Buckets: []float64{.01, .1, .5, 1, 5, 10, 30, 60, 300, 900, 3600},
+		}, []string{"job", "result"}),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scheduler_job_runs_total",
+			Help: "Scheduled job runs by result: success, error, skipped or not_leader.",
+		}, []string{"job", "result"}),
+		success: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "scheduler_job_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful run.",
+		}, []string{"job"}),
+		done: make(chan struct{}),
+	}
+}
+
+// Register adds a job, it must be called before the scheduler runs.
+func (s *Scheduler) Register(job Job) error {
+	if s.running.Load() {
+		return errors.New("scheduler is already running")
+	}
+
+	schedule, err := cron.ParseStandard(job.Spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q for job %s: %w", job.Spec, job.Name, err)
+	}
+
+	s.jobMu.Lock()
+	defer s.jobMu.Unlock()
+
+	for _, j := range s.jobs {
+		if j.Name == job.Name {
+			return fmt.Errorf("job %s already registered", job.Name)
+		}
+	}
+
+	triggers := make(chan time.Time)
+	if job.Overlap == OverlapQueue {
+		triggers = make(chan time.Time, schedulerQueueSize)
+	}
+	s.jobs = append(s.jobs, &scheduledJob{Job: job, schedule: schedule, triggers: triggers})
+
+	return nil
+}
+
+// SetLeaderCheck sets how LeaderOnly jobs find out whether this instance leads, without it every
+// instance runs them. It must be called before the scheduler runs.
+func (s *Scheduler) SetLeaderCheck(isLeader func() bool) {
+	s.jobMu.Lock()
+	defer s.jobMu.Unlock()
+
+	s.isLeader = isLeader
+}
+
+func (s *Scheduler) Name() string {
+	return "scheduler"
+}
+
+func (s *Scheduler) Ready() bool {
+	return true
+}
+
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.mu.Lock()
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.running.Store(true)
+	s.mu.Unlock()
+	defer close(s.done)
+
+	s.jobMu.Lock()
+	jobs, isLeader := s.jobs, s.isLeader
+	s.jobMu.Unlock()
+
+	for _, job := range jobs {
+		s.wg.Add(2)
+		goRecover("job trigger "+job.Name, s.report, func() { s.trigger(ctx, job) })
+		goRecover("job worker "+job.Name, s.report, func() { s.work(ctx, job, isLeader) })
+	}
+
+	<-ctx.Done()
+	s.wg.Wait()
+
+	return nil
+}
+
+// trigger sends the due times to the job worker, applying the overlap policy.
+func (s *Scheduler) trigger(ctx context.Context, job *scheduledJob) {
+	defer s.wg.Done()
+
+	for {
+		next := job.schedule.Next(time.Now())
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case at := <-timer.C:
+			select {
+			case job.triggers <- at:
+			default:
+				log.Warn().Str("job", job.Name).Msg("job still running, skipping run")
+				s.runs.WithLabelValues(job.Name, "skipped").Inc()
+			}
+		}
+	}
+}
+
+func (s *Scheduler) work(ctx context.Context, job *scheduledJob, isLeader func() bool) {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-job.triggers:
+			if ctx.Err() != nil {
+				return
+			}
+			if job.LeaderOnly && isLeader != nil && !isLeader() {
+				log.Debug().Str("job", job.Name).Msg("not the leader, skipping run")
+				s.runs.WithLabelValues(job.Name, "not_leader").Inc()
+				continue
+			}
+			// in-flight runs are not interrupted by shutdown
+			s.runJob(context.WithoutCancel(ctx), job)
+		}
+	}
+}
+
+// Pending is the number of running jobs.
+func (s *Scheduler) Pending() int {
+	return int(s.active.Load())
+}
+
+func (s *Scheduler) runJob(ctx context.Context, job *scheduledJob) {
+	s.active.Add(1)
+	defer s.active.Add(-1)
+
+	start := time.Now()
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("job panicked: %v\n%s", r, debug.Stack())
+			}
+		}()
+		return job.Run(ctx)
+	}()
+
+	result := "success"
+	if err != nil {
+		result = "error"
+		log.Error().Err(err).Str("job", job.Name).Msg("scheduled job failed")
+	} else {
+		s.success.WithLabelValues(job.Name).SetToCurrentTime()
+		log.Debug().Str("job", job.Name).Dur("duration", time.Since(start)).Msg("scheduled job finished")
+	}
+
+	s.duration.WithLabelValues(job.Name, result).Observe(time.Since(start).Seconds())
+	s.runs.WithLabelValues(job.Name, result).Inc()
+}
+
+func (s *Scheduler) Close() error {
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	if s.running.Load() {
+		<-s.done
+	}
+	return nil
+}
+
+type SchedulerOption struct {
+	jobs []Job
+}
+
+func (w SchedulerOption) Apply(s *Service) error {
+	if s.Scheduler == nil {
+		scheduler := NewScheduler()
+		scheduler.duration = registerCollector(s.registry, scheduler.duration)
+		scheduler.runs = registerCollector(s.registry, scheduler.runs)
+		scheduler.success = registerCollector(s.registry, scheduler.success)
+		scheduler.report = s.notifyReporter
+		if err := s.RegisterSubService(scheduler); err != nil {
+			return err
+		}
+		s.Scheduler = scheduler
+	}
+
+	for _, job := range w.jobs {
+		if err := s.Scheduler.Register(job); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WithScheduler runs jobs on cron schedules, more jobs can be added with Service.Scheduler.Register before Start.
+func WithScheduler(jobs ...Job) Option {
+	return SchedulerOption{jobs: jobs}
+}
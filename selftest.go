@@ -0,0 +1,200 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const defaultSelfTestTimeout = 30 * time.Second
+
+// SelfTest is a functional smoke test run once the servers listen and before the service is marked as
+// started, catching dependencies which are reachable but misconfigured: missing grants, a wrong topic or
+// bucket, a read-only replica. Pings don't.
+type SelfTest struct {
+	Name string
+	Run  func(ctx context.Context) error
+	// Timeout bounds the test, zero means defaultSelfTestTimeout.
+	Timeout time.Duration
+}
+
+// runSelfTests runs every test in order, returning false when one of them failed.
+func (s *Service) runSelfTests(ctx context.Context) bool {
+	passed := true
+	for _, test := range s.selfTests {
+		start := time.Now()
+		err := s.recoverPanic(test.Name, func() error {
+			testCtx, cancel := context.WithTimeout(ctx, test.Timeout)
+			defer cancel()
+
+			return test.Run(testCtx)
+		})()
+		if err != nil {
+			log.Error().Err(err).Str("test", test.Name).Msg("self-test failed")
+			passed = false
+			continue
+		}
+		log.Info().Str("test", test.Name).Dur("duration", time.Since(start)).Msg("self-test passed")
+	}
+
+	return passed
+}
+
+// selfTestValue is unique to each run, so a test can't pass on data left by a previous one.
+func selfTestValue() string {
+	return uuid.NewString()
+}
+
+// SentinelRowSelfTest upserts a row of table and reads it back, checking the database accepts writes. The
+// row is keyed by the host name, so replicas don't race each other:
+//
+//	CREATE TABLE self_test (id text PRIMARY KEY, value text NOT NULL, updated_at timestamptz NOT NULL DEFAULT now());
+func SentinelRowSelfTest(db DBTX, table string) SelfTest {
+	return SelfTest{
+		Name: "sentinel-row-" + table,
+		Run: func(ctx context.Context) error {
+			id, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("failed to get the host name: %w", err)
+			}
+			value := selfTestValue()
+			name := pgx.Identifier{table}.Sanitize()
+
+			if _, err := db.Exec(ctx, fmt.Sprintf(
+				`INSERT INTO %s (id, value, updated_at) VALUES ($1, $2, now())
+				ON CONFLICT (id) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at`, name),
+				id, value); err != nil {
+				return fmt.Errorf("failed to write the sentinel row: %w", err)
+			}
+
+			var read string
+			if err := db.QueryRow(ctx, fmt.Sprintf(`SELECT value FROM %s WHERE id = $1`, name), id).Scan(&read); err != nil {
+				return fmt.Errorf("failed to read the sentinel row: %w", err)
+			}
+			if read != value {
+				return fmt.Errorf("sentinel row read %q, wrote %q", read, value)
+			}
+			return nil
+		},
+	}
+}
+
+// KafkaSelfTest produces a message to topic, a health topic of the service, and consumes it back from
+// its partition without a consumer group. The brokers of the producer are used, opts configure the
+// consuming client, e.g. its SASL or TLS options.
+func KafkaSelfTest(producer *KafkaProducer, topic string, opts ...kgo.Opt) SelfTest {
+	return SelfTest{
+		Name: "kafka-" + topic,
+		Run: func(ctx context.Context) error {
+			value := selfTestValue()
+			record := &kgo.Record{Topic: topic, Key: []byte("self-test"), Value: []byte(value)}
+			// ProduceSync sets the partition and offset of the record
+			if err := producer.Produce(ctx, record); err != nil {
+				return fmt.Errorf("failed to produce the test message: %w", err)
+			}
+
+			brokers, _ := producer.Client().OptValue(kgo.SeedBrokers).([]string)
+			client, err := kgo.NewClient(append([]kgo.Opt{
+				kgo.SeedBrokers(brokers...),
+				kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{
+					topic: {record.Partition: kgo.NewOffset().At(record.Offset)},
+				}),
+			}, opts...)...)
+			if err != nil {
+				return fmt.Errorf("failed to create kafka client: %w", err)
+			}
+			defer client.Close()
+
+			for {
+				fetches := client.PollFetches(ctx)
+				if ctx.Err() != nil {
+					return fmt.Errorf("test message not consumed: %w", ctx.Err())
+				}
+				if err := errors.Join(fetchErrors(fetches)...); err != nil {
+					return fmt.Errorf("failed to consume the test message: %w", err)
+				}
+				for _, r := range fetches.Records() {
+					if r.Offset == record.Offset {
+						if string(r.Value) != value {
+							return fmt.Errorf("test message read %q, wrote %q", r.Value, value)
+						}
+						return nil
+					}
+				}
+			}
+		},
+	}
+}
+
+func fetchErrors(fetches kgo.Fetches) []error {
+	var errs []error
+	for _, e := range fetches.Errors() {
+		errs = append(errs, fmt.Errorf("%s/%d: %w", e.Topic, e.Partition, e.Err))
+	}
+	return errs
+}
+
+// ObjectStoreSelfTest puts an object under key, reads it back and deletes it.
+func ObjectStoreSelfTest(store BackupStore, key string) SelfTest {
+	return SelfTest{
+		Name: "object-store-" + key,
+		Run: func(ctx context.Context) error {
+			value := selfTestValue()
+			if err := store.Put(ctx, key, bytes.NewReader([]byte(value))); err != nil {
+				return fmt.Errorf("failed to put the test object: %w", err)
+			}
+
+			r, err := store.Get(ctx, key)
+			if err != nil {
+				return fmt.Errorf("failed to get the test object: %w", err)
+			}
+			read, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return fmt.Errorf("failed to read the test object: %w", err)
+			}
+			if string(read) != value {
+				return fmt.Errorf("test object read %q, wrote %q", read, value)
+			}
+
+			if err := store.Delete(ctx, key); err != nil {
+				return fmt.Errorf("failed to delete the test object: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+type SelfTestOption struct {
+	tests []SelfTest
+}
+
+func (w SelfTestOption) Apply(s *Service) error {
+	for _, test := range w.tests {
+		if test.Name == "" || test.Run == nil {
+			return errors.New("self-tests require a name and a run function")
+		}
+		if test.Timeout == 0 {
+			test.Timeout = defaultSelfTestTimeout
+		}
+		s.selfTests = append(s.selfTests, test)
+	}
+
+	return nil
+}
+
+// WithSelfTest runs the tests once the servers listen and the startup checks passed. The service is
+// marked as started only if they all pass: it is not ready otherwise and, with a strict StartupPolicy,
+// Start fails. Tests are run once, in order, and must be safe to run on every start.
+func WithSelfTest(tests ...SelfTest) Option {
+	return SelfTestOption{tests: tests}
+}
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// ForcedShutdownExitCode is the exit code of the process when the shutdown is forced.
+var ForcedShutdownExitCode = 3
+
+// Shutdown priorities of subservices, lower values are closed first. Consumers stop fetching and
+// finish in-flight messages before producers flush, so nothing is accepted that can't be processed
+// or published.
+const (
+	ShutdownPriorityConsumer = 100
+	ShutdownPriorityDefault  = 200
+	ShutdownPriorityProducer = 300
+)
+
+// ShutdownPrioritizer is implemented by subservices which are not closed with ShutdownPriorityDefault.
+type ShutdownPrioritizer interface {
+	ShutdownPriority() int
+}
+
+func (s *Service) shutdownPriority(subService SubService) int {
+	if priority, ok := s.shutdownOrder[subService.Name()]; ok {
+		return priority
+	}
+	if p, ok := subService.(ShutdownPrioritizer); ok {
+		return p.ShutdownPriority()
+	}
+
+	return ShutdownPriorityDefault
+}
+
+// closeSubServices closes subservices group by group in priority order,
+// subservices sharing a priority are closed concurrently.
+func (s *Service) closeSubServices() {
+	groups := make(map[int][]SubService)
+	for _, subService := range s.subServices() {
+		if subService == nil {
+			continue // reported by validate
+		}
+		priority := s.shutdownPriority(subService)
+		groups[priority] = append(groups[priority], subService)
+	}
+
+	priorities := make([]int, 0, len(groups))
+	for priority := range groups {
+		priorities = append(priorities, priority)
+	}
+	sort.Ints(priorities)
+
+	for _, priority := range priorities {
+		var wg sync.WaitGroup
+		for _, subService := range groups[priority] {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				if err := s.stopComponent("subservice", subService.Name(), subService.Close); err != nil {
+					log.Error().Err(err).Str("service", subService.Name()).Msg("failed to stop service")
+				} else {
+					log.Debug().Str("service", subService.Name()).Int("priority", priority).Msg("subservice stopped")
+				}
+				s.cancelRun(subService.Name())
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// cancelRun cancels the context the subservice was started with, once it is closed.
+func (s *Service) cancelRun(name string) {
+	s.subMu.Lock()
+	cancel, ok := s.runCancels[name]
+	delete(s.runCancels, name)
+	s.subMu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+func newShutdownPhaseMetric() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "service_shutdown_phase_timestamp_seconds",
+		Help: "Unix time the service entered a shutdown phase: draining, shutdown or completed.",
+	}, []string{"phase"})
+}
+
+func (s *Service) enterShutdownPhase(phase string) {
+	s.inFlight.mu.Lock()
+	s.inFlight.phase = phase
+	s.inFlight.mu.Unlock()
+
+	s.shutdownPhase.WithLabelValues(phase).SetToCurrentTime()
+	log.Info().Str("phase", phase).Msg("shutdown phase")
+}
+
+// drain fails the readiness probe and keeps serving for the drain delay,
+// giving load balancers time to stop routing new traffic to the instance.
+func (s *Service) drain() {
+	s.isServing.Store(false)
+	s.startDrain()
+	s.enterShutdownPhase("draining")
+
+	// a service which never started received no traffic to drain
+	if s.drainDelay > 0 && s.Started() {
+		log.Info().Dur("delay", s.drainDelay).Msg("draining before shutdown")
+		time.Sleep(s.drainDelay)
+	}
+}
+
+// escalate forces the shutdown when it exceeds the drain delay plus the shutdown timeout, or when a
+// termination signal is received meanwhile: servers are closed without waiting for their connections
+// and the process exits with ForcedShutdownExitCode, see WithForcedShutdownExit.
+func (s *Service) escalate(stopped <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signaled := make(chan error, 1)
+	go func() {
+		signaled <- s.sigHandler.Wait(ctx)
+	}()
+
+	timer := time.NewTimer(s.drainDelay + s.stopTimeout)
+	defer timer.Stop()
+
+	var reason string
+	select {
+	case <-stopped:
+		return
+	case <-timer.C:
+		reason = "graceful shutdown timed out"
+	case err := <-signaled:
+		if !errors.Is(err, ErrTermSig) {
+			return
+		}
+		reason = "termination signal received during shutdown"
+	}
+
+	log.Error().Str("reason", reason).Int("exit_code", ForcedShutdownExitCode).Msg("forcing shutdown")
+
+	for _, grpcServer := range s.GRPCServers {
+		grpcServer.server.Stop()
+	}
+	for _, httpServer := range s.HTTPServers {
+		httpServer.Close()
+	}
+	s.emitShutdownReport(true, reason)
+
+	if s.forcedExit != nil {
+		s.forcedExit(ForcedShutdownExitCode)
+	}
+}
+
+type ShutdownTimeoutOption struct {
+	timeout time.Duration
+}
+
+func (w ShutdownTimeoutOption) Apply(s *Service) error {
+	switch {
+	case w.timeout < 0:
+		return fmt.Errorf("negative shutdown timeout %s", w.timeout)
+	case w.timeout == 0:
+		s.stopTimeout = defaultShutdownTimeout
+	default:
+		s.stopTimeout = w.timeout
+	}
+	return nil
+}
+
+// WithShutdownTimeout bounds the shutdown following the drain delay, zero means defaultShutdownTimeout.
+// Past it the shutdown is forced and the process exits with ForcedShutdownExitCode.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return ShutdownTimeoutOption{timeout: timeout}
+}
+
+type ForcedShutdownExitOption struct {
+	exit func(code int)
+}
+
+func (w ForcedShutdownExitOption) Apply(s *Service) error {
+	s.forcedExit = w.exit
+	return nil
+}
+
+// WithForcedShutdownExit replaces os.Exit, called with ForcedShutdownExitCode once a shutdown is forced,
+// e.g. to fail a test instead of exiting. With nil the servers are closed and Stop returns.
+func WithForcedShutdownExit(exit func(code int)) Option {
+	return ForcedShutdownExitOption{exit: exit}
+}
+
+type DrainDelayOption struct {
+	delay time.Duration
+}
+
+func (w DrainDelayOption) Apply(s *Service) error {
+	s.drainDelay = w.delay
+	return nil
+}
+
+// WithDrainDelay makes Stop fail readiness and keep serving for delay before shutting down,
+// it should exceed the time the load balancer needs to notice (probe period times failure threshold).
+func WithDrainDelay(delay time.Duration) Option {
+	return DrainDelayOption{delay: delay}
+}
+
+type ShutdownPriorityOption struct {
+	name     string
+	priority int
+}
+
+func (w ShutdownPriorityOption) Apply(s *Service) error {
+	s.shutdownOrder[w.name] = w.priority
+	return nil
+}
+
+// WithShutdownPriority overrides the shutdown priority of the named subservice.
+func WithShutdownPriority(subServiceName string, priority int) Option {
+	return ShutdownPriorityOption{name: subServiceName, priority: priority}
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	shutdownResultOK      = "ok"
+	shutdownResultError   = "error"
+	shutdownResultRunning = "running"
+	shutdownResultForced  = "forced"
+)
+
+// ShutdownComponent is the outcome of stopping one component.
+type ShutdownComponent struct {
+	// Kind is drain, subservice, grpc_server, http_server or database.
+	Kind     string        `json:"kind"`
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration_ns"`
+	// Result is ok, error, or forced when the shutdown was forced before the component stopped.
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+	// Pending is the work left by a subservice reporting it, see PendingReporter.
+	Pending int `json:"pending,omitempty"`
+
+	start time.Time
+}
+
+// ShutdownReport summarizes a shutdown, logged once at its end and written to the file of WithShutdownReport.
+type ShutdownReport struct {
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration_ns"`
+	Forced    bool          `json:"forced"`
+	Reason    string        `json:"reason,omitempty"`
+	// InFlightRequests, InFlightRPCs and OpenStreams are left over when the report is emitted.
+	InFlightRequests int64               `json:"in_flight_requests"`
+	InFlightRPCs     int64               `json:"in_flight_rpcs"`
+	OpenStreams      int64               `json:"open_streams"`
+	Components       []ShutdownComponent `json:"components"`
+}
+
+type shutdownRecorder struct {
+	mu         sync.Mutex
+	startedAt  time.Time
+	components []*ShutdownComponent
+	emitted    bool
+}
+
+// stopComponent runs the stop function of a component, recording how it went.
+func (s *Service) stopComponent(kind, name string, stop func() error) error {
+	c := &ShutdownComponent{Kind: kind, Name: name, Result: shutdownResultRunning, start: time.Now()}
+	s.stopReport.mu.Lock()
+	s.stopReport.components = append(s.stopReport.components, c)
+	s.stopReport.mu.Unlock()
+
+	err := stop()
+
+	s.stopReport.mu.Lock()
+	defer s.stopReport.mu.Unlock()
+
+	c.Duration = time.Since(c.start)
+	c.Result = shutdownResultOK
+	if err != nil {
+		c.Result = shutdownResultError
+		c.Error = err.Error()
+	}
+	if p, err := GetSubService[PendingReporter](s, name); err == nil && kind == "subservice" {
+		c.Pending = p.Pending()
+	}
+	return err
+}
+
+// emitShutdownReport logs the report, and writes it when a path is configured. Components still
+// stopping when the shutdown is forced are reported as forced.
+func (s *Service) emitShutdownReport(forced bool, reason string) {
+	s.stopReport.mu.Lock()
+	defer s.stopReport.mu.Unlock()
+
+	if s.stopReport.emitted {
+		return
+	}
+	s.stopReport.emitted = true
+
+	report := ShutdownReport{
+		StartedAt:        s.stopReport.startedAt,
+		Duration:         time.Since(s.stopReport.startedAt),
+		Forced:           forced,
+		Reason:           reason,
+		InFlightRequests: s.inFlight.requests.Load(),
+		InFlightRPCs:     s.inFlight.rpcs.Load(),
+		OpenStreams:      s.inFlight.streams.Load(),
+	}
+	for _, c := range s.stopReport.components {
+		component := *c
+		if component.Result == shutdownResultRunning {
+			component.Result = shutdownResultForced
+			component.Duration = time.Since(component.start)
+		}
+		report.Components = append(report.Components, component)
+	}
+
+	log.Info().Interface("report", report).Msg("shutdown report")
+
+	if s.reportPath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = os.WriteFile(s.reportPath, data, 0o644)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("path", s.reportPath).Msg("failed to write shutdown report")
+	}
+}
+
+type ShutdownReportOption struct {
+	path string
+}
+
+func (w ShutdownReportOption) Apply(s *Service) error {
+	s.reportPath = w.path
+	return nil
+}
+
+// WithShutdownReport writes the shutdown report as JSON to path, e.g. on a volume kept after the
+// container exits. The report is logged in any case.
+func WithShutdownReport(path string) Option {
+	return ShutdownReportOption{path: path}
+}
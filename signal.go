@@ -3,9 +3,18 @@ package app
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
 	"syscall"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 )
 
 var (
@@ -15,13 +24,18 @@ var (
 type SignalTrap chan os.Signal
 
 func TermSignalTrap() SignalTrap {
-	trap := SignalTrap(make(chan os.Signal, 1))
+	trap := make(SignalTrap, 1)
 
 	signal.Notify(trap, syscall.SIGINT, syscall.SIGTERM)
 
 	return trap
 }
 
+// Stop restores the default action of the signals, once the trap is no longer waited for.
+func (t SignalTrap) Stop() {
+	signal.Stop(t)
+}
+
 func (t SignalTrap) Wait(ctx context.Context) error {
 	select {
 	case <-t:
@@ -30,3 +44,151 @@ func (t SignalTrap) Wait(ctx context.Context) error {
 		return ctx.Err()
 	}
 }
+
+const signalHandlersName = "signals"
+
+// SignalHandler reacts to a signal subscribed with WithSignalHandler, e.g. reloading the config on SIGHUP.
+type SignalHandler func(ctx context.Context, sig os.Signal) error
+
+// SignalHandlers dispatches the subscribed signals to their handlers, one signal at a time. The signals
+// are trapped from Handle on, those received while the handlers don't run are ignored instead of taking
+// their default action, e.g. SIGHUP terminating the process.
+type SignalHandlers struct {
+	handlers map[os.Signal][]SignalHandler
+	trap     chan os.Signal
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewSignalHandlers() *SignalHandlers {
+	return &SignalHandlers{
+		handlers: make(map[os.Signal][]SignalHandler),
+		trap:     make(chan os.Signal, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+func (h *SignalHandlers) Name() string {
+	return signalHandlersName
+}
+
+func (h *SignalHandlers) Ready() bool {
+	return true
+}
+
+func (h *SignalHandlers) Handle(sig os.Signal, handler SignalHandler) {
+	h.handlers[sig] = append(h.handlers[sig], handler)
+	signal.Notify(h.trap, sig)
+}
+
+// Release restores the default action of the signals, the service calls it once Stop completed.
+func (h *SignalHandlers) Release() {
+	signal.Stop(h.trap)
+}
+
+func (h *SignalHandlers) Run(ctx context.Context) error {
+	h.mu.Lock()
+	ctx, h.cancel = context.WithCancel(ctx)
+	h.running.Store(true)
+	h.mu.Unlock()
+	defer close(h.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case sig := <-h.trap:
+			log.Info().Str("signal", sig.String()).Msg("signal received")
+			for _, handler := range h.handlers[sig] {
+				if err := h.handle(ctx, sig, handler); err != nil {
+					log.Error().Err(err).Str("signal", sig.String()).Msg("signal handler failed")
+				}
+			}
+		}
+	}
+}
+
+func (h *SignalHandlers) handle(ctx context.Context, sig os.Signal, handler SignalHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("signal handler panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	return handler(ctx, sig)
+}
+
+func (h *SignalHandlers) Close() error {
+	h.mu.Lock()
+	if h.cancel != nil {
+		h.cancel()
+	}
+	h.mu.Unlock()
+
+	if h.running.Load() {
+		<-h.done
+	}
+	return nil
+}
+
+// DumpGoroutines writes the stack of every goroutine to w, e.g. os.Stderr.
+func DumpGoroutines(w io.Writer) SignalHandler {
+	return func(_ context.Context, _ os.Signal) error {
+		return pprof.Lookup("goroutine").WriteTo(w, 2)
+	}
+}
+
+// ToggleDebugLogging switches the global log level between debug and the level it had before.
+func ToggleDebugLogging() SignalHandler {
+	var mu sync.Mutex
+	previous := zerolog.GlobalLevel()
+
+	return func(_ context.Context, _ os.Signal) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if zerolog.GlobalLevel() == zerolog.DebugLevel {
+			zerolog.SetGlobalLevel(previous)
+		} else {
+			previous = zerolog.GlobalLevel()
+			zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		}
+		log.Info().Str("level", zerolog.GlobalLevel().String()).Msg("log level changed")
+		return nil
+	}
+}
+
+type SignalHandlerOption struct {
+	sig     os.Signal
+	handler SignalHandler
+}
+
+func (w SignalHandlerOption) Apply(s *Service) error {
+	if w.sig == syscall.SIGINT || w.sig == syscall.SIGTERM {
+		return fmt.Errorf("%v is handled by the service to shut down", w.sig)
+	}
+	if w.handler == nil {
+		return fmt.Errorf("%v handler is nil", w.sig)
+	}
+
+	h, err := GetSubService[*SignalHandlers](s, signalHandlersName)
+	if err != nil {
+		h = NewSignalHandlers()
+		if err := s.RegisterSubService(h); err != nil {
+			return err
+		}
+	}
+
+	h.Handle(w.sig, w.handler)
+	return nil
+}
+
+// WithSignalHandler runs handler whenever the process receives sig while the service runs, e.g.
+// syscall.SIGHUP to reload the config, SIGUSR1 with DumpGoroutines or SIGUSR2 with ToggleDebugLogging.
+// From New until Stop returns, sig is ignored while the handler doesn't run.
+func WithSignalHandler(sig os.Signal, handler SignalHandler) Option {
+	return SignalHandlerOption{sig: sig, handler: handler}
+}
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultSLOThreshold = 500 * time.Millisecond
+	sloUnmatchedRoute   = "unmatched"
+)
+
+type SLOObjective struct {
+	// Method is the HTTP method, empty matches every method. gRPC calls are matched on Route only.
+	Method string
+	// Route is the chi route pattern, e.g. "/orders/{id}", or the gRPC full method, e.g. "/orders.v1.Orders/Get".
+	Route string
+	// Threshold is the latency objective, slower requests consume the error budget.
+	Threshold time.Duration
+}
+
+type SLOConfig struct {
+	Objectives []SLOObjective
+	// DefaultThreshold applies to routes without an objective, defaults to defaultSLOThreshold.
+	DefaultThreshold time.Duration
+}
+
+type sloKey struct {
+	method string
+	route  string
+}
+
+// SLOs counts requests and good requests, those which succeeded within the latency objective of
+// their route, so error budget burn rates are computed from two counters:
+//
+//	1 - rate(slo_good_requests_total[1h]) / rate(slo_requests_total[1h])
+type SLOs struct {
+	thresholds       map[sloKey]time.Duration
+	defaultThreshold time.Duration
+
+	requests  *prometheus.CounterVec
+	good      *prometheus.CounterVec
+	objective *prometheus.GaugeVec
+
+	exclusions *telemetryFilter
+}
+
+func NewSLOs(cfg SLOConfig) *SLOs {
+	s := &SLOs{
+		thresholds:       make(map[sloKey]time.Duration, len(cfg.Objectives)),
+		defaultThreshold: cfg.DefaultThreshold,
+	}
+	if s.defaultThreshold <= 0 {
+		s.defaultThreshold = defaultSLOThreshold
+	}
+	for _, o := range cfg.Objectives {
+		s.thresholds[sloKey{method: o.Method, route: o.Route}] = o.Threshold
+	}
+	s.setMetrics(newSLOMetrics())
+
+	return s
+}
+
+func (s *SLOs) setMetrics(m sloMetrics) {
+	s.requests = m.requests
+	s.good = m.good
+	s.objective = m.objective
+
+	for key, threshold := range s.thresholds {
+		s.objective.WithLabelValues(key.route, key.method).Set(threshold.Seconds())
+	}
+}
+
+func (s *SLOs) threshold(method, route string) time.Duration {
+	if threshold, ok := s.thresholds[sloKey{method: method, route: route}]; ok {
+		return threshold
+	}
+	if threshold, ok := s.thresholds[sloKey{route: route}]; ok {
+		return threshold
+	}
+	return s.defaultThreshold
+}
+
+func (s *SLOs) observe(protocol, method, route string, success bool, latency time.Duration) {
+	s.requests.WithLabelValues(protocol, route, method).Inc()
+	if success && latency <= s.threshold(method, route) {
+		s.good.WithLabelValues(protocol, route, method).Inc()
+	}
+}
+
+// Middleware records the SLO of HTTP requests, 5xx responses are failures. Routes are labelled
+// with their pattern, so mount it on a chi router or a http.ServeMux.
+func (s *SLOs) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.exclusions.skip(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			code := ww.Status()
+			if code == 0 {
+				code = http.StatusOK
+			}
+			s.observe("http", r.Method, httpRoute(r), code < http.StatusInternalServerError, time.Since(start))
+		})
+	}
+}
+
+func httpRoute(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+
+	// unmatched paths are not labelled to bound the cardinality
+	return sloUnmatchedRoute
+}
+
+// UnaryServerInterceptor records the SLO of unary gRPC calls, server side codes are failures.
+func (s *SLOs) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if s.exclusions.skip(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		s.observe("grpc", "", info.FullMethod, !isServerFault(status.Code(err)), time.Since(start))
+		return resp, err
+	}
+}
+
+func isServerFault(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Unimplemented,
+		codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// sloInterceptor lets gRPC servers record SLOs whatever the order WithSLO is applied in.
+func (s *Service) sloInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if s.SLO == nil {
+		return handler(ctx, req)
+	}
+	return s.SLO.UnaryServerInterceptor()(ctx, req, info, handler)
+}
+
+type sloMetrics struct {
+	requests  *prometheus.CounterVec
+	good      *prometheus.CounterVec
+	objective *prometheus.GaugeVec
+}
+
+func newSLOMetrics() sloMetrics {
+	return sloMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "slo_requests_total",
+			Help: "Number of requests by protocol, route and method.",
+		}, []string{"protocol", "route", "method"}),
+		good: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "slo_good_requests_total",
+			Help: "Number of requests which succeeded within the latency objective of their route.",
+		}, []string{"protocol", "route", "method"}),
+		objective: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "slo_latency_objective_seconds",
+			Help: "Latency objective of the routes with a configured objective.",
+		}, []string{"route", "method"}),
+	}
+}
+
+type SLOOption struct {
+	cfg SLOConfig
+}
+
+func (w SLOOption) Apply(s *Service) error {
+	slos := NewSLOs(w.cfg)
+
+	m := newSLOMetrics()
+	m.requests = registerCollector(s.registry, m.requests)
+	m.good = registerCollector(s.registry, m.good)
+	m.objective = registerCollector(s.registry, m.objective)
+	slos.setMetrics(m)
+	slos.exclusions = s.exclusions
+
+	s.SLO = slos
+	return nil
+}
+
+// WithSLO records SLO counters of the gRPC servers, and of HTTP handlers wrapped with Service.SLO.Middleware().
+func WithSLO(cfg SLOConfig) Option {
+	return SLOOption{cfg: cfg}
+}
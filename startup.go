@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultStartupDeadline   = time.Minute
+	defaultStartupBackoff    = 500 * time.Millisecond
+	defaultStartupMaxBackoff = 10 * time.Second
+)
+
+type StartupMode int
+
+const (
+	// StartupTolerant serves without checking the databases, the startup gates pass in the background.
+	// It is the default.
+	StartupTolerant StartupMode = iota
+	// StartupFailFast makes Start return when a database is unreachable or the startup gates don't
+	// pass by the deadline.
+	StartupFailFast
+	// StartupRetry retries the databases with backoff until the deadline, the startup gates are
+	// awaited as with StartupFailFast.
+	StartupRetry
+)
+
+type StartupPolicy struct {
+	Mode StartupMode
+	// Deadline bounds the whole startup, from connecting dependencies to passing the startup gates,
+	// defaults to defaultStartupDeadline.
+	Deadline time.Duration
+	// Backoff is the first delay between dependency attempts, doubled after each attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func (p StartupPolicy) strict() bool {
+	return p.Mode != StartupTolerant
+}
+
+// connectDependencies pings every database before the servers start, retrying with backoff under StartupRetry.
+func (s *Service) connectDependencies(ctx context.Context) error {
+	for _, name := range slices.Sorted(maps.Keys(s.DBs)) {
+		backoff := s.startup.Backoff
+		for {
+			err := s.pingDB(ctx, name)
+			if err == nil {
+				log.Info().Str("db", name).Msg("db connected")
+				break
+			}
+
+			if ctx.Err() != nil {
+				return fmt.Errorf("%w: %w", ErrStartupTimeout, &DependencyUnavailableError{Dep: "db:" + name, Err: err})
+			}
+			if s.startup.Mode == StartupFailFast {
+				return &DependencyUnavailableError{Dep: "db:" + name, Err: err}
+			}
+			log.Warn().Err(err).Str("db", name).Dur("retry_in", backoff).Msg("waiting for db")
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w: %w", ErrStartupTimeout, &DependencyUnavailableError{Dep: "db:" + name, Err: err})
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, s.startup.MaxBackoff)
+		}
+	}
+
+	return nil
+}
+
+// awaitStartup runs the startup gates, ctx is canceled as well when a server or subservice fails meanwhile.
+func (s *Service) awaitStartup(ctx context.Context) error {
+	s.runStartupGates(ctx)
+	if s.Started() {
+		return nil
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: startup gates did not pass", ErrStartupTimeout)
+	}
+	return errors.New("startup gates did not pass")
+}
+
+type StartupPolicyOption struct {
+	policy StartupPolicy
+}
+
+func (w StartupPolicyOption) Apply(s *Service) error {
+	p := w.policy
+	if p.Deadline <= 0 {
+		p.Deadline = defaultStartupDeadline
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = defaultStartupBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultStartupMaxBackoff
+	}
+	p.MaxBackoff = max(p.MaxBackoff, p.Backoff)
+
+	s.startup = p
+	return nil
+}
+
+// WithStartupPolicy decides whether Start checks the databases and awaits the startup gates before
+// serving (StartupFailFast, StartupRetry), returning an error when they fail, or not (StartupTolerant).
+func WithStartupPolicy(policy StartupPolicy) Option {
+	return StartupPolicyOption{policy: policy}
+}
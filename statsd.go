@@ -0,0 +1,318 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultStatsDInterval      = 10 * time.Second
+	defaultStatsDMaxPacketSize = 1432
+)
+
+// StatsDConfig configures pushing of the service metrics to a StatsD or DogStatsD agent.
+type StatsDConfig struct {
+	// Address of the agent, e.g. "127.0.0.1:8125".
+	Address string
+	// Prefix is prepended to every metric name, e.g. "myservice.".
+	Prefix string
+	// Interval between pushes, zero means defaultStatsDInterval.
+	Interval time.Duration
+	// Tags are added to every metric.
+	Tags map[string]string
+	// Plain disables DogStatsD tags, label values are appended to the metric name instead.
+	Plain bool
+	// MaxPacketSize bounds a single UDP datagram, zero means defaultStatsDMaxPacketSize.
+	MaxPacketSize int
+}
+
+// StatsDEmitter periodically gathers the metrics registered on a prometheus registry and pushes them
+// to a StatsD agent, so instruments are declared once with the prometheus API whatever the backend is.
+// Counters are sent as deltas, gauges as values, histograms and summaries as count/sum counters
+// plus bucket and quantile gauges.
+type StatsDEmitter struct {
+	cfg      StatsDConfig
+	gatherer prometheus.Gatherer
+	conn     net.Conn
+	tags     string
+	// pushMu serializes the pushes of the ticker and Close, previous being the counters last pushed
+	pushMu   sync.Mutex
+	previous map[string]float64
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewStatsDEmitter(cfg StatsDConfig, gatherer prometheus.Gatherer) (*StatsDEmitter, error) {
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultStatsDInterval
+	}
+	if cfg.MaxPacketSize == 0 {
+		cfg.MaxPacketSize = defaultStatsDMaxPacketSize
+	}
+
+	conn, err := net.Dial("udp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial statsd %s: %w", cfg.Address, err)
+	}
+
+	return &StatsDEmitter{
+		cfg:      cfg,
+		gatherer: gatherer,
+		conn:     conn,
+		tags:     formatTags(cfg.Tags),
+		previous: make(map[string]float64),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (e *StatsDEmitter) Name() string {
+	return "statsd"
+}
+
+func (e *StatsDEmitter) Ready() bool {
+	return true
+}
+
+func (e *StatsDEmitter) Run(ctx context.Context) error {
+	e.mu.Lock()
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.running.Store(true)
+	e.mu.Unlock()
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := e.Push(); err != nil {
+				log.Error().Err(err).Msg("failed to push statsd metrics")
+			}
+		}
+	}
+}
+
+// Close flushes the metrics one last time and closes the connection.
+func (e *StatsDEmitter) Close() error {
+	e.mu.Lock()
+	if e.cancel != nil {
+		e.cancel()
+	}
+	e.mu.Unlock()
+
+	if e.running.Load() {
+		<-e.done
+	}
+
+	err := e.Push()
+	if closeErr := e.conn.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Push gathers and sends all metrics once.
+func (e *StatsDEmitter) Push() error {
+	e.pushMu.Lock()
+	defer e.pushMu.Unlock()
+
+	mfs, err := e.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+
+	var lines []string
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			lines = e.appendMetric(lines, mf, m)
+		}
+	}
+
+	return e.send(lines)
+}
+
+func (e *StatsDEmitter) appendMetric(lines []string, mf *dto.MetricFamily, m *dto.Metric) []string {
+	name := mf.GetName()
+	labels := m.GetLabel()
+
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		lines = e.appendCounter(lines, name, labels, m.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		lines = e.appendGauge(lines, name, labels, m.GetGauge().GetValue())
+	case dto.MetricType_UNTYPED:
+		lines = e.appendGauge(lines, name, labels, m.GetUntyped().GetValue())
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		h := m.GetHistogram()
+		lines = e.appendCounter(lines, name+".count", labels, float64(h.GetSampleCount()))
+		lines = e.appendCounter(lines, name+".sum", labels, h.GetSampleSum())
+		for _, b := range h.GetBucket() {
+			lines = e.appendGauge(lines, name+".bucket", withLabel(labels, "le", formatFloat(b.GetUpperBound())),
+				float64(b.GetCumulativeCount()))
+		}
+	case dto.MetricType_SUMMARY:
+		sm := m.GetSummary()
+		lines = e.appendCounter(lines, name+".count", labels, float64(sm.GetSampleCount()))
+		lines = e.appendCounter(lines, name+".sum", labels, sm.GetSampleSum())
+		for _, q := range sm.GetQuantile() {
+			lines = e.appendGauge(lines, name, withLabel(labels, "quantile", formatFloat(q.GetQuantile())), q.GetValue())
+		}
+	}
+
+	return lines
+}
+
+// appendCounter sends the increase since the previous push, prometheus counters being cumulative.
+func (e *StatsDEmitter) appendCounter(lines []string, name string, labels []*dto.LabelPair, value float64) []string {
+	line := e.format(name, labels, "%s|c")
+	key := name + line
+	delta := value - e.previous[key]
+	e.previous[key] = value
+	if delta <= 0 {
+		return lines
+	}
+
+	return append(lines, fmt.Sprintf(line, formatFloat(delta)))
+}
+
+func (e *StatsDEmitter) appendGauge(lines []string, name string, labels []*dto.LabelPair, value float64) []string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return lines
+	}
+
+	return append(lines, fmt.Sprintf(e.format(name, labels, "%s|g"), formatFloat(value)))
+}
+
+// format builds a line template with the value placeholder, e.g. "prefix.name:%s|c|#tag:value".
+func (e *StatsDEmitter) format(name string, labels []*dto.LabelPair, kind string) string {
+	var b strings.Builder
+	b.WriteString(e.cfg.Prefix)
+	b.WriteString(name)
+
+	if e.cfg.Plain {
+		for _, l := range labels {
+			b.WriteByte('.')
+			b.WriteString(sanitizeStatsDPath(l.GetValue()))
+		}
+		b.WriteByte(':')
+		b.WriteString(kind)
+		return b.String()
+	}
+
+	b.WriteByte(':')
+	b.WriteString(kind)
+
+	tags := make([]string, 0, len(labels)+1)
+	for _, l := range labels {
+		tags = append(tags, sanitizeStatsD(l.GetName())+":"+sanitizeStatsD(l.GetValue()))
+	}
+	if e.tags != "" {
+		tags = append(tags, e.tags)
+	}
+	if len(tags) > 0 {
+		b.WriteString("|#")
+		b.WriteString(strings.Join(tags, ","))
+	}
+
+	return b.String()
+}
+
+// send packs lines into datagrams of at most MaxPacketSize bytes.
+func (e *StatsDEmitter) send(lines []string) error {
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := e.conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > e.cfg.MaxPacketSize {
+			if err := flush(); err != nil {
+				return fmt.Errorf("failed to write statsd packet: %w", err)
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	if err := flush(); err != nil {
+		return fmt.Errorf("failed to write statsd packet: %w", err)
+	}
+	return nil
+}
+
+func withLabel(labels []*dto.LabelPair, name, value string) []*dto.LabelPair {
+	return append(append(make([]*dto.LabelPair, 0, len(labels)+1), labels...), &dto.LabelPair{Name: &name, Value: &value})
+}
+
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, sanitizeStatsD(k)+":"+sanitizeStatsD(v))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var statsDReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", "\n", "_")
+
+func sanitizeStatsD(s string) string {
+	return statsDReplacer.Replace(s)
+}
+
+// statsDPathReplacer also replaces the separators of the metric path, for the label values of plain mode.
+var statsDPathReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", "\n", "_",
+	".", "_", " ", "_", "/", "_")
+
+func sanitizeStatsDPath(s string) string {
+	return statsDPathReplacer.Replace(s)
+}
+
+type StatsDOption struct {
+	cfg StatsDConfig
+}
+
+func (w StatsDOption) Apply(s *Service) error {
+	e, err := NewStatsDEmitter(w.cfg, s.registry)
+	if err != nil {
+		return err
+	}
+
+	return s.RegisterSubService(e)
+}
+
+// WithStatsD pushes every metric registered on Service.Registry() to a StatsD/DogStatsD agent.
+func WithStatsD(cfg StatsDConfig) Option {
+	return StatsDOption{cfg: cfg}
+}
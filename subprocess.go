@@ -0,0 +1,360 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultSubprocessStopTimeout = 10 * time.Second
+	maxSubprocessLineSize        = 64 << 10
+	// subprocessPipeGrace is left to the killed group to release the output pipes before they are closed.
+	subprocessPipeGrace = time.Second
+)
+
+var errSubprocessExited = errors.New("subprocess exited")
+
+// SubprocessRestart tells when a Subprocess is started again after it exits.
+type SubprocessRestart string
+
+const (
+	// SubprocessRestartNever lets Run return once the process exits, with an error if it failed, which
+	// stops the service.
+	SubprocessRestartNever     SubprocessRestart = "never"
+	SubprocessRestartOnFailure SubprocessRestart = "on-failure"
+	SubprocessRestartAlways    SubprocessRestart = "always"
+)
+
+type SubprocessConfig struct {
+	// Name of the subservice, also logged with every line the process writes.
+	Name string
+	// Path of the executable, looked up in PATH when it has no separator.
+	Path string
+	Args []string
+	// Env is added to the environment of the service, as KEY=value.
+	Env []string
+	// Dir is the working directory, the one of the service if empty.
+	Dir string
+	// Restart defaults to SubprocessRestartOnFailure.
+	Restart SubprocessRestart
+	// Policy gives the backoff between restarts and the attempts before giving up.
+	Policy RestartPolicy
+	// ForwardSignals received by the service are sent to the process, e.g. syscall.SIGHUP to reload it.
+	ForwardSignals []os.Signal
+	// StopSignal is sent on Close, SIGTERM if nil. The process is killed after StopTimeout, zero meaning 10s.
+	StopSignal  os.Signal
+	StopTimeout time.Duration
+}
+
+// Subprocess runs an executable, e.g. a sidecar binary or a legacy script the service wraps, for the
+// lifetime of the service. Its stdout and stderr are logged line by line.
+type Subprocess struct {
+	cfg SubprocessConfig
+
+	process *os.Process
+	ready   atomic.Bool
+
+	restarts *prometheus.CounterVec
+	exits    *prometheus.CounterVec
+	report   func(err error)
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+type subprocessMetrics struct {
+	restarts *prometheus.CounterVec
+	exits    *prometheus.CounterVec
+}
+
+func newSubprocessMetrics() subprocessMetrics {
+	return subprocessMetrics{
+		restarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "subprocess_restarts_total",
+			Help: "Number of restarts of managed subprocesses.",
+		}, []string{"subprocess"}),
+		exits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "subprocess_exits_total",
+			Help: "Exits of managed subprocesses by exit code, -1 when killed by a signal.",
+		}, []string{"subprocess", "code"}),
+	}
+}
+
+func NewSubprocess(cfg SubprocessConfig) (*Subprocess, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("subprocess requires a name")
+	}
+	path, err := exec.LookPath(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("subprocess %s: %w", cfg.Name, err)
+	}
+	cfg.Path = path
+
+	switch cfg.Restart {
+	case "":
+		cfg.Restart = SubprocessRestartOnFailure
+	case SubprocessRestartNever, SubprocessRestartOnFailure, SubprocessRestartAlways:
+	default:
+		return nil, fmt.Errorf("subprocess %s: unknown restart %q", cfg.Name, cfg.Restart)
+	}
+	cfg.Policy = cfg.Policy.withDefaults()
+	if cfg.StopSignal == nil {
+		cfg.StopSignal = syscall.SIGTERM
+	}
+	if cfg.StopTimeout == 0 {
+		cfg.StopTimeout = defaultSubprocessStopTimeout
+	}
+
+	m := newSubprocessMetrics()
+	return &Subprocess{
+		cfg:      cfg,
+		restarts: m.restarts,
+		exits:    m.exits,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (p *Subprocess) setMetrics(m subprocessMetrics) {
+	p.restarts = m.restarts
+	p.exits = m.exits
+}
+
+func (p *Subprocess) Name() string {
+	return p.cfg.Name
+}
+
+// Ready reports whether the process is running.
+func (p *Subprocess) Ready() bool {
+	return p.ready.Load()
+}
+
+func (p *Subprocess) Run(ctx context.Context) error {
+	p.mu.Lock()
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.running.Store(true)
+	p.mu.Unlock()
+	defer close(p.done)
+
+	if len(p.cfg.ForwardSignals) > 0 {
+		goRecover("subprocess "+p.cfg.Name+" signals", p.report, func() { p.forwardSignals(ctx) })
+	}
+
+	attempt := 0
+	for {
+		started := time.Now()
+		err := p.runOnce(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		switch {
+		case p.cfg.Restart == SubprocessRestartNever:
+			return err
+		case p.cfg.Restart == SubprocessRestartOnFailure && err == nil:
+			log.Info().Str("subprocess", p.cfg.Name).Msg("subprocess exited")
+			return nil
+		}
+		if err == nil {
+			err = errSubprocessExited
+		}
+
+		if time.Since(started) >= p.cfg.Policy.ResetAfter {
+			attempt = 0
+		}
+		attempt++
+		if p.cfg.Policy.MaxAttempts > 0 && attempt > p.cfg.Policy.MaxAttempts {
+			return fmt.Errorf("gave up after %d restarts: %w", p.cfg.Policy.MaxAttempts, err)
+		}
+
+		delay := p.cfg.Policy.delay(attempt)
+		log.Warn().Err(err).Str("subprocess", p.cfg.Name).Int("attempt", attempt).Dur("restart_in", delay).
+			Msg("subprocess exited, restarting")
+		if p.cfg.Policy.OnRestart != nil {
+			p.cfg.Policy.OnRestart(p.cfg.Name, attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		p.restarts.WithLabelValues(p.cfg.Name).Inc()
+	}
+}
+
+// runOnce starts the process and waits for it to exit. Cancelling ctx sends the stop signal to its group,
+// then kills the group once the stop timeout is over.
+func (p *Subprocess) runOnce(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, p.cfg.Path, p.cfg.Args...)
+	cmd.Env = append(os.Environ(), p.cfg.Env...)
+	cmd.Dir = p.cfg.Dir
+	stdout := &subprocessLogWriter{name: p.cfg.Name, stream: "stdout", level: zerolog.InfoLevel}
+	stderr := &subprocessLogWriter{name: p.cfg.Name, stream: "stderr", level: zerolog.WarnLevel}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	setProcessGroup(cmd)
+	// exec would only kill the process itself once WaitDelay is over, leaving its children running. The
+	// group is killed even when the process exited, the children it spawned may not have.
+	cmd.Cancel = func() error {
+		time.AfterFunc(p.cfg.StopTimeout, func() {
+			if signalProcess(cmd.Process, os.Kill) == nil {
+				log.Warn().Str("subprocess", p.cfg.Name).Msg("subprocess group didn't stop in time, killed")
+			}
+		})
+		return signalProcess(cmd.Process, p.cfg.StopSignal)
+	}
+	cmd.WaitDelay = p.cfg.StopTimeout + subprocessPipeGrace
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start subprocess %s: %w", p.cfg.Name, err)
+	}
+	p.mu.Lock()
+	p.process = cmd.Process
+	p.mu.Unlock()
+	p.ready.Store(true)
+	log.Info().Str("subprocess", p.cfg.Name).Int("pid", cmd.Process.Pid).Msg("subprocess started")
+
+	err := cmd.Wait()
+	p.ready.Store(false)
+	p.mu.Lock()
+	p.process = nil
+	p.mu.Unlock()
+	stdout.flush()
+	stderr.flush()
+
+	code := -1
+	if cmd.ProcessState != nil {
+		code = cmd.ProcessState.ExitCode()
+	}
+	p.exits.WithLabelValues(p.cfg.Name, strconv.Itoa(code)).Inc()
+
+	if err != nil {
+		return fmt.Errorf("subprocess %s: %w", p.cfg.Name, err)
+	}
+	return nil
+}
+
+func (p *Subprocess) forwardSignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, p.cfg.ForwardSignals...)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			if err := p.Signal(sig); err != nil {
+				log.Warn().Err(err).Str("subprocess", p.cfg.Name).Msgf("failed to forward %s", sig)
+			}
+		}
+	}
+}
+
+// Signal sends sig to the running process and the processes it spawned.
+func (p *Subprocess) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.process == nil {
+		return fmt.Errorf("subprocess %s is not running", p.cfg.Name)
+	}
+	return signalProcess(p.process, sig)
+}
+
+// Close stops the restarts and the process, which is killed if it doesn't exit within the stop timeout.
+func (p *Subprocess) Close() error {
+	p.mu.Lock()
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.mu.Unlock()
+
+	if p.running.Load() {
+		<-p.done
+	}
+	return nil
+}
+
+// subprocessLogWriter logs each line written by the process, a line longer than maxSubprocessLineSize
+// being split.
+type subprocessLogWriter struct {
+	name   string
+	stream string
+	level  zerolog.Level
+	buf    []byte
+}
+
+func (w *subprocessLogWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			w.buf = append(w.buf, b...)
+			if len(w.buf) >= maxSubprocessLineSize {
+				w.flush()
+			}
+			break
+		}
+		w.buf = append(w.buf, b[:i]...)
+		w.flush()
+		b = b[i+1:]
+	}
+	return n, nil
+}
+
+func (w *subprocessLogWriter) flush() {
+	if len(w.buf) == 0 {
+		return
+	}
+	line := bytes.TrimSuffix(w.buf, []byte{'\r'})
+	log.WithLevel(w.level).Str("subprocess", w.name).Str("stream", w.stream).Msg(string(line))
+	w.buf = w.buf[:0]
+}
+
+type SubprocessOption struct {
+	cfg SubprocessConfig
+}
+
+func (w SubprocessOption) Apply(s *Service) error {
+	p, err := NewSubprocess(w.cfg)
+	if err != nil {
+		return err
+	}
+
+	m := newSubprocessMetrics()
+	m.restarts = registerCollector(s.registry, m.restarts)
+	m.exits = registerCollector(s.registry, m.exits)
+	p.setMetrics(m)
+	p.report = s.notifyReporter
+
+	return s.RegisterSubService(p)
+}
+
+// WithSubprocess runs an executable alongside the service, restarted with backoff per cfg.Restart and
+// stopped with the service.
+//
+//	app.WithSubprocess(app.SubprocessConfig{
+//		Name:           "legacy-exporter",
+//		Path:           "/opt/legacy/exporter",
+//		Args:           []string{"--port", "9102"},
+//		ForwardSignals: []os.Signal{syscall.SIGHUP},
+//	})
+func WithSubprocess(cfg SubprocessConfig) Option {
+	return SubprocessOption{cfg: cfg}
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package app
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(*exec.Cmd) {}
+
+func signalProcess(process *os.Process, sig os.Signal) error {
+	return process.Signal(sig)
+}
@@ -0,0 +1,21 @@
+//go:build linux || darwin
+
+package app
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the process in its own group so the signals reach the processes it spawned too.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func signalProcess(process *os.Process, sig os.Signal) error {
+	if s, ok := sig.(syscall.Signal); ok {
+		return syscall.Kill(-process.Pid, s)
+	}
+	return process.Signal(sig)
+}
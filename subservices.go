@@ -0,0 +1,71 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+)
+
+var (
+	ErrSubServiceNotFound = errors.New("subservice not found")
+	ErrSubServiceExists   = errors.New("subservice already registered")
+)
+
+// RegisterSubService adds sub under its name, failing with ErrSubServiceExists when the name is taken.
+// It is safe for concurrent use; subservices registered once Start is called are reported by readiness
+// and closed, but their Run is not started.
+func (s *Service) RegisterSubService(sub SubService) error {
+	if sub == nil {
+		return errors.New("subservice is nil")
+	}
+	name := sub.Name()
+
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	if _, ok := s.SubServices[name]; ok {
+		return fmt.Errorf("%w: %s", ErrSubServiceExists, name)
+	}
+	s.SubServices[name] = sub
+	return nil
+}
+
+// GetSubService returns the subservice registered as name, typed, e.g. GetSubService[*app.KafkaConsumer].
+// It fails with ErrSubServiceNotFound, or when the subservice is not a T.
+func GetSubService[T any](s *Service, name string) (T, error) {
+	var zero T
+
+	sub, ok := s.subService(name)
+	if !ok {
+		return zero, fmt.Errorf("%w: %s", ErrSubServiceNotFound, name)
+	}
+	typed, ok := sub.(T)
+	if !ok {
+		return zero, fmt.Errorf("subservice %s is %T, not %s", name, sub, reflect.TypeFor[T]())
+	}
+	return typed, nil
+}
+
+func (s *Service) subService(name string) (SubService, bool) {
+	s.subMu.RLock()
+	defer s.subMu.RUnlock()
+
+	sub, ok := s.SubServices[name]
+	return sub, ok
+}
+
+// subServices returns a copy of the registry, to range over while subservices may be registered.
+func (s *Service) subServices() map[string]SubService {
+	s.subMu.RLock()
+	defer s.subMu.RUnlock()
+
+	return maps.Clone(s.SubServices)
+}
+
+func (s *Service) removeSubService(name string) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	delete(s.SubServices, name)
+}
@@ -0,0 +1,280 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRestartBackoff    = time.Second
+	defaultRestartMaxBackoff = time.Minute
+	defaultRestartJitter     = 0.2
+	defaultRestartResetAfter = time.Minute
+)
+
+var errSubServiceExited = errors.New("subservice exited")
+
+// RestartPolicy tells how a Supervisor restarts its subservice.
+type RestartPolicy struct {
+	// MaxAttempts is the number of consecutive restarts before giving up and stopping the service,
+	// zero means no limit.
+	MaxAttempts int
+	// Backoff is the first delay before a restart, doubled after each attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Jitter randomizes each delay by up to this fraction, defaults to defaultRestartJitter.
+	Jitter float64
+	// ResetAfter is how long a run must last for the attempts to be counted from zero again.
+	ResetAfter time.Duration
+	// OnRestart is notified before each restart, e.g. to publish an event.
+	OnRestart func(name string, attempt int, err error)
+}
+
+func (p RestartPolicy) withDefaults() RestartPolicy {
+	if p.Backoff == 0 {
+		p.Backoff = defaultRestartBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = defaultRestartMaxBackoff
+	}
+	if p.Jitter == 0 {
+		p.Jitter = defaultRestartJitter
+	}
+	if p.ResetAfter == 0 {
+		p.ResetAfter = defaultRestartResetAfter
+	}
+	return p
+}
+
+// delay returns the backoff before the given attempt, starting at 1.
+func (p RestartPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for range attempt - 1 {
+		if d >= p.MaxBackoff {
+			break
+		}
+		d *= 2
+	}
+	d = min(d, p.MaxBackoff)
+
+	return time.Duration(float64(d) * (1 + p.Jitter*(2*rand.Float64()-1)))
+}
+
+// Supervisor runs a subservice built by a factory and replaces it with a new one when its Run fails,
+// panics or returns before the service stops. Runners can't be run twice, hence the factory.
+type Supervisor struct {
+	name    string
+	factory func() (SubService, error)
+	policy  RestartPolicy
+	report  func(err error)
+
+	current    SubService
+	closed     bool
+	restarting atomic.Bool
+
+	restarts *prometheus.CounterVec
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+// NewSupervisor builds the first subservice, which must implement Runner.
+func NewSupervisor(name string, factory func() (SubService, error), policy RestartPolicy) (*Supervisor, error) {
+	sup := &Supervisor{
+		name:    name,
+		factory: factory,
+		policy:  policy.withDefaults(),
+		restarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "subservice_restarts_total",
+			Help: "Number of restarts of supervised subservices.",
+		}, []string{"subservice"}),
+		done: make(chan struct{}),
+	}
+
+	child, err := sup.build()
+	if err != nil {
+		return nil, err
+	}
+	sup.current = child
+
+	return sup, nil
+}
+
+func (sup *Supervisor) build() (SubService, error) {
+	child, err := sup.factory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build subservice %s: %w", sup.name, err)
+	}
+	if _, ok := child.(Runner); !ok {
+		return nil, fmt.Errorf("supervised subservice %s must implement Runner", sup.name)
+	}
+
+	return child, nil
+}
+
+func (sup *Supervisor) Name() string {
+	return sup.name
+}
+
+func (sup *Supervisor) child() SubService {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+
+	return sup.current
+}
+
+func (sup *Supervisor) Ready() bool {
+	return !sup.restarting.Load() && sup.child().Ready()
+}
+
+func (sup *Supervisor) ShutdownPriority() int {
+	if p, ok := sup.child().(ShutdownPrioritizer); ok {
+		return p.ShutdownPriority()
+	}
+	return ShutdownPriorityDefault
+}
+
+func (sup *Supervisor) Run(ctx context.Context) error {
+	sup.mu.Lock()
+	ctx, sup.cancel = context.WithCancel(ctx)
+	sup.running.Store(true)
+	sup.mu.Unlock()
+	defer close(sup.done)
+
+	attempt := 0
+	for {
+		started := time.Now()
+		err := sup.runChild(ctx, sup.child().(Runner))
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			err = errSubServiceExited
+		}
+
+		if time.Since(started) >= sup.policy.ResetAfter {
+			attempt = 0
+		}
+		if err := sup.restart(ctx, &attempt, err); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// restart closes the failed subservice and builds a new one, retrying the factory with the same backoff.
+func (sup *Supervisor) restart(ctx context.Context, attempt *int, err error) error {
+	sup.restarting.Store(true)
+	defer sup.restarting.Store(false)
+
+	if closeErr := sup.child().Close(); closeErr != nil {
+		log.Error().Err(closeErr).Str("service", sup.name).Msg("failed to close failed subservice")
+	}
+	sup.mu.Lock()
+	sup.closed = true
+	sup.mu.Unlock()
+
+	for {
+		*attempt++
+		if sup.policy.MaxAttempts > 0 && *attempt > sup.policy.MaxAttempts {
+			return fmt.Errorf("gave up after %d restarts: %w", sup.policy.MaxAttempts, err)
+		}
+
+		delay := sup.policy.delay(*attempt)
+		log.Warn().Err(err).Str("service", sup.name).Int("attempt", *attempt).Dur("restart_in", delay).
+			Msg("subservice failed, restarting")
+		if sup.policy.OnRestart != nil {
+			sup.policy.OnRestart(sup.name, *attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		child, buildErr := sup.build()
+		if buildErr != nil {
+			err = buildErr
+			continue
+		}
+
+		sup.mu.Lock()
+		sup.current, sup.closed = child, false
+		sup.mu.Unlock()
+		sup.restarts.WithLabelValues(sup.name).Inc()
+		log.Info().Str("service", sup.name).Int("attempt", *attempt).Msg("subservice restarted")
+
+		return nil
+	}
+}
+
+// runChild turns a panic of the subservice into a *PanicError, which is restarted like any failure.
+func (sup *Supervisor) runChild(ctx context.Context, runner Runner) error {
+	err := catchPanic(sup.name, func() error { return runner.Run(ctx) })
+	reportPanic(sup.report, err)
+	return err
+}
+
+// Close stops the restarts and closes the current subservice.
+func (sup *Supervisor) Close() error {
+	sup.mu.Lock()
+	if sup.cancel != nil {
+		sup.cancel()
+	}
+	sup.mu.Unlock()
+
+	if sup.running.Load() {
+		<-sup.done
+	}
+
+	sup.mu.Lock()
+	child, closed := sup.current, sup.closed
+	sup.closed = true
+	sup.mu.Unlock()
+	if closed {
+		return nil
+	}
+
+	return child.Close()
+}
+
+type SupervisorOption struct {
+	name    string
+	factory func() (SubService, error)
+	policy  RestartPolicy
+}
+
+func (w SupervisorOption) Apply(s *Service) error {
+	sup, err := NewSupervisor(w.name, w.factory, w.policy)
+	if err != nil {
+		return err
+	}
+
+	sup.report = s.notifyReporter
+	sup.restarts = registerCollector(s.registry, sup.restarts)
+
+	return s.RegisterSubService(sup)
+}
+
+// WithSupervisedSubService runs the subservice built by factory under the name, building a new one
+// with backoff whenever it fails. Start returns the last error once MaxAttempts is exhausted.
+//
+//	app.WithSupervisedSubService("kafka-consumer-orders", func() (app.SubService, error) {
+//		return app.NewKafkaConsumer(brokers, "orders", topics, handle)
+//	}, app.RestartPolicy{MaxAttempts: 10})
+func WithSupervisedSubService(name string, factory func() (SubService, error), policy RestartPolicy) Option {
+	return SupervisorOption{name: name, factory: factory, policy: policy}
+}
@@ -0,0 +1,326 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultTCPShutdownTimeout = 30 * time.Second
+
+// TCPHandler serves a connection, which is closed once it returns. ctx is canceled on shutdown,
+// handlers are expected to finish the current frame and return.
+type TCPHandler func(ctx context.Context, conn net.Conn) error
+
+type TCPServerOpt func(*TCPServer)
+
+// TCPMaxConns bounds the concurrent connections, connections over the limit are closed on accept.
+func TCPMaxConns(n int) TCPServerOpt {
+	return func(s *TCPServer) { s.maxConns = n }
+}
+
+// TCPIdleTimeout closes connections when no data was read for d.
+func TCPIdleTimeout(d time.Duration) TCPServerOpt {
+	return func(s *TCPServer) { s.idleTimeout = d }
+}
+
+func TCPTLS(cfg *tls.Config) TCPServerOpt {
+	return func(s *TCPServer) { s.tlsConfig = cfg }
+}
+
+// TCPShutdownTimeout is how long Close waits for handlers before closing their connections,
+// defaults to defaultTCPShutdownTimeout.
+func TCPShutdownTimeout(d time.Duration) TCPServerOpt {
+	return func(s *TCPServer) { s.shutdownTimeout = d }
+}
+
+// TCPServer runs an accept loop for custom binary or line protocols, serving each connection in its own goroutine.
+type TCPServer struct {
+	addr            string
+	handler         TCPHandler
+	maxConns        int
+	idleTimeout     time.Duration
+	tlsConfig       *tls.Config
+	shutdownTimeout time.Duration
+
+	listener  net.Listener
+	listening atomic.Bool
+	conns     map[net.Conn]struct{}
+	connMu    sync.Mutex
+	wg        sync.WaitGroup
+
+	active   prometheus.Gauge
+	accepted prometheus.Counter
+	rejected prometheus.Counter
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewTCPServer(addr string, handler TCPHandler, opts ...TCPServerOpt) *TCPServer {
+	s := &TCPServer{
+		addr:            addr,
+		handler:         handler,
+		shutdownTimeout: defaultTCPShutdownTimeout,
+		conns:           make(map[net.Conn]struct{}),
+		done:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.setMetrics(newTCPServerMetrics())
+
+	return s
+}
+
+func (s *TCPServer) setMetrics(m tcpServerMetrics) {
+	s.active = m.active.WithLabelValues(s.addr)
+	s.accepted = m.conns.WithLabelValues(s.addr, "accepted")
+	s.rejected = m.conns.WithLabelValues(s.addr, "rejected")
+}
+
+func (s *TCPServer) Name() string {
+	return "tcp-server-" + s.addr
+}
+
+func (s *TCPServer) Ready() bool {
+	return s.listening.Load()
+}
+
+// Addr returns the listening address, useful when listening on port 0.
+func (s *TCPServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *TCPServer) Run(ctx context.Context) error {
+	s.mu.Lock()
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.running.Store(true)
+
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		s.mu.Unlock()
+		close(s.done)
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+	s.listener = listener
+	s.mu.Unlock()
+	defer close(s.done)
+
+	s.listening.Store(true)
+	defer s.listening.Store(false)
+	log.Info().Msgf("started tcp server address %s", s.addr)
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var tempDelay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			if temporaryAcceptError(err) {
+				// same backoff as net/http on temporary accept errors
+				tempDelay = min(max(2*tempDelay, 5*time.Millisecond), time.Second)
+				log.Warn().Err(err).Str("addr", s.addr).Msgf("accept error, retrying in %v", tempDelay)
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(tempDelay):
+				}
+				continue
+			}
+			return fmt.Errorf("failed to accept on %s: %w", s.addr, err)
+		}
+		tempDelay = 0
+
+		if !s.track(conn) {
+			s.rejected.Inc()
+			log.Warn().Str("addr", s.addr).Str("remote", conn.RemoteAddr().String()).Msg("tcp connection limit reached")
+			conn.Close()
+			continue
+		}
+		s.accepted.Inc()
+
+		go s.serve(ctx, conn)
+	}
+}
+
+// temporaryAcceptError reports whether accepting may succeed later, e.g. once connections were closed
+// when the process ran out of file descriptors.
+func temporaryAcceptError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNABORTED} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// track registers the connection unless the limit is reached.
+func (s *TCPServer) track(conn net.Conn) bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	if s.maxConns > 0 && len(s.conns) >= s.maxConns {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	s.active.Inc()
+	return true
+}
+
+func (s *TCPServer) untrack(conn net.Conn) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+
+	delete(s.conns, conn)
+	s.wg.Done()
+	s.active.Dec()
+}
+
+func (s *TCPServer) serve(ctx context.Context, conn net.Conn) {
+	defer s.untrack(conn)
+	defer conn.Close()
+
+	if s.idleTimeout > 0 {
+		conn = &idleConn{Conn: conn, timeout: s.idleTimeout}
+	}
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("tcp handler panicked: %v\n%s", r, debug.Stack())
+			}
+		}()
+		return s.handler(ctx, conn)
+	}()
+	if err != nil && ctx.Err() == nil {
+		log.Error().Err(err).Str("addr", s.addr).Str("remote", conn.RemoteAddr().String()).Msg("tcp handler failed")
+	}
+}
+
+// Close stops accepting connections, cancels the handlers context and waits for them up to the
+// shutdown timeout before closing the remaining connections.
+func (s *TCPServer) Close() error {
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	if !s.running.Load() {
+		return nil
+	}
+	<-s.done
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(s.shutdownTimeout):
+	}
+
+	s.connMu.Lock()
+	log.Warn().Str("addr", s.addr).Int("connections", len(s.conns)).Msg("closing remaining tcp connections")
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connMu.Unlock()
+
+	<-drained
+	return nil
+}
+
+// idleConn extends the read deadline before every read.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+type tcpServerMetrics struct {
+	active *prometheus.GaugeVec
+	conns  *prometheus.CounterVec
+}
+
+func newTCPServerMetrics() tcpServerMetrics {
+	return tcpServerMetrics{
+		active: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "tcp_server_active_connections",
+			Help: "Number of connections being served.",
+		}, []string{"addr"}),
+		conns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tcp_server_connections_total",
+			Help: "Connections by result: accepted or rejected over the limit.",
+		}, []string{"addr", "result"}),
+	}
+}
+
+type TCPServerOption struct {
+	addr    string
+	handler TCPHandler
+	opts    []TCPServerOpt
+}
+
+func (w TCPServerOption) Apply(s *Service) error {
+	if w.handler == nil {
+		return fmt.Errorf("tcp server %s: handler is nil", w.addr)
+	}
+	srv := NewTCPServer(w.addr, w.handler, w.opts...)
+
+	m := newTCPServerMetrics()
+	m.active = registerCollector(s.registry, m.active)
+	m.conns = registerCollector(s.registry, m.conns)
+	srv.setMetrics(m)
+
+	return s.RegisterSubService(srv)
+}
+
+// WithTCPServer serves a custom protocol on addr, connections are tracked and drained on shutdown.
+func WithTCPServer(addr string, handler TCPHandler, opts ...TCPServerOpt) Option {
+	return TCPServerOption{addr: addr, handler: handler, opts: opts}
+}
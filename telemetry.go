@@ -1,13 +1,40 @@
 package app
 
 import (
+	"errors"
+	"net/http"
+	"time"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
 )
 
+const defaultMetricsTimeout = 5 * time.Second
+
+// MetricsConfig tunes the /metrics endpoint of the tech server.
+type MetricsConfig struct {
+	// Disabled removes the endpoint, e.g. when the metrics are pushed with WithOTelMetrics.
+	Disabled bool
+	// Timeout bounds a single scrape, zero means defaultMetricsTimeout.
+	Timeout time.Duration
+	// MaxRequestsInFlight limits concurrent scrapes, zero means no limit.
+	MaxRequestsInFlight int
+	// MaxSamples caps the number of exposed samples, metric families over the limit are dropped.
+	MaxSamples int
+	// DisableOpenMetrics turns off OpenMetrics content negotiation.
+	DisableOpenMetrics bool
+	// DisableCompression turns off gzip encoding of the response.
+	DisableCompression bool
+	// Middlewares protect the endpoint separately from the rest of the tech server, e.g. BearerTokenAuth.
+	Middlewares []func(http.Handler) http.Handler
+}
+
 type TelemetryHandler struct {
 	prometheusRegistry *prometheus.Registry
+	cfg                MetricsConfig
 }
 
 func NewTelemtryHandler(prometheusRegistry *prometheus.Registry) TelemetryHandler {
@@ -15,9 +42,105 @@ func NewTelemtryHandler(prometheusRegistry *prometheus.Registry) TelemetryHandle
 		prometheusRegistry: prometheusRegistry}
 }
 
+func (h TelemetryHandler) WithConfig(cfg MetricsConfig) TelemetryHandler {
+	h.cfg = cfg
+	return h
+}
+
 func (h TelemetryHandler) Register(r chi.Router) {
+	timeout := h.cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultMetricsTimeout
+	}
+
+	var gatherer prometheus.Gatherer = h.prometheusRegistry
+	if h.cfg.MaxSamples > 0 {
+		// the handler may be registered on several routers
+		truncated := registerCollector(h.prometheusRegistry, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "metrics_exposition_truncated_total",
+			Help: "Number of scrapes where metric families were dropped because of the samples limit.",
+		}))
+
+		gatherer = samplesLimitGatherer{
+			gatherer:   h.prometheusRegistry,
+			maxSamples: h.cfg.MaxSamples,
+			truncated:  truncated,
+		}
+	}
+
 	prometheusHandler := promhttp.InstrumentMetricHandler(
-		h.prometheusRegistry, promhttp.HandlerFor(h.prometheusRegistry, promhttp.HandlerOpts{}),
+		h.prometheusRegistry, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+			EnableOpenMetrics:   !h.cfg.DisableOpenMetrics,
+			DisableCompression:  h.cfg.DisableCompression,
+			MaxRequestsInFlight: h.cfg.MaxRequestsInFlight,
+			Timeout:             timeout,
+		}),
 	)
-	r.Get("/metrics", prometheusHandler.ServeHTTP)
+	r.With(h.cfg.Middlewares...).Get("/metrics", prometheusHandler.ServeHTTP)
+}
+
+type samplesLimitGatherer struct {
+	gatherer   prometheus.Gatherer
+	maxSamples int
+	truncated  prometheus.Counter
+}
+
+func (g samplesLimitGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.gatherer.Gather()
+
+	samples := 0
+	for i, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			samples += countSamples(m)
+		}
+
+		if samples > g.maxSamples {
+			log.Warn().Int("max_samples", g.maxSamples).Str("first_dropped", mf.GetName()).
+				Int("dropped_families", len(mfs)-i).Msg("metrics exposition truncated")
+			g.truncated.Inc()
+			return mfs[:i], err
+		}
+	}
+
+	return mfs, err
+}
+
+func countSamples(m *dto.Metric) int {
+	switch {
+	case m.GetHistogram() != nil:
+		// buckets plus +Inf, _sum and _count
+		return len(m.GetHistogram().GetBucket()) + 3
+	case m.GetSummary() != nil:
+		return len(m.GetSummary().GetQuantile()) + 2
+	default:
+		return 1
+	}
+}
+
+type MetricsConfigOption struct {
+	cfg MetricsConfig
+}
+
+func (w MetricsConfigOption) Apply(s *Service) error {
+	s.metricsCfg = w.cfg
+	return nil
+}
+
+func WithMetricsConfig(cfg MetricsConfig) Option {
+	return MetricsConfigOption{cfg: cfg}
+}
+
+// registerCollector registers c, returning the already registered collector when an equal one exists.
+func registerCollector[T prometheus.Collector](r prometheus.Registerer, c T) T {
+	if err := r.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+
+	return c
 }
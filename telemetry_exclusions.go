@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"math/rand/v2"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// DefaultTelemetryExclusions are the probe, scrape and tooling endpoints: kubelet and Prometheus call them
+// every few seconds on every instance.
+var DefaultTelemetryExclusions = []string{
+	"/health/", "/ready", "/metrics", "/debug/pprof/",
+	"/grpc.health.v1.Health/", "/grpc.reflection.",
+}
+
+type TelemetryExclusions struct {
+	// Paths are the prefixes of the HTTP paths and gRPC full methods excluded, empty means
+	// DefaultTelemetryExclusions.
+	Paths []string
+	// SampleRate is the fraction of the excluded requests still observed, e.g. 0.01 keeps one in a
+	// hundred probes in the logs, zero drops them all.
+	SampleRate float64
+}
+
+// telemetryFilter decides which requests the gRPC call logs, the SLO and the usage middlewares skip.
+// It is shared with them when they are set up, so options apply in any order.
+type telemetryFilter struct {
+	prefixes   []string
+	sampleRate float64
+}
+
+// skip reports whether the HTTP request or gRPC call on path is left out of the logs and metrics.
+func (f *telemetryFilter) skip(path string) bool {
+	if f == nil {
+		return false
+	}
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return f.sampleRate <= 0 || rand.Float64() >= f.sampleRate
+		}
+	}
+	return false
+}
+
+func (s *Service) loggingInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if s.exclusions.skip(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	return loggingInterceptor(ctx, req, info, handler)
+}
+
+func (s *Service) loggingStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if s.exclusions.skip(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	return loggingStreamInterceptor(srv, ss, info, handler)
+}
+
+type TelemetryExclusionsOption struct {
+	cfg TelemetryExclusions
+}
+
+func (w TelemetryExclusionsOption) Apply(s *Service) error {
+	s.exclusions.prefixes = w.cfg.Paths
+	if len(s.exclusions.prefixes) == 0 {
+		s.exclusions.prefixes = DefaultTelemetryExclusions
+	}
+	s.exclusions.sampleRate = w.cfg.SampleRate
+	return nil
+}
+
+// WithTelemetryExclusions keeps the probe and scrape traffic out of the gRPC call logs, the SLO counters
+// and the usage records, so it doesn't dominate them.
+func WithTelemetryExclusions(cfg TelemetryExclusions) Option {
+	return TelemetryExclusionsOption{cfg: cfg}
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const defaultCertReloadInterval = time.Minute
+
+type TLSConfig struct {
+	// CertFile and KeyFile are PEM files reloaded when they change, e.g. rotated by cert-manager.
+	CertFile string
+	KeyFile  string
+	// ReloadInterval is how often the files are checked, zero means defaultCertReloadInterval.
+	ReloadInterval time.Duration
+	// Config is used instead of the files, e.g. with its own GetCertificate.
+	Config *tls.Config
+}
+
+// CertReloader serves the certificate of a key pair and reloads it when the files change, so rotated
+// certificates are used by new connections without restarting the servers.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	cert    atomic.Pointer[tls.Certificate]
+	modTime time.Time
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+// NewCertReloader loads the key pair, failing when it is invalid.
+func NewCertReloader(certFile, keyFile string, interval time.Duration) (*CertReloader, error) {
+	if interval == 0 {
+		interval = defaultCertReloadInterval
+	}
+
+	c := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *CertReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load tls key pair %s: %w", c.certFile, err)
+	}
+
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		cert.Leaf = leaf
+		log.Info().Str("cert", c.certFile).Time("not_after", leaf.NotAfter).Msg("tls certificate loaded")
+	}
+
+	c.modTime = c.lastModified()
+	c.cert.Store(&cert)
+	return nil
+}
+
+// lastModified is the latest modification time of the files, zero when one can't be read.
+func (c *CertReloader) lastModified() time.Time {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+func (c *CertReloader) Name() string {
+	return "tls-cert-" + c.certFile
+}
+
+func (c *CertReloader) Ready() bool {
+	return true
+}
+
+// Run reloads the key pair when the files change, an invalid pair is logged and the current one kept.
+func (c *CertReloader) Run(ctx context.Context) error {
+	c.mu.Lock()
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.running.Store(true)
+	c.mu.Unlock()
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		modTime := c.lastModified()
+		if modTime.IsZero() || modTime.Equal(c.modTime) {
+			continue
+		}
+
+		// the key and the certificate may be written one after the other, the pair is retried on the next tick
+		if err := c.load(); err != nil {
+			log.Warn().Err(err).Msg("failed to reload tls certificate, keeping the current one")
+		}
+	}
+}
+
+func (c *CertReloader) Close() error {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
+
+	if c.running.Load() {
+		<-c.done
+	}
+
+	return nil
+}
+
+// tlsConfig builds the server config, registering the reloader of the files as a subservice.
+func (s *Service) tlsConfig(cfg TLSConfig) (*tls.Config, error) {
+	if cfg.Config != nil {
+		return cfg.Config, nil
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("tls requires a certificate and a key file, or a tls.Config")
+	}
+
+	reloader, err := GetSubService[*CertReloader](s, "tls-cert-"+cfg.CertFile)
+	if err != nil {
+		if reloader, err = NewCertReloader(cfg.CertFile, cfg.KeyFile, cfg.ReloadInterval); err != nil {
+			return nil, err
+		}
+		if err := s.RegisterSubService(reloader); err != nil {
+			return nil, err
+		}
+	}
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// AddHTTPSServer adds a server served over TLS, see TLSConfig.
+func (s *Service) AddHTTPSServer(httpServer *http.Server, cfg TLSConfig) error {
+	tlsCfg, err := s.tlsConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	httpServer.TLSConfig = tlsCfg
+	s.AddHTTPServer(httpServer)
+	return nil
+}
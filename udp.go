@@ -0,0 +1,272 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultUDPQueueSize     = 1024
+	defaultUDPMaxPacketSize = 65535
+)
+
+// UDPHandler processes a datagram, packet is reused once the handler returns.
+type UDPHandler func(ctx context.Context, packet []byte, from net.Addr) error
+
+type UDPServerOpt func(*UDPServer)
+
+// UDPWorkers is the number of goroutines running the handler, defaults to runtime.NumCPU().
+func UDPWorkers(n int) UDPServerOpt {
+	return func(s *UDPServer) { s.workers = n }
+}
+
+// UDPQueueSize bounds the datagrams waiting for a worker, datagrams arriving while it is full are dropped.
+func UDPQueueSize(n int) UDPServerOpt {
+	return func(s *UDPServer) { s.queueSize = n }
+}
+
+// UDPReadBuffer sets the socket receive buffer (SO_RCVBUF), raise it for bursty traffic.
+func UDPReadBuffer(bytes int) UDPServerOpt {
+	return func(s *UDPServer) { s.readBuffer = bytes }
+}
+
+// UDPMaxPacketSize bounds the datagram size, larger datagrams are truncated.
+func UDPMaxPacketSize(bytes int) UDPServerOpt {
+	return func(s *UDPServer) { s.maxPacketSize = bytes }
+}
+
+type udpPacket struct {
+	buf  *[]byte
+	n    int
+	from net.Addr
+}
+
+// UDPServer reads datagrams (StatsD, syslog, ...) and hands them to a pool of workers.
+type UDPServer struct {
+	addr          string
+	handler       UDPHandler
+	workers       int
+	queueSize     int
+	readBuffer    int
+	maxPacketSize int
+
+	conn      net.PacketConn
+	listening atomic.Bool
+	queue     chan udpPacket
+	buffers   sync.Pool
+
+	received prometheus.Counter
+	dropped  prometheus.Counter
+	failed   prometheus.Counter
+	depth    prometheus.Gauge
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewUDPServer(addr string, handler UDPHandler, opts ...UDPServerOpt) *UDPServer {
+	s := &UDPServer{
+		addr:          addr,
+		handler:       handler,
+		workers:       runtime.NumCPU(),
+		queueSize:     defaultUDPQueueSize,
+		maxPacketSize: defaultUDPMaxPacketSize,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.queue = make(chan udpPacket, s.queueSize)
+	s.buffers.New = func() any {
+		buf := make([]byte, s.maxPacketSize)
+		return &buf
+	}
+	s.setMetrics(newUDPServerMetrics())
+
+	return s
+}
+
+func (s *UDPServer) setMetrics(m udpServerMetrics) {
+	s.received = m.packets.WithLabelValues(s.addr, "received")
+	s.dropped = m.packets.WithLabelValues(s.addr, "dropped")
+	s.failed = m.packets.WithLabelValues(s.addr, "failed")
+	s.depth = m.depth.WithLabelValues(s.addr)
+}
+
+func (s *UDPServer) Name() string {
+	return "udp-server-" + s.addr
+}
+
+func (s *UDPServer) Ready() bool {
+	return s.listening.Load()
+}
+
+// Addr returns the listening address, useful when listening on port 0.
+func (s *UDPServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+func (s *UDPServer) Run(ctx context.Context) error {
+	s.mu.Lock()
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.running.Store(true)
+	defer close(s.done)
+
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+	if s.readBuffer > 0 {
+		if udpConn, ok := conn.(*net.UDPConn); ok {
+			if err := udpConn.SetReadBuffer(s.readBuffer); err != nil {
+				log.Warn().Err(err).Str("addr", s.addr).Msg("failed to set udp read buffer")
+			}
+		}
+	}
+	s.conn = conn
+	s.mu.Unlock()
+
+	s.listening.Store(true)
+	defer s.listening.Store(false)
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(s.workers)
+	for i := 0; i < s.workers; i++ {
+		go func() {
+			defer wg.Done()
+			// queued datagrams are processed during shutdown
+			s.work(context.WithoutCancel(ctx))
+		}()
+	}
+
+	err = s.read(ctx, conn)
+	close(s.queue)
+	wg.Wait()
+
+	return err
+}
+
+func (s *UDPServer) read(ctx context.Context, conn net.PacketConn) error {
+	for {
+		buf := s.buffers.Get().(*[]byte)
+		n, from, err := conn.ReadFrom(*buf)
+		if err != nil {
+			s.buffers.Put(buf)
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to read on %s: %w", s.addr, err)
+		}
+		s.received.Inc()
+
+		select {
+		case s.queue <- udpPacket{buf: buf, n: n, from: from}:
+			s.depth.Inc()
+		default:
+			s.dropped.Inc()
+			s.buffers.Put(buf)
+		}
+	}
+}
+
+func (s *UDPServer) work(ctx context.Context) {
+	for packet := range s.queue {
+		s.depth.Dec()
+		if err := s.handle(ctx, packet); err != nil {
+			s.failed.Inc()
+			log.Debug().Err(err).Str("addr", s.addr).Str("from", packet.from.String()).Msg("udp handler failed")
+		}
+		s.buffers.Put(packet.buf)
+	}
+}
+
+func (s *UDPServer) handle(ctx context.Context, packet udpPacket) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("udp handler panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	return s.handler(ctx, (*packet.buf)[:packet.n], packet.from)
+}
+
+// Close stops reading and waits for the queued datagrams to be processed.
+func (s *UDPServer) Close() error {
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	if s.running.Load() {
+		<-s.done
+	}
+	return nil
+}
+
+type udpServerMetrics struct {
+	packets *prometheus.CounterVec
+	depth   *prometheus.GaugeVec
+}
+
+func newUDPServerMetrics() udpServerMetrics {
+	return udpServerMetrics{
+		packets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "udp_server_packets_total",
+			Help: "Datagrams by result: received, dropped because the queue was full, or failed in the handler.",
+		}, []string{"addr", "result"}),
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "udp_server_queue_depth",
+			Help: "Number of datagrams waiting for a worker.",
+		}, []string{"addr"}),
+	}
+}
+
+type UDPServerOption struct {
+	addr    string
+	handler UDPHandler
+	opts    []UDPServerOpt
+}
+
+func (w UDPServerOption) Apply(s *Service) error {
+	if w.handler == nil {
+		return fmt.Errorf("udp server %s: handler is nil", w.addr)
+	}
+	srv := NewUDPServer(w.addr, w.handler, w.opts...)
+
+	m := newUDPServerMetrics()
+	m.packets = registerCollector(s.registry, m.packets)
+	m.depth = registerCollector(s.registry, m.depth)
+	srv.setMetrics(m)
+
+	return s.RegisterSubService(srv)
+}
+
+// WithUDPServer reads datagrams on addr and processes them with a pool of workers.
+func WithUDPServer(addr string, handler UDPHandler, opts ...UDPServerOpt) Option {
+	return UDPServerOption{addr: addr, handler: handler, opts: opts}
+}
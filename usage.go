@@ -0,0 +1,443 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	defaultUsageInterval = time.Minute
+	defaultUsageMaxKeys  = 10000
+
+	// UsageOtherClient aggregates the clients seen once MaxKeys is reached.
+	UsageOtherClient = "other"
+	// UsageAnonymousClient is used for requests without a client id.
+	UsageAnonymousClient = "anonymous"
+)
+
+// usageLatencyBuckets are the upper bounds of the latency histogram percentiles are computed from.
+var usageLatencyBuckets = []time.Duration{
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// UsageRecord is the usage of an endpoint by a client over a window. Latency percentiles are the
+// upper bound of the histogram bucket they fall in, or the max latency past the last bucket.
+type UsageRecord struct {
+	Client      string        `json:"client"`
+	Method      string        `json:"method"`
+	Route       string        `json:"route"`
+	WindowStart time.Time     `json:"window_start"`
+	WindowEnd   time.Time     `json:"window_end"`
+	Requests    int64         `json:"requests"`
+	Errors      int64         `json:"errors"`
+	BytesIn     int64         `json:"bytes_in"`
+	BytesOut    int64         `json:"bytes_out"`
+	LatencyP50  time.Duration `json:"latency_p50_ns"`
+	LatencyP90  time.Duration `json:"latency_p90_ns"`
+	LatencyP99  time.Duration `json:"latency_p99_ns"`
+	LatencyMax  time.Duration `json:"latency_max_ns"`
+}
+
+// UsageSink exports the records of a window, records are dropped when it fails.
+type UsageSink interface {
+	Export(ctx context.Context, records []UsageRecord) error
+}
+
+type UsageSinkFunc func(ctx context.Context, records []UsageRecord) error
+
+func (f UsageSinkFunc) Export(ctx context.Context, records []UsageRecord) error {
+	return f(ctx, records)
+}
+
+// KafkaUsageSink produces a JSON message per record to topic, keyed by client.
+func KafkaUsageSink(producer *KafkaProducer, topic string) UsageSink {
+	return UsageSinkFunc(func(ctx context.Context, records []UsageRecord) error {
+		kafkaRecords := make([]*kgo.Record, 0, len(records))
+		for _, record := range records {
+			value, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			kafkaRecords = append(kafkaRecords, &kgo.Record{Topic: topic, Key: []byte(record.Client), Value: value})
+		}
+
+		return producer.Produce(ctx, kafkaRecords...)
+	})
+}
+
+// FileUsageSink appends the records to path as JSON lines.
+func FileUsageSink(path string) UsageSink {
+	var mu sync.Mutex
+	return UsageSinkFunc(func(_ context.Context, records []UsageRecord) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(f)
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		return f.Close()
+	})
+}
+
+type ClickHouseUsageConfig struct {
+	// URL of the ClickHouse HTTP interface, e.g. http://clickhouse:8123.
+	URL      string
+	Table    string
+	User     string
+	Password string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// ClickHouseUsageSink inserts the records over the ClickHouse HTTP interface with the JSONEachRow
+// format, the table columns are named after the JSON fields of UsageRecord.
+func ClickHouseUsageSink(cfg ClickHouseUsageConfig) UsageSink {
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return UsageSinkFunc(func(ctx context.Context, records []UsageRecord) error {
+		var body bytes.Buffer
+		enc := json.NewEncoder(&body)
+		for _, record := range records {
+			if err := enc.Encode(clickHouseUsageRow(record)); err != nil {
+				return err
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, &body)
+		if err != nil {
+			return err
+		}
+		q := req.URL.Query()
+		q.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", cfg.Table))
+		req.URL.RawQuery = q.Encode()
+		if cfg.User != "" {
+			req.SetBasicAuth(cfg.User, cfg.Password)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return fmt.Errorf("clickhouse: %s: %s", resp.Status, bytes.TrimSpace(msg))
+		}
+		return nil
+	})
+}
+
+// clickHouseUsageRow formats the windows as unix seconds, which DateTime columns parse.
+func clickHouseUsageRow(r UsageRecord) any {
+	type row UsageRecord
+	return struct {
+		row
+		WindowStart int64 `json:"window_start"`
+		WindowEnd   int64 `json:"window_end"`
+	}{row: row(r), WindowStart: r.WindowStart.Unix(), WindowEnd: r.WindowEnd.Unix()}
+}
+
+type UsageConfig struct {
+	Sink UsageSink
+	// Interval between exports, zero means defaultUsageInterval.
+	Interval time.Duration
+	// ClientID identifies the client of a request, defaults to the X-Client-ID header.
+	ClientID func(r *http.Request) string
+	// MaxKeys bounds the client and endpoint pairs kept per window, zero means defaultUsageMaxKeys.
+	// New clients past the limit are aggregated as UsageOtherClient.
+	MaxKeys int
+}
+
+type usageKey struct {
+	client string
+	method string
+	route  string
+}
+
+type usageStats struct {
+	requests   int64
+	errors     int64
+	bytesIn    int64
+	bytesOut   int64
+	latencyMax time.Duration
+	buckets    []int64
+}
+
+func (u *usageStats) observe(latency time.Duration, code int, bytesIn, bytesOut int64) {
+	u.requests++
+	if code >= http.StatusInternalServerError {
+		u.errors++
+	}
+	u.bytesIn += bytesIn
+	u.bytesOut += bytesOut
+	u.latencyMax = max(u.latencyMax, latency)
+
+	i := 0
+	for i < len(usageLatencyBuckets) && latency > usageLatencyBuckets[i] {
+		i++
+	}
+	u.buckets[i]++
+}
+
+func (u *usageStats) percentile(p float64) time.Duration {
+	rank := int64(p * float64(u.requests))
+	var seen int64
+	for i, count := range u.buckets {
+		seen += count
+		if seen > rank {
+			if i == len(usageLatencyBuckets) {
+				return u.latencyMax
+			}
+			return min(usageLatencyBuckets[i], u.latencyMax)
+		}
+	}
+	return u.latencyMax
+}
+
+// Usage aggregates the usage of HTTP endpoints per client in memory and exports it every interval,
+// for billing and capacity planning. Requests are recorded by Middleware.
+type Usage struct {
+	cfg         UsageConfig
+	stats       map[usageKey]*usageStats
+	windowStart time.Time
+	statsMu     sync.Mutex
+
+	exported prometheus.Counter
+	failures prometheus.Counter
+
+	exclusions *telemetryFilter
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewUsage(cfg UsageConfig) *Usage {
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultUsageInterval
+	}
+	if cfg.MaxKeys == 0 {
+		cfg.MaxKeys = defaultUsageMaxKeys
+	}
+	if cfg.ClientID == nil {
+		cfg.ClientID = func(r *http.Request) string { return r.Header.Get("X-Client-ID") }
+	}
+
+	return &Usage{
+		cfg:         cfg,
+		stats:       make(map[usageKey]*usageStats),
+		windowStart: time.Now(),
+		exported: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "usage_exported_records_total",
+			Help: "Number of usage records exported to the sink.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "usage_export_failures_total",
+			Help: "Number of usage exports which failed, their records are dropped.",
+		}),
+		done: make(chan struct{}),
+	}
+}
+
+func (u *Usage) Name() string {
+	return "usage"
+}
+
+func (u *Usage) Ready() bool {
+	return true
+}
+
+// Middleware records the requests, endpoints are labelled with their route pattern as in SLOs.Middleware.
+func (u *Usage) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u.exclusions.skip(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			body := &countingReader{ReadCloser: r.Body}
+			if r.Body != nil {
+				r.Body = body
+			}
+
+			next.ServeHTTP(ww, r)
+
+			code := ww.Status()
+			if code == 0 {
+				code = http.StatusOK
+			}
+			key := usageKey{client: u.cfg.ClientID(r), method: r.Method, route: httpRoute(r)}
+			u.observe(key, time.Since(start), code, body.n, int64(ww.BytesWritten()))
+		})
+	}
+}
+
+func (u *Usage) observe(key usageKey, latency time.Duration, code int, bytesIn, bytesOut int64) {
+	if key.client == "" {
+		key.client = UsageAnonymousClient
+	}
+
+	u.statsMu.Lock()
+	defer u.statsMu.Unlock()
+
+	stats, ok := u.stats[key]
+	if !ok && len(u.stats) >= u.cfg.MaxKeys {
+		key.client = UsageOtherClient
+		stats, ok = u.stats[key]
+	}
+	if !ok {
+		stats = &usageStats{buckets: make([]int64, len(usageLatencyBuckets)+1)}
+		u.stats[key] = stats
+	}
+
+	stats.observe(latency, code, bytesIn, bytesOut)
+}
+
+// flush swaps the window and returns its records.
+func (u *Usage) flush() []UsageRecord {
+	u.statsMu.Lock()
+	stats, start := u.stats, u.windowStart
+	u.stats = make(map[usageKey]*usageStats, len(stats))
+	u.windowStart = time.Now()
+	u.statsMu.Unlock()
+
+	records := make([]UsageRecord, 0, len(stats))
+	for key, s := range stats {
+		records = append(records, UsageRecord{
+			Client:      key.client,
+			Method:      key.method,
+			Route:       key.route,
+			WindowStart: start,
+			WindowEnd:   u.windowStart,
+			Requests:    s.requests,
+			Errors:      s.errors,
+			BytesIn:     s.bytesIn,
+			BytesOut:    s.bytesOut,
+			LatencyP50:  s.percentile(0.5),
+			LatencyP90:  s.percentile(0.9),
+			LatencyP99:  s.percentile(0.99),
+			LatencyMax:  s.latencyMax,
+		})
+	}
+
+	return records
+}
+
+func (u *Usage) export(ctx context.Context) {
+	records := u.flush()
+	if len(records) == 0 {
+		return
+	}
+
+	if err := u.cfg.Sink.Export(ctx, records); err != nil {
+		u.failures.Inc()
+		log.Error().Err(err).Int("records", len(records)).Msg("failed to export usage")
+		return
+	}
+	u.exported.Add(float64(len(records)))
+}
+
+func (u *Usage) Run(ctx context.Context) error {
+	u.mu.Lock()
+	ctx, u.cancel = context.WithCancel(ctx)
+	u.running.Store(true)
+	u.mu.Unlock()
+	defer close(u.done)
+
+	ticker := time.NewTicker(u.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the last window is exported on shutdown
+			exportCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), u.cfg.Interval)
+			u.export(exportCtx)
+			cancel()
+			return nil
+		case <-ticker.C:
+			u.export(ctx)
+		}
+	}
+}
+
+func (u *Usage) Close() error {
+	u.mu.Lock()
+	if u.cancel != nil {
+		u.cancel()
+	}
+	u.mu.Unlock()
+
+	if u.running.Load() {
+		<-u.done
+	}
+	return nil
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type UsageOption struct {
+	cfg UsageConfig
+}
+
+func (w UsageOption) Apply(s *Service) error {
+	if w.cfg.Sink == nil {
+		return errors.New("usage requires a sink")
+	}
+
+	u := NewUsage(w.cfg)
+	u.exported = registerCollector(s.registry, u.exported)
+	u.failures = registerCollector(s.registry, u.failures)
+	u.exclusions = s.exclusions
+
+	s.Usage = u
+	return s.RegisterSubService(u)
+}
+
+// WithUsage aggregates the usage of the HTTP handlers wrapped with Service.Usage.Middleware() and
+// exports it to the sink every interval.
+func WithUsage(cfg UsageConfig) Option {
+	return UsageOption{cfg: cfg}
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+)
+
+// dependencyError is returned by the options applied without an option they depend on. applyOptions drops
+// it when one of these options failed, since that failure is already reported.
+type dependencyError struct {
+	err      error
+	requires []Option
+}
+
+func (e *dependencyError) Error() string {
+	return e.err.Error()
+}
+
+func (e *dependencyError) Unwrap() error {
+	return e.err
+}
+
+// requireOption marks err as caused by the absence of one of the options, given as zero values.
+func requireOption(err error, options ...Option) error {
+	return &dependencyError{err: err, requires: options}
+}
+
+// applyOptions applies every option, even after one failed, so New reports all the problems at once.
+func (s *Service) applyOptions(options []Option) []error {
+	var errs []error
+	for i, o := range options {
+		if o == nil {
+			errs = append(errs, fmt.Errorf("option %d is nil", i))
+			continue
+		}
+
+		err := o.Apply(s)
+		if err == nil {
+			continue
+		}
+		s.failedOptions = append(s.failedOptions, reflect.TypeOf(o))
+
+		var derr *dependencyError
+		if errors.As(err, &derr) && slices.ContainsFunc(derr.requires, func(required Option) bool {
+			return slices.Contains(s.failedOptions, reflect.TypeOf(required))
+		}) {
+			log.Debug().Err(err).Msg("option skipped, an option it depends on failed")
+			continue
+		}
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// validate reports the problems of the service once the options are applied, which would otherwise
+// surface at runtime as a panic, a failed bind or a component silently missing.
+func (s *Service) validate() []error {
+	var errs []error
+	subServices := s.subServices()
+	for _, name := range slices.Sorted(maps.Keys(subServices)) {
+		switch subService := subServices[name]; {
+		case subService == nil:
+			errs = append(errs, fmt.Errorf("subservice %q is nil", name))
+		case subService.Name() != name:
+			errs = append(errs, fmt.Errorf("subservice %q is registered as %q, register it under its Name()", subService.Name(), name))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.DBs)) {
+		if s.DBs[name] == nil {
+			errs = append(errs, fmt.Errorf("db pool %q is nil", name))
+		}
+	}
+	for i, httpServer := range s.HTTPServers {
+		if httpServer == nil {
+			errs = append(errs, fmt.Errorf("http server %d is nil", i))
+		}
+	}
+
+	if err := s.checkConflicts(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// invalidOptions joins errs under ErrInvalidOptions, nil without errors.
+func invalidOptions(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrInvalidOptions, errors.Join(errs...))
+}
@@ -0,0 +1,372 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrSecretNotFound is returned for a Vault path holding no secret.
+var ErrSecretNotFound = errors.New("vault secret not found")
+
+const (
+	defaultVaultDatabaseMount = "database"
+	vaultRotateRetryDelay     = 5 * time.Second
+	minVaultRenewDelay        = time.Second
+)
+
+type VaultConfig struct {
+	// Address of the Vault server, defaults to the VAULT_ADDR variable.
+	Address string
+	// Token defaults to the VAULT_TOKEN variable.
+	Token string
+	// Secrets are the paths read at startup, e.g. "secret/data/orders" for a KV v2 engine.
+	Secrets []string
+	// Database enables dynamic database credentials.
+	Database *VaultDatabaseConfig
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+type VaultDatabaseConfig struct {
+	// Role is the database secrets engine role the credentials are generated for.
+	Role string
+	// Mount is the database secrets engine path, empty means defaultVaultDatabaseMount.
+	Mount string
+	// DB is the registry name of the pool, empty means DefaultDBName.
+	DB string
+	// Config is the pool config without credentials, the user and password come from Vault.
+	Config pgxpool.Config
+}
+
+type vaultLease struct {
+	ID        string
+	Duration  time.Duration
+	Renewable bool
+}
+
+type vaultCredentials struct {
+	username string
+	password string
+	lease    vaultLease
+}
+
+// Vault reads secrets at startup and keeps the dynamic database credentials valid: their lease is
+// renewed in the background, and once it can't be renewed anymore new credentials are generated and
+// the pool connections are re-created with them.
+type Vault struct {
+	cfg     VaultConfig
+	secrets map[string]map[string]any
+
+	db      *pgxpool.Pool
+	creds   vaultCredentials
+	ttl     time.Duration
+	credsMu sync.RWMutex
+
+	renewals  *prometheus.CounterVec
+	rotations prometheus.Counter
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running atomic.Bool
+	mu      sync.Mutex
+}
+
+func NewVault(cfg VaultConfig) *Vault {
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("VAULT_ADDR")
+	}
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if cfg.Database != nil && cfg.Database.Mount == "" {
+		cfg.Database.Mount = defaultVaultDatabaseMount
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+
+	return &Vault{
+		cfg:     cfg,
+		secrets: make(map[string]map[string]any),
+		renewals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "vault_lease_renewals_total",
+			Help: "Number of database credential lease renewals by result: renewed or failed.",
+		}, []string{"result"}),
+		rotations: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "vault_credential_rotations_total",
+			Help: "Number of times new database credentials were generated after the startup.",
+		}),
+		done: make(chan struct{}),
+	}
+}
+
+func (v *Vault) call(ctx context.Context, method, path string, in, out any) error {
+	url := fmt.Sprintf("%s/v1/%s", v.cfg.Address, path)
+	header := http.Header{"X-Vault-Token": {v.cfg.Token}}
+
+	status, err := jsonRequest(ctx, v.cfg.Client, method, url, header, in, out)
+	if status == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrSecretNotFound, path)
+	}
+	if err != nil {
+		return fmt.Errorf("vault: %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// Read returns the data of the secret at path, unwrapping the KV v2 envelope.
+func (v *Vault) Read(ctx context.Context, path string) (map[string]any, error) {
+	var resp struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := v.call(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	if data, ok := resp.Data["data"].(map[string]any); ok {
+		if _, ok := resp.Data["metadata"]; ok {
+			return data, nil
+		}
+	}
+	return resp.Data, nil
+}
+
+// Secret returns a key of a secret read at startup.
+func (v *Vault) Secret(path, key string) (string, bool) {
+	value, ok := v.secrets[path][key]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
+
+func (v *Vault) readSecrets(ctx context.Context) error {
+	for _, path := range v.cfg.Secrets {
+		data, err := v.Read(ctx, path)
+		if err != nil {
+			return err
+		}
+		v.secrets[path] = data
+	}
+	return nil
+}
+
+func (v *Vault) generateCredentials(ctx context.Context) (vaultCredentials, error) {
+	var resp struct {
+		LeaseID       string `json:"lease_id"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+		Data          struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"data"`
+	}
+	path := fmt.Sprintf("%s/creds/%s", v.cfg.Database.Mount, v.cfg.Database.Role)
+	if err := v.call(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return vaultCredentials{}, err
+	}
+
+	return vaultCredentials{
+		username: resp.Data.Username,
+		password: resp.Data.Password,
+		lease: vaultLease{
+			ID:        resp.LeaseID,
+			Duration:  time.Duration(resp.LeaseDuration) * time.Second,
+			Renewable: resp.Renewable,
+		},
+	}, nil
+}
+
+func (v *Vault) renewLease(ctx context.Context, lease vaultLease) (vaultLease, error) {
+	var resp struct {
+		LeaseID       string `json:"lease_id"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	}
+	in := map[string]any{"lease_id": lease.ID, "increment": int(lease.Duration.Seconds())}
+	if err := v.call(ctx, http.MethodPut, "sys/leases/renew", in, &resp); err != nil {
+		return vaultLease{}, err
+	}
+
+	return vaultLease{
+		ID:        resp.LeaseID,
+		Duration:  time.Duration(resp.LeaseDuration) * time.Second,
+		Renewable: resp.Renewable,
+	}, nil
+}
+
+// beforeConnect authenticates new pool connections with the current credentials.
+func (v *Vault) beforeConnect(_ context.Context, cfg *pgx.ConnConfig) error {
+	v.credsMu.RLock()
+	defer v.credsMu.RUnlock()
+
+	cfg.User = v.creds.username
+	cfg.Password = v.creds.password
+	return nil
+}
+
+func (v *Vault) lease() vaultLease {
+	v.credsMu.RLock()
+	defer v.credsMu.RUnlock()
+
+	return v.creds.lease
+}
+
+func (v *Vault) Name() string {
+	return "vault"
+}
+
+func (v *Vault) Ready() bool {
+	return v.lease().ID != ""
+}
+
+// Run renews the lease of the database credentials at two thirds of its duration, and rotates the
+// credentials when the lease can't be renewed or Vault shortens it because of its max TTL.
+func (v *Vault) Run(ctx context.Context) error {
+	v.mu.Lock()
+	ctx, v.cancel = context.WithCancel(ctx)
+	v.running.Store(true)
+	v.mu.Unlock()
+	defer close(v.done)
+
+	for {
+		lease := v.lease()
+		delay := max(lease.Duration*2/3, minVaultRenewDelay)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		if lease.Renewable {
+			renewed, err := v.renewLease(ctx, lease)
+			if err == nil && renewed.Duration >= v.ttl/3 {
+				v.renewals.WithLabelValues("renewed").Inc()
+				v.credsMu.Lock()
+				v.creds.lease = renewed
+				v.credsMu.Unlock()
+				continue
+			}
+			if err != nil {
+				v.renewals.WithLabelValues("failed").Inc()
+				log.Warn().Err(err).Msg("failed to renew vault lease, rotating database credentials")
+			}
+		}
+
+		v.rotate(ctx)
+	}
+}
+
+// rotate generates new credentials until it succeeds or ctx is done, then recycles the pool
+// connections: idle ones are closed right away, acquired ones once released.
+func (v *Vault) rotate(ctx context.Context) {
+	for {
+		creds, err := v.generateCredentials(ctx)
+		if err == nil {
+			v.credsMu.Lock()
+			v.creds = creds
+			v.ttl = creds.lease.Duration
+			v.credsMu.Unlock()
+
+			v.db.Reset()
+			v.rotations.Inc()
+			log.Info().Str("username", creds.username).Msg("database credentials rotated")
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Error().Err(err).Dur("retry_in", vaultRotateRetryDelay).Msg("failed to rotate database credentials")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(vaultRotateRetryDelay):
+		}
+	}
+}
+
+func (v *Vault) Close() error {
+	v.mu.Lock()
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.mu.Unlock()
+
+	if v.running.Load() {
+		<-v.done
+	}
+
+	return nil
+}
+
+type VaultOption struct {
+	cfg VaultConfig
+}
+
+func (w VaultOption) Apply(s *Service) error {
+	v := NewVault(w.cfg)
+	if v.cfg.Address == "" {
+		return errors.New("vault address is required")
+	}
+
+	ctx, cancel := context.WithTimeout(s.GetContext(), defaultStartupDeadline)
+	defer cancel()
+
+	if err := v.readSecrets(ctx); err != nil {
+		return err
+	}
+	s.Vault = v
+
+	if v.cfg.Database == nil {
+		return nil
+	}
+
+	creds, err := v.generateCredentials(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to generate database credentials: %w", err)
+	}
+	v.creds, v.ttl = creds, creds.lease.Duration
+
+	p, err := newDBPool(v.cfg.Database.Config, func(cfg *pgxpool.Config) {
+		cfg.BeforeConnect = v.beforeConnect
+	})
+	if err != nil {
+		return err
+	}
+
+	name := v.cfg.Database.DB
+	if name == "" {
+		name = DefaultDBName
+	}
+	if err := s.addDB(name, p); err != nil {
+		p.Close()
+		return err
+	}
+	v.db = p
+
+	v.renewals = registerCollector(s.registry, v.renewals)
+	v.rotations = registerCollector(s.registry, v.rotations)
+	return s.RegisterSubService(v)
+}
+
+// WithVault reads the secrets at startup, exposed by Service.Vault.Secret, and with Database
+// registers a pool authenticated with dynamic credentials which are renewed and rotated while the
+// service runs. The pool is the same across rotations, only its connections are re-created.
+func WithVault(cfg VaultConfig) Option {
+	return VaultOption{cfg: cfg}
+}
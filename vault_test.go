@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVaultRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr error
+	}{
+		{"kv v1", http.StatusOK, `{"data":{"password":"s3cret"}}`, "s3cret", nil},
+		{"kv v2", http.StatusOK, `{"data":{"data":{"password":"s3cret"},"metadata":{"version":1}}}`, "s3cret", nil},
+		{"missing secret", http.StatusNotFound, `{"errors":[]}`, "", ErrSecretNotFound},
+		{"server error", http.StatusInternalServerError, `{"errors":["down"]}`, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Vault-Token") != "token" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			v := NewVault(VaultConfig{Address: server.URL, Token: "token", Client: server.Client()})
+
+			data, err := v.Read(context.Background(), "secret/data/orders")
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			case tt.want == "":
+				if err == nil || errors.Is(err, ErrSecretNotFound) || errors.Is(err, ErrKMSKeyNotFound) {
+					t.Fatalf("err = %v, want a request error", err)
+				}
+			default:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if data["password"] != tt.want {
+					t.Errorf("password = %v, want %s", data["password"], tt.want)
+				}
+			}
+		})
+	}
+}
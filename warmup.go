@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+const defaultWarmUpTimeout = 10 * time.Second
+
+// WarmUpConfig declares a downstream target whose connections are established by Start, before the
+// servers accept requests, so the first requests after a deploy don't pay the DNS lookup and the
+// TCP and TLS handshakes.
+type WarmUpConfig struct {
+	// URL requested to open the connections, e.g. a health endpoint of the target.
+	URL string
+	// Connections opened concurrently and kept idle in the pool, zero means 1. HTTP/2 targets multiplex
+	// the requests on a single connection.
+	Connections int
+	// Timeout bounds the warm-up of the target, zero means 10s.
+	Timeout time.Duration
+}
+
+type warmUpTarget struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+type warmUpRegistry struct {
+	mu      sync.Mutex
+	targets []warmUpTarget
+
+	duration *prometheus.GaugeVec
+}
+
+func (s *Service) addWarmUp(target warmUpTarget) {
+	if target.timeout == 0 {
+		target.timeout = defaultWarmUpTimeout
+	}
+
+	s.warmUps.mu.Lock()
+	defer s.warmUps.mu.Unlock()
+
+	if s.warmUps.duration == nil {
+		s.warmUps.duration = registerCollector(s.registry, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "dependency_warmup_seconds",
+			Help: "Duration of the warm-up of the connections to a downstream target by result: ok or error.",
+		}, []string{"target", "result"}))
+	}
+	s.warmUps.targets = append(s.warmUps.targets, target)
+}
+
+// warmUp warms the declared targets concurrently. It is best effort: failures are logged and the
+// service starts anyway, the connections being established on the first requests instead.
+func (s *Service) warmUp(ctx context.Context) {
+	s.warmUps.mu.Lock()
+	targets := s.warmUps.targets
+	s.warmUps.mu.Unlock()
+	if len(targets) == 0 {
+		return
+	}
+
+	start := time.Now()
+	var g errgroup.Group
+	for _, target := range targets {
+		g.Go(s.recoverPanic("warm-up "+target.name, func() error {
+			targetCtx, cancel := context.WithTimeout(ctx, target.timeout)
+			defer cancel()
+
+			targetStart := time.Now()
+			err := target.run(targetCtx)
+			result := "ok"
+			if err != nil {
+				result = "error"
+				log.Warn().Err(err).Str("target", target.name).Msg("failed to warm up connections")
+			}
+			s.warmUps.duration.WithLabelValues(target.name, result).Set(time.Since(targetStart).Seconds())
+			return nil
+		}))
+	}
+	_ = g.Wait()
+
+	log.Info().Int("targets", len(targets)).Dur("duration", time.Since(start)).Msg("connections warmed up")
+}
+
+// warmUpHTTP resolves the host of cfg.URL unless the client routes by locality, its URL host being a
+// name, then sends cfg.Connections concurrent requests so as many connections are left idle in the pool.
+func (s *Service) warmUpHTTP(client *http.Client, cfg WarmUpConfig, resolve bool) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return fmt.Errorf("invalid warm-up url: %w", err)
+		}
+		if resolve {
+			if err := s.preResolve(ctx, u.Hostname()); err != nil {
+				return err
+			}
+		}
+
+		var g errgroup.Group
+		for range max(cfg.Connections, 1) {
+			g.Go(func() error {
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.URL, nil)
+				if err != nil {
+					return err
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					return err
+				}
+				// the connection is reused once the body is drained
+				_, _ = io.Copy(io.Discard, resp.Body)
+				return resp.Body.Close()
+			})
+		}
+		return g.Wait()
+	}
+}
+
+func (s *Service) preResolve(ctx context.Context, host string) error {
+	if host == "" || net.ParseIP(host) != nil {
+		return nil
+	}
+
+	var err error
+	if s.DNSRefresher != nil {
+		_, err = s.DNSRefresher.Lookup(ctx, host)
+	} else {
+		_, err = net.DefaultResolver.LookupHost(ctx, host)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	return nil
+}
+
+// WarmUpGRPC makes Start connect conn, to the target called name, and wait for it to be ready before the
+// servers accept requests. The name resolution of the connection happens then as well.
+func (s *Service) WarmUpGRPC(name string, conn *grpc.ClientConn, timeout time.Duration) {
+	s.addWarmUp(warmUpTarget{name: name, timeout: timeout, run: func(ctx context.Context) error {
+		conn.Connect()
+		for {
+			state := conn.GetState()
+			if state == connectivity.Ready {
+				return nil
+			}
+			if !conn.WaitForStateChange(ctx, state) {
+				return fmt.Errorf("grpc connection %s: %w", state, ctx.Err())
+			}
+		}
+	}})
+}
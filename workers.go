@@ -0,0 +1,320 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultWorkerPoolName is used by WithWorkerPool when WorkerPoolConfig.Name is empty.
+	DefaultWorkerPoolName = "default"
+
+	defaultWorkerPoolQueueSize = 100
+)
+
+var (
+	ErrQueueFull          = errors.New("worker pool queue is full")
+	ErrPoolClosed         = errors.New("worker pool is closed")
+	ErrWorkerPoolNotFound = errors.New("worker pool not found")
+)
+
+// Task is a unit of work. Name labels the metrics, Payload is opaque data kept
+// for the WorkerPoolConfig.Persist hook since Run can't be persisted.
+type Task struct {
+	Name    string
+	Payload any
+	Run     func(ctx context.Context) error
+}
+
+type WorkerPoolConfig struct {
+	Name string
+	// Concurrency is the number of workers, zero means runtime.NumCPU().
+	Concurrency int
+	// QueueSize bounds the pending tasks, zero means defaultWorkerPoolQueueSize.
+	QueueSize int
+	// Persist, when set, receives the tasks still queued on shutdown instead of running them.
+	Persist func(ctx context.Context, tasks []Task) error
+}
+
+type queuedTask struct {
+	Task
+	queuedAt time.Time
+}
+
+// WorkerPool runs submitted tasks with bounded concurrency. On Close the queue is drained,
+// or handed to Persist, and in-flight tasks complete.
+type WorkerPool struct {
+	cfg      WorkerPoolConfig
+	queue    chan queuedTask
+	stopping chan struct{}
+	stopOnce sync.Once
+	closed   bool
+	mu       sync.RWMutex
+	wg       sync.WaitGroup
+	running  bool
+	active   atomic.Int64
+
+	depth    prometheus.Gauge
+	wait     prometheus.Observer
+	duration prometheus.ObserverVec
+	rejected prometheus.Counter
+}
+
+func NewWorkerPool(cfg WorkerPoolConfig) *WorkerPool {
+	if cfg.Name == "" {
+		cfg.Name = DefaultWorkerPoolName
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = runtime.NumCPU()
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = defaultWorkerPoolQueueSize
+	}
+
+	p := &WorkerPool{
+		cfg:      cfg,
+		queue:    make(chan queuedTask, cfg.QueueSize),
+		stopping: make(chan struct{}),
+	}
+	p.setMetrics(newWorkerPoolMetrics())
+
+	return p
+}
+
+func (p *WorkerPool) setMetrics(m workerPoolMetrics) {
+	p.depth = m.depth.WithLabelValues(p.cfg.Name)
+	p.wait = m.wait.WithLabelValues(p.cfg.Name)
+	p.duration = m.duration.MustCurryWith(prometheus.Labels{"pool": p.cfg.Name})
+	p.rejected = m.rejected.WithLabelValues(p.cfg.Name)
+}
+
+func (p *WorkerPool) Name() string {
+	return "worker-pool-" + p.cfg.Name
+}
+
+func (p *WorkerPool) Ready() bool {
+	return true
+}
+
+// Submit queues the task without blocking, returning ErrQueueFull when the queue is full.
+func (p *WorkerPool) Submit(task Task) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	select {
+	case p.queue <- queuedTask{Task: task, queuedAt: time.Now()}:
+		p.depth.Inc()
+		return nil
+	default:
+		p.rejected.Inc()
+		return ErrQueueFull
+	}
+}
+
+// SubmitWait queues the task, waiting for room in the queue until ctx is done.
+func (p *WorkerPool) SubmitWait(ctx context.Context, task Task) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	select {
+	case p.queue <- queuedTask{Task: task, queuedAt: time.Now()}:
+		p.depth.Inc()
+		return nil
+	case <-p.stopping:
+		return ErrPoolClosed
+	case <-ctx.Done():
+		p.rejected.Inc()
+		return ctx.Err()
+	}
+}
+
+// Run starts the workers and returns once they all stopped after Close.
+func (p *WorkerPool) Run(ctx context.Context) error {
+	p.mu.Lock()
+	p.running = true
+	p.wg.Add(p.cfg.Concurrency)
+	p.mu.Unlock()
+
+	// queued tasks complete during shutdown
+	taskCtx := context.WithoutCancel(ctx)
+	for i := 0; i < p.cfg.Concurrency; i++ {
+		go func() {
+			defer p.wg.Done()
+			for task := range p.queue {
+				p.depth.Dec()
+				p.run(taskCtx, task)
+			}
+		}()
+	}
+
+	p.wg.Wait()
+	return nil
+}
+
+// Pending is the number of queued and running tasks.
+func (p *WorkerPool) Pending() int {
+	return len(p.queue) + int(p.active.Load())
+}
+
+func (p *WorkerPool) run(ctx context.Context, task queuedTask) {
+	p.active.Add(1)
+	defer p.active.Add(-1)
+
+	start := time.Now()
+	p.wait.Observe(start.Sub(task.queuedAt).Seconds())
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("task panicked: %v\n%s", r, debug.Stack())
+			}
+		}()
+		return task.Run(ctx)
+	}()
+
+	result := "success"
+	if err != nil {
+		result = "error"
+		log.Error().Err(err).Str("pool", p.cfg.Name).Str("task", task.Name).Msg("task failed")
+	}
+	p.duration.WithLabelValues(task.Name, result).Observe(time.Since(start).Seconds())
+}
+
+// Close stops accepting tasks, then either persists the queued ones or lets the workers drain them.
+// Later calls, e.g. Stop closing a pool the application closed already, wait for the first one and
+// return nil.
+func (p *WorkerPool) Close() error {
+	var err error
+	p.stopOnce.Do(func() {
+		err = p.close()
+	})
+	return err
+}
+
+func (p *WorkerPool) close() error {
+	close(p.stopping)
+
+	p.mu.Lock()
+	p.closed = true
+	running := p.running
+	p.mu.Unlock()
+
+	var err error
+	if p.cfg.Persist != nil || !running {
+		err = p.persist()
+	}
+
+	p.mu.Lock()
+	close(p.queue)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	return err
+}
+
+func (p *WorkerPool) persist() error {
+	var pending []Task
+	for {
+		select {
+		case task := <-p.queue:
+			p.depth.Dec()
+			pending = append(pending, task.Task)
+			continue
+		default:
+		}
+		break
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+	if p.cfg.Persist == nil {
+		log.Warn().Str("pool", p.cfg.Name).Int("tasks", len(pending)).Msg("worker pool never started, dropping queued tasks")
+		return nil
+	}
+
+	log.Info().Str("pool", p.cfg.Name).Int("tasks", len(pending)).Msg("persisting queued tasks")
+	if err := p.cfg.Persist(context.Background(), pending); err != nil {
+		return fmt.Errorf("failed to persist %d queued tasks: %w", len(pending), err)
+	}
+	return nil
+}
+
+type workerPoolMetrics struct {
+	depth    *prometheus.GaugeVec
+	wait     *prometheus.HistogramVec
+	duration *prometheus.HistogramVec
+	rejected *prometheus.CounterVec
+}
+
+func newWorkerPoolMetrics() workerPoolMetrics {
+	return workerPoolMetrics{
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "worker_pool_queue_depth",
+			Help: "Number of tasks waiting in the queue.",
+		}, []string{"pool"}),
+		wait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "worker_pool_task_wait_seconds",
+			Help:    "Time tasks spent in the queue before a worker picked them up.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"pool"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "worker_pool_task_duration_seconds",
+			Help:    "Task execution time.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"pool", "task", "result"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "worker_pool_rejected_total",
+			Help: "Tasks rejected because the queue was full.",
+		}, []string{"pool"}),
+	}
+}
+
+// WorkerPool returns the pool registered with WithWorkerPool.
+func (s *Service) WorkerPool(name string) (*WorkerPool, error) {
+	p, err := GetSubService[*WorkerPool](s, "worker-pool-"+name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrWorkerPoolNotFound, name)
+	}
+
+	return p, nil
+}
+
+type WorkerPoolOption struct {
+	cfg WorkerPoolConfig
+}
+
+func (w WorkerPoolOption) Apply(s *Service) error {
+	p := NewWorkerPool(w.cfg)
+
+	m := newWorkerPoolMetrics()
+	m.depth = registerCollector(s.registry, m.depth)
+	m.wait = registerCollector(s.registry, m.wait)
+	m.duration = registerCollector(s.registry, m.duration)
+	m.rejected = registerCollector(s.registry, m.rejected)
+	p.setMetrics(m)
+
+	return s.RegisterSubService(p)
+}
+
+// WithWorkerPool adds a managed pool reachable through Service.WorkerPool(cfg.Name).
+func WithWorkerPool(cfg WorkerPoolConfig) Option {
+	return WorkerPoolOption{cfg: cfg}
+}